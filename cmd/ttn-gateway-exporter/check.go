@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/check"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"os"
+	"strings"
+	"time"
+)
+
+// gatewayFlags collects the values of a repeatable flag.
+type gatewayFlags []string
+
+func (g *gatewayFlags) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *gatewayFlags) Set(value string) error {
+	*g = append(*g, value)
+	return nil
+}
+
+// runCheck is a Nagios/Icinga plugin: it reads the status of the selected gateways, evaluates them against the
+// thresholds and exits with the plugin state.
+func runCheck(args []string) {
+	logging.SetOutput(os.Stderr)
+
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	targetConfigPath := flags.String("target-config-path", "/etc/ttn-exporter/targets.yaml", "Path to a target config file")
+	var gateways gatewayFlags
+	flags.Var(&gateways, "gateway", "Gateway ID to check, as gateway-id or gateway-id@tenant-id, repeatable (default all targets)")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout for reading all gateways, UNKNOWN when exceeded")
+	var thresholds check.Thresholds
+	flags.DurationVar(&thresholds.StatusAgeWarning, "status-age-warning", 5*time.Minute, "Warning if the last status is older, 0 disables")
+	flags.DurationVar(&thresholds.StatusAgeCritical, "status-age-critical", 15*time.Minute, "Critical if the last status is older, 0 disables")
+	flags.DurationVar(&thresholds.UplinkAgeWarning, "uplink-age-warning", time.Hour, "Warning if the last uplink is older, 0 disables")
+	flags.DurationVar(&thresholds.UplinkAgeCritical, "uplink-age-critical", 0, "Critical if the last uplink is older, 0 disables")
+	flags.DurationVar(&thresholds.RTTWarning, "rtt-warning", 300*time.Millisecond, "Warning if the median round-trip time is higher, 0 disables")
+	flags.DurationVar(&thresholds.RTTCritical, "rtt-critical", time.Second, "Critical if the median round-trip time is higher, 0 disables")
+	flags.Float64Var(&thresholds.SubBandWarning, "subband-warning", 0.8, "Warning if a sub-band uses this share of its duty-cycle limit, 0 disables")
+	flags.Float64Var(&thresholds.SubBandCritical, "subband-critical", 0.95, "Critical if a sub-band uses this share of its duty-cycle limit, 0 disables")
+	_ = flags.Parse(args)
+
+	unknown := func(format string, args ...interface{}) {
+		fmt.Printf("TTN GATEWAY UNKNOWN - "+format+"\n", args...)
+		os.Exit(int(check.Unknown))
+	}
+
+	targetConfig, err := config.ReadTargets(*targetConfigPath)
+	if err != nil {
+		unknown("invalid config %s: %s", *targetConfigPath, strings.ReplaceAll(err.Error(), "\n", "; "))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	discovered, err := exporter.DiscoverTenantGateways(ctx, targetConfig)
+	if err != nil {
+		unknown("tenant gateway discovery failed: %s", err)
+	}
+	targetConfig.Targets = append(targetConfig.Targets, discovered...)
+
+	selected := map[string]bool{}
+	for _, gatewayID := range gateways {
+		selected[gatewayID] = false
+	}
+	var targets []*exporter.Target
+	for _, target := range targetConfig.Targets {
+		if len(gateways) > 0 {
+			if _, ok := selected[target.FullGatewayID()]; ok {
+				selected[target.FullGatewayID()] = true
+			} else if _, ok := selected[target.GatewayID]; ok {
+				selected[target.GatewayID] = true
+			} else {
+				continue
+			}
+		}
+		targetCollector, err := exporter.NewTarget(targetConfig, target)
+		if err != nil {
+			unknown("%s: %s", target.FullGatewayID(), err)
+		}
+		targets = append(targets, targetCollector)
+	}
+	for gatewayID, found := range selected {
+		if !found {
+			unknown("gateway %s is not configured in %s", gatewayID, *targetConfigPath)
+		}
+	}
+	if len(targets) == 0 {
+		unknown("no gateways configured in %s", *targetConfigPath)
+	}
+
+	var results []check.Result
+	for _, targetStatus := range exporter.Statuses(ctx, targets, 0) {
+		results = append(results, check.Evaluate(targetStatus.Target, targetStatus.Status, targetStatus.Err, thresholds))
+	}
+	out, state := check.Output(results)
+	fmt.Print(out)
+	os.Exit(int(state))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"os"
+	"time"
+)
+
+// checkConfig validates the target config and exits non-zero if it contains errors. In online mode, every target's
+// API key is additionally used to read its gateway's connection stats.
+func checkConfig(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	targetConfigPath := flags.String("target-config-path", "/etc/ttn-exporter/targets.yaml", "Path to a target config file")
+	online := flags.Bool("online", false, "Verify that every API key can read its gateway")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout per target for online checks")
+	_ = flags.Parse(args)
+
+	targetConfig, err := config.ReadTargets(*targetConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid config\n%s\n", *targetConfigPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %d targets OK\n", *targetConfigPath, len(targetConfig.Targets))
+	if !*online {
+		return
+	}
+
+	failed := 0
+	for _, target := range targetConfig.Targets {
+		if target.Backend != config.BackendTTN {
+			fmt.Printf("%s: online check skipped for backend %s\n", target.GatewayID, target.Backend)
+			continue
+		}
+		err := checkTargetOnline(target, *timeout)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s (key %s): %s\n", target.GatewayID, config.KeyID(target.APIKey), err)
+			continue
+		}
+		fmt.Printf("%s (key %s): OK\n", target.GatewayID, config.KeyID(target.APIKey))
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d targets failed the online check\n", failed, len(targetConfig.Targets))
+		os.Exit(1)
+	}
+}
+
+func checkTargetOnline(target config.Target, timeout time.Duration) error {
+	client, err := exporter.NewClient(target)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err = client.GetGatewayConnectionStats(ctx, target.GatewayID)
+	var apiErr *ttnclient.APIError
+	if errors.As(err, &apiErr) && apiErr.Name == "not_connected" {
+		// the key was accepted, the gateway is just offline right now
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckConfigProcess runs check-config with the arguments of the environment. It only runs as subprocess of
+// TestCheckConfigExitStatus, as check-config exits the process.
+func TestCheckConfigProcess(t *testing.T) {
+	args, ok := os.LookupEnv("TEST_CHECK_CONFIG_ARGS")
+	if !ok {
+		t.Skip("only run as subprocess")
+	}
+	checkConfig(strings.Fields(args))
+	os.Exit(0)
+}
+
+func TestCheckConfigExitStatus(t *testing.T) {
+	apiKey := "NNSXS." + strings.Repeat("A", 39) + "." + strings.Repeat("B", 64)
+	tests := []struct {
+		name       string
+		config     string
+		wantStatus int
+		wantOutput string
+	}{
+		{
+			name:       "valid",
+			config:     "targets:\n  - gateway_id: my-gateway\n    api_key: " + apiKey + "\n",
+			wantStatus: 0,
+			wantOutput: "1 targets OK",
+		},
+		{
+			name:       "unknown field",
+			config:     "targets:\n  - gateway_id: my-gateway\n    api_kye: " + apiKey + "\n",
+			wantStatus: 1,
+			wantOutput: `line 3, column 5: unknown field "api_kye"`,
+		},
+		{
+			name:       "invalid API key",
+			config:     "targets:\n  - gateway_id: my-gateway\n    api_key: NNSXS.TOOSHORT.SECRET\n",
+			wantStatus: 1,
+			wantOutput: "line 3, column 14: targets[0]: api key TOOSHORT is malformed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "targets.yaml")
+			if err := os.WriteFile(location, []byte(test.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(os.Args[0], "-test.run=^TestCheckConfigProcess$")
+			cmd.Env = append(os.Environ(), "TEST_CHECK_CONFIG_ARGS=--target-config-path "+location)
+			output, err := cmd.CombinedOutput()
+
+			status := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatalf("running check-config: %v", err)
+			}
+			if status != test.wantStatus {
+				t.Errorf("exit status = %d, want %d, output:\n%s", status, test.wantStatus, output)
+			}
+			if !strings.Contains(string(output), test.wantOutput) {
+				t.Errorf("output %q does not contain %q", output, test.wantOutput)
+			}
+		})
+	}
+
+	// a missing config file is an error as well
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCheckConfigProcess$")
+	cmd.Env = append(os.Environ(), "TEST_CHECK_CONFIG_ARGS=--target-config-path "+filepath.Join(t.TempDir(), "missing.yaml"))
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+		t.Errorf("check-config with missing file = %v, want exit status 1", err)
+	}
+}
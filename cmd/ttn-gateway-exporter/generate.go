@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/generate"
+	"os"
+)
+
+// generateOutput writes a Grafana dashboard or Prometheus alerting rules for the metrics of this exporter version.
+func generateOutput(args []string) {
+	if len(args) == 0 || (args[0] != "dashboard" && args[0] != "rules") {
+		fmt.Fprintln(os.Stderr, "usage: ttn-gateway-exporter generate dashboard|rules [flags]")
+		os.Exit(2)
+	}
+	kind := args[0]
+
+	flags := flag.NewFlagSet("generate "+kind, flag.ExitOnError)
+	output := flags.String("output", "-", "Output file, - for stdout")
+	selector := flags.String("selector", "", "Label matchers added to every selector, e.g. job=\"ttn-gateway-exporter\"")
+	thresholds := generate.DefaultThresholds
+	flags.DurationVar(&thresholds.OfflineAfter, "offline-after", thresholds.OfflineAfter, "How long a gateway may be disconnected or without status")
+	flags.DurationVar(&thresholds.NoUplinkAfter, "no-uplink-after", thresholds.NoUplinkAfter, "How long a gateway may receive no uplinks")
+	flags.Float64Var(&thresholds.SubBandUtilization, "subband-utilization", thresholds.SubBandUtilization, "Share of the duty-cycle limit from which a sub-band is near its limit")
+	flags.DurationVar(&thresholds.MaxClockOffset, "max-clock-offset", thresholds.MaxClockOffset, "Largest clock offset of a synced gateway")
+	flags.DurationVar(&thresholds.MaxRTT, "max-rtt", thresholds.MaxRTT, "Largest median round-trip time")
+	datasource := flags.String("datasource", "Prometheus", "Name of the Prometheus datasource selected by default (dashboard)")
+	title := flags.String("title", "TTN Gateways", "Dashboard title (dashboard)")
+	uid := flags.String("uid", "ttn-gateway-exporter", "Dashboard UID (dashboard)")
+	group := flags.String("group", "ttn-gateway-exporter", "Rule group name (rules)")
+	_ = flags.Parse(args[1:])
+
+	var content []byte
+	var err error
+	switch kind {
+	case "dashboard":
+		content, err = generate.Dashboard(generate.DashboardOptions{Title: *title, UID: *uid, Datasource: *datasource, Thresholds: thresholds, Selector: *selector})
+	case "rules":
+		content, err = generate.Rules(generate.RuleOptions{GroupName: *group, Thresholds: thresholds, Selector: *selector})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "-" {
+		_, err = os.Stdout.Write(content)
+	} else {
+		err = os.WriteFile(*output, content, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
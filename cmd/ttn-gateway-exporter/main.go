@@ -1,19 +1,45 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
 	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/gatewaymap"
 	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/mqttpublisher"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/notifier"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/otlp"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/output"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/publicstatus"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/remotewrite"
 	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/server"
 	"github.com/prometheus/client_golang/prometheus"
+	"os"
+	"time"
 )
 
 var log = logging.Logger("main")
 
+var subcommands = map[string]func(args []string){
+	"check":              runCheck,
+	"check-config":       checkConfig,
+	"generate":           generateOutput,
+	"once":               once,
+	"test-notifications": testNotifications,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			subcommand(os.Args[2:])
+			return
+		}
+	}
+
 	address := flag.String("address", ":8080", "HTTP listener address")
 	targetConfigPath := flag.String("target-config-path", "/etc/ttn-exporter/targets.yaml", "Path to a target config file")
+	metricsEndpoint := flag.Bool("metrics-endpoint", true, "Serve /metrics, disable to only push the metrics")
 	flag.Parse()
 
 	targetConfig, err := config.ReadTargets(*targetConfigPath)
@@ -21,19 +47,126 @@ func main() {
 		log.Fatalw("target config error", "path", *targetConfigPath, "error", err)
 	}
 
+	discovered, err := exporter.DiscoverTenantGateways(context.Background(), targetConfig)
+	if err != nil {
+		log.Fatalw("tenant gateway discovery error", "error", err)
+	}
+	targetConfig.Targets = append(targetConfig.Targets, discovered...)
+
+	var targets []*exporter.Target
 	for _, target := range targetConfig.Targets {
-		targetCollector, err := exporter.NewTarget(target)
+		targetCollector, err := exporter.NewTarget(targetConfig, target)
 		if err != nil {
-			log.Fatalw("error creating target", "id", target.GatewayID, "baseUrl", target.BaseUrl, "apiKeyType", target.APIKey[:5])
+			log.Fatalw("error creating target", "id", target.FullGatewayID(), "baseUrl", target.BaseUrl, "keyId", config.KeyID(target.APIKey), "error", err)
 		}
 		err = prometheus.Register(targetCollector)
 		if err != nil {
-			log.Fatalw("error registering target", "id", target.GatewayID, "error", err)
+			log.Fatalw("error registering target", "id", target.FullGatewayID(), "error", err)
+		}
+		targets = append(targets, targetCollector)
+	}
+
+	keyMonitor, err := exporter.NewKeyMonitor(targetConfig)
+	if err != nil {
+		log.Fatalw("error creating api key monitor", "error", err)
+	}
+	prometheus.MustRegister(keyMonitor)
+	go keyMonitor.Run(context.Background())
+
+	configMonitor := exporter.NewConfigMonitor(targetConfig, targets)
+	prometheus.MustRegister(configMonitor)
+	go configMonitor.Run(context.Background())
+
+	eventMonitor, err := exporter.NewEventMonitor(targetConfig, targets)
+	if err != nil {
+		log.Fatalw("error creating event monitor", "error", err)
+	}
+	prometheus.MustRegister(eventMonitor)
+	go eventMonitor.Run(context.Background())
+
+	if targetConfig.Notifications.Enabled {
+		gatewayNotifier, err := notifier.New(targetConfig)
+		if err != nil {
+			log.Fatalw("error creating notifier", "error", err)
+		}
+		prometheus.MustRegister(gatewayNotifier)
+		poller := exporter.NewPoller(targets, targetConfig.Notifications.Interval)
+		poller.Subscribe(gatewayNotifier.Observe)
+		go poller.Run(context.Background())
+	}
+
+	if targetConfig.MQTTPublisher.Enabled {
+		publisher, err := mqttpublisher.New(targetConfig.MQTTPublisher)
+		if err != nil {
+			log.Fatalw("error creating mqtt publisher", "error", err)
+		}
+		prometheus.MustRegister(publisher)
+		poller := exporter.NewPoller(targets, targetConfig.MQTTPublisher.Interval)
+		poller.Subscribe(publisher.Observe)
+		go poller.Run(context.Background())
+	}
+
+	if targetConfig.OTLP.Enabled {
+		otlpExporter, err := otlp.New(targetConfig.OTLP)
+		if err != nil {
+			log.Fatalw("error creating otlp exporter", "error", err)
+		}
+		prometheus.MustRegister(otlpExporter)
+		poller := exporter.NewPoller(targets, targetConfig.OTLP.Interval)
+		poller.Subscribe(otlpExporter.Push)
+		go poller.Run(context.Background())
+	}
+
+	// outputs with the same interval share a poller, Run keeps a slow output from delaying the others
+	outputPollers := map[time.Duration]*exporter.Poller{}
+	for _, outputConfig := range targetConfig.Outputs {
+		targetOutput, err := output.New(outputConfig)
+		if err != nil {
+			log.Fatalw("error creating output", "output", outputConfig.Name, "error", err)
+		}
+		prometheus.MustRegister(targetOutput)
+		poller, ok := outputPollers[outputConfig.Interval]
+		if !ok {
+			poller = exporter.NewPoller(targets, outputConfig.Interval)
+			outputPollers[outputConfig.Interval] = poller
 		}
+		poller.Subscribe(targetOutput.Push)
+		go targetOutput.Run(context.Background())
+	}
+	for _, poller := range outputPollers {
+		go poller.Run(context.Background())
+	}
+
+	if targetConfig.RemoteWrite.Enabled {
+		remoteWriteClient := remotewrite.New(targetConfig.RemoteWrite, prometheus.DefaultGatherer)
+		prometheus.MustRegister(remoteWriteClient)
+		go remoteWriteClient.Run(context.Background())
 	}
 
+	if targetConfig.PublicStatus.Enabled {
+		publicServer := server.NewPublicServer(targetConfig.PublicStatus.Address)
+		publicstatus.New(targets, targetConfig.PublicStatus).Register(publicServer)
+		go func() {
+			log.Infow("listening for public status", "address", targetConfig.PublicStatus.Address)
+			err := publicServer.ListenAndServe()
+			if err != nil {
+				log.Fatalw("public status listening error", "addr", targetConfig.PublicStatus.Address, "error", err)
+			}
+		}()
+	}
+
+	if !*metricsEndpoint && !targetConfig.Map.Enabled {
+		log.Infow("metrics endpoint disabled, only pushing metrics")
+		select {}
+	}
 	log.Infow("listening", "address", *address)
 	srv := server.NewServer(*address)
+	if !*metricsEndpoint {
+		srv = server.NewPublicServer(*address)
+	}
+	if targetConfig.Map.Enabled {
+		gatewaymap.New(targets, targetConfig.Map).Register(srv)
+	}
 	err = srv.ListenAndServe()
 	if err != nil {
 		log.Fatalw("listening error", "addr", *address, "error", err)
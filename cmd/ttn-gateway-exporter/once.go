@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"os"
+	"time"
+)
+
+// exitSetupError is the exit code of once if the config cannot be read or the output cannot be written. Lower exit
+// codes are the number of failed targets.
+const exitSetupError = 126
+
+// once collects all targets a single time, writes the metrics in the text exposition format to stdout or atomically
+// to a file, e.g. for the textfile collector of node_exporter, and exits with the number of failed targets.
+func once(args []string) {
+	flags := flag.NewFlagSet("once", flag.ExitOnError)
+	targetConfigPath := flags.String("target-config-path", "/etc/ttn-exporter/targets.yaml", "Path to a target config file")
+	output := flags.String("output", "-", "Output file, e.g. /var/lib/node_exporter/textfile/ttn.prom, - for stdout")
+	_ = flags.Parse(args)
+
+	if *output == "-" {
+		logging.SetOutput(os.Stderr)
+	}
+
+	targetConfig, err := config.ReadTargets(*targetConfigPath)
+	if err != nil {
+		log.Errorw("target config error", "path", *targetConfigPath, "error", err)
+		os.Exit(exitSetupError)
+	}
+	discovered, err := exporter.DiscoverTenantGateways(context.Background(), targetConfig)
+	if err != nil {
+		log.Errorw("tenant gateway discovery error", "error", err)
+		os.Exit(exitSetupError)
+	}
+	targetConfig.Targets = append(targetConfig.Targets, discovered...)
+
+	registry := prometheus.NewRegistry()
+	var targets []*exporter.Target
+	for _, target := range targetConfig.Targets {
+		targetCollector, err := exporter.NewTarget(targetConfig, target)
+		if err == nil {
+			err = registry.Register(targetCollector)
+		}
+		if err != nil {
+			log.Errorw("error creating target", "id", target.FullGatewayID(), "error", err)
+			os.Exit(exitSetupError)
+		}
+		targets = append(targets, targetCollector)
+	}
+
+	startedAt := time.Now()
+	families, err := registry.Gather()
+	if err != nil {
+		log.Errorw("error collecting targets", "error", err)
+	}
+
+	// the statuses were just read by the collectors, a disconnected gateway is not a failure
+	failed := 0
+	for _, targetStatus := range exporter.Statuses(context.Background(), targets, time.Hour) {
+		if targetStatus.Err != nil && !errors.Is(targetStatus.Err, exporter.ErrNotConnected) {
+			failed++
+		}
+	}
+
+	runRegistry := prometheus.NewRegistry()
+	runGauge := func(name, help string, value float64) {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "ttn", Subsystem: "gateway_exporter", Name: name, Help: help})
+		gauge.Set(value)
+		runRegistry.MustRegister(gauge)
+	}
+	runGauge("run_timestamp_seconds", "Time the metrics were collected by ttn-gateway-exporter once", float64(startedAt.Unix()))
+	runGauge("run_duration_seconds", "Time it took to collect all targets", time.Since(startedAt).Seconds())
+	runGauge("failed_targets", "Number of targets whose status could not be read", float64(failed))
+	runFamilies, err := runRegistry.Gather()
+	if err != nil {
+		log.Errorw("error collecting run metrics", "error", err)
+	}
+	families = append(families, runFamilies...)
+
+	if *output == "-" {
+		err = writeText(os.Stdout, families)
+	} else {
+		err = prometheus.WriteToTextfile(*output, prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return families, nil
+		}))
+	}
+	if err != nil {
+		log.Errorw("output error", "output", *output, "error", err)
+		os.Exit(exitSetupError)
+	}
+
+	if failed > 0 {
+		log.Warnw("targets failed", "failed", failed, "targets", len(targets))
+		if failed > exitSetupError-1 {
+			failed = exitSetupError - 1
+		}
+		os.Exit(failed)
+	}
+}
+
+func writeText(out *os.File, families []*dto.MetricFamily) error {
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(out, family); err != nil {
+			return fmt.Errorf("writing %s: %w", family.GetName(), err)
+		}
+	}
+	return nil
+}
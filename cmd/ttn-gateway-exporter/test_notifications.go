@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/notifier"
+	"os"
+	"time"
+)
+
+// testNotifications renders an example notification with the configured templates and sends it to every configured
+// recipient, or to the default recipient of a single sink. It exits non-zero if a delivery failed.
+func testNotifications(args []string) {
+	flags := flag.NewFlagSet("test-notifications", flag.ExitOnError)
+	targetConfigPath := flags.String("target-config-path", "/etc/ttn-exporter/targets.yaml", "Path to a target config file")
+	sinkName := flags.String("sink", "", "Only send to the default recipient of this sink")
+	event := flags.String("event", "offline", "Event of the example notification")
+	gatewayID := flags.String("gateway-id", "example-gateway", "Gateway ID of the example notification")
+	timeout := flags.Duration("timeout", 30*time.Second, "Timeout per delivery")
+	_ = flags.Parse(args)
+
+	targetConfig, err := config.ReadTargets(*targetConfigPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid config\n%s\n", *targetConfigPath, err)
+		os.Exit(1)
+	}
+	gatewayNotifier, err := notifier.New(targetConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	notification := notifier.Notification{
+		Event:       *event,
+		GatewayID:   *gatewayID,
+		Since:       now.Add(-targetConfig.Notifications.HoldDown),
+		LastSeenAt:  now.Add(-targetConfig.Notifications.HoldDown),
+		ClockOffset: 2 * targetConfig.Health.MaxClockOffset,
+		Transitions: targetConfig.Notifications.FlappingTransitions,
+		Window:      targetConfig.Notifications.FlappingWindow,
+		Time:        now,
+	}
+	title, body, err := gatewayNotifier.Render(notification)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "template error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s\n%s\n\n", title, body)
+
+	var recipients []config.Recipient
+	if *sinkName != "" {
+		recipients = append(recipients, config.Recipient{Sink: *sinkName})
+	} else {
+		seen := map[config.Recipient]bool{}
+		for _, target := range append(targetConfig.Targets, targetConfig.TenantDiscovery...) {
+			for _, recipient := range targetConfig.NotificationRecipients(target) {
+				if !seen[recipient] {
+					seen[recipient] = true
+					recipients = append(recipients, recipient)
+				}
+			}
+		}
+	}
+
+	failed := 0
+	for _, recipient := range recipients {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		err := gatewayNotifier.Send(ctx, recipient, title, body, notification)
+		cancel()
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", recipient.Sink, recipient.To, err)
+			continue
+		}
+		fmt.Printf("%s %s: sent\n", recipient.Sink, recipient.To)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d deliveries failed\n", failed, len(recipients))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,209 @@
+// Package check evaluates gateways against thresholds and formats the result as monitoring plugin output for Nagios
+// and Icinga.
+package check
+
+import (
+	"errors"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is a monitoring plugin state, its value is the exit code.
+type State int
+
+const (
+	OK       State = 0
+	Warning  State = 1
+	Critical State = 2
+	Unknown  State = 3
+)
+
+func (s State) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case Warning:
+		return "WARNING"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// severity orders the states for the overall result, an unknown gateway is worse than a warning.
+var severity = map[State]int{OK: 0, Warning: 1, Unknown: 2, Critical: 3}
+
+// Worse returns the more severe state.
+func Worse(a, b State) State {
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+// Thresholds are the warning and critical limits, zero disables a limit.
+type Thresholds struct {
+	StatusAgeWarning, StatusAgeCritical time.Duration
+	UplinkAgeWarning, UplinkAgeCritical time.Duration
+	RTTWarning, RTTCritical             time.Duration
+	// SubBandWarning and SubBandCritical are shares of the duty-cycle limit of a sub-band
+	SubBandWarning, SubBandCritical float64
+}
+
+// Result is the evaluation of a single gateway.
+type Result struct {
+	GatewayID string
+	State     State
+	// Problems describe the values beyond a threshold
+	Problems []string
+	PerfData []PerfData
+}
+
+// PerfData is a performance data item of the plugin output.
+type PerfData struct {
+	Label             string
+	Value             float64
+	Unit              string
+	Warning, Critical float64
+	Min, Max          *float64
+}
+
+func (p PerfData) String() string {
+	limit := func(value float64) string {
+		if value == 0 {
+			return ""
+		}
+		return formatFloat(value)
+	}
+	bound := func(value *float64) string {
+		if value == nil {
+			return ""
+		}
+		return formatFloat(*value)
+	}
+	return fmt.Sprintf("'%s'=%s%s;%s;%s;%s;%s", strings.ReplaceAll(p.Label, "'", "''"), formatFloat(p.Value), p.Unit,
+		limit(p.Warning), limit(p.Critical), bound(p.Min), bound(p.Max))
+}
+
+// Evaluate checks the status of a gateway, using the metrics the exporter derives from it.
+func Evaluate(target *exporter.Target, status exporter.GatewayStatus, err error, thresholds Thresholds) Result {
+	result := Result{GatewayID: target.Config().FullGatewayID(), State: OK}
+	if err != nil && !errors.Is(err, exporter.ErrNotConnected) {
+		result.State = Unknown
+		result.Problems = append(result.Problems, fmt.Sprintf("status could not be read: %s", err))
+		return result
+	}
+
+	samples := map[string][]exporter.Sample{}
+	for _, sample := range target.Samples(status, err) {
+		samples[sample.Definition.Key] = append(samples[sample.Definition.Key], sample)
+	}
+	if connected := samples["connected"]; len(connected) > 0 && connected[0].Value == 0 {
+		result.State = Critical
+		result.Problems = append(result.Problems, "disconnected")
+	}
+
+	zero := 0.0
+	age := func(key, name string, warning, critical time.Duration) {
+		for _, sample := range samples[key] {
+			result.add(name, sample.Value, warning.Seconds(), critical.Seconds(), func(value float64) string {
+				return (time.Duration(value) * time.Second).String()
+			})
+			result.PerfData = append(result.PerfData, PerfData{Label: result.GatewayID + " " + name, Value: sample.Value, Unit: "s",
+				Warning: warning.Seconds(), Critical: critical.Seconds(), Min: &zero})
+		}
+	}
+	age("since_last_status", "status_age", thresholds.StatusAgeWarning, thresholds.StatusAgeCritical)
+	age("since_last_uplink", "uplink_age", thresholds.UplinkAgeWarning, thresholds.UplinkAgeCritical)
+
+	for _, sample := range samples["rtt_median"] {
+		rtt := time.Duration(sample.Value).Seconds()
+		result.add("rtt", rtt, thresholds.RTTWarning.Seconds(), thresholds.RTTCritical.Seconds(), func(value float64) string {
+			return time.Duration(value * float64(time.Second)).String()
+		})
+		result.PerfData = append(result.PerfData, PerfData{Label: result.GatewayID + " rtt", Value: rtt, Unit: "s",
+			Warning: thresholds.RTTWarning.Seconds(), Critical: thresholds.RTTCritical.Seconds(), Min: &zero})
+	}
+
+	limits := map[string]float64{}
+	for _, sample := range samples["subband_utilization_limit"] {
+		limits[subBand(sample)] = sample.Value
+	}
+	hundred := 100.0
+	for _, sample := range samples["subband_utilization"] {
+		limit := limits[subBand(sample)]
+		if limit <= 0 {
+			continue
+		}
+		used := sample.Value / limit * 100
+		name := "subband " + subBand(sample)
+		result.add(name+" limit used", used, thresholds.SubBandWarning*100, thresholds.SubBandCritical*100, func(value float64) string {
+			return formatFloat(value) + "%"
+		})
+		result.PerfData = append(result.PerfData, PerfData{Label: result.GatewayID + " " + name, Value: used, Unit: "%",
+			Warning: thresholds.SubBandWarning * 100, Critical: thresholds.SubBandCritical * 100, Min: &zero, Max: &hundred})
+	}
+	return result
+}
+
+// add compares a value with its limits and records a problem if it exceeds one.
+func (r *Result) add(name string, value, warning, critical float64, format func(float64) string) {
+	switch {
+	case critical > 0 && value >= critical:
+		r.State = Worse(r.State, Critical)
+		r.Problems = append(r.Problems, fmt.Sprintf("%s %s >= %s", name, format(value), format(critical)))
+	case warning > 0 && value >= warning:
+		r.State = Worse(r.State, Warning)
+		r.Problems = append(r.Problems, fmt.Sprintf("%s %s >= %s", name, format(value), format(warning)))
+	}
+}
+
+// Output formats the results as plugin output: a summary line with the performance data of all gateways, followed by
+// one line per gateway. It returns the overall state.
+func Output(results []Result) (string, State) {
+	state := OK
+	counts := map[State]int{}
+	var problems, perfData, details []string
+	for _, result := range results {
+		state = Worse(state, result.State)
+		counts[result.State]++
+		if len(result.Problems) > 0 {
+			problems = append(problems, result.GatewayID+": "+strings.Join(result.Problems, ", "))
+		}
+		for _, item := range result.PerfData {
+			perfData = append(perfData, item.String())
+		}
+		detail := "[" + result.State.String() + "] " + result.GatewayID
+		if len(result.Problems) > 0 {
+			detail += ": " + strings.Join(result.Problems, ", ")
+		}
+		details = append(details, detail)
+	}
+	sort.Strings(details)
+
+	summary := fmt.Sprintf("%d of %d gateways OK", counts[OK], len(results))
+	if len(problems) > 0 {
+		summary = strings.Join(problems, "; ")
+	}
+	out := "TTN GATEWAY " + state.String() + " - " + summary
+	if len(perfData) > 0 {
+		out += " | " + strings.Join(perfData, " ")
+	}
+	return out + "\n" + strings.Join(details, "\n") + "\n", state
+}
+
+// subBand identifies the sub-band of a sample by its frequency range.
+func subBand(sample exporter.Sample) string {
+	return sample.Labels["freqMin"] + "-" + sample.Labels["freqMax"]
+}
+
+// formatFloat formats a value with at most 3 decimals.
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64)
+}
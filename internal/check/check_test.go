@@ -0,0 +1,213 @@
+package check
+
+import (
+	"errors"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPerfData(t *testing.T) {
+	zero, hundred := 0.0, 100.0
+	tests := []struct {
+		perfData PerfData
+		want     string
+	}{
+		{PerfData{Label: "my-gateway rtt", Value: 0.04, Unit: "s", Warning: 0.1, Critical: 0.5, Min: &zero}, "'my-gateway rtt'=0.04s;0.1;0.5;0;"},
+		{PerfData{Label: "gateway's subband", Value: 12.34567, Unit: "%", Warning: 80, Min: &zero, Max: &hundred}, "'gateway''s subband'=12.346%;80;;0;100"},
+		{PerfData{Label: "uplinks", Value: 2}, "'uplinks'=2;;;;"},
+	}
+	for _, test := range tests {
+		if got := test.perfData.String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	target, err := exporter.NewTarget(config.TargetConfig{}, config.Target{GatewayID: "my-gateway", APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN})
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+	thresholds := Thresholds{RTTWarning: 100 * time.Millisecond, RTTCritical: 500 * time.Millisecond, SubBandWarning: 0.5, SubBandCritical: 0.9}
+	rtt := func(median time.Duration) *exporter.RoundTripTimes {
+		return &exporter.RoundTripTimes{Min: median, Median: median, Max: median, Count: 10}
+	}
+	subBand := func(utilization float64) []exporter.SubBand {
+		return []exporter.SubBand{
+			{MinFrequency: 863000000, MaxFrequency: 865000000, DownlinkUtilizationLimit: 0.5, DownlinkUtilization: utilization},
+			// without limit, the share can't be computed
+			{MinFrequency: 869400000, MaxFrequency: 869650000, DownlinkUtilization: 0.5},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		status       exporter.GatewayStatus
+		err          error
+		wantState    State
+		wantProblems []string
+		wantPerfData []string
+	}{
+		{
+			name:         "ok",
+			status:       exporter.GatewayStatus{Connected: true, RoundTripTimes: rtt(40 * time.Millisecond)},
+			wantState:    OK,
+			wantPerfData: []string{"'my-gateway rtt'=0.04s;0.1;0.5;0;"},
+		},
+		{
+			name:         "warning at the threshold",
+			status:       exporter.GatewayStatus{Connected: true, RoundTripTimes: rtt(100 * time.Millisecond)},
+			wantState:    Warning,
+			wantProblems: []string{"rtt 100ms >= 100ms"},
+			wantPerfData: []string{"'my-gateway rtt'=0.1s;0.1;0.5;0;"},
+		},
+		{
+			name:         "below the warning threshold",
+			status:       exporter.GatewayStatus{Connected: true, RoundTripTimes: rtt(99 * time.Millisecond)},
+			wantState:    OK,
+			wantPerfData: []string{"'my-gateway rtt'=0.099s;0.1;0.5;0;"},
+		},
+		{
+			name:         "critical at the threshold",
+			status:       exporter.GatewayStatus{Connected: true, RoundTripTimes: rtt(500 * time.Millisecond)},
+			wantState:    Critical,
+			wantProblems: []string{"rtt 500ms >= 500ms"},
+			wantPerfData: []string{"'my-gateway rtt'=0.5s;0.1;0.5;0;"},
+		},
+		{
+			name:         "disconnected",
+			status:       exporter.GatewayStatus{Connected: false, RoundTripTimes: rtt(40 * time.Millisecond)},
+			wantState:    Critical,
+			wantProblems: []string{"disconnected"},
+			wantPerfData: []string{"'my-gateway rtt'=0.04s;0.1;0.5;0;"},
+		},
+		{
+			name:         "not connected",
+			err:          exporter.ErrNotConnected,
+			wantState:    Critical,
+			wantProblems: []string{"disconnected"},
+		},
+		{
+			name:         "read error",
+			err:          errors.New("context deadline exceeded"),
+			wantState:    Unknown,
+			wantProblems: []string{"status could not be read: context deadline exceeded"},
+		},
+		{
+			name:         "sub-band below the warning threshold",
+			status:       exporter.GatewayStatus{Connected: true, SubBands: subBand(0.125)},
+			wantState:    OK,
+			wantPerfData: []string{"'my-gateway subband 863000000-865000000'=25%;50;90;0;100"},
+		},
+		{
+			name:         "sub-band at the warning threshold",
+			status:       exporter.GatewayStatus{Connected: true, SubBands: subBand(0.25)},
+			wantState:    Warning,
+			wantProblems: []string{"subband 863000000-865000000 limit used 50% >= 50%"},
+			wantPerfData: []string{"'my-gateway subband 863000000-865000000'=50%;50;90;0;100"},
+		},
+		{
+			name:         "sub-band beyond the critical threshold",
+			status:       exporter.GatewayStatus{Connected: true, SubBands: subBand(0.5)},
+			wantState:    Critical,
+			wantProblems: []string{"subband 863000000-865000000 limit used 100% >= 90%"},
+			wantPerfData: []string{"'my-gateway subband 863000000-865000000'=100%;50;90;0;100"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := Evaluate(target, test.status, test.err, thresholds)
+			if result.GatewayID != "my-gateway" || result.State != test.wantState {
+				t.Errorf("result = %s %s, want my-gateway %s", result.GatewayID, result.State, test.wantState)
+			}
+			if !reflect.DeepEqual(result.Problems, test.wantProblems) {
+				t.Errorf("problems = %q, want %q", result.Problems, test.wantProblems)
+			}
+			var perfData []string
+			for _, item := range result.PerfData {
+				perfData = append(perfData, item.String())
+			}
+			if !reflect.DeepEqual(perfData, test.wantPerfData) {
+				t.Errorf("perfdata = %q, want %q", perfData, test.wantPerfData)
+			}
+		})
+	}
+}
+
+func TestEvaluateAges(t *testing.T) {
+	target, err := exporter.NewTarget(config.TargetConfig{}, config.Target{GatewayID: "my-gateway", APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN})
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+	now := time.Now()
+	status := exporter.GatewayStatus{Connected: true, LastStatusReceivedAt: now.Add(-10 * time.Minute), LastUplinkReceivedAt: now.Add(-time.Hour)}
+	thresholds := Thresholds{
+		StatusAgeWarning: 5 * time.Minute, StatusAgeCritical: 15 * time.Minute,
+		UplinkAgeWarning: 10 * time.Minute, UplinkAgeCritical: 30 * time.Minute,
+	}
+	result := Evaluate(target, status, nil, thresholds)
+	if result.State != Critical {
+		t.Errorf("state = %s, want CRITICAL", result.State)
+	}
+	want := []string{"status_age 10m0s >= 5m0s", "uplink_age 1h0m0s >= 30m0s"}
+	if !reflect.DeepEqual(result.Problems, want) {
+		t.Errorf("problems = %q, want %q", result.Problems, want)
+	}
+	if len(result.PerfData) != 2 || !strings.HasSuffix(result.PerfData[0].String(), "s;300;900;0;") || !strings.HasSuffix(result.PerfData[1].String(), "s;600;1800;0;") {
+		t.Errorf("perfdata = %v, want the ages with thresholds in seconds", result.PerfData)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	zero := 0.0
+	ok := func(id string) Result {
+		return Result{GatewayID: id, State: OK, PerfData: []PerfData{{Label: id + " rtt", Value: 0.04, Unit: "s", Min: &zero}}}
+	}
+	out, state := Output([]Result{ok("gateway-b"), ok("gateway-a")})
+	want := "TTN GATEWAY OK - 2 of 2 gateways OK | 'gateway-b rtt'=0.04s;;;0; 'gateway-a rtt'=0.04s;;;0;\n" +
+		"[OK] gateway-a\n[OK] gateway-b\n"
+	if out != want || state != OK {
+		t.Errorf("Output = %q, %s, want %q, OK", out, state, want)
+	}
+
+	warning := Result{GatewayID: "gateway-w", State: Warning, Problems: []string{"rtt 100ms >= 100ms"}}
+	unknown := Result{GatewayID: "gateway-u", State: Unknown, Problems: []string{"status could not be read: timeout"}}
+	critical := Result{GatewayID: "gateway-c", State: Critical, Problems: []string{"disconnected"}}
+	tests := []struct {
+		name      string
+		results   []Result
+		wantState State
+	}{
+		{name: "warning", results: []Result{ok("gateway-a"), warning}, wantState: Warning},
+		{name: "unknown is worse than warning", results: []Result{warning, unknown, ok("gateway-a")}, wantState: Unknown},
+		{name: "critical is worse than unknown", results: []Result{unknown, critical, warning}, wantState: Critical},
+		{name: "no gateways", wantState: OK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out, state := Output(test.results)
+			if state != test.wantState {
+				t.Errorf("state = %s, want %s", state, test.wantState)
+			}
+			if !strings.HasPrefix(out, "TTN GATEWAY "+test.wantState.String()+" - ") {
+				t.Errorf("output %q does not start with the state", out)
+			}
+			for _, result := range test.results {
+				if len(result.Problems) > 0 && !strings.Contains(strings.SplitN(out, "\n", 2)[0], result.GatewayID+": "+result.Problems[0]) {
+					t.Errorf("summary of %q lacks the problem of %s", out, result.GatewayID)
+				}
+			}
+		})
+	}
+
+	out, _ = Output([]Result{warning, critical})
+	want = "TTN GATEWAY CRITICAL - gateway-w: rtt 100ms >= 100ms; gateway-c: disconnected\n" +
+		"[CRITICAL] gateway-c: disconnected\n[WARNING] gateway-w: rtt 100ms >= 100ms\n"
+	if out != want {
+		t.Errorf("Output = %q, want %q", out, want)
+	}
+}
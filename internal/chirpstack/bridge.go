@@ -0,0 +1,288 @@
+package chirpstack
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"time"
+)
+
+// GatewayStats is a stats event published by ChirpStack Gateway Bridge on gateway/{eui}/event/stats.
+// https://github.com/chirpstack/chirpstack/blob/master/api/proto/gw/gw.proto
+type GatewayStats struct {
+	GatewayID           string
+	Time                time.Time
+	Location            *Location
+	RxPacketsReceived   uint32
+	RxPacketsReceivedOk uint32
+	TxPacketsReceived   uint32
+	TxPacketsEmitted    uint32
+	Metadata            map[string]string
+}
+
+// ConnState is the connection state published by ChirpStack Gateway Bridge on gateway/{eui}/state/conn.
+// https://github.com/chirpstack/chirpstack/blob/master/api/proto/gw/gw.proto
+type ConnState struct {
+	GatewayID string
+	Online    bool
+}
+
+type jsonGatewayStats struct {
+	GatewayID           string            `json:"gatewayId"`
+	GatewayIDLegacy     []byte            `json:"gatewayIdLegacy"`
+	Time                *time.Time        `json:"time"`
+	Location            *Location         `json:"location"`
+	RxPacketsReceived   uint32            `json:"rxPacketsReceived"`
+	RxPacketsReceivedOk uint32            `json:"rxPacketsReceivedOk"`
+	RxPacketsReceivedOK uint32            `json:"rxPacketsReceivedOK"`
+	TxPacketsReceived   uint32            `json:"txPacketsReceived"`
+	TxPacketsEmitted    uint32            `json:"txPacketsEmitted"`
+	Metadata            map[string]string `json:"metadata"`
+}
+
+type jsonConnState struct {
+	GatewayID       string `json:"gatewayId"`
+	GatewayIDLegacy []byte `json:"gatewayIdLegacy"`
+	State           string `json:"state"`
+}
+
+// DecodeGatewayStats decodes a stats event encoded with the json or protobuf marshaler of the Gateway Bridge.
+func DecodeGatewayStats(payload []byte, marshaler string) (GatewayStats, error) {
+	switch marshaler {
+	case "json":
+		var decoded jsonGatewayStats
+		if err := json.Unmarshal(payload, &decoded); err != nil {
+			return GatewayStats{}, err
+		}
+		stats := GatewayStats{
+			GatewayID:           gatewayID(decoded.GatewayID, decoded.GatewayIDLegacy),
+			Location:            decoded.Location,
+			RxPacketsReceived:   decoded.RxPacketsReceived,
+			RxPacketsReceivedOk: decoded.RxPacketsReceivedOk + decoded.RxPacketsReceivedOK,
+			TxPacketsReceived:   decoded.TxPacketsReceived,
+			TxPacketsEmitted:    decoded.TxPacketsEmitted,
+			Metadata:            decoded.Metadata,
+		}
+		if decoded.Time != nil {
+			stats.Time = *decoded.Time
+		}
+		return stats, nil
+	case "protobuf":
+		return decodeGatewayStatsProtobuf(payload)
+	default:
+		return GatewayStats{}, fmt.Errorf("unknown marshaler %q", marshaler)
+	}
+}
+
+// DecodeConnState decodes a connection state message encoded with the json or protobuf marshaler of the Gateway Bridge.
+func DecodeConnState(payload []byte, marshaler string) (ConnState, error) {
+	switch marshaler {
+	case "json":
+		var decoded jsonConnState
+		if err := json.Unmarshal(payload, &decoded); err != nil {
+			return ConnState{}, err
+		}
+		return ConnState{
+			GatewayID: gatewayID(decoded.GatewayID, decoded.GatewayIDLegacy),
+			Online:    decoded.State == "ONLINE",
+		}, nil
+	case "protobuf":
+		return decodeConnStateProtobuf(payload)
+	default:
+		return ConnState{}, fmt.Errorf("unknown marshaler %q", marshaler)
+	}
+}
+
+// gatewayID prefers the string gateway ID of ChirpStack v4 over the legacy byte encoded EUI of v3.
+func gatewayID(id string, legacy []byte) string {
+	if id != "" {
+		return id
+	}
+	return hex.EncodeToString(legacy)
+}
+
+// protobuf field numbers of gw.GatewayStats
+const (
+	statsGatewayIDLegacy     = 1
+	statsTime                = 2
+	statsLocation            = 3
+	statsRxPacketsReceived   = 5
+	statsRxPacketsReceivedOk = 6
+	statsTxPacketsReceived   = 7
+	statsTxPacketsEmitted    = 8
+	statsMetadata            = 10
+	statsGatewayID           = 17
+)
+
+func decodeGatewayStatsProtobuf(payload []byte) (GatewayStats, error) {
+	var stats GatewayStats
+	var legacyID []byte
+	err := walkFields(payload, func(number protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case number == statsGatewayIDLegacy && typ == protowire.BytesType:
+			legacyID = value
+		case number == statsGatewayID && typ == protowire.BytesType:
+			stats.GatewayID = string(value)
+		case number == statsTime && typ == protowire.BytesType:
+			t, err := decodeTimestamp(value)
+			if err != nil {
+				return err
+			}
+			stats.Time = t
+		case number == statsLocation && typ == protowire.BytesType:
+			location, err := decodeLocation(value)
+			if err != nil {
+				return err
+			}
+			stats.Location = &location
+		case number == statsRxPacketsReceived && typ == protowire.VarintType:
+			stats.RxPacketsReceived = uint32(varint)
+		case number == statsRxPacketsReceivedOk && typ == protowire.VarintType:
+			stats.RxPacketsReceivedOk = uint32(varint)
+		case number == statsTxPacketsReceived && typ == protowire.VarintType:
+			stats.TxPacketsReceived = uint32(varint)
+		case number == statsTxPacketsEmitted && typ == protowire.VarintType:
+			stats.TxPacketsEmitted = uint32(varint)
+		case number == statsMetadata && typ == protowire.BytesType:
+			key, entryValue, err := decodeStringMapEntry(value)
+			if err != nil {
+				return err
+			}
+			if stats.Metadata == nil {
+				stats.Metadata = map[string]string{}
+			}
+			stats.Metadata[key] = entryValue
+		}
+		return nil
+	})
+	stats.GatewayID = gatewayID(stats.GatewayID, legacyID)
+	return stats, err
+}
+
+// protobuf field numbers of gw.ConnState
+const (
+	connStateGatewayIDLegacy = 1
+	connStateState           = 2
+	connStateGatewayID       = 3
+)
+
+func decodeConnStateProtobuf(payload []byte) (ConnState, error) {
+	var state ConnState
+	var legacyID []byte
+	err := walkFields(payload, func(number protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case number == connStateGatewayIDLegacy && typ == protowire.BytesType:
+			legacyID = value
+		case number == connStateGatewayID && typ == protowire.BytesType:
+			state.GatewayID = string(value)
+		case number == connStateState && typ == protowire.VarintType:
+			// ConnState.State: OFFLINE = 0, ONLINE = 1
+			state.Online = varint == 1
+		}
+		return nil
+	})
+	state.GatewayID = gatewayID(state.GatewayID, legacyID)
+	return state, err
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp.
+func decodeTimestamp(payload []byte) (time.Time, error) {
+	var seconds, nanos int64
+	err := walkFields(payload, func(number protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case number == 1 && typ == protowire.VarintType:
+			seconds = int64(varint)
+		case number == 2 && typ == protowire.VarintType:
+			nanos = int64(int32(varint))
+		}
+		return nil
+	})
+	return time.Unix(seconds, nanos), err
+}
+
+// decodeLocation decodes a common.Location.
+func decodeLocation(payload []byte) (Location, error) {
+	var location Location
+	err := walkFields(payload, func(number protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case number == 1 && typ == protowire.Fixed64Type:
+			location.Latitude = math.Float64frombits(varint)
+		case number == 2 && typ == protowire.Fixed64Type:
+			location.Longitude = math.Float64frombits(varint)
+		case number == 3 && typ == protowire.Fixed64Type:
+			location.Altitude = math.Float64frombits(varint)
+		case number == 4 && typ == protowire.VarintType:
+			location.Source = locationSources[varint]
+		case number == 5 && typ == protowire.Fixed32Type:
+			location.Accuracy = float64(math.Float32frombits(uint32(varint)))
+		case number == 5 && typ == protowire.VarintType:
+			location.Accuracy = float64(varint)
+		}
+		return nil
+	})
+	return location, err
+}
+
+// common.LocationSource
+var locationSources = map[uint64]string{
+	0: "UNKNOWN",
+	1: "GPS",
+	2: "CONFIG",
+	3: "GEO_RESOLVER_TDOA",
+	4: "GEO_RESOLVER_RSSI",
+	5: "GEO_RESOLVER_GNSS",
+	6: "GEO_RESOLVER_WIFI",
+}
+
+func decodeStringMapEntry(payload []byte) (key, value string, err error) {
+	err = walkFields(payload, func(number protowire.Number, typ protowire.Type, fieldValue []byte, varint uint64) error {
+		switch {
+		case number == 1 && typ == protowire.BytesType:
+			key = string(fieldValue)
+		case number == 2 && typ == protowire.BytesType:
+			value = string(fieldValue)
+		}
+		return nil
+	})
+	return key, value, err
+}
+
+// walkFields calls fn for every field of a protobuf message. Length-delimited fields are passed as value, varint and
+// fixed size fields as varint.
+func walkFields(payload []byte, fn func(number protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(payload) > 0 {
+		number, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		payload = payload[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(payload)
+		case protowire.Fixed32Type:
+			var fixed uint32
+			fixed, n = protowire.ConsumeFixed32(payload)
+			varint = uint64(fixed)
+		case protowire.Fixed64Type:
+			varint, n = protowire.ConsumeFixed64(payload)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(payload)
+		default:
+			n = protowire.ConsumeFieldValue(number, typ, payload)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		payload = payload[n:]
+
+		if err := fn(number, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package chirpstack
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsJSON is the JSON encoding of testdata/stats.pb.hex.
+const statsJSON = `{"gatewayId":"0102030405060708","time":"2026-10-19T08:15:30.250Z","location":{"latitude":49.1401,` +
+	`"longitude":9.2201,"altitude":190,"source":"GPS","accuracy":12.5},"configVersion":"1.2.3","rxPacketsReceived":12,` +
+	`"rxPacketsReceivedOk":9,"txPacketsReceived":3,"txPacketsEmitted":2,"metadata":{"concentratord_version":"4.3.0",` +
+	`"model":"rak7268"},"txPacketsPerFrequency":{"868100000":2}}`
+
+// readFixture returns a hex encoded payload of testdata. The protobuf payloads are gw.GatewayStats and gw.ConnState
+// messages as published by ChirpStack Gateway Bridge v4, encoded with the protobuf runtime from the field definitions
+// of gw.proto. stats.pb.hex includes fields the exporter doesn't read, config_version and tx_packets_per_frequency,
+// which must be skipped. The legacy payloads only have the byte encoded gateway_id_legacy of ChirpStack v3. The
+// exporter tests share these fixtures.
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	decoded, err := hex.DecodeString(strings.Join(strings.Fields(string(content)), ""))
+	if err != nil {
+		t.Fatalf("invalid fixture %s: %v", name, err)
+	}
+	return decoded
+}
+
+func TestDecodeGatewayStats(t *testing.T) {
+	full := GatewayStats{
+		GatewayID: "0102030405060708",
+		Time:      time.Date(2026, 10, 19, 8, 15, 30, 250000000, time.UTC),
+		Location: &Location{
+			Latitude:  49.1401,
+			Longitude: 9.2201,
+			Altitude:  190,
+			Source:    "GPS",
+			Accuracy:  12.5,
+		},
+		RxPacketsReceived:   12,
+		RxPacketsReceivedOk: 9,
+		TxPacketsReceived:   3,
+		TxPacketsEmitted:    2,
+		Metadata:            map[string]string{"concentratord_version": "4.3.0", "model": "rak7268"},
+	}
+	tests := []struct {
+		name      string
+		payload   []byte
+		marshaler string
+		want      GatewayStats
+	}{
+		{name: "protobuf", payload: readFixture(t, "stats.pb.hex"), marshaler: "protobuf", want: full},
+		{name: "json", payload: []byte(statsJSON), marshaler: "json", want: full},
+		{name: "protobuf legacy gateway id", payload: readFixture(t, "stats-legacy.pb.hex"), marshaler: "protobuf", want: GatewayStats{
+			GatewayID:           "0102030405060708",
+			RxPacketsReceived:   4,
+			RxPacketsReceivedOk: 3,
+		}},
+		{name: "json legacy gateway id", payload: []byte(`{"gatewayIdLegacy":"AQIDBAUGBwg=","rxPacketsReceivedOK":3}`), marshaler: "json", want: GatewayStats{
+			GatewayID:           "0102030405060708",
+			RxPacketsReceivedOk: 3,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeGatewayStats(test.payload, test.marshaler)
+			if err != nil {
+				t.Fatalf("DecodeGatewayStats: %v", err)
+			}
+			if !got.Time.Equal(test.want.Time) {
+				t.Errorf("Time = %s, want %s", got.Time, test.want.Time)
+			}
+			got.Time, test.want.Time = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DecodeGatewayStats = %+v, want %+v", got, test.want)
+			}
+			if !reflect.DeepEqual(got.Location, test.want.Location) {
+				t.Errorf("Location = %+v, want %+v", got.Location, test.want.Location)
+			}
+		})
+	}
+}
+
+func TestDecodeConnState(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   []byte
+		marshaler string
+		want      ConnState
+	}{
+		{name: "protobuf online", payload: readFixture(t, "conn-online.pb.hex"), marshaler: "protobuf", want: ConnState{GatewayID: "0102030405060708", Online: true}},
+		// OFFLINE is the zero value, so the state field is omitted
+		{name: "protobuf offline", payload: readFixture(t, "conn-offline.pb.hex"), marshaler: "protobuf", want: ConnState{GatewayID: "0102030405060708"}},
+		{name: "protobuf legacy gateway id", payload: readFixture(t, "conn-legacy-online.pb.hex"), marshaler: "protobuf", want: ConnState{GatewayID: "0102030405060708", Online: true}},
+		{name: "json online", payload: []byte(`{"gatewayId":"0102030405060708","state":"ONLINE"}`), marshaler: "json", want: ConnState{GatewayID: "0102030405060708", Online: true}},
+		{name: "json offline", payload: []byte(`{"gatewayIdLegacy":"","gatewayId":"0102030405060708","state":"OFFLINE"}`), marshaler: "json", want: ConnState{GatewayID: "0102030405060708"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeConnState(test.payload, test.marshaler)
+			if err != nil {
+				t.Fatalf("DecodeConnState: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("DecodeConnState = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	truncated := readFixture(t, "stats.pb.hex")
+	truncated = truncated[:len(truncated)-4]
+	if _, err := DecodeGatewayStats(truncated, "protobuf"); err == nil {
+		t.Error("DecodeGatewayStats of a truncated payload succeeded")
+	}
+	if _, err := DecodeGatewayStats([]byte(statsJSON), "protobuf"); err == nil {
+		t.Error("DecodeGatewayStats of JSON with the protobuf marshaler succeeded")
+	}
+	if _, err := DecodeGatewayStats(readFixture(t, "stats.pb.hex"), "json"); err == nil {
+		t.Error("DecodeGatewayStats of protobuf with the json marshaler succeeded")
+	}
+	if _, err := DecodeConnState([]byte(`{}`), "xml"); err == nil {
+		t.Error("DecodeConnState with an unknown marshaler succeeded")
+	}
+}
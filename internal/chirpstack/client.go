@@ -0,0 +1,87 @@
+package chirpstack
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// Client talks to the REST API of ChirpStack v4, as served by the chirpstack-rest-api gRPC gateway.
+type Client struct {
+	baseUrl  url.URL
+	apiToken string
+	http     *http.Client
+}
+
+// NewClient creates a client for the ChirpStack REST API at baseUrl. If httpClient is nil, a client with a 10s timeout
+// is used.
+func NewClient(baseUrl, apiToken string, httpClient *http.Client) (*Client, error) {
+	parsedUrl, err := url.ParseRequestURI(baseUrl)
+	if err != nil {
+		return nil, err
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{
+		baseUrl:  *parsedUrl,
+		apiToken: apiToken,
+		http:     httpClient,
+	}, nil
+}
+
+// GetGateway reads a gateway by its EUI.
+func (client *Client) GetGateway(ctx context.Context, gatewayId string) (gateway GetGatewayResponse, err error) {
+	err = client.get(ctx, fmt.Sprintf("/api/gateways/%s", gatewayId), nil, &gateway)
+	return gateway, err
+}
+
+// GetGatewayMetrics reads the aggregated packet counters of a gateway between start and end. aggregation is one of
+// HOUR, DAY or MONTH.
+func (client *Client) GetGatewayMetrics(ctx context.Context, gatewayId string, start, end time.Time, aggregation string) (metrics GetGatewayMetricsResponse, err error) {
+	query := url.Values{}
+	query.Set("start", start.UTC().Format(time.RFC3339))
+	query.Set("end", end.UTC().Format(time.RFC3339))
+	query.Set("aggregation", aggregation)
+	err = client.get(ctx, fmt.Sprintf("/api/gateways/%s/metrics", gatewayId), query, &metrics)
+	return metrics, err
+}
+
+func (client *Client) get(ctx context.Context, apiPath string, query url.Values, out interface{}) (err error) {
+	reqUrl := client.baseUrl
+	reqUrl.Path = path.Join(reqUrl.Path, apiPath)
+	reqUrl.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", client.apiToken))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := resp.Body.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		respBuf, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("ChirpStack API responded with non 200 status code %s: %s", resp.Status, string(respBuf))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
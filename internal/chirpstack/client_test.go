@@ -0,0 +1,30 @@
+package chirpstack
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestPaths(t *testing.T) {
+	var requested []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.EscapedPath())
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer api.Close()
+	client, err := NewClient(api.URL, "TOKEN", api.Client())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	// IDs are escaped exactly once
+	_, _ = client.GetGateway(context.Background(), "my gateway")
+	_, _ = client.GetGatewayMetrics(context.Background(), "my gateway", time.Now().Add(-time.Hour), time.Now(), "HOUR")
+	want := []string{"/api/gateways/my%20gateway", "/api/gateways/my%20gateway/metrics"}
+	if len(requested) != len(want) || requested[0] != want[0] || requested[1] != want[1] {
+		t.Errorf("requested %v, want %v", requested, want)
+	}
+}
@@ -0,0 +1,48 @@
+package chirpstack
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+var chirpStackApiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "chirpstack",
+	Subsystem: "client",
+	Name:      "request_duration_seconds",
+	Help:      "Histogram of the request duration towards the ChirpStack API",
+	Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+}, []string{"code", "method"})
+
+var chirpStackApiRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "chirpstack",
+	Subsystem: "client",
+	Name:      "request_inflight",
+	Help:      "Number of requests towards the ChirpStack API that are currently ongoing",
+})
+
+func init() {
+	prometheus.MustRegister(
+		chirpStackApiRequestDuration,
+		chirpStackApiRequestsInFlight,
+	)
+}
+
+// NewHTTPClient creates an HTTP client on the given transport that is instrumented with the ChirpStack API client
+// metrics. A zero timeout defaults to 10s.
+func NewHTTPClient(transport http.RoundTripper, timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: promhttp.InstrumentRoundTripperDuration(
+			chirpStackApiRequestDuration,
+			promhttp.InstrumentRoundTripperInFlight(
+				chirpStackApiRequestsInFlight,
+				transport,
+			),
+		),
+	}
+}
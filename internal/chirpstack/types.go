@@ -0,0 +1,55 @@
+package chirpstack
+
+import (
+	"time"
+)
+
+// GetGatewayResponse https://github.com/chirpstack/chirpstack/blob/master/api/proto/api/gateway.proto
+type GetGatewayResponse struct {
+	Gateway    Gateway    `json:"gateway"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	LastSeenAt *time.Time `json:"lastSeenAt"`
+}
+
+// Gateway https://github.com/chirpstack/chirpstack/blob/master/api/proto/api/gateway.proto
+type Gateway struct {
+	GatewayID   string            `json:"gatewayId"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Location    Location          `json:"location"`
+	TenantID    string            `json:"tenantId"`
+	Tags        map[string]string `json:"tags"`
+	Metadata    map[string]string `json:"metadata"`
+	// StatsInterval is the expected interval of gateway stats messages in seconds
+	StatsInterval uint32 `json:"statsInterval"`
+}
+
+// Location https://github.com/chirpstack/chirpstack/blob/master/api/proto/common/common.proto
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+	Source    string  `json:"source"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+// GetGatewayMetricsResponse https://github.com/chirpstack/chirpstack/blob/master/api/proto/api/gateway.proto
+type GetGatewayMetricsResponse struct {
+	RxPackets Metric `json:"rxPackets"`
+	TxPackets Metric `json:"txPackets"`
+}
+
+// Metric https://github.com/chirpstack/chirpstack/blob/master/api/proto/common/common.proto
+type Metric struct {
+	Name       string          `json:"name"`
+	Timestamps []time.Time     `json:"timestamps"`
+	Datasets   []MetricDataset `json:"datasets"`
+	Kind       string          `json:"kind"`
+}
+
+// MetricDataset https://github.com/chirpstack/chirpstack/blob/master/api/proto/common/common.proto
+type MetricDataset struct {
+	Label string    `json:"label"`
+	Data  []float64 `json:"data"`
+}
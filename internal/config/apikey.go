@@ -0,0 +1,53 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// apiKeyCommandTimeout is how long an api_key_command may run before it is killed.
+var apiKeyCommandTimeout = 10 * time.Second
+
+// KeyID returns an identifier for an API key that is safe to log and to use as a metric label. For keys in the
+// The Things Stack format (NNSXS.<key id>.<secret>) this is the public key ID, for everything else a truncated hash.
+func KeyID(apiKey string) string {
+	parts := strings.Split(apiKey, ".")
+	if len(parts) == 3 && parts[1] != "" {
+		return parts[1]
+	}
+	hash := sha256.Sum256([]byte(apiKey))
+	return "sha256:" + hex.EncodeToString(hash[:6])
+}
+
+// RunAPIKeyCommand runs an api_key_command without a shell and returns its standard output without surrounding
+// whitespace. Errors never contain the output, as it may contain the key.
+func RunAPIKeyCommand(ctx context.Context, command []string) (string, error) {
+	if len(command) == 0 || command[0] == "" {
+		return "", fmt.Errorf("api_key_command is empty")
+	}
+	ctx, cancel := context.WithTimeout(ctx, apiKeyCommandTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("api_key_command %s timed out after %s", command[0], apiKeyCommandTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("api_key_command %s: %w", command[0], err)
+	}
+	key := strings.TrimSpace(stdout.String())
+	if key == "" {
+		return "", fmt.Errorf("api_key_command %s printed no key", command[0])
+	}
+	return key, nil
+}
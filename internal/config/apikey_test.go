@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunAPIKeyCommand(t *testing.T) {
+	key, err := RunAPIKeyCommand(context.Background(), []string{"echo", "  " + testAPIKey + "  "})
+	if err != nil {
+		t.Fatalf("RunAPIKeyCommand: %v", err)
+	}
+	if key != testAPIKey {
+		t.Errorf("key = %q, want the trimmed output", key)
+	}
+
+	// arguments are passed as they are, without a shell interpreting them
+	key, err = RunAPIKeyCommand(context.Background(), []string{"echo", "$HOME;", "`id`"})
+	if err != nil {
+		t.Fatalf("RunAPIKeyCommand: %v", err)
+	}
+	if key != "$HOME; `id`" {
+		t.Errorf("key = %q, want the arguments unexpanded", key)
+	}
+
+	defaultTimeout := apiKeyCommandTimeout
+	apiKeyCommandTimeout = 100 * time.Millisecond
+	defer func() { apiKeyCommandTimeout = defaultTimeout }()
+
+	tests := []struct {
+		name    string
+		command []string
+		wantErr string
+	}{
+		{name: "empty", command: []string{""}, wantErr: "api_key_command is empty"},
+		{name: "failing", command: []string{"sh", "-c", "echo " + testAPIKey + "; exit 3"}, wantErr: "api_key_command sh: exit status 3"},
+		{name: "no output", command: []string{"true"}, wantErr: "api_key_command true printed no key"},
+		{name: "not found", command: []string{"ttn-gateway-exporter-no-such-command"}, wantErr: "api_key_command ttn-gateway-exporter-no-such-command:"},
+		{name: "timeout", command: []string{"sleep", "5"}, wantErr: "api_key_command sleep timed out after 100ms"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			_, err := RunAPIKeyCommand(context.Background(), test.command)
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("RunAPIKeyCommand = %v, want %q", err, test.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), testAPIKey) {
+				t.Errorf("error %q contains the output of the command", err)
+			}
+			if time.Since(start) > 2*time.Second {
+				t.Errorf("RunAPIKeyCommand took %s, want it killed after the timeout", time.Since(start))
+			}
+		})
+	}
+}
@@ -1,40 +1,911 @@
 package config
 
 import (
+	"context"
+	"fmt"
 	"gopkg.in/yaml.v3"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	BackendTTN        = "ttn"
+	BackendChirpStack = "chirpstack"
+	// BackendChirpStackMQTT is used for gateways of mqtt_sources, it can't be configured on targets
+	BackendChirpStackMQTT = "chirpstack-mqtt"
 )
 
 type TargetConfig struct {
-	DefaultBaseUrl string   `yaml:"default_base_url" json:"default_base_url"`
-	Targets        []Target `yaml:"targets" json:"targets"`
+	DefaultBaseUrl string `yaml:"default_base_url" json:"default_base_url"`
+	// APIKeyCheckInterval is how often the rights and expiry of every API key are checked
+	APIKeyCheckInterval time.Duration `yaml:"api_key_check_interval" json:"api_key_check_interval"`
+	// APIKeyExpiryWarning is how long before the expiry of an API key a warning is logged on every check
+	APIKeyExpiryWarning time.Duration `yaml:"api_key_expiry_warning" json:"api_key_expiry_warning"`
+	Clusters            []Cluster     `yaml:"clusters" json:"clusters"`
+	// ClusterAutoRouting looks up the Gateway Server of every gateway instead of relying on its base_url
+	ClusterAutoRouting ClusterAutoRouting `yaml:"cluster_auto_routing" json:"cluster_auto_routing"`
+	Targets            []Target           `yaml:"targets" json:"targets"`
+	// TenantDiscovery lists all gateways of a tenant with a tenant-admin key and adds them as targets. Entries take
+	// the same settings as targets, except for gateway_id.
+	TenantDiscovery []Target `yaml:"tenant_discovery" json:"tenant_discovery"`
+	// MQTTSources receive gateway stats from ChirpStack Gateway Bridge over MQTT. Their gateways are added as targets.
+	MQTTSources []MQTTSource `yaml:"mqtt_sources" json:"mqtt_sources"`
+	// GatewayConfiguration watches the packet forwarder configuration served by the Gateway Configuration Server
+	GatewayConfiguration GatewayConfiguration `yaml:"gateway_configuration" json:"gateway_configuration"`
+	// SubBands configures the duty-cycle metrics of the sub-bands of TTN targets
+	SubBands SubBands `yaml:"sub_bands" json:"sub_bands"`
+	// Events subscribes to the traffic events of every TTN target
+	Events Events `yaml:"events" json:"events"`
+	// Health configures the thresholds of the derived clock and GPS health metrics
+	Health Health `yaml:"health" json:"health"`
+	// LocationDrift compares the reported antenna locations of TTN targets with the locations in the registry
+	LocationDrift LocationDrift `yaml:"location_drift" json:"location_drift"`
+	// Map publishes the gateway locations as GeoJSON, KML and an HTML map
+	Map Map `yaml:"map" json:"map"`
+	// PublicStatus publishes a filtered status of the gateways on a separate listener
+	PublicStatus PublicStatus `yaml:"public_status" json:"public_status"`
+	// Notifications sends messages when gateways go offline, come back online, flap or lose clock sync
+	Notifications Notifications `yaml:"notifications" json:"notifications"`
+	// OTLP pushes the gateway metrics to an OpenTelemetry Collector
+	OTLP OTLP `yaml:"otlp" json:"otlp"`
+	// Outputs push the gateway metrics to InfluxDB or Graphite
+	Outputs []Output `yaml:"outputs" json:"outputs"`
+	// RemoteWrite pushes all metrics of the exporter to a Prometheus remote-write endpoint
+	RemoteWrite RemoteWrite `yaml:"remote_write" json:"remote_write"`
+	// MQTTPublisher publishes the state of every gateway to an MQTT broker, e.g. for Home Assistant
+	MQTTPublisher MQTTPublisher `yaml:"mqtt_publisher" json:"mqtt_publisher"`
+}
+
+type MQTTPublisher struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Broker is the broker URL, e.g. tcp://mqtt.example.com:1883 or ssl://mqtt.example.com:8883
+	Broker   string `yaml:"broker" json:"broker"`
+	ClientID string `yaml:"client_id" json:"client_id,omitempty"`
+	Username string `yaml:"username" json:"username,omitempty"`
+	Password string `yaml:"password" json:"-"`
+	// CAFile is a PEM bundle of additional certificate authorities to trust for ssl:// brokers
+	CAFile             string `yaml:"ca_file" json:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+	// TopicPrefix is the prefix of the availability topic <prefix>/status and the state topics <prefix>/<gateway>/state
+	TopicPrefix string `yaml:"topic_prefix" json:"topic_prefix"`
+	// Interval is how often the state of the gateways is published
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	QoS      byte          `yaml:"qos" json:"qos"`
+	// HomeAssistant publishes MQTT discovery configs, so the gateways appear as devices in Home Assistant
+	HomeAssistant HomeAssistant `yaml:"home_assistant" json:"home_assistant"`
+}
+
+type HomeAssistant struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// DiscoveryPrefix is the discovery prefix configured in Home Assistant
+	DiscoveryPrefix string `yaml:"discovery_prefix" json:"discovery_prefix"`
+}
+
+type RemoteWrite struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// URL of the remote-write endpoint, e.g. https://prometheus.example.com/api/v1/write
+	URL string `yaml:"url" json:"url"`
+	// Username and Password configure basic auth, BearerToken bearer auth
+	Username    string            `yaml:"username" json:"username,omitempty"`
+	Password    string            `yaml:"password" json:"-"`
+	BearerToken string            `yaml:"bearer_token" json:"-"`
+	Headers     map[string]string `yaml:"headers" json:"-"`
+	// Interval is how often the metrics are gathered
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// Timeout of a single request
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// ExternalLabels are added to every series that doesn't have the label, e.g. to tell exporters apart
+	ExternalLabels map[string]string `yaml:"external_labels" json:"external_labels,omitempty"`
+	// QueueCapacity is the number of samples kept while the endpoint is unreachable, older samples are dropped
+	QueueCapacity int `yaml:"queue_capacity" json:"queue_capacity"`
+	// MaxSamplesPerSend is the maximum number of samples in a request
+	MaxSamplesPerSend int `yaml:"max_samples_per_send" json:"max_samples_per_send"`
+	// MaxRetries is how often a failed request is retried before its samples are given up
+	MaxRetries int `yaml:"max_retries" json:"max_retries"`
+	// MaxBackoff limits the delay between retries, which doubles from 1s
+	MaxBackoff time.Duration `yaml:"max_backoff" json:"max_backoff"`
+}
+
+// OTLP protocols
+const (
+	OTLPProtocolHTTP = "http/protobuf"
+	OTLPProtocolGRPC = "grpc"
+)
+
+type OTLP struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Protocol is http/protobuf or grpc
+	Protocol string `yaml:"protocol" json:"protocol"`
+	// Endpoint is the metrics URL for http/protobuf, e.g. http://localhost:4318/v1/metrics, and the base URL of the
+	// server for grpc, e.g. http://localhost:4317. https URLs use TLS.
+	Endpoint string            `yaml:"endpoint" json:"endpoint"`
+	Headers  map[string]string `yaml:"headers" json:"-"`
+	// Interval is how often the metrics are pushed
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// Timeout of a single export, including retries
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// ServiceName is the service.name resource attribute
+	ServiceName string `yaml:"service_name" json:"service_name"`
+}
+
+// Output types
+const (
+	OutputInfluxDB = "influxdb"
+	OutputGraphite = "graphite"
+)
+
+type Output struct {
+	Name string `yaml:"name" json:"name"`
+	// Type is influxdb or graphite
+	Type string `yaml:"type" json:"type"`
+	// URL of the InfluxDB server, e.g. http://localhost:8086. Org, Bucket and Token are passed to its v2 write API.
+	URL    string `yaml:"url" json:"url,omitempty"`
+	Org    string `yaml:"org" json:"org,omitempty"`
+	Bucket string `yaml:"bucket" json:"bucket,omitempty"`
+	Token  string `yaml:"token" json:"-"`
+	// Address of the Graphite plaintext listener, e.g. localhost:2003
+	Address string `yaml:"address" json:"address,omitempty"`
+	// Interval is how often the metrics are pushed
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// Timeout of writing a single batch
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+	// BatchSize is the maximum number of lines written at once
+	BatchSize int `yaml:"batch_size" json:"batch_size"`
+	// MaxRetries is how often a failed batch is retried before it is kept for the next interval
+	MaxRetries int `yaml:"max_retries" json:"max_retries"`
+	// BufferSize is the number of lines kept while the output is unreachable, older lines are dropped
+	BufferSize int          `yaml:"buffer_size" json:"buffer_size"`
+	Layout     OutputLayout `yaml:"layout" json:"layout"`
+}
+
+// OutputLayout maps the metrics to InfluxDB measurements and Graphite paths.
+type OutputLayout struct {
+	// Measurement is the InfluxDB measurement or the Graphite path. {metric} is replaced by the metric key, e.g.
+	// uplink_count, and {label} by the value of a label, e.g. {gateway}. InfluxDB measurements without {metric} get one
+	// field per metric, Graphite paths without {metric} are followed by it.
+	Measurement string `yaml:"measurement" json:"measurement"`
+	// Tags are the target labels (gateway, backend, cluster and tenant) written as tags. The labels of a metric are
+	// always tags, or parts of the path for untagged Graphite.
+	Tags []string `yaml:"tags" json:"tags"`
+	// ExtraTags are constant tags added to every line
+	ExtraTags map[string]string `yaml:"extra_tags" json:"extra_tags,omitempty"`
+	// Tagged writes Graphite tags (Graphite 1.1 and later) instead of putting the metric labels into the path
+	Tagged bool `yaml:"tagged" json:"tagged"`
+}
+
+// OutputTargetLabels are the labels of every target metric that can be used as output tags.
+var OutputTargetLabels = []string{"gateway", "backend", "cluster", "tenant"}
+
+// Notification sink types
+const (
+	SinkWebhook  = "webhook"
+	SinkMatrix   = "matrix"
+	SinkTelegram = "telegram"
+	SinkNtfy     = "ntfy"
+	SinkSMTP     = "smtp"
+)
+
+// NotificationEvents are the gateway state transitions that are notified.
+var NotificationEvents = []string{"offline", "online", "flapping", "clock_unsynced", "clock_synced"}
+
+type Notifications struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Interval is how often the state of the gateways is checked
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// HoldDown is how long a new state has to last before it is notified
+	HoldDown time.Duration `yaml:"hold_down" json:"hold_down"`
+	// RepeatInterval repeats offline and clock_unsynced notifications while the state lasts, 0 disables repeats
+	RepeatInterval time.Duration `yaml:"repeat_interval" json:"repeat_interval"`
+	// FlappingTransitions online/offline changes within FlappingWindow mark a gateway as flapping
+	FlappingTransitions int           `yaml:"flapping_transitions" json:"flapping_transitions"`
+	FlappingWindow      time.Duration `yaml:"flapping_window" json:"flapping_window"`
+	// Templates are Go templates of the message per event, and of the title under the key title
+	Templates map[string]string  `yaml:"templates" json:"templates"`
+	Sinks     []NotificationSink `yaml:"sinks" json:"sinks"`
+	// Recipients are notified for targets without notify
+	Recipients []Recipient `yaml:"recipients" json:"recipients"`
+}
+
+// NotificationSink is a destination for notifications. Which fields apply depends on the type.
+type NotificationSink struct {
+	Name string `yaml:"name" json:"name"`
+	// Type is webhook, matrix, telegram, ntfy or smtp
+	Type string `yaml:"type" json:"type"`
+	// URL is the webhook URL, the Matrix homeserver, the ntfy server or the Telegram Bot API (default
+	// https://api.telegram.org)
+	URL     string            `yaml:"url" json:"url"`
+	Headers map[string]string `yaml:"headers" json:"-"`
+	// Token is the Matrix access token, the Telegram bot token or the ntfy access token
+	Token string `yaml:"token" json:"-"`
+	// To is the default recipient: the Matrix room ID, the Telegram chat ID, the ntfy topic or the email address
+	To string `yaml:"to" json:"to,omitempty"`
+	// Host, Port, Username, Password and From configure the SMTP server
+	Host     string `yaml:"host" json:"host,omitempty"`
+	Port     int    `yaml:"port" json:"port,omitempty"`
+	Username string `yaml:"username" json:"username,omitempty"`
+	Password string `yaml:"password" json:"-"`
+	From     string `yaml:"from" json:"from,omitempty"`
+	// Timeout of a delivery
+	Timeout time.Duration `yaml:"timeout" json:"timeout"`
+}
+
+// Recipient references a sink, optionally overriding its default recipient.
+type Recipient struct {
+	Sink string `yaml:"sink" json:"sink"`
+	To   string `yaml:"to" json:"to,omitempty"`
+}
+
+// PublicStatusFields are the fields that can be published in the public status feed.
+var PublicStatusFields = []string{"gateway_id", "tenant", "online", "last_seen_at", "connected_at", "uplink_count", "downlink_count", "location"}
+
+type PublicStatus struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Address is the listener of /public/status.json, separate from /metrics
+	Address string `yaml:"address" json:"address"`
+	// Fields is the allowlist of published fields, see PublicStatusFields
+	Fields []string `yaml:"fields" json:"fields"`
+	// CacheTTL is how long a generated feed is served before it is generated again
+	CacheTTL time.Duration `yaml:"cache_ttl" json:"cache_ttl"`
+	// RateLimit is the number of requests per second allowed per client IP, with bursts of RateLimitBurst
+	RateLimit      float64 `yaml:"rate_limit" json:"rate_limit"`
+	RateLimitBurst int     `yaml:"rate_limit_burst" json:"rate_limit_burst"`
+}
+
+type Map struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// CORSAllowedOrigins may read the map endpoints from a browser, * allows all origins
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins" json:"cors_allowed_origins"`
+	// CacheTTL is how long a gateway status is reused for the map before it is read again
+	CacheTTL time.Duration `yaml:"cache_ttl" json:"cache_ttl"`
+}
+
+type LocationDrift struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// SignificantMove is the distance in meters a reported antenna location has to move to be counted as a move
+	SignificantMove float64 `yaml:"significant_move" json:"significant_move"`
+	// HistorySize is the number of significant moves kept per gateway
+	HistorySize int `yaml:"history_size" json:"history_size"`
+}
+
+type Health struct {
+	// MaxClockOffset is the largest offset between gateway time and server receive time of a synced clock
+	MaxClockOffset time.Duration `yaml:"max_clock_offset" json:"max_clock_offset"`
+	// GPSLostAfter flags the GPS of a gateway as lost if it reported no GPS location for this long
+	GPSLostAfter time.Duration `yaml:"gps_lost_after" json:"gps_lost_after"`
+}
+
+type Events struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// OwnDevAddrPrefixes are the DevAddr ranges of our own devices, in the form 260B1200/24
+	OwnDevAddrPrefixes []string `yaml:"own_dev_addr_prefixes" json:"own_dev_addr_prefixes"`
+	// TTNNetIDs are the NetIDs counted as TTN traffic, all other NetIDs reach their network through Packet Broker
+	TTNNetIDs []string `yaml:"ttn_net_ids" json:"ttn_net_ids"`
+}
+
+type SubBands struct {
+	// ResolveFrequencyPlans looks up the frequency plans of every gateway to name its sub-bands
+	ResolveFrequencyPlans bool `yaml:"resolve_frequency_plans" json:"resolve_frequency_plans"`
+	// NearLimitThreshold is the share of the duty-cycle limit from which a sub-band is flagged as near its limit
+	NearLimitThreshold float64 `yaml:"near_limit_threshold" json:"near_limit_threshold"`
+}
+
+// DefaultGatewayConfigurationPath is the Gateway Configuration Server path of the Semtech UDP packet forwarder config.
+const DefaultGatewayConfigurationPath = "/api/v3/gcs/gateways/{gateway_id}/semtechudp/global_conf.json"
+
+type GatewayConfiguration struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Interval is how often the configuration of every gateway is fetched
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// Path is the API path of the configuration, {gateway_id} is replaced by the gateway ID. Targets can override it
+	// with gateway_configuration_path, e.g. for Basic Station gateways.
+	Path string `yaml:"path" json:"path"`
+}
+
+// MQTTSource is an MQTT broker that ChirpStack Gateway Bridge publishes gateway events to.
+type MQTTSource struct {
+	Name string `yaml:"name" json:"name"`
+	// Broker is the broker URL, e.g. tcp://mqtt.example.com:1883 or ssl://mqtt.example.com:8883
+	Broker   string `yaml:"broker" json:"broker"`
+	ClientID string `yaml:"client_id" json:"client_id,omitempty"`
+	Username string `yaml:"username" json:"username,omitempty"`
+	Password string `yaml:"password" json:"-"`
+	// CAFile is a PEM bundle of additional certificate authorities to trust for ssl:// brokers
+	CAFile             string `yaml:"ca_file" json:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+	// TopicPrefix is prepended to the gateway/... topics, e.g. eu868 for a region-prefixed Gateway Bridge
+	TopicPrefix string `yaml:"topic_prefix" json:"topic_prefix,omitempty"`
+	// Marshaler is the encoding configured in the Gateway Bridge, json or protobuf
+	Marshaler string `yaml:"marshaler" json:"marshaler"`
+	// OfflineAfter marks a gateway as disconnected if it sent no stats for this long and no connection state is known
+	OfflineAfter time.Duration `yaml:"offline_after" json:"offline_after"`
+	// Gateways maps gateway EUIs to the gateway IDs used in metrics
+	Gateways map[string]string `yaml:"gateways" json:"gateways"`
+}
+
+type ClusterAutoRouting struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// IdentityServerURL is where gateways are looked up, defaults to default_base_url
+	IdentityServerURL string `yaml:"identity_server_url" json:"identity_server_url"`
+	// CacheTTL is how long a looked up Gateway Server is used before looking it up again
+	CacheTTL time.Duration `yaml:"cache_ttl" json:"cache_ttl"`
+}
+
+// Cluster holds settings shared by all targets that reference it by name.
+type Cluster struct {
+	Name    string `yaml:"name" json:"name"`
+	BaseUrl string `yaml:"base_url" json:"base_url"`
+	// Backend is the network server software of the cluster, ttn or chirpstack
+	Backend string `yaml:"backend" json:"backend,omitempty"`
+	// Tenant is the tenant ID on multi-tenant deployments of The Things Industries
+	Tenant string  `yaml:"tenant" json:"tenant,omitempty"`
+	OAuth2 *OAuth2 `yaml:"oauth2" json:"oauth2,omitempty"`
+	// HTTPClient configures the connection to the cluster
+	HTTPClient *HTTPClient `yaml:"http_client" json:"http_client,omitempty"`
+}
+
+// HTTPClient configures proxy, TLS and timeouts of the connection to the TTN API. Zero values keep the defaults.
+type HTTPClient struct {
+	ProxyURL           string `yaml:"proxy_url" json:"proxy_url,omitempty"`
+	CAFile             string `yaml:"ca_file" json:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file" json:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file" json:"key_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+
+	Timeout             time.Duration `yaml:"timeout" json:"timeout,omitempty"`
+	DialTimeout         time.Duration `yaml:"dial_timeout" json:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout" json:"tls_handshake_timeout,omitempty"`
+	KeepAlive           time.Duration `yaml:"keep_alive" json:"keep_alive,omitempty"`
+	DisableKeepAlives   bool          `yaml:"disable_keep_alives" json:"disable_keep_alives,omitempty"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout" json:"idle_conn_timeout,omitempty"`
+	MaxIdleConns        int           `yaml:"max_idle_conns" json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host" json:"max_idle_conns_per_host,omitempty"`
+}
+
+// OAuth2 configures authentication through an OAuth client of The Things Stack instead of an API key.
+type OAuth2 struct {
+	// TokenURL defaults to /oauth/token on the base URL
+	TokenURL     string `yaml:"token_url" json:"token_url"`
+	ClientID     string `yaml:"client_id" json:"client_id"`
+	ClientSecret string `yaml:"client_secret" json:"-"`
+	// RefreshToken selects the refresh token grant, without it the client credentials grant is used
+	RefreshToken string   `yaml:"refresh_token" json:"-"`
+	Scopes       []string `yaml:"scopes" json:"scopes"`
 }
 
 type Target struct {
 	GatewayID string `yaml:"gateway_id" json:"gateway_id"`
-	APIKey    string `yaml:"api_key" json:"api_key"`
-	BaseUrl   string `yaml:"base_url" json:"base_url"`
+	APIKey    string `yaml:"api_key" json:"-"`
+	// APIKeyFile points to a file containing the API key. The file is re-read whenever it changes, so rotated keys
+	// are picked up without a restart.
+	APIKeyFile string `yaml:"api_key_file" json:"api_key_file,omitempty"`
+	// APIKeyEnv names an environment variable containing the API key.
+	APIKeyEnv string `yaml:"api_key_env" json:"api_key_env,omitempty"`
+	// APIKeyCommand is a command printing the API key, e.g. [pass, show, ttn/my-gateway]. It is executed without a
+	// shell at startup and again whenever the API rejects the key, so rotated keys are picked up.
+	APIKeyCommand []string `yaml:"api_key_command" json:"-"`
+	BaseUrl       string   `yaml:"base_url" json:"base_url"`
+	// Backend is the network server software, ttn (the default) or chirpstack. For ChirpStack, the gateway ID is the
+	// gateway EUI, base_url points to the REST API and the API key is a ChirpStack API token.
+	Backend string `yaml:"backend" json:"backend,omitempty"`
+	// Tenant is the tenant ID on multi-tenant deployments of The Things Industries. It can also be given as part of the
+	// gateway ID in the form gateway-id@tenant-id.
+	Tenant string `yaml:"tenant" json:"tenant,omitempty"`
+	// Cluster references an entry of clusters. Its settings apply unless overridden on the target.
+	Cluster string `yaml:"cluster" json:"cluster,omitempty"`
+	// OAuth2 authenticates through an OAuth client instead of an API key
+	OAuth2 *OAuth2 `yaml:"oauth2" json:"oauth2,omitempty"`
+	// HideOnMap leaves the gateway out of the map endpoints
+	HideOnMap bool `yaml:"hide_on_map" json:"hide_on_map,omitempty"`
+	// GatewayConfigurationPath overrides gateway_configuration.path for this target
+	GatewayConfigurationPath string `yaml:"gateway_configuration_path" json:"gateway_configuration_path,omitempty"`
+	// Notify overrides notifications.recipients for this target
+	Notify []Recipient `yaml:"notify" json:"notify,omitempty"`
+	// HTTPClient is inherited from the cluster, it is not configurable per target
+	HTTPClient HTTPClient `yaml:"-" json:"-"`
+	// GatewayEUI and MQTTSource identify gateways of mqtt_sources
+	GatewayEUI string `yaml:"-" json:"gateway_eui,omitempty"`
+	MQTTSource string `yaml:"-" json:"mqtt_source,omitempty"`
 }
 
 func ReadTargets(location string) (TargetConfig, error) {
-	file, err := os.Open(location)
+	content, err := os.ReadFile(location)
 	if err != nil {
 		return TargetConfig{}, err
 	}
 
+	var root yaml.Node
+	err = yaml.Unmarshal(content, &root)
+	if err != nil {
+		return TargetConfig{}, err
+	}
+	err = expandEnv(&root)
+	if err != nil {
+		return TargetConfig{}, err
+	}
+
+	var errs ValidationErrors
+	checkKnownFields(&root, reflect.TypeOf(TargetConfig{}), &errs)
+	if len(errs) > 0 {
+		return TargetConfig{}, errs
+	}
+
 	targetConfig := TargetConfig{
-		DefaultBaseUrl: "https://eu1.cloud.thethings.network",
+		DefaultBaseUrl:      "https://eu1.cloud.thethings.network",
+		APIKeyCheckInterval: time.Hour,
+		APIKeyExpiryWarning: 30 * 24 * time.Hour,
+	}
+	err = root.Decode(&targetConfig)
+	if err != nil {
+		return TargetConfig{}, err
+	}
+	if targetConfig.ClusterAutoRouting.IdentityServerURL == "" {
+		targetConfig.ClusterAutoRouting.IdentityServerURL = targetConfig.DefaultBaseUrl
+	}
+	if targetConfig.ClusterAutoRouting.CacheTTL == 0 {
+		targetConfig.ClusterAutoRouting.CacheTTL = time.Hour
+	}
+	if targetConfig.GatewayConfiguration.Interval == 0 {
+		targetConfig.GatewayConfiguration.Interval = time.Hour
+	}
+	if targetConfig.PublicStatus.Address == "" {
+		targetConfig.PublicStatus.Address = ":8081"
+	}
+	if targetConfig.PublicStatus.Fields == nil {
+		targetConfig.PublicStatus.Fields = []string{"gateway_id", "online", "last_seen_at"}
+	}
+	if targetConfig.PublicStatus.CacheTTL == 0 {
+		targetConfig.PublicStatus.CacheTTL = time.Minute
+	}
+	if targetConfig.PublicStatus.RateLimit == 0 {
+		targetConfig.PublicStatus.RateLimit = 1
+	}
+	if targetConfig.PublicStatus.RateLimitBurst == 0 {
+		targetConfig.PublicStatus.RateLimitBurst = 10
+	}
+	if targetConfig.Notifications.Interval == 0 {
+		targetConfig.Notifications.Interval = time.Minute
+	}
+	if targetConfig.Notifications.HoldDown == 0 {
+		targetConfig.Notifications.HoldDown = 5 * time.Minute
+	}
+	if targetConfig.Notifications.FlappingTransitions == 0 {
+		targetConfig.Notifications.FlappingTransitions = 4
+	}
+	if targetConfig.Notifications.FlappingWindow == 0 {
+		targetConfig.Notifications.FlappingWindow = time.Hour
+	}
+	for i := range targetConfig.Notifications.Sinks {
+		sink := &targetConfig.Notifications.Sinks[i]
+		if sink.Type == SinkTelegram && sink.URL == "" {
+			sink.URL = "https://api.telegram.org"
+		}
+		if sink.Type == SinkSMTP && sink.Port == 0 {
+			sink.Port = 587
+		}
+		if sink.Timeout == 0 {
+			sink.Timeout = 10 * time.Second
+		}
+	}
+	if targetConfig.OTLP.Protocol == "" {
+		targetConfig.OTLP.Protocol = OTLPProtocolHTTP
+	}
+	if targetConfig.OTLP.Endpoint == "" {
+		targetConfig.OTLP.Endpoint = "http://localhost:4318/v1/metrics"
+		if targetConfig.OTLP.Protocol == OTLPProtocolGRPC {
+			targetConfig.OTLP.Endpoint = "http://localhost:4317"
+		}
+	}
+	if targetConfig.OTLP.Interval == 0 {
+		targetConfig.OTLP.Interval = time.Minute
+	}
+	if targetConfig.OTLP.Timeout == 0 {
+		targetConfig.OTLP.Timeout = 10 * time.Second
+	}
+	if targetConfig.OTLP.ServiceName == "" {
+		targetConfig.OTLP.ServiceName = "ttn-gateway-exporter"
+	}
+	if targetConfig.MQTTPublisher.TopicPrefix == "" {
+		targetConfig.MQTTPublisher.TopicPrefix = "ttn-gateway-exporter"
+	}
+	if targetConfig.MQTTPublisher.Interval == 0 {
+		targetConfig.MQTTPublisher.Interval = time.Minute
+	}
+	if targetConfig.MQTTPublisher.HomeAssistant.DiscoveryPrefix == "" {
+		targetConfig.MQTTPublisher.HomeAssistant.DiscoveryPrefix = "homeassistant"
+	}
+	if targetConfig.RemoteWrite.Interval == 0 {
+		targetConfig.RemoteWrite.Interval = time.Minute
+	}
+	if targetConfig.RemoteWrite.Timeout == 0 {
+		targetConfig.RemoteWrite.Timeout = 30 * time.Second
+	}
+	if targetConfig.RemoteWrite.QueueCapacity == 0 {
+		targetConfig.RemoteWrite.QueueCapacity = 100000
+	}
+	if targetConfig.RemoteWrite.MaxSamplesPerSend == 0 {
+		targetConfig.RemoteWrite.MaxSamplesPerSend = 2000
+	}
+	if targetConfig.RemoteWrite.MaxRetries == 0 {
+		targetConfig.RemoteWrite.MaxRetries = 5
+	}
+	if targetConfig.RemoteWrite.MaxBackoff == 0 {
+		targetConfig.RemoteWrite.MaxBackoff = 30 * time.Second
+	}
+	for i := range targetConfig.Outputs {
+		output := &targetConfig.Outputs[i]
+		if output.Interval == 0 {
+			output.Interval = time.Minute
+		}
+		if output.Timeout == 0 {
+			output.Timeout = 10 * time.Second
+		}
+		if output.BatchSize == 0 {
+			output.BatchSize = 1000
+		}
+		if output.MaxRetries == 0 {
+			output.MaxRetries = 3
+		}
+		if output.BufferSize == 0 {
+			output.BufferSize = 100000
+		}
+		if output.Layout.Measurement == "" {
+			output.Layout.Measurement = "ttn_gateway"
+			if output.Type == OutputGraphite {
+				output.Layout.Measurement = "ttn.gateway.{gateway}"
+			}
+		}
+		if output.Layout.Tags == nil {
+			output.Layout.Tags = OutputTargetLabels
+		}
+	}
+	if targetConfig.Map.CacheTTL == 0 {
+		targetConfig.Map.CacheTTL = time.Minute
+	}
+	if targetConfig.LocationDrift.SignificantMove == 0 {
+		targetConfig.LocationDrift.SignificantMove = 100
+	}
+	if targetConfig.LocationDrift.HistorySize == 0 {
+		targetConfig.LocationDrift.HistorySize = 100
+	}
+	if targetConfig.Health.MaxClockOffset == 0 {
+		targetConfig.Health.MaxClockOffset = 10 * time.Second
+	}
+	if targetConfig.Health.GPSLostAfter == 0 {
+		targetConfig.Health.GPSLostAfter = 10 * time.Minute
+	}
+	if targetConfig.Events.TTNNetIDs == nil {
+		targetConfig.Events.TTNNetIDs = []string{"000013"}
+	}
+	if targetConfig.SubBands.NearLimitThreshold == 0 {
+		targetConfig.SubBands.NearLimitThreshold = 0.8
+	}
+	if targetConfig.GatewayConfiguration.Path == "" {
+		targetConfig.GatewayConfiguration.Path = DefaultGatewayConfigurationPath
+	}
+
+	document := documentMapping(&root)
+	for section, targets := range map[string][]Target{"targets": targetConfig.Targets, "tenant_discovery": targetConfig.TenantDiscovery} {
+		for i := range targets {
+			err = targetConfig.complete(&targets[i])
+			if err != nil {
+				errs.add(sequenceItem(mappingValue(document, section), i), "%s[%d]: %s", section, i, err)
+			}
+		}
 	}
-	err = yaml.NewDecoder(file).Decode(&targetConfig)
+
+	for i := range targetConfig.MQTTSources {
+		source := &targetConfig.MQTTSources[i]
+		if source.Marshaler == "" {
+			source.Marshaler = "json"
+		}
+		if source.OfflineAfter == 0 {
+			source.OfflineAfter = 2 * time.Minute
+		}
+	}
+
+	errs = append(errs, targetConfig.validate(&root)...)
+	if len(errs) > 0 {
+		errs.sort()
+		return TargetConfig{}, errs
+	}
+
+	targetConfig.Targets = append(targetConfig.Targets, targetConfig.mqttTargets()...)
+
+	return targetConfig, nil
+}
+
+const tenantPlaceholder = "{tenant}"
+
+// complete applies the cluster settings, defaults and tenant to the target and resolves its API key.
+func (c TargetConfig) complete(t *Target) error {
+	if parts := strings.SplitN(t.GatewayID, "@", 2); len(parts) == 2 {
+		if t.Tenant != "" && t.Tenant != parts[1] {
+			return fmt.Errorf("gateway_id %s conflicts with tenant %s", t.GatewayID, t.Tenant)
+		}
+		t.GatewayID, t.Tenant = parts[0], parts[1]
+	}
+	if cluster, ok := c.Cluster(t.Cluster); ok {
+		t.inherit(cluster)
+	}
+	if t.Backend == "" {
+		t.Backend = BackendTTN
+	}
+	if t.BaseUrl == "" && t.Backend == BackendTTN {
+		t.BaseUrl = c.DefaultBaseUrl
+	}
+	t.BaseUrl = tenantBaseUrl(t.BaseUrl, t.Tenant)
+	if t.OAuth2 != nil && t.OAuth2.TokenURL == "" {
+		t.OAuth2.TokenURL = strings.TrimSuffix(t.BaseUrl, "/") + "/oauth/token"
+	}
+	return t.resolveAPIKey()
+}
+
+// NotificationRecipients returns the recipients of the notifications about the target.
+func (c TargetConfig) NotificationRecipients(target Target) []Recipient {
+	if target.Notify != nil {
+		return target.Notify
+	}
+	return c.Notifications.Recipients
+}
+
+// Publishes reports whether the public status feed is enabled and publishes the field.
+func (p PublicStatus) Publishes(field string) bool {
+	if !p.Enabled {
+		return false
+	}
+	for _, published := range p.Fields {
+		if published == field {
+			return true
+		}
+	}
+	return false
+}
+
+// FullGatewayID returns the gateway ID including the tenant, if any, in the form gateway-id@tenant-id.
+func (t Target) FullGatewayID() string {
+	if t.Tenant == "" {
+		return t.GatewayID
+	}
+	return t.GatewayID + "@" + t.Tenant
+}
+
+// tenantBaseUrl scopes a base URL to a tenant. A {tenant} placeholder is replaced by the tenant ID, and hosts of The
+// Things Industries Cloud are prefixed with the tenant ID, e.g. tenant.eu1.cloud.thethings.industries.
+func tenantBaseUrl(baseUrl, tenant string) string {
+	if tenant == "" {
+		return baseUrl
+	}
+	if strings.Contains(baseUrl, tenantPlaceholder) {
+		return strings.ReplaceAll(baseUrl, tenantPlaceholder, tenant)
+	}
+	parsed, err := url.Parse(baseUrl)
 	if err != nil {
-		return TargetConfig{}, nil
+		return baseUrl
+	}
+	host := parsed.Hostname()
+	if strings.HasSuffix(host, ".cloud.thethings.industries") && !strings.HasPrefix(host, tenant+".") {
+		parsed.Host = tenant + "." + parsed.Host
+	}
+	return parsed.String()
+}
+
+// mqttTargets returns a target for every gateway of the MQTT sources, ordered by gateway ID.
+func (c TargetConfig) mqttTargets() []Target {
+	var targets []Target
+	for _, source := range c.MQTTSources {
+		for eui, gatewayID := range source.Gateways {
+			targets = append(targets, Target{
+				GatewayID:  gatewayID,
+				Backend:    BackendChirpStackMQTT,
+				BaseUrl:    source.Broker,
+				Cluster:    source.Name,
+				GatewayEUI: strings.ToLower(eui),
+				MQTTSource: source.Name,
+			})
+		}
 	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].GatewayID < targets[j].GatewayID
+	})
+	return targets
+}
 
-	for i := range targetConfig.Targets {
-		if targetConfig.Targets[i].BaseUrl == "" {
-			targetConfig.Targets[i].BaseUrl = targetConfig.DefaultBaseUrl
+// MQTTSource returns the MQTT source with the given name.
+func (c TargetConfig) MQTTSource(name string) (MQTTSource, bool) {
+	for _, source := range c.MQTTSources {
+		if source.Name == name {
+			return source, true
 		}
 	}
+	return MQTTSource{}, false
+}
+
+// Cluster returns the cluster with the given name.
+func (c TargetConfig) Cluster(name string) (Cluster, bool) {
+	for _, cluster := range c.Clusters {
+		if name != "" && cluster.Name == name {
+			return cluster, true
+		}
+	}
+	return Cluster{}, false
+}
+
+// RouteCandidates returns a copy of the target for every configured cluster and the default base URL, in that order.
+// The copies keep the target's credentials.
+func (c TargetConfig) RouteCandidates(target Target) []Target {
+	var candidates []Target
+	seen := map[string]bool{}
+	for _, cluster := range c.Clusters {
+		candidate := target.WithBaseUrl(cluster.BaseUrl)
+		candidate.Cluster = cluster.Name
+		if cluster.HTTPClient != nil {
+			candidate.HTTPClient = *cluster.HTTPClient
+		}
+		candidates = append(candidates, candidate)
+		seen[hostname(cluster.BaseUrl)] = true
+	}
+	if !seen[hostname(c.DefaultBaseUrl)] {
+		candidates = append(candidates, target.WithBaseUrl(c.DefaultBaseUrl))
+	}
+	return candidates
+}
+
+// WithBaseUrl returns a copy of the target that uses the given base URL and no named cluster.
+func (t Target) WithBaseUrl(baseUrl string) Target {
+	t.BaseUrl = tenantBaseUrl(baseUrl, t.Tenant)
+	t.Cluster = ""
+	if t.OAuth2 != nil {
+		oauth2 := *t.OAuth2
+		t.OAuth2 = &oauth2
+	}
+	return t
+}
 
-	return targetConfig, err
+// ClusterName returns the name of the target's cluster, or the host of its base URL if it doesn't use a named cluster.
+func (t Target) ClusterName() string {
+	if t.Cluster != "" {
+		return t.Cluster
+	}
+	return hostname(t.BaseUrl)
+}
+
+func hostname(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || parsed.Hostname() == "" {
+		return rawUrl
+	}
+	return parsed.Hostname()
+}
+
+// inherit applies the settings of the cluster that are not set on the target itself.
+func (t *Target) inherit(cluster Cluster) {
+	if t.Tenant == "" {
+		t.Tenant = cluster.Tenant
+	}
+	if t.Backend == "" {
+		t.Backend = cluster.Backend
+	}
+	if t.BaseUrl == "" {
+		t.BaseUrl = cluster.BaseUrl
+	}
+	if cluster.HTTPClient != nil {
+		t.HTTPClient = *cluster.HTTPClient
+	}
+	if t.OAuth2 == nil && cluster.OAuth2 != nil && !t.HasAPIKeySource() {
+		oauth2 := *cluster.OAuth2
+		t.OAuth2 = &oauth2
+	}
+}
+
+// HasAPIKeySource reports whether any of api_key, api_key_file, api_key_env and api_key_command is set.
+func (t Target) HasAPIKeySource() bool {
+	return t.APIKey != "" || t.APIKeyFile != "" || t.APIKeyEnv != "" || len(t.APIKeyCommand) > 0
+}
+
+// resolveAPIKey fills APIKey from api_key_env, api_key_file or api_key_command, making sure at most one key source is
+// configured.
+func (t *Target) resolveAPIKey() error {
+	sources := 0
+	for _, source := range []string{t.APIKey, t.APIKeyFile, t.APIKeyEnv, strings.Join(t.APIKeyCommand, " ")} {
+		if source != "" {
+			sources++
+		}
+	}
+	if t.OAuth2 != nil {
+		sources++
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of api_key, api_key_file, api_key_env, api_key_command and oauth2 may be set")
+	}
+
+	switch {
+	case t.APIKeyEnv != "":
+		key, ok := os.LookupEnv(t.APIKeyEnv)
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("environment variable %s from api_key_env is not set", t.APIKeyEnv)
+		}
+		t.APIKey = strings.TrimSpace(key)
+	case t.APIKeyFile != "":
+		key, err := ReadAPIKeyFile(t.APIKeyFile)
+		if err != nil {
+			return err
+		}
+		t.APIKey = key
+	case len(t.APIKeyCommand) > 0:
+		key, err := RunAPIKeyCommand(context.Background(), t.APIKeyCommand)
+		if err != nil {
+			return err
+		}
+		t.APIKey = key
+	}
+	return nil
+}
+
+// ReadAPIKeyFile reads an API key from a file, ignoring surrounding whitespace.
+func ReadAPIKeyFile(location string) (string, error) {
+	content, err := os.ReadFile(location)
+	if err != nil {
+		return "", fmt.Errorf("reading api_key_file: %w", err)
+	}
+	key := strings.TrimSpace(string(content))
+	if key == "" {
+		return "", fmt.Errorf("api_key_file %s is empty", location)
+	}
+	return key, nil
+}
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references in the scalar values of the parsed document with the value of the environment
+// variable, so values can't change the structure of the document and references in comments are ignored. Unlike
+// os.ExpandEnv, bare $VAR references are left alone, and referencing an unset variable is an error instead of silently
+// expanding to nothing.
+func expandEnv(root *yaml.Node) error {
+	var missing []string
+	expanded := map[*yaml.Node]bool{}
+	var expand func(node *yaml.Node)
+	expand = func(node *yaml.Node) {
+		if node == nil || expanded[node] {
+			return
+		}
+		expanded[node] = true
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range node.Content {
+				expand(child)
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(node.Content); i += 2 {
+				expand(node.Content[i])
+			}
+		case yaml.AliasNode:
+			expand(node.Alias)
+		case yaml.ScalarNode:
+			if !envReference.MatchString(node.Value) {
+				return
+			}
+			node.Value = envReference.ReplaceAllStringFunc(node.Value, func(reference string) string {
+				name := envReference.FindStringSubmatch(reference)[1]
+				value, ok := os.LookupEnv(name)
+				if !ok {
+					missing = append(missing, name)
+				}
+				return value
+			})
+			if node.Style == 0 {
+				// resolve the tag of unquoted values again, so e.g. port: ${SMTP_PORT} is decoded as int
+				node.Tag = ""
+			}
+		}
+	}
+	expand(root)
+	if len(missing) > 0 {
+		return fmt.Errorf("environment variables referenced in config are not set: %s", strings.Join(missing, ", "))
+	}
+	return nil
 }
@@ -0,0 +1,786 @@
+package config
+
+import (
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/lora"
+	"gopkg.in/yaml.v3"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ValidationError is a problem in the target config, located by line and column in the YAML file.
+type ValidationError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// ValidationErrors collects all problems found in a target config.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e ValidationErrors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Line != e[j].Line {
+			return e[i].Line < e[j].Line
+		}
+		return e[i].Column < e[j].Column
+	})
+}
+
+func (e *ValidationErrors) add(node *yaml.Node, format string, args ...interface{}) {
+	validationError := ValidationError{Message: fmt.Sprintf(format, args...)}
+	if node != nil {
+		validationError.Line = node.Line
+		validationError.Column = node.Column
+	}
+	*e = append(*e, validationError)
+}
+
+// https://www.thethingsindustries.com/docs/reference/id-eui-constraints/
+var gatewayIDPattern = regexp.MustCompile(`^[a-z0-9](?:[-]?[a-z0-9]){2,}$`)
+
+const maxGatewayIDLength = 36
+
+// API keys of The Things Stack consist of the NNSXS prefix, the public key ID and the secret.
+var apiKeyPattern = regexp.MustCompile(`^NNSXS\.[A-Z0-9]{39}\.[A-Z0-9]{64}$`)
+
+func validateGatewayID(id string) error {
+	if id == "" {
+		return fmt.Errorf("gateway_id is required")
+	}
+	if len(id) > maxGatewayIDLength || !gatewayIDPattern.MatchString(id) {
+		return fmt.Errorf("gateway_id %q is invalid: must be 3 to %d lowercase letters, digits and single dashes, starting and ending with a letter or digit", id, maxGatewayIDLength)
+	}
+	return nil
+}
+
+var gatewayEUIPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// labelNamePattern is the Prometheus label name syntax
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func validateGatewayEUI(eui string) error {
+	if !gatewayEUIPattern.MatchString(eui) {
+		return fmt.Errorf("gateway_id %q is invalid: must be the gateway EUI as 16 lowercase hex digits", eui)
+	}
+	return nil
+}
+
+func validateTenantID(id string) error {
+	if len(id) > maxGatewayIDLength || !gatewayIDPattern.MatchString(id) {
+		return fmt.Errorf("tenant %q is invalid: must be 3 to %d lowercase letters, digits and single dashes, starting and ending with a letter or digit", id, maxGatewayIDLength)
+	}
+	return nil
+}
+
+func validateAPIKey(apiKey string) error {
+	if apiKey == "" {
+		return fmt.Errorf("api key is required, set one of api_key, api_key_file, api_key_env or api_key_command")
+	}
+	if !strings.HasPrefix(apiKey, "NNSXS.") {
+		return fmt.Errorf("api key %s does not start with NNSXS.", KeyID(apiKey))
+	}
+	if !apiKeyPattern.MatchString(apiKey) {
+		return fmt.Errorf("api key %s is malformed: expected NNSXS.<39 character key ID>.<64 character secret>", KeyID(apiKey))
+	}
+	return nil
+}
+
+func validateOAuth2(oauth2 OAuth2) []error {
+	var errs []error
+	if oauth2.ClientID == "" {
+		errs = append(errs, fmt.Errorf("oauth2 client_id is required"))
+	}
+	if oauth2.ClientSecret == "" && oauth2.RefreshToken == "" {
+		errs = append(errs, fmt.Errorf("oauth2 needs a client_secret for the client credentials grant or a refresh_token"))
+	}
+	if oauth2.TokenURL != "" {
+		if err := validateURL("token_url", oauth2.TokenURL); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func validateHTTPClient(httpClient HTTPClient) []error {
+	var errs []error
+	if httpClient.ProxyURL != "" {
+		if _, err := url.Parse(httpClient.ProxyURL); err != nil {
+			errs = append(errs, fmt.Errorf("http_client proxy_url %q is not a valid URL: %w", httpClient.ProxyURL, err))
+		}
+	}
+	if (httpClient.CertFile == "") != (httpClient.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("http_client cert_file and key_file must be set together"))
+	}
+	for _, file := range []string{httpClient.CAFile, httpClient.CertFile, httpClient.KeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("http_client: %w", err))
+		}
+	}
+	return errs
+}
+
+func validateURL(field, value string) error {
+	parsed, err := url.ParseRequestURI(value)
+	if err != nil {
+		return fmt.Errorf("%s %q is not a valid URL: %w", field, value, err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("%s %q must use http or https", field, value)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("%s %q has no host", field, value)
+	}
+	return nil
+}
+
+// validateAPIPath checks an absolute API path that may contain the {gateway_id} placeholder.
+func validateAPIPath(field, value string) error {
+	if !strings.HasPrefix(value, "/") {
+		return fmt.Errorf("%s %q must start with /", field, value)
+	}
+	if _, err := url.ParseRequestURI(strings.ReplaceAll(value, "{gateway_id}", "gateway")); err != nil {
+		return fmt.Errorf("%s %q is not a valid path: %w", field, value, err)
+	}
+	return nil
+}
+
+// validate checks the decoded config for semantic errors. root is the document the config was decoded from and is
+// used to attach line and column information.
+func (c *TargetConfig) validate(root *yaml.Node) ValidationErrors {
+	var errs ValidationErrors
+	document := documentMapping(root)
+
+	if err := validateURL("default_base_url", c.DefaultBaseUrl); err != nil {
+		errs.add(mappingValue(document, "default_base_url"), "%s", err)
+	}
+
+	if c.ClusterAutoRouting.Enabled {
+		routingNode := mappingValue(document, "cluster_auto_routing")
+		if err := validateURL("identity_server_url", c.ClusterAutoRouting.IdentityServerURL); err != nil {
+			errs.add(mappingValue(routingNode, "identity_server_url"), "cluster_auto_routing: %s", err)
+		}
+		if c.ClusterAutoRouting.CacheTTL < time.Minute {
+			errs.add(mappingValue(routingNode, "cache_ttl"), "cluster_auto_routing: cache_ttl must be at least 1m")
+		}
+	}
+
+	if c.APIKeyCheckInterval < time.Minute {
+		errs.add(mappingValue(document, "api_key_check_interval"), "api_key_check_interval must be at least 1m")
+	}
+	if c.APIKeyExpiryWarning < 0 {
+		errs.add(mappingValue(document, "api_key_expiry_warning"), "api_key_expiry_warning must not be negative")
+	}
+
+	if threshold := c.SubBands.NearLimitThreshold; threshold <= 0 || threshold > 1 {
+		subBandsNode := mappingValue(document, "sub_bands")
+		errs.add(fieldOrParent(subBandsNode, "near_limit_threshold"), "sub_bands: near_limit_threshold must be greater than 0 and at most 1")
+	}
+
+	healthNode := mappingValue(document, "health")
+	if c.Health.MaxClockOffset < 0 {
+		errs.add(fieldOrParent(healthNode, "max_clock_offset"), "health: max_clock_offset must be positive")
+	}
+	if c.Health.GPSLostAfter < 0 {
+		errs.add(fieldOrParent(healthNode, "gps_lost_after"), "health: gps_lost_after must be positive")
+	}
+
+	mapNode := mappingValue(document, "map")
+	for i, origin := range c.Map.CORSAllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		if err := validateURL("cors_allowed_origins", origin); err != nil {
+			errs.add(sequenceItem(mappingValue(mapNode, "cors_allowed_origins"), i), "map: %s", err)
+		}
+	}
+	if c.Map.CacheTTL < 0 {
+		errs.add(fieldOrParent(mapNode, "cache_ttl"), "map: cache_ttl must be positive")
+	}
+
+	if c.PublicStatus.Enabled {
+		publicNode := mappingValue(document, "public_status")
+		known := map[string]bool{}
+		for _, field := range PublicStatusFields {
+			known[field] = true
+		}
+		for i, field := range c.PublicStatus.Fields {
+			if !known[field] {
+				errs.add(sequenceItem(mappingValue(publicNode, "fields"), i), "public_status: unknown field %q, must be one of %s", field, strings.Join(PublicStatusFields, ", "))
+			}
+		}
+		if c.PublicStatus.CacheTTL < 0 {
+			errs.add(fieldOrParent(publicNode, "cache_ttl"), "public_status: cache_ttl must be positive")
+		}
+		if c.PublicStatus.RateLimit < 0 || c.PublicStatus.RateLimitBurst < 0 {
+			errs.add(fieldOrParent(publicNode, "rate_limit"), "public_status: rate_limit and rate_limit_burst must be positive")
+		}
+	}
+
+	if c.Notifications.Enabled {
+		errs = append(errs, c.validateNotifications(document)...)
+	}
+
+	if c.OTLP.Enabled {
+		otlpNode := mappingValue(document, "otlp")
+		if c.OTLP.Protocol != OTLPProtocolHTTP && c.OTLP.Protocol != OTLPProtocolGRPC {
+			errs.add(fieldOrParent(otlpNode, "protocol"), "otlp: unknown protocol %q, must be %s or %s", c.OTLP.Protocol, OTLPProtocolHTTP, OTLPProtocolGRPC)
+		}
+		if err := validateURL("endpoint", c.OTLP.Endpoint); err != nil {
+			errs.add(fieldOrParent(otlpNode, "endpoint"), "otlp: %s", err)
+		}
+		if c.OTLP.Interval < time.Second {
+			errs.add(fieldOrParent(otlpNode, "interval"), "otlp: interval must be at least 1s")
+		}
+		if c.OTLP.Timeout < 0 {
+			errs.add(fieldOrParent(otlpNode, "timeout"), "otlp: timeout must be positive")
+		}
+	}
+
+	errs = append(errs, c.validateOutputs(document)...)
+
+	if c.RemoteWrite.Enabled {
+		remoteWriteNode := mappingValue(document, "remote_write")
+		if err := validateURL("url", c.RemoteWrite.URL); err != nil {
+			errs.add(fieldOrParent(remoteWriteNode, "url"), "remote_write: %s", err)
+		}
+		if c.RemoteWrite.BearerToken != "" && (c.RemoteWrite.Username != "" || c.RemoteWrite.Password != "") {
+			errs.add(fieldOrParent(remoteWriteNode, "bearer_token"), "remote_write: configure either bearer_token or username and password")
+		}
+		if c.RemoteWrite.Interval < time.Second {
+			errs.add(fieldOrParent(remoteWriteNode, "interval"), "remote_write: interval must be at least 1s")
+		}
+		if c.RemoteWrite.Timeout < 0 || c.RemoteWrite.MaxBackoff < 0 {
+			errs.add(remoteWriteNode, "remote_write: timeout and max_backoff must be positive")
+		}
+		if c.RemoteWrite.QueueCapacity < 0 || c.RemoteWrite.MaxSamplesPerSend < 0 || c.RemoteWrite.MaxRetries < 0 {
+			errs.add(remoteWriteNode, "remote_write: queue_capacity, max_samples_per_send and max_retries must be positive")
+		}
+		for name := range c.RemoteWrite.ExternalLabels {
+			if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+				errs.add(mappingKey(mappingValue(remoteWriteNode, "external_labels"), name), "remote_write: invalid external label name %q", name)
+			}
+		}
+	}
+
+	if c.MQTTPublisher.Enabled {
+		publisherNode := mappingValue(document, "mqtt_publisher")
+		if broker, err := url.Parse(c.MQTTPublisher.Broker); err != nil || broker.Host == "" {
+			errs.add(fieldOrParent(publisherNode, "broker"), "mqtt_publisher: broker %q must be a URL like tcp://host:1883", c.MQTTPublisher.Broker)
+		}
+		if strings.ContainsAny(c.MQTTPublisher.TopicPrefix, "+#") || strings.ContainsAny(c.MQTTPublisher.HomeAssistant.DiscoveryPrefix, "+#") {
+			errs.add(publisherNode, "mqtt_publisher: topic_prefix and discovery_prefix must not contain wildcards")
+		}
+		if c.MQTTPublisher.Interval < time.Second {
+			errs.add(fieldOrParent(publisherNode, "interval"), "mqtt_publisher: interval must be at least 1s")
+		}
+		if c.MQTTPublisher.QoS > 2 {
+			errs.add(fieldOrParent(publisherNode, "qos"), "mqtt_publisher: qos must be 0, 1 or 2")
+		}
+	}
+
+	driftNode := mappingValue(document, "location_drift")
+	if c.LocationDrift.SignificantMove < 0 {
+		errs.add(fieldOrParent(driftNode, "significant_move"), "location_drift: significant_move must be positive")
+	}
+	if c.LocationDrift.HistorySize < 0 {
+		errs.add(fieldOrParent(driftNode, "history_size"), "location_drift: history_size must be positive")
+	}
+
+	eventsNode := mappingValue(document, "events")
+	for i, prefix := range c.Events.OwnDevAddrPrefixes {
+		if _, err := lora.ParseDevAddrPrefix(prefix); err != nil {
+			errs.add(sequenceItem(mappingValue(eventsNode, "own_dev_addr_prefixes"), i), "events: %s", err)
+		}
+	}
+	for i, netID := range c.Events.TTNNetIDs {
+		if _, err := lora.ParseNetID(netID); err != nil {
+			errs.add(sequenceItem(mappingValue(eventsNode, "ttn_net_ids"), i), "events: %s", err)
+		}
+	}
+
+	if c.GatewayConfiguration.Enabled {
+		gcsNode := mappingValue(document, "gateway_configuration")
+		if c.GatewayConfiguration.Interval < time.Minute {
+			errs.add(fieldOrParent(gcsNode, "interval"), "gateway_configuration: interval must be at least 1m")
+		}
+		if err := validateAPIPath("path", c.GatewayConfiguration.Path); err != nil {
+			errs.add(fieldOrParent(gcsNode, "path"), "gateway_configuration: %s", err)
+		}
+	}
+
+	clusterNodes := mappingValue(document, "clusters")
+	clusterNames := map[string]bool{}
+	for i, cluster := range c.Clusters {
+		clusterNode := sequenceItem(clusterNodes, i)
+		fieldNode := func(field string) *yaml.Node {
+			return fieldOrParent(clusterNode, field)
+		}
+
+		if cluster.Name == "" {
+			errs.add(clusterNode, "clusters[%d]: name is required", i)
+		} else if clusterNames[cluster.Name] {
+			errs.add(fieldNode("name"), "clusters[%d]: duplicate cluster name %q", i, cluster.Name)
+		}
+		clusterNames[cluster.Name] = true
+		// the tenant may also be set per target, so only the placeholder syntax is checked here
+		if err := validateURL("base_url", strings.ReplaceAll(cluster.BaseUrl, tenantPlaceholder, "tenant")); err != nil {
+			errs.add(fieldNode("base_url"), "clusters[%d]: %s", i, err)
+		}
+		if cluster.Backend != "" && cluster.Backend != BackendTTN && cluster.Backend != BackendChirpStack {
+			errs.add(fieldNode("backend"), "clusters[%d]: unknown backend %q, must be %s or %s", i, cluster.Backend, BackendTTN, BackendChirpStack)
+		}
+		if cluster.Tenant != "" {
+			if err := validateTenantID(cluster.Tenant); err != nil {
+				errs.add(fieldNode("tenant"), "clusters[%d]: %s", i, err)
+			}
+		}
+		if cluster.OAuth2 != nil {
+			for _, err := range validateOAuth2(*cluster.OAuth2) {
+				errs.add(fieldNode("oauth2"), "clusters[%d]: %s", i, err)
+			}
+		}
+		if cluster.HTTPClient != nil {
+			for _, err := range validateHTTPClient(*cluster.HTTPClient) {
+				errs.add(fieldNode("http_client"), "clusters[%d]: %s", i, err)
+			}
+		}
+	}
+
+	targetNodes := mappingValue(document, "targets")
+	if len(c.Targets) == 0 && len(c.TenantDiscovery) == 0 && len(c.MQTTSources) == 0 {
+		errs.add(targetNodes, "no targets configured")
+	}
+	seen := map[string]*yaml.Node{}
+	for i, target := range c.Targets {
+		targetNode := sequenceItem(targetNodes, i)
+		idNode := fieldOrParent(targetNode, "gateway_id")
+
+		validateID := validateGatewayID
+		if target.Backend == BackendChirpStack {
+			validateID = validateGatewayEUI
+		}
+		if err := validateID(target.GatewayID); err != nil {
+			errs.add(idNode, "targets[%d]: %s", i, err)
+		} else if previous, ok := seen[target.FullGatewayID()]; ok {
+			errs.add(idNode, "targets[%d]: duplicate gateway_id %q, first defined in line %d", i, target.FullGatewayID(), lineOf(previous))
+		} else {
+			seen[target.FullGatewayID()] = idNode
+		}
+		errs = append(errs, validateTarget(fmt.Sprintf("targets[%d]", i), target, targetNode, clusterNames)...)
+	}
+
+	sourceNodes := mappingValue(document, "mqtt_sources")
+	sourceNames := map[string]bool{}
+	for i, source := range c.MQTTSources {
+		sourceNode := sequenceItem(sourceNodes, i)
+		fieldNode := func(field string) *yaml.Node {
+			return fieldOrParent(sourceNode, field)
+		}
+
+		if source.Name == "" {
+			errs.add(sourceNode, "mqtt_sources[%d]: name is required", i)
+		} else if sourceNames[source.Name] || clusterNames[source.Name] {
+			errs.add(fieldNode("name"), "mqtt_sources[%d]: name %q is already used by another MQTT source or cluster", i, source.Name)
+		}
+		sourceNames[source.Name] = true
+		if broker, err := url.Parse(source.Broker); err != nil || broker.Host == "" {
+			errs.add(fieldNode("broker"), "mqtt_sources[%d]: broker %q must be a URL like tcp://host:1883", i, source.Broker)
+		}
+		if source.Marshaler != "json" && source.Marshaler != "protobuf" {
+			errs.add(fieldNode("marshaler"), "mqtt_sources[%d]: marshaler must be json or protobuf", i)
+		}
+		gatewayNodes := mappingValue(sourceNode, "gateways")
+		if len(source.Gateways) == 0 {
+			errs.add(fieldNode("gateways"), "mqtt_sources[%d]: no gateways configured", i)
+		}
+		for eui, gatewayID := range source.Gateways {
+			euiNode := mappingKey(gatewayNodes, eui)
+			if err := validateGatewayEUI(strings.ToLower(eui)); err != nil {
+				errs.add(euiNode, "mqtt_sources[%d]: %s", i, err)
+			}
+			if err := validateGatewayID(gatewayID); err != nil {
+				errs.add(mappingValue(gatewayNodes, eui), "mqtt_sources[%d]: %s", i, err)
+			} else if previous, ok := seen[gatewayID]; ok {
+				errs.add(mappingValue(gatewayNodes, eui), "mqtt_sources[%d]: duplicate gateway_id %q, first defined in line %d", i, gatewayID, lineOf(previous))
+			} else {
+				seen[gatewayID] = mappingValue(gatewayNodes, eui)
+			}
+		}
+	}
+
+	discoveryNodes := mappingValue(document, "tenant_discovery")
+	for i, discovery := range c.TenantDiscovery {
+		discoveryNode := sequenceItem(discoveryNodes, i)
+		section := fmt.Sprintf("tenant_discovery[%d]", i)
+		if discovery.GatewayID != "" {
+			errs.add(fieldOrParent(discoveryNode, "gateway_id"), "%s: gateway_id must not be set, all gateways of the tenant are discovered", section)
+		}
+		if discovery.Tenant == "" {
+			errs.add(discoveryNode, "%s: tenant is required", section)
+		}
+		if discovery.Backend != BackendTTN {
+			errs.add(fieldOrParent(discoveryNode, "backend"), "%s: tenant discovery is only supported for the %s backend", section, BackendTTN)
+		}
+		errs = append(errs, validateTarget(section, discovery, discoveryNode, clusterNames)...)
+	}
+
+	return errs
+}
+
+// validateNotifications checks the sinks, the templates and that all recipients reference a sink.
+func (c *TargetConfig) validateNotifications(document *yaml.Node) ValidationErrors {
+	var errs ValidationErrors
+	notificationsNode := mappingValue(document, "notifications")
+
+	if c.Notifications.Interval < 10*time.Second {
+		errs.add(fieldOrParent(notificationsNode, "interval"), "notifications: interval must be at least 10s")
+	}
+	if c.Notifications.HoldDown < 0 || c.Notifications.RepeatInterval < 0 || c.Notifications.FlappingWindow < 0 {
+		errs.add(notificationsNode, "notifications: hold_down, repeat_interval and flapping_window must be positive")
+	}
+	if c.Notifications.FlappingTransitions < 2 {
+		errs.add(fieldOrParent(notificationsNode, "flapping_transitions"), "notifications: flapping_transitions must be at least 2")
+	}
+
+	templatesNode := mappingValue(notificationsNode, "templates")
+	known := map[string]bool{"title": true}
+	for _, event := range NotificationEvents {
+		known[event] = true
+	}
+	for name, text := range c.Notifications.Templates {
+		if !known[name] {
+			errs.add(mappingKey(templatesNode, name), "notifications: unknown template %q, must be title or one of %s", name, strings.Join(NotificationEvents, ", "))
+		} else if _, err := template.New(name).Parse(text); err != nil {
+			errs.add(mappingValue(templatesNode, name), "notifications: template %s: %s", name, err)
+		}
+	}
+
+	sinkNodes := mappingValue(notificationsNode, "sinks")
+	sinks := map[string]NotificationSink{}
+	for i, sink := range c.Notifications.Sinks {
+		sinkNode := sequenceItem(sinkNodes, i)
+		fieldNode := func(field string) *yaml.Node {
+			return fieldOrParent(sinkNode, field)
+		}
+
+		if sink.Name == "" {
+			errs.add(sinkNode, "notifications.sinks[%d]: name is required", i)
+		} else if _, ok := sinks[sink.Name]; ok {
+			errs.add(fieldNode("name"), "notifications.sinks[%d]: duplicate name %q", i, sink.Name)
+		}
+		sinks[sink.Name] = sink
+		switch sink.Type {
+		case SinkWebhook, SinkMatrix, SinkTelegram, SinkNtfy:
+			if err := validateURL("url", sink.URL); err != nil {
+				errs.add(fieldNode("url"), "notifications.sinks[%d]: %s", i, err)
+			}
+			if (sink.Type == SinkMatrix || sink.Type == SinkTelegram) && sink.Token == "" {
+				errs.add(sinkNode, "notifications.sinks[%d]: token is required for %s", i, sink.Type)
+			}
+		case SinkSMTP:
+			if sink.Host == "" || sink.From == "" {
+				errs.add(sinkNode, "notifications.sinks[%d]: host and from are required for smtp", i)
+			}
+		default:
+			errs.add(fieldNode("type"), "notifications.sinks[%d]: unknown type %q, must be one of %s, %s, %s, %s or %s", i, sink.Type, SinkWebhook, SinkMatrix, SinkTelegram, SinkNtfy, SinkSMTP)
+		}
+		if sink.Timeout < 0 {
+			errs.add(fieldNode("timeout"), "notifications.sinks[%d]: timeout must be positive", i)
+		}
+	}
+
+	validateRecipients := func(section string, recipients []Recipient, recipientNodes *yaml.Node) {
+		for i, recipient := range recipients {
+			recipientNode := sequenceItem(recipientNodes, i)
+			sink, ok := sinks[recipient.Sink]
+			switch {
+			case !ok:
+				errs.add(fieldOrParent(recipientNode, "sink"), "%s[%d]: unknown sink %q", section, i, recipient.Sink)
+			case sink.Type == SinkWebhook && recipient.To != "":
+				errs.add(fieldOrParent(recipientNode, "to"), "%s[%d]: webhook sinks have no recipient, configure another sink instead", section, i)
+			case sink.Type != SinkWebhook && recipient.To == "" && sink.To == "":
+				errs.add(recipientNode, "%s[%d]: to is required, sink %q has no default recipient", section, i, recipient.Sink)
+			}
+		}
+	}
+	validateRecipients("notifications.recipients", c.Notifications.Recipients, mappingValue(notificationsNode, "recipients"))
+	for section, targets := range map[string][]Target{"targets": c.Targets, "tenant_discovery": c.TenantDiscovery} {
+		for i, target := range targets {
+			targetNode := sequenceItem(mappingValue(document, section), i)
+			validateRecipients(fmt.Sprintf("%s[%d].notify", section, i), target.Notify, mappingValue(targetNode, "notify"))
+		}
+	}
+	return errs
+}
+
+// validateOutputs checks the connection settings, the batching and the layout of the outputs.
+func (c *TargetConfig) validateOutputs(document *yaml.Node) ValidationErrors {
+	var errs ValidationErrors
+	outputNodes := mappingValue(document, "outputs")
+	targetLabels := map[string]bool{}
+	for _, label := range OutputTargetLabels {
+		targetLabels[label] = true
+	}
+	names := map[string]bool{}
+	for i, output := range c.Outputs {
+		outputNode := sequenceItem(outputNodes, i)
+		fieldNode := func(field string) *yaml.Node {
+			return fieldOrParent(outputNode, field)
+		}
+
+		if output.Name == "" {
+			errs.add(outputNode, "outputs[%d]: name is required", i)
+		} else if names[output.Name] {
+			errs.add(fieldNode("name"), "outputs[%d]: duplicate name %q", i, output.Name)
+		}
+		names[output.Name] = true
+		switch output.Type {
+		case OutputInfluxDB:
+			if err := validateURL("url", output.URL); err != nil {
+				errs.add(fieldNode("url"), "outputs[%d]: %s", i, err)
+			}
+			if output.Org == "" || output.Bucket == "" {
+				errs.add(outputNode, "outputs[%d]: org and bucket are required for influxdb", i)
+			}
+		case OutputGraphite:
+			if _, _, err := net.SplitHostPort(output.Address); err != nil {
+				errs.add(fieldNode("address"), "outputs[%d]: address must be host:port: %s", i, err)
+			}
+		default:
+			errs.add(fieldNode("type"), "outputs[%d]: unknown type %q, must be %s or %s", i, output.Type, OutputInfluxDB, OutputGraphite)
+		}
+		if output.Interval < time.Second {
+			errs.add(fieldNode("interval"), "outputs[%d]: interval must be at least 1s", i)
+		}
+		if output.Timeout < 0 {
+			errs.add(fieldNode("timeout"), "outputs[%d]: timeout must be positive", i)
+		}
+		if output.BatchSize < 0 || output.MaxRetries < 0 || output.BufferSize < 0 {
+			errs.add(outputNode, "outputs[%d]: batch_size, max_retries and buffer_size must be positive", i)
+		}
+
+		layoutNode := mappingValue(outputNode, "layout")
+		for j, tag := range output.Layout.Tags {
+			if !targetLabels[tag] {
+				errs.add(sequenceItem(mappingValue(layoutNode, "tags"), j), "outputs[%d]: unknown tag %q, must be one of %s", i, tag, strings.Join(OutputTargetLabels, ", "))
+			}
+		}
+		if output.Type == OutputGraphite && !output.Layout.Tagged && len(output.Layout.ExtraTags) > 0 {
+			errs.add(mappingValue(layoutNode, "extra_tags"), "outputs[%d]: extra_tags require tagged for graphite", i)
+		}
+	}
+	return errs
+}
+
+// validateTarget checks the connection settings and credentials of a target.
+func validateTarget(section string, target Target, targetNode *yaml.Node, clusterNames map[string]bool) ValidationErrors {
+	var errs ValidationErrors
+
+	switch target.Backend {
+	case BackendTTN:
+	case BackendChirpStack:
+		if target.Tenant != "" {
+			errs.add(fieldOrParent(targetNode, "tenant"), "%s: tenant is not supported for the %s backend", section, target.Backend)
+		}
+		if target.OAuth2 != nil {
+			errs.add(fieldOrParent(targetNode, "oauth2"), "%s: oauth2 is not supported for the %s backend", section, target.Backend)
+		}
+		if err := validateURL("base_url", target.BaseUrl); err != nil {
+			errs.add(fieldOrParent(targetNode, "base_url"), "%s: %s, the %s backend has no default", section, err, target.Backend)
+		}
+		if !target.HasAPIKeySource() {
+			errs.add(targetNode, "%s: an API token is required, set one of api_key, api_key_file, api_key_env or api_key_command", section)
+		}
+		return errs
+	default:
+		errs.add(fieldOrParent(targetNode, "backend"), "%s: unknown backend %q, must be %s or %s", section, target.Backend, BackendTTN, BackendChirpStack)
+		return errs
+	}
+
+	if target.Tenant != "" {
+		if err := validateTenantID(target.Tenant); err != nil {
+			errs.add(fieldOrParent(targetNode, "tenant"), "%s: %s", section, err)
+		}
+	}
+
+	// an inherited default_base_url has already been checked above
+	if err := validateURL("base_url", target.BaseUrl); err != nil && mappingValue(targetNode, "base_url") != nil {
+		errs.add(fieldOrParent(targetNode, "base_url"), "%s: %s", section, err)
+	}
+	if strings.Contains(target.BaseUrl, tenantPlaceholder) {
+		errs.add(fieldOrParent(targetNode, "base_url"), "%s: base_url contains %s but no tenant is set", section, tenantPlaceholder)
+	}
+
+	if target.GatewayConfigurationPath != "" {
+		if err := validateAPIPath("gateway_configuration_path", target.GatewayConfigurationPath); err != nil {
+			errs.add(fieldOrParent(targetNode, "gateway_configuration_path"), "%s: %s", section, err)
+		}
+	}
+
+	if target.Cluster != "" && !clusterNames[target.Cluster] {
+		errs.add(fieldOrParent(targetNode, "cluster"), "%s: unknown cluster %q", section, target.Cluster)
+	}
+
+	if target.OAuth2 != nil {
+		// oauth2 settings inherited from a cluster have already been checked above
+		if mappingValue(targetNode, "oauth2") != nil {
+			for _, err := range validateOAuth2(*target.OAuth2) {
+				errs.add(fieldOrParent(targetNode, "oauth2"), "%s: %s", section, err)
+			}
+		}
+		return errs
+	}
+
+	keyResolved := target.APIKey != "" || !target.HasAPIKeySource()
+	if err := validateAPIKey(target.APIKey); keyResolved && err != nil {
+		keyNode := fieldOrParent(targetNode, "api_key")
+		if target.APIKeyFile != "" {
+			keyNode = fieldOrParent(targetNode, "api_key_file")
+		} else if target.APIKeyEnv != "" {
+			keyNode = fieldOrParent(targetNode, "api_key_env")
+		} else if len(target.APIKeyCommand) > 0 {
+			keyNode = fieldOrParent(targetNode, "api_key_command")
+		}
+		errs.add(keyNode, "%s: %s", section, err)
+	}
+	return errs
+}
+
+// checkKnownFields reports every mapping key in node that has no corresponding yaml tag in the Go type.
+func checkKnownFields(node *yaml.Node, t reflect.Type, errs *ValidationErrors) {
+	if node == nil {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			checkKnownFields(child, t, errs)
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for _, child := range node.Content {
+			checkKnownFields(child, t.Elem(), errs)
+		}
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Map:
+			for i := 1; i < len(node.Content); i += 2 {
+				checkKnownFields(node.Content[i], t.Elem(), errs)
+			}
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i]
+				fieldType, ok := fields[key.Value]
+				if !ok {
+					errs.add(key, "unknown field %q", key.Value)
+					continue
+				}
+				checkKnownFields(node.Content[i+1], fieldType, errs)
+			}
+		}
+	}
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func documentMapping(root *yaml.Node) *yaml.Node {
+	if root != nil && root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		return root.Content[0]
+	}
+	return root
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil if the key does not exist.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingKey returns the key node for key in a mapping node, or nil if the key does not exist.
+func mappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i]
+		}
+	}
+	return nil
+}
+
+// sequenceItem returns the i-th item of a sequence node, or nil if it doesn't exist.
+func sequenceItem(sequence *yaml.Node, i int) *yaml.Node {
+	if sequence == nil || sequence.Kind != yaml.SequenceNode || i >= len(sequence.Content) {
+		return nil
+	}
+	return sequence.Content[i]
+}
+
+// fieldOrParent returns the value node for field in a mapping node, or the mapping node itself if the field is not set.
+func fieldOrParent(mapping *yaml.Node, field string) *yaml.Node {
+	if node := mappingValue(mapping, field); node != nil {
+		return node
+	}
+	return mapping
+}
+
+func lineOf(node *yaml.Node) int {
+	if node == nil {
+		return 0
+	}
+	return node.Line
+}
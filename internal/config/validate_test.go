@@ -0,0 +1,306 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testAPIKey has the format of an API key of The Things Stack
+var testAPIKey = "NNSXS." + strings.Repeat("A", 39) + "." + strings.Repeat("B", 64)
+
+// readTestTargets writes the config to a file and reads it, with {key} replaced by a valid API key.
+func readTestTargets(t *testing.T, content string) (TargetConfig, error) {
+	t.Helper()
+	location := filepath.Join(t.TempDir(), "targets.yaml")
+	if err := os.WriteFile(location, []byte(strings.ReplaceAll(content, "{key}", testAPIKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return ReadTargets(location)
+}
+
+func TestValidation(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "api-key")
+	if err := os.WriteFile(keyFile, []byte(testAPIKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_GATEWAY_API_KEY", testAPIKey)
+
+	tests := []struct {
+		name   string
+		config string
+		// want are the expected errors as line:column: message prefix, empty for valid configs. The config starts
+		// with an empty line, so the first line of the fixture is line 2.
+		want []string
+	}{
+		{
+			name: "valid",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key: {key}
+  - gateway_id: my-gateway
+    tenant: acme
+    api_key: {key}
+  - gateway_id: other-gateway
+    api_key_file: ` + keyFile + `
+  - gateway_id: env-gateway
+    api_key_env: TEST_GATEWAY_API_KEY
+  - gateway_id: command-gateway
+    api_key_command: [echo, {key}]
+`,
+		},
+		{
+			name: "unknown top-level field",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key: {key}
+defualt_base_url: https://eu1.cloud.thethings.network
+`,
+			want: []string{`5:1: unknown field "defualt_base_url"`},
+		},
+		{
+			name: "unknown target field",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_kye: {key}
+`,
+			want: []string{`4:5: unknown field "api_kye"`},
+		},
+		{
+			name: "unknown cluster field",
+			config: `
+clusters:
+  - name: eu1
+    base_url: https://eu1.cloud.thethings.network
+    http_client:
+      timout: 10s
+targets:
+  - gateway_id: my-gateway
+    cluster: eu1
+    api_key: {key}
+`,
+			want: []string{`6:7: unknown field "timout"`},
+		},
+		{
+			name: "unknown fields are all reported",
+			config: `
+clusters:
+  - name: eu1
+    base_ulr: https://eu1.cloud.thethings.network
+targets:
+  - gateway_id: my-gateway
+    apikey: {key}
+`,
+			want: []string{`4:5: unknown field "base_ulr"`, `7:5: unknown field "apikey"`},
+		},
+		{
+			name: "duplicate gateway ID",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key: {key}
+  - gateway_id: my-gateway
+    api_key: {key}
+`,
+			want: []string{`5:17: targets[1]: duplicate gateway_id "my-gateway", first defined in line 3`},
+		},
+		{
+			name: "duplicate gateway ID with tenant",
+			config: `
+targets:
+  - gateway_id: my-gateway@acme
+    api_key: {key}
+  - gateway_id: my-gateway
+    tenant: acme
+    api_key: {key}
+`,
+			want: []string{`5:17: targets[1]: duplicate gateway_id "my-gateway@acme"`},
+		},
+		{
+			name: "invalid gateway IDs",
+			config: `
+targets:
+  - gateway_id: My_Gateway
+    api_key: {key}
+  - gateway_id: gw
+    api_key: {key}
+  - gateway_id: my--gateway
+    api_key: {key}
+  - gateway_id: 0016c001ff10d3f6
+    backend: chirpstack
+    base_url: http://chirpstack:8090
+    api_key: token
+  - gateway_id: 0016C001FF10D3F
+    backend: chirpstack
+    base_url: http://chirpstack:8090
+    api_key: token
+`,
+			want: []string{
+				`3:17: targets[0]: gateway_id "My_Gateway" is invalid`,
+				`5:17: targets[1]: gateway_id "gw" is invalid`,
+				`7:17: targets[2]: gateway_id "my--gateway" is invalid`,
+				`13:17: targets[4]: gateway_id "0016C001FF10D3F" is invalid: must be the gateway EUI`,
+			},
+		},
+		{
+			name: "invalid API keys",
+			config: `
+targets:
+  - gateway_id: gateway-one
+    api_key: ABCDEF.secret
+  - gateway_id: gateway-two
+    api_key: NNSXS.TOOSHORT.SECRET
+`,
+			want: []string{
+				`4:14: targets[0]: api key sha256:`,
+				`6:14: targets[1]: api key TOOSHORT is malformed`,
+			},
+		},
+		{
+			name: "invalid API key from file",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key_file: ` + writeFile(t, "NNSXS.TOOSHORT.SECRET") + `
+`,
+			want: []string{`4:19: targets[0]: api key TOOSHORT is malformed`},
+		},
+		{
+			name: "two key sources",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key: {key}
+    api_key_env: TEST_GATEWAY_API_KEY
+`,
+			want: []string{`3:5: targets[0]: only one of api_key, api_key_file, api_key_env, api_key_command and oauth2 may be set`},
+		},
+		{
+			name: "key file and command",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key_file: ` + keyFile + `
+    api_key_command: [echo, {key}]
+`,
+			want: []string{`3:5: targets[0]: only one of api_key, api_key_file, api_key_env, api_key_command and oauth2 may be set`},
+		},
+		{
+			name: "failing key command",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key_command: [false]
+`,
+			want: []string{`3:5: targets[0]: api_key_command false: exit status 1`},
+		},
+		{
+			name: "invalid API key from command",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key_command:
+      - echo
+      - NNSXS.TOOSHORT.SECRET
+`,
+			want: []string{`5:7: targets[0]: api key TOOSHORT is malformed`},
+		},
+		{
+			name: "key and oauth2",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key_file: ` + keyFile + `
+    oauth2:
+      client_id: exporter
+      client_secret: secret
+`,
+			want: []string{`3:5: targets[0]: only one of api_key, api_key_file, api_key_env, api_key_command and oauth2 may be set`},
+		},
+		{
+			name: "no key source",
+			config: `
+targets:
+  - gateway_id: my-gateway
+`,
+			want: []string{`3:5: targets[0]: api key is required`},
+		},
+		{
+			name: "unset key environment variable",
+			config: `
+targets:
+  - gateway_id: my-gateway
+    api_key_env: TEST_GATEWAY_API_KEY_UNSET
+`,
+			want: []string{`3:5: targets[0]: environment variable TEST_GATEWAY_API_KEY_UNSET from api_key_env is not set`},
+		},
+		{
+			name: "errors are sorted by position",
+			config: `
+clusters:
+  - name: eu1
+    base_url: eu1.cloud.thethings.network
+targets:
+  - gateway_id: my-gateway
+    cluster: eu2
+    api_key: {key}
+`,
+			want: []string{
+				`4:15: clusters[0]: base_url "eu1.cloud.thethings.network" is not a valid URL`,
+				`7:14: targets[0]: unknown cluster "eu2"`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readTestTargets(t, test.config)
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("ReadTargets: %v", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("ReadTargets = %v, want validation errors", err)
+			}
+			if len(errs) != len(test.want) {
+				t.Errorf("got %d errors, want %d:\n%s", len(errs), len(test.want), errs)
+			}
+			for i, want := range test.want {
+				if i >= len(errs) {
+					break
+				}
+				got := strings.Replace(strings.Replace(errs[i].Error(), "line ", "", 1), ", column ", ":", 1)
+				if !strings.HasPrefix(got, want) {
+					t.Errorf("error %d = %q, want prefix %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationErrorFormat(t *testing.T) {
+	errs := ValidationErrors{
+		{Line: 4, Column: 5, Message: `unknown field "api_kye"`},
+		{Message: "no targets configured"},
+	}
+	want := "line 4, column 5: unknown field \"api_kye\"\nno targets configured"
+	if errs.Error() != want {
+		t.Errorf("Error() = %q, want %q", errs.Error(), want)
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	location := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(location, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
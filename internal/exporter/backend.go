@@ -0,0 +1,116 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"time"
+)
+
+// Backend reads the status of a single gateway from a LoRaWAN network server.
+type Backend interface {
+	// Name is exported as the backend label of the gateway's metrics
+	Name() string
+	// GatewayStatus returns the current status of the gateway. If the network server knows that the gateway is not
+	// connected but has no status for it, the error wraps ErrNotConnected.
+	GatewayStatus(ctx context.Context) (GatewayStatus, error)
+}
+
+// ErrNotConnected is wrapped by errors of backends that have no status for disconnected gateways.
+var ErrNotConnected = errors.New("gateway not connected")
+
+// GatewayStatus is the backend-neutral status of a gateway. Fields a backend doesn't know are left at their zero value,
+// optional values are nil.
+type GatewayStatus struct {
+	// Cluster is the network server cluster the status was read from
+	Cluster   string
+	Connected bool
+
+	ConnectedAt            time.Time
+	DisconnectedAt         time.Time
+	LastSeenAt             time.Time
+	LastStatusReceivedAt   time.Time
+	LastUplinkReceivedAt   time.Time
+	LastDownlinkReceivedAt time.Time
+
+	UplinkCount   *uint64
+	DownlinkCount *uint64
+
+	Protocol string
+	// Time is the gateway's own time in its last status message
+	Time     time.Time
+	BootTime time.Time
+	Versions map[string]string
+	IPs      []string
+	Metrics  map[string]float64
+
+	Locations      []Location
+	RoundTripTimes *RoundTripTimes
+	SubBands       []SubBand
+	// RegistryLocations are the antenna locations configured in the Identity Server, nil for antennas without location.
+	// They are only read for TTN targets with location_drift or map.
+	RegistryLocations []*Location
+	// LocationPublic is the location_public setting of the registry, nil if it is not known
+	LocationPublic *bool
+	// FrequencyPlans are only resolved for TTN targets with sub_bands.resolve_frequency_plans
+	FrequencyPlans []FrequencyPlan
+}
+
+// ClockOffset returns the gateway time minus the time the network server received the last status, if both are known.
+func (s GatewayStatus) ClockOffset() (time.Duration, bool) {
+	if s.Time.IsZero() || s.LastStatusReceivedAt.IsZero() {
+		return 0, false
+	}
+	return s.Time.Sub(s.LastStatusReceivedAt), true
+}
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+	Accuracy  float64
+	Source    string
+}
+
+type RoundTripTimes struct {
+	Min    time.Duration
+	Max    time.Duration
+	Median time.Duration
+	Count  uint32
+}
+
+type SubBand struct {
+	MinFrequency             uint64
+	MaxFrequency             uint64
+	DownlinkUtilizationLimit float64
+	DownlinkUtilization      float64
+	// Name, BandID and DutyCycle are set if the sub-band was found in the frequency plans of the gateway
+	Name      string
+	BandID    string
+	DutyCycle float64
+}
+
+// newBackend creates the backend configured for the target.
+func newBackend(targetConfig config.TargetConfig, target config.Target) (Backend, error) {
+	switch target.Backend {
+	case config.BackendTTN:
+		return newTTNBackend(targetConfig, target)
+	case config.BackendChirpStack:
+		return newChirpStackBackend(target)
+	case config.BackendChirpStackMQTT:
+		return newMQTTBackend(targetConfig, target)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", target.Backend)
+	}
+}
+
+func latest(times ...time.Time) time.Time {
+	var latestTime time.Time
+	for _, t := range times {
+		if t.After(latestTime) {
+			latestTime = t
+		}
+	}
+	return latestTime
+}
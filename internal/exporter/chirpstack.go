@@ -0,0 +1,141 @@
+package exporter
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/chirpstack"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chirpStackMetricsWindow is how far back the hourly packet counters are read. Buckets that have already been counted
+// are remembered, so only new packets are added to the cumulative counts. The buckets of the first read are the
+// baseline, so packets from before the exporter started are not counted.
+const chirpStackMetricsWindow = 2 * time.Hour
+
+// chirpStackBackend reads the gateway status from the REST API of ChirpStack v4. ChirpStack only keeps aggregated
+// packet counters, so uplink and downlink counts are accumulated by the exporter, starting when it starts.
+type chirpStackBackend struct {
+	gatewayID string
+	cluster   string
+	client    *chirpstack.Client
+
+	mu        sync.Mutex
+	rxBuckets map[int64]float64
+	txBuckets map[int64]float64
+	rxTotal   uint64
+	txTotal   uint64
+	seeded    bool
+}
+
+func newChirpStackBackend(target config.Target) (*chirpStackBackend, error) {
+	httpClient, err := chirpStackHTTPClient(target.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	client, err := chirpstack.NewClient(target.BaseUrl, target.APIKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &chirpStackBackend{
+		gatewayID: target.GatewayID,
+		cluster:   target.ClusterName(),
+		client:    client,
+		rxBuckets: map[int64]float64{},
+		txBuckets: map[int64]float64{},
+	}, nil
+}
+
+func (b *chirpStackBackend) Name() string {
+	return config.BackendChirpStack
+}
+
+func (b *chirpStackBackend) GatewayStatus(ctx context.Context) (GatewayStatus, error) {
+	status := GatewayStatus{Cluster: b.cluster}
+
+	gateway, err := b.client.GetGateway(ctx, b.gatewayID)
+	if err != nil {
+		return status, err
+	}
+	now := time.Now()
+	metrics, err := b.client.GetGatewayMetrics(ctx, b.gatewayID, now.Add(-chirpStackMetricsWindow), now, "HOUR")
+	if err != nil {
+		return status, err
+	}
+
+	if gateway.LastSeenAt != nil {
+		status.LastSeenAt = *gateway.LastSeenAt
+		status.LastStatusReceivedAt = *gateway.LastSeenAt
+		statsInterval := time.Duration(gateway.Gateway.StatsInterval) * time.Second
+		if statsInterval == 0 {
+			statsInterval = 30 * time.Second
+		}
+		status.Connected = now.Sub(*gateway.LastSeenAt) < 2*statsInterval
+	}
+	status.Versions = gateway.Gateway.Metadata
+
+	location := gateway.Gateway.Location
+	if location.Latitude != 0 || location.Longitude != 0 {
+		status.Locations = []Location{{
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Altitude:  location.Altitude,
+			Accuracy:  location.Accuracy,
+			Source:    chirpStackLocationSource(location.Source),
+		}}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rxAdded := accumulate(b.rxBuckets, metrics.RxPackets, now)
+	txAdded := accumulate(b.txBuckets, metrics.TxPackets, now)
+	if b.seeded {
+		b.rxTotal += rxAdded
+		b.txTotal += txAdded
+	}
+	b.seeded = true
+	uplinkCount, downlinkCount := b.rxTotal, b.txTotal
+	status.UplinkCount = &uplinkCount
+	status.DownlinkCount = &downlinkCount
+
+	return status, nil
+}
+
+// accumulate returns how many packets were added to the hourly buckets since they were last seen, and forgets
+// buckets that have left the window.
+func accumulate(buckets map[int64]float64, metric chirpstack.Metric, now time.Time) uint64 {
+	var added float64
+	for i, timestamp := range metric.Timestamps {
+		var value float64
+		for _, dataset := range metric.Datasets {
+			if i < len(dataset.Data) {
+				value += dataset.Data[i]
+			}
+		}
+		bucket := timestamp.Unix()
+		if delta := value - buckets[bucket]; delta > 0 {
+			added += delta
+		}
+		buckets[bucket] = value
+	}
+	for bucket := range buckets {
+		if now.Sub(time.Unix(bucket, 0)) > chirpStackMetricsWindow+time.Hour {
+			delete(buckets, bucket)
+		}
+	}
+	return uint64(added)
+}
+
+// chirpStackLocationSource maps ChirpStack's location sources to the names used by The Things Stack, so both backends
+// can share dashboards.
+func chirpStackLocationSource(source string) string {
+	switch source {
+	case "", "UNKNOWN":
+		return "SOURCE_UNKNOWN"
+	case "CONFIG":
+		return "SOURCE_REGISTRY"
+	default:
+		return "SOURCE_" + strings.ToUpper(source)
+	}
+}
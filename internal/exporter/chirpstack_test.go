@@ -0,0 +1,140 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/chirpstack"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// bucket is an hourly packet counter of ChirpStack, hours before the current hour.
+type bucket struct {
+	hoursAgo int
+	rx, tx   float64
+}
+
+// chirpStackMetrics builds a metrics response of the hourly buckets. The rx packets are split into two datasets, as
+// ChirpStack may return one per label.
+func chirpStackMetrics(hour time.Time, buckets []bucket) chirpstack.GetGatewayMetricsResponse {
+	var response chirpstack.GetGatewayMetricsResponse
+	response.RxPackets.Datasets = []chirpstack.MetricDataset{{Label: "rx_count"}, {Label: "rx_count"}}
+	response.TxPackets.Datasets = []chirpstack.MetricDataset{{Label: "tx_count"}}
+	for _, b := range buckets {
+		timestamp := hour.Add(-time.Duration(b.hoursAgo) * time.Hour)
+		response.RxPackets.Timestamps = append(response.RxPackets.Timestamps, timestamp)
+		response.RxPackets.Datasets[0].Data = append(response.RxPackets.Datasets[0].Data, b.rx-1)
+		response.RxPackets.Datasets[1].Data = append(response.RxPackets.Datasets[1].Data, 1)
+		response.TxPackets.Timestamps = append(response.TxPackets.Timestamps, timestamp)
+		response.TxPackets.Datasets[0].Data = append(response.TxPackets.Datasets[0].Data, b.tx)
+	}
+	return response
+}
+
+func TestChirpStackCounters(t *testing.T) {
+	tests := []struct {
+		name string
+		// scrapes are the buckets returned by every scrape
+		scrapes [][]bucket
+		// wantRx and wantTx are the cumulative counts after every scrape
+		wantRx []uint64
+		wantTx []uint64
+	}{
+		{
+			name:    "first scrape is the baseline",
+			scrapes: [][]bucket{{{1, 10, 2}, {0, 5, 1}}},
+			wantRx:  []uint64{0},
+			wantTx:  []uint64{0},
+		},
+		{
+			name:    "bucket grows within the hour",
+			scrapes: [][]bucket{{{1, 10, 2}, {0, 5, 1}}, {{1, 10, 2}, {0, 8, 1}}, {{1, 10, 2}, {0, 12, 3}}},
+			wantRx:  []uint64{0, 3, 7},
+			wantTx:  []uint64{0, 0, 2},
+		},
+		{
+			name: "hour rollover",
+			scrapes: [][]bucket{
+				{{2, 10, 0}, {1, 5, 0}},
+				// the previous hour got its last packets and a new bucket started
+				{{2, 10, 0}, {1, 7, 0}, {0, 2, 1}},
+				// the oldest bucket left the window, which does not decrease the count
+				{{1, 7, 0}, {0, 3, 1}},
+			},
+			wantRx: []uint64{0, 4, 5},
+			wantTx: []uint64{0, 1, 1},
+		},
+		{
+			name: "gap of several hours",
+			scrapes: [][]bucket{
+				{{6, 10, 1}, {5, 5, 1}},
+				// the buckets in between are no longer read, the buckets in the window are counted completely
+				{{1, 4, 1}, {0, 2, 0}},
+				{{1, 4, 1}, {0, 3, 0}},
+			},
+			wantRx: []uint64{0, 6, 7},
+			wantTx: []uint64{0, 1, 1},
+		},
+		{
+			name:    "counters never decrease",
+			scrapes: [][]bucket{{{0, 10, 0}}, {{0, 4, 0}}, {{0, 6, 0}}},
+			wantRx:  []uint64{0, 0, 2},
+			wantTx:  []uint64{0, 0, 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hour := time.Now().UTC().Truncate(time.Hour)
+			scrape := 0
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/gateways/0016c001ff000001":
+					_ = json.NewEncoder(w).Encode(chirpstack.GetGatewayResponse{Gateway: chirpstack.Gateway{GatewayID: "0016c001ff000001"}})
+				case "/api/gateways/0016c001ff000001/metrics":
+					start, startErr := time.Parse(time.RFC3339, r.URL.Query().Get("start"))
+					end, endErr := time.Parse(time.RFC3339, r.URL.Query().Get("end"))
+					if startErr != nil || endErr != nil || end.Sub(start) != chirpStackMetricsWindow || r.URL.Query().Get("aggregation") != "HOUR" {
+						t.Errorf("metrics query = %s, want hourly buckets of the window", r.URL.RawQuery)
+					}
+					_ = json.NewEncoder(w).Encode(chirpStackMetrics(hour, test.scrapes[scrape]))
+					scrape++
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer api.Close()
+			backend, err := newChirpStackBackend(config.Target{GatewayID: "0016c001ff000001", APIKey: "TOKEN", BaseUrl: api.URL, Backend: config.BackendChirpStack})
+			if err != nil {
+				t.Fatalf("newChirpStackBackend: %v", err)
+			}
+
+			for i := range test.scrapes {
+				status, err := backend.GatewayStatus(context.Background())
+				if err != nil {
+					t.Fatalf("scrape %d: %v", i+1, err)
+				}
+				if *status.UplinkCount != test.wantRx[i] || *status.DownlinkCount != test.wantTx[i] {
+					t.Errorf("scrape %d: uplinks %d, downlinks %d, want %d and %d", i+1, *status.UplinkCount, *status.DownlinkCount, test.wantRx[i], test.wantTx[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAccumulateForgetsOldBuckets(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 30, 0, 0, time.UTC)
+	buckets := map[int64]float64{now.Add(-5 * time.Hour).Truncate(time.Hour).Unix(): 3}
+	metric := chirpstack.Metric{
+		Timestamps: []time.Time{now.Add(-time.Hour).Truncate(time.Hour), now.Truncate(time.Hour)},
+		Datasets:   []chirpstack.MetricDataset{{Data: []float64{4, 2}}},
+	}
+	if added := accumulate(buckets, metric, now); added != 6 {
+		t.Errorf("added = %d, want 6", added)
+	}
+	if len(buckets) != 2 {
+		t.Errorf("buckets = %v, want only the buckets in the window", buckets)
+	}
+}
@@ -0,0 +1,128 @@
+package exporter
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/chirpstack"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// NewClient creates a TTN API client with the credentials and HTTP settings of the target.
+func NewClient(config config.Target) (*ttnclient.TTNClient, error) {
+	httpClient, err := httpClient(config.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	return ttnclient.NewTTNClient(config.BaseUrl, authenticator(config, httpClient), httpClient)
+}
+
+// newRegistryClient creates a client for the Identity Server the target's gateway is registered in. With auto routing,
+// that is the configured identity_server_url, otherwise the target's cluster.
+func newRegistryClient(targetConfig config.TargetConfig, target config.Target) (*ttnclient.TTNClient, error) {
+	if targetConfig.ClusterAutoRouting.Enabled {
+		target = target.WithBaseUrl(targetConfig.ClusterAutoRouting.IdentityServerURL)
+	}
+	return NewClient(target)
+}
+
+func authenticator(target config.Target, httpClient *http.Client) ttnclient.Authenticator {
+	if target.OAuth2 != nil {
+		return oauth2Authenticator(*target.OAuth2, httpClient)
+	}
+	if target.APIKeyFile != "" {
+		return &ttnclient.FileApiKeyAuthenticator{Path: target.APIKeyFile}
+	}
+	if len(target.APIKeyCommand) > 0 {
+		return ttnclient.NewCommandApiKeyAuthenticator(target.APIKey, func(ctx context.Context) (string, error) {
+			return config.RunAPIKeyCommand(ctx, target.APIKeyCommand)
+		})
+	}
+	return ttnclient.ApiKeyAuthenticator{ApiKey: target.APIKey}
+}
+
+var (
+	httpClientsMu         sync.Mutex
+	httpClients           = map[config.HTTPClient]*http.Client{}
+	chirpStackHTTPClients = map[config.HTTPClient]*http.Client{}
+)
+
+// httpClient returns the same HTTP client for identical settings, so targets of a cluster share their connections.
+func httpClient(options config.HTTPClient) (*http.Client, error) {
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+
+	if client, ok := httpClients[options]; ok {
+		return client, nil
+	}
+	client, err := ttnclient.NewHTTPClient(httpOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	httpClients[options] = client
+	return client, nil
+}
+
+// chirpStackHTTPClient is httpClient for the ChirpStack API, whose requests are counted separately from the TTN API.
+func chirpStackHTTPClient(options config.HTTPClient) (*http.Client, error) {
+	httpClientsMu.Lock()
+	defer httpClientsMu.Unlock()
+
+	if client, ok := chirpStackHTTPClients[options]; ok {
+		return client, nil
+	}
+	transport, err := ttnclient.NewTransport(httpOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	client := chirpstack.NewHTTPClient(transport, options.Timeout)
+	chirpStackHTTPClients[options] = client
+	return client, nil
+}
+
+func httpOptions(options config.HTTPClient) ttnclient.HTTPOptions {
+	return ttnclient.HTTPOptions{
+		ProxyURL:            options.ProxyURL,
+		CAFile:              options.CAFile,
+		CertFile:            options.CertFile,
+		KeyFile:             options.KeyFile,
+		InsecureSkipVerify:  options.InsecureSkipVerify,
+		Timeout:             options.Timeout,
+		DialTimeout:         options.DialTimeout,
+		TLSHandshakeTimeout: options.TLSHandshakeTimeout,
+		KeepAlive:           options.KeepAlive,
+		DisableKeepAlives:   options.DisableKeepAlives,
+		IdleConnTimeout:     options.IdleConnTimeout,
+		MaxIdleConns:        options.MaxIdleConns,
+		MaxIdleConnsPerHost: options.MaxIdleConnsPerHost,
+	}
+}
+
+var (
+	oauth2AuthenticatorsMu sync.Mutex
+	oauth2Authenticators   = map[string]*ttnclient.OAuth2Authenticator{}
+)
+
+// oauth2Authenticator returns the same authenticator for identical OAuth client settings, so targets of a cluster share
+// one access token and a rotated refresh token is not invalidated by another target.
+func oauth2Authenticator(oauth2 config.OAuth2, httpClient *http.Client) *ttnclient.OAuth2Authenticator {
+	oauth2AuthenticatorsMu.Lock()
+	defer oauth2AuthenticatorsMu.Unlock()
+
+	cacheKey := strings.Join([]string{oauth2.TokenURL, oauth2.ClientID, oauth2.ClientSecret, oauth2.RefreshToken, strings.Join(oauth2.Scopes, " ")}, "\x00")
+	if authenticator, ok := oauth2Authenticators[cacheKey]; ok {
+		return authenticator
+	}
+	authenticator := &ttnclient.OAuth2Authenticator{
+		TokenURL:     oauth2.TokenURL,
+		ClientID:     oauth2.ClientID,
+		ClientSecret: oauth2.ClientSecret,
+		RefreshToken: oauth2.RefreshToken,
+		Scopes:       oauth2.Scopes,
+		HTTP:         httpClient,
+	}
+	oauth2Authenticators[cacheKey] = authenticator
+	return authenticator
+}
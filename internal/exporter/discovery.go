@@ -0,0 +1,42 @@
+package exporter
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"time"
+)
+
+// DiscoverTenantGateways lists the gateways of every tenant_discovery entry and returns a target for each gateway that
+// is not configured explicitly.
+func DiscoverTenantGateways(ctx context.Context, targetConfig config.TargetConfig) ([]config.Target, error) {
+	configured := map[string]bool{}
+	for _, target := range targetConfig.Targets {
+		configured[target.FullGatewayID()] = true
+	}
+
+	var discovered []config.Target
+	for _, discovery := range targetConfig.TenantDiscovery {
+		client, err := NewClient(discovery)
+		if err != nil {
+			return nil, err
+		}
+		listCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		gateways, err := client.ListGateways(listCtx, "ids")
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, gateway := range gateways {
+			target := discovery
+			target.GatewayID = gateway.IDs.GatewayID
+			if configured[target.FullGatewayID()] {
+				continue
+			}
+			configured[target.FullGatewayID()] = true
+			discovered = append(discovered, target)
+		}
+		log.Infow("discovered tenant gateways", "tenant", discovery.Tenant, "gateways", len(gateways))
+	}
+	return discovered, nil
+}
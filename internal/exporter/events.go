@@ -0,0 +1,310 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/lora"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	eventUplink   = "gs.up.receive"
+	eventDownlink = "gs.down.send"
+
+	minStreamBackoff = 5 * time.Second
+	maxStreamBackoff = 5 * time.Minute
+)
+
+// EventMonitor subscribes to the uplink and downlink events of every gateway, accumulates the time on air of the
+// messages per channel and classifies the received uplinks by network and message type.
+type EventMonitor struct {
+	gateways []*trafficGateway
+	descs    map[string]*prometheus.Desc
+}
+
+// uplinkClass is the network an uplink belongs to and its message type.
+type uplinkClass struct {
+	network string
+	mType   string
+}
+
+// trafficClassifier assigns uplinks to our own devices, to other devices on TTN, or to foreign networks whose traffic
+// is forwarded through Packet Broker.
+type trafficClassifier struct {
+	own []lora.DevAddrPrefix
+	ttn map[lora.NetID]bool
+}
+
+type trafficGateway struct {
+	target     config.Target
+	router     *router
+	classifier *trafficClassifier
+
+	mu               sync.Mutex
+	cluster          string
+	streaming        bool
+	uplinkAirtime    map[uint64]time.Duration
+	downlinkAirtime  map[uint64]time.Duration
+	unknownDataRates uint64
+	uplinks          map[uplinkClass]uint64
+}
+
+func NewEventMonitor(targetConfig config.TargetConfig, targets []*Target) (*EventMonitor, error) {
+	labels := func(variableLabels ...string) []string {
+		return append([]string{"gateway", "backend", "tenant", "cluster"}, variableLabels...)
+	}
+	monitor := &EventMonitor{
+		descs: map[string]*prometheus.Desc{
+			"stream_connected":   prometheus.NewDesc(metricName("events_stream_connected"), "1 if the event stream of the Gateway is connected", labels(), nil),
+			"uplink_airtime":     prometheus.NewDesc(metricName("uplink_airtime_seconds_total"), "Time on air of the uplinks received by the Gateway", labels("frequency"), nil),
+			"downlink_airtime":   prometheus.NewDesc(metricName("downlink_airtime_seconds_total"), "Time on air of the downlinks sent by the Gateway", labels("frequency"), nil),
+			"unknown_data_rates": prometheus.NewDesc(metricName("airtime_unknown_data_rate_total"), "Number of messages whose time on air could not be calculated, e.g. FSK or LR-FHSS", labels(), nil),
+			"uplinks":            prometheus.NewDesc(metricName("received_uplinks_total"), "Number of uplinks received by the Gateway by network (own, ttn, packetbroker or unknown) and message type", labels("network", "type"), nil),
+		},
+	}
+	if !targetConfig.Events.Enabled {
+		return monitor, nil
+	}
+
+	classifier, err := newTrafficClassifier(targetConfig.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, gatewayTarget := range targets {
+		router, ok := gatewayTarget.ttnRouter()
+		if !ok {
+			continue
+		}
+		target := gatewayTarget.Config()
+		monitor.gateways = append(monitor.gateways, &trafficGateway{
+			target:          target,
+			router:          router,
+			classifier:      classifier,
+			cluster:         target.ClusterName(),
+			uplinkAirtime:   map[uint64]time.Duration{},
+			downlinkAirtime: map[uint64]time.Duration{},
+			uplinks:         map[uplinkClass]uint64{},
+		})
+	}
+	return monitor, nil
+}
+
+func (m *EventMonitor) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range m.descs {
+		descs <- desc
+	}
+}
+
+func (m *EventMonitor) Collect(metrics chan<- prometheus.Metric) {
+	for _, gateway := range m.gateways {
+		m.collect(gateway, metrics)
+	}
+}
+
+func (m *EventMonitor) collect(gateway *trafficGateway, metrics chan<- prometheus.Metric) {
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+
+	metric := func(name string, valueType prometheus.ValueType, value float64, labelValues ...string) prometheus.Metric {
+		labelValues = append([]string{gateway.target.GatewayID, gateway.target.Backend, gateway.target.Tenant, gateway.cluster}, labelValues...)
+		return prometheus.MustNewConstMetric(m.descs[name], valueType, value, labelValues...)
+	}
+	metrics <- metric("stream_connected", prometheus.GaugeValue, boolValue(gateway.streaming))
+	metrics <- metric("unknown_data_rates", prometheus.CounterValue, float64(gateway.unknownDataRates))
+	for _, frequency := range sortedFrequencies(gateway.uplinkAirtime) {
+		metrics <- metric("uplink_airtime", prometheus.CounterValue, gateway.uplinkAirtime[frequency].Seconds(), strconv.FormatUint(frequency, 10))
+	}
+	for _, frequency := range sortedFrequencies(gateway.downlinkAirtime) {
+		metrics <- metric("downlink_airtime", prometheus.CounterValue, gateway.downlinkAirtime[frequency].Seconds(), strconv.FormatUint(frequency, 10))
+	}
+	for class, count := range gateway.uplinks {
+		metrics <- metric("uplinks", prometheus.CounterValue, float64(count), class.network, class.mType)
+	}
+}
+
+// Run streams the events of all gateways until the context is cancelled. Broken streams are reconnected with
+// exponential backoff, to the cluster the gateway is routed to at that time.
+func (m *EventMonitor) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, gateway := range m.gateways {
+		wg.Add(1)
+		go func(gateway *trafficGateway) {
+			defer wg.Done()
+			gateway.run(ctx)
+		}(gateway)
+	}
+	wg.Wait()
+}
+
+func (g *trafficGateway) run(ctx context.Context) {
+	gatewayID := g.target.FullGatewayID()
+	backoff := minStreamBackoff
+	for {
+		startedAt := time.Now()
+		cluster, client, err := g.router.Route(ctx)
+		if err == nil {
+			g.setStreaming(true, cluster.ClusterName())
+			err = client.StreamGatewayEvents(ctx, g.target.GatewayID, []string{eventUplink, eventDownlink}, g.handle)
+			g.setStreaming(false, cluster.ClusterName())
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(startedAt) > maxStreamBackoff {
+			backoff = minStreamBackoff
+		}
+		log.Warnw("event stream ended", "target", gatewayID, "error", err, "retryIn", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxStreamBackoff {
+			backoff = maxStreamBackoff
+		}
+	}
+}
+
+// setStreaming records the state of the stream. The airtime counters are kept when the gateway moves to another
+// cluster, only the cluster label changes.
+func (g *trafficGateway) setStreaming(streaming bool, cluster string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.streaming, g.cluster = streaming, cluster
+}
+
+func (g *trafficGateway) handle(event ttnclient.Event) {
+	switch event.Name {
+	case eventUplink:
+		gatewayMessage, err := ttnclient.DecodeGatewayUplinkMessage(event.Data)
+		if err != nil {
+			log.Errorw("event decoding error", "target", g.target.FullGatewayID(), "event", event.Name, "error", err)
+			return
+		}
+		message := gatewayMessage.Message
+		// LoRaWAN uplinks carry a payload CRC
+		g.addAirtime(g.uplinkAirtime, message.Settings, len(message.RawPayload), true)
+		g.countUplink(g.classifier.classify(message.RawPayload))
+	case eventDownlink:
+		var message ttnclient.DownlinkMessage
+		if err := json.Unmarshal(event.Data, &message); err != nil {
+			log.Errorw("event decoding error", "target", g.target.FullGatewayID(), "event", event.Name, "error", err)
+			return
+		}
+		if message.Scheduled == nil {
+			return
+		}
+		g.addAirtime(g.downlinkAirtime, *message.Scheduled, len(message.RawPayload), false)
+	}
+}
+
+func (g *trafficGateway) addAirtime(airtime map[uint64]time.Duration, settings ttnclient.TxSettings, payloadSize int, crc bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	frequency, err := strconv.ParseUint(settings.Frequency, 10, 64)
+	loRa := settings.DataRate.LoRa
+	if err != nil || loRa == nil {
+		g.unknownDataRates++
+		return
+	}
+	codingRate := loRa.CodingRate
+	if codingRate == "" {
+		codingRate = settings.CodingRate
+	}
+	dataRate := lora.DataRate{SpreadingFactor: loRa.SpreadingFactor, Bandwidth: loRa.Bandwidth, CodingRate: codingRate}
+	timeOnAir, err := dataRate.TimeOnAir(payloadSize, crc)
+	if err != nil {
+		g.unknownDataRates++
+		return
+	}
+	airtime[frequency] += timeOnAir
+}
+
+func (g *trafficGateway) countUplink(class uplinkClass) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.uplinks[class]++
+}
+
+func newTrafficClassifier(events config.Events) (*trafficClassifier, error) {
+	classifier := &trafficClassifier{ttn: map[lora.NetID]bool{}}
+	for _, value := range events.OwnDevAddrPrefixes {
+		prefix, err := lora.ParseDevAddrPrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		classifier.own = append(classifier.own, prefix)
+	}
+	for _, value := range events.TTNNetIDs {
+		netID, err := lora.ParseNetID(value)
+		if err != nil {
+			return nil, err
+		}
+		classifier.ttn[netID] = true
+	}
+	return classifier, nil
+}
+
+// classify determines the network and message type of an uplink from its PHY payload. Join and rejoin requests carry
+// no DevAddr, so their network is unknown.
+func (c *trafficClassifier) classify(payload []byte) uplinkClass {
+	class := uplinkClass{network: "unknown", mType: "unknown"}
+	mType, err := lora.ParseMType(payload)
+	if err != nil {
+		return class
+	}
+	switch mType {
+	case lora.MTypeJoinRequest:
+		class.mType = "join-request"
+		return class
+	case lora.MTypeRejoinRequest:
+		class.mType = "rejoin-request"
+		return class
+	case lora.MTypeProprietary:
+		class.mType = "proprietary"
+		return class
+	case lora.MTypeUnconfirmedDataUp, lora.MTypeConfirmedDataUp:
+		class.mType = "data-up"
+	default:
+		return class
+	}
+
+	devAddr, err := lora.ParseDevAddr(payload)
+	if err != nil {
+		return class
+	}
+	for _, prefix := range c.own {
+		if prefix.Matches(devAddr) {
+			class.network = "own"
+			return class
+		}
+	}
+	netID, err := devAddr.NetID()
+	if err != nil {
+		return class
+	}
+	if c.ttn[netID] {
+		class.network = "ttn"
+	} else {
+		class.network = "packetbroker"
+	}
+	return class
+}
+
+func sortedFrequencies(airtime map[uint64]time.Duration) []uint64 {
+	frequencies := make([]uint64, 0, len(airtime))
+	for frequency := range airtime {
+		frequencies = append(frequencies, frequency)
+	}
+	sort.Slice(frequencies, func(i, j int) bool { return frequencies[i] < frequencies[j] })
+	return frequencies
+}
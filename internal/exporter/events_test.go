@@ -0,0 +1,205 @@
+package exporter
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	// gatewayUplinkEvent is the data of a gs.up.receive event of The Things Stack 3.x, a GatewayUplinkMessage
+	gatewayUplinkEvent = `{
+		"@type": "type.googleapis.com/ttn.lorawan.v3.GatewayUplinkMessage",
+		"message": {
+			"raw_payload": "QDQSCyYAAQABAQIDqrvM3Q==",
+			"settings": {
+				"data_rate": {"lora": {"bandwidth": 125000, "spreading_factor": 7, "coding_rate": "4/5"}},
+				"frequency": "868100000",
+				"timestamp": 2541234561
+			},
+			"rx_metadata": [{"gateway_ids": {"gateway_id": "my-gateway"}, "rssi": -87, "snr": 9.5}],
+			"received_at": "2026-10-19T08:15:30.250Z"
+		},
+		"band_id": "EU_863_870"
+	}`
+	// bareUplinkEvent is the data of a gs.up.receive event of older versions, an UplinkMessage with the coding rate in
+	// the settings
+	bareUplinkEvent = `{
+		"@type": "type.googleapis.com/ttn.lorawan.v3.UplinkMessage",
+		"raw_payload": "QDQSCyYAAQABAQIDqrvM3Q==",
+		"settings": {
+			"data_rate": {"lora": {"bandwidth": 125000, "spreading_factor": 7}},
+			"coding_rate": "4/5",
+			"frequency": "868300000"
+		}
+	}`
+	downlinkEvent = `{
+		"@type": "type.googleapis.com/ttn.lorawan.v3.DownlinkMessage",
+		"raw_payload": "YDQSCyYgAQAAAAAA",
+		"scheduled": {
+			"data_rate": {"lora": {"bandwidth": 125000, "spreading_factor": 9, "coding_rate": "4/5"}},
+			"frequency": "869525000"
+		}
+	}`
+	fskUplinkEvent = `{
+		"message": {
+			"raw_payload": "QDQSCyYAAQABAQIDqrvM3Q==",
+			"settings": {"data_rate": {"fsk": {"bit_rate": 50000}}, "frequency": "868800000"}
+		},
+		"band_id": "EU_863_870"
+	}`
+)
+
+func newTestTrafficGateway(t *testing.T) *trafficGateway {
+	t.Helper()
+	classifier, err := newTrafficClassifier(config.Events{TTNNetIDs: []string{"000013"}})
+	if err != nil {
+		t.Fatalf("newTrafficClassifier: %v", err)
+	}
+	return &trafficGateway{
+		target:          config.Target{GatewayID: "my-gateway"},
+		classifier:      classifier,
+		uplinkAirtime:   map[uint64]time.Duration{},
+		downlinkAirtime: map[uint64]time.Duration{},
+		uplinks:         map[uplinkClass]uint64{},
+	}
+}
+
+func TestTrafficGatewayHandle(t *testing.T) {
+	gateway := newTestTrafficGateway(t)
+	for _, data := range []string{gatewayUplinkEvent, bareUplinkEvent, fskUplinkEvent, `{"message": []}`} {
+		gateway.handle(ttnclient.Event{Name: eventUplink, Data: []byte(data)})
+	}
+	gateway.handle(ttnclient.Event{Name: eventDownlink, Data: []byte(downlinkEvent)})
+
+	// 16 byte uplinks at SF7BW125 and a 12 byte downlink at SF9BW125 without CRC, as in the Semtech calculator
+	wantUplinkAirtime := map[uint64]time.Duration{
+		868100000: 51456 * time.Microsecond,
+		868300000: 51456 * time.Microsecond,
+	}
+	for frequency, want := range wantUplinkAirtime {
+		if got := gateway.uplinkAirtime[frequency]; got != want {
+			t.Errorf("uplink airtime on %d = %s, want %s", frequency, got, want)
+		}
+	}
+	if len(gateway.uplinkAirtime) != len(wantUplinkAirtime) {
+		t.Errorf("uplink airtime = %v, want %v", gateway.uplinkAirtime, wantUplinkAirtime)
+	}
+	if got, want := gateway.downlinkAirtime[869525000], 144384*time.Microsecond; got != want {
+		t.Errorf("downlink airtime = %s, want %s", got, want)
+	}
+	if gateway.unknownDataRates != 1 {
+		t.Errorf("unknown data rates = %d, want 1 for the FSK uplink", gateway.unknownDataRates)
+	}
+	// the FSK uplink is still classified
+	if got := gateway.uplinks[uplinkClass{network: "ttn", mType: "data-up"}]; got != 3 {
+		t.Errorf("ttn data-up uplinks = %d, want 3 (uplinks %v)", got, gateway.uplinks)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	classifier, err := newTrafficClassifier(config.Events{OwnDevAddrPrefixes: []string{"260B1200/24"}, TTNNetIDs: []string{"000013"}})
+	if err != nil {
+		t.Fatalf("newTrafficClassifier: %v", err)
+	}
+	// dataUp is a data uplink of the DevAddr with the given MHDR, with FCtrl, FCnt and FPort
+	dataUp := func(mhdr byte, devAddr ...byte) []byte {
+		return append(append([]byte{mhdr}, devAddr...), 0x00, 0x01, 0x00, 0x01)
+	}
+	tests := []struct {
+		name    string
+		payload []byte
+		want    uplinkClass
+	}{
+		{name: "own prefix wins over ttn", payload: dataUp(0x40, 0x34, 0x12, 0x0B, 0x26), want: uplinkClass{network: "own", mType: "data-up"}},
+		{name: "ttn", payload: dataUp(0x40, 0x34, 0x13, 0x0B, 0x26), want: uplinkClass{network: "ttn", mType: "data-up"}},
+		{name: "confirmed", payload: dataUp(0x80, 0x34, 0x13, 0x0B, 0x26), want: uplinkClass{network: "ttn", mType: "data-up"}},
+		{name: "packetbroker type 0", payload: dataUp(0x40, 0x00, 0x00, 0x00, 0x02), want: uplinkClass{network: "packetbroker", mType: "data-up"}},
+		{name: "packetbroker type 3", payload: dataUp(0x40, 0x00, 0x00, 0x46, 0xE2), want: uplinkClass{network: "packetbroker", mType: "data-up"}},
+		{name: "invalid NetID type", payload: dataUp(0x40, 0x00, 0x00, 0x00, 0xFF), want: uplinkClass{network: "unknown", mType: "data-up"}},
+		{name: "truncated", payload: []byte{0x40, 0x34, 0x12}, want: uplinkClass{network: "unknown", mType: "data-up"}},
+		{name: "join-request", payload: make([]byte, 23), want: uplinkClass{network: "unknown", mType: "join-request"}},
+		{name: "rejoin-request", payload: []byte{0xC0, 0x00, 0x13, 0x00, 0x00}, want: uplinkClass{network: "unknown", mType: "rejoin-request"}},
+		{name: "proprietary", payload: []byte{0xE0, 0x34, 0x12, 0x0B, 0x26}, want: uplinkClass{network: "unknown", mType: "proprietary"}},
+		{name: "downlink", payload: dataUp(0x60, 0x34, 0x12, 0x0B, 0x26), want: uplinkClass{network: "unknown", mType: "unknown"}},
+		{name: "empty", want: uplinkClass{network: "unknown", mType: "unknown"}},
+	}
+	for _, test := range tests {
+		if got := classifier.classify(test.payload); got != test.want {
+			t.Errorf("%s: classify = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEventMonitorSharesRouter(t *testing.T) {
+	targetConfig := config.TargetConfig{Events: config.Events{Enabled: true}}
+	var targets []*Target
+	for _, target := range []config.Target{
+		{GatewayID: "my-gateway", APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN},
+		{GatewayID: "0016c001ff000001", APIKey: "TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendChirpStack},
+	} {
+		gatewayTarget, err := NewTarget(targetConfig, target)
+		if err != nil {
+			t.Fatalf("NewTarget: %v", err)
+		}
+		targets = append(targets, gatewayTarget)
+	}
+
+	monitor, err := NewEventMonitor(targetConfig, targets)
+	if err != nil {
+		t.Fatalf("NewEventMonitor: %v", err)
+	}
+	// ChirpStack targets have no event stream, the stream of a TTN target follows the route of the target
+	if len(monitor.gateways) != 1 || monitor.gateways[0].router != targets[0].backend.(*ttnBackend).router {
+		t.Errorf("gateways = %+v, want my-gateway with the router of its target", monitor.gateways)
+	}
+}
+
+func TestEventMonitorLabels(t *testing.T) {
+	target := config.Target{GatewayID: "my-gateway", Tenant: "acme", APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN}
+	gatewayTarget, err := NewTarget(config.TargetConfig{}, target)
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+	monitor, err := NewEventMonitor(config.TargetConfig{}, nil)
+	if err != nil {
+		t.Fatalf("NewEventMonitor: %v", err)
+	}
+	gateway := newTestTrafficGateway(t)
+	gateway.target, gateway.cluster = target, "eu1"
+	gateway.handle(ttnclient.Event{Name: eventUplink, Data: []byte(gatewayUplinkEvent)})
+	monitor.gateways = []*trafficGateway{gateway}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(monitor)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	want := map[string]string{}
+	for _, sample := range gatewayTarget.Samples(GatewayStatus{Cluster: "eu1"}, nil) {
+		if sample.Definition.Key == "connected" {
+			want = sample.Labels
+		}
+	}
+	if len(want) != 4 {
+		t.Fatalf("target labels = %v, want gateway, backend, tenant and cluster", want)
+	}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := map[string]string{}
+			for _, pair := range metric.Label {
+				if _, ok := want[pair.GetName()]; ok {
+					labels[pair.GetName()] = pair.GetValue()
+				}
+			}
+			// the metrics join with the target metrics on all of their labels
+			if !reflect.DeepEqual(labels, want) {
+				t.Errorf("%s has labels %v, want %v", family.GetName(), labels, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// frequencyPlanRefreshInterval is how often the frequency plans of a gateway are resolved again
+	frequencyPlanRefreshInterval = 24 * time.Hour
+	// frequencyPlanRetryInterval is how long to wait after a failed resolution
+	frequencyPlanRetryInterval = 5 * time.Minute
+)
+
+type FrequencyPlan struct {
+	ID     string
+	Name   string
+	BandID string
+}
+
+// subBandNames are the regional names of sub-bands, keyed by band ID and frequency range. The EU 868 sub-bands are
+// named after annex 1 of ERC Recommendation 70-03.
+var subBandNames = map[string]map[[2]uint64]string{
+	"EU_863_870": {
+		{863000000, 865000000}: "h1.3",
+		{865000000, 868000000}: "h1.4",
+		{868000000, 868600000}: "h1.5",
+		{868700000, 869200000}: "h1.6",
+		{869400000, 869650000}: "h1.7",
+		{869700000, 870000000}: "h1.9",
+	},
+}
+
+// frequencyPlanResolver looks up the frequency plans of a gateway in the Identity Server and the sub-bands of their
+// bands in the configuration service.
+type frequencyPlanResolver struct {
+	gatewayID string
+	registry  *ttnclient.TTNClient
+
+	mu         sync.Mutex
+	resolvedAt time.Time
+	retryAt    time.Time
+	plans      []FrequencyPlan
+	subBands   map[[2]uint64]SubBand
+}
+
+func newFrequencyPlanResolver(targetConfig config.TargetConfig, target config.Target) (*frequencyPlanResolver, error) {
+	registry, err := newRegistryClient(targetConfig, target)
+	if err != nil {
+		return nil, err
+	}
+	return &frequencyPlanResolver{gatewayID: target.GatewayID, registry: registry}, nil
+}
+
+// annotate adds the frequency plans of the gateway to the status and names the sub-bands. cluster is the target the
+// configuration of the frequency plans is read from. Resolution errors are logged, the status is left as is.
+func (r *frequencyPlanResolver) annotate(ctx context.Context, cluster config.Target, status *GatewayStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.resolvedAt) > frequencyPlanRefreshInterval && time.Now().After(r.retryAt) {
+		if err := r.resolve(ctx, cluster); err != nil {
+			log.Errorw("frequency plan resolution error", "target", r.gatewayID, "error", err)
+			r.retryAt = time.Now().Add(frequencyPlanRetryInterval)
+		} else {
+			r.resolvedAt = time.Now()
+		}
+	}
+
+	status.FrequencyPlans = r.plans
+	for i, band := range status.SubBands {
+		if resolved, ok := r.subBands[[2]uint64{band.MinFrequency, band.MaxFrequency}]; ok {
+			status.SubBands[i].Name = resolved.Name
+			status.SubBands[i].BandID = resolved.BandID
+			status.SubBands[i].DutyCycle = resolved.DutyCycle
+		}
+	}
+}
+
+func (r *frequencyPlanResolver) resolve(ctx context.Context, cluster config.Target) error {
+	gateway, err := r.registry.GetGateway(ctx, r.gatewayID, "frequency_plan_ids")
+	if err != nil {
+		return err
+	}
+	descriptions, err := frequencyPlanDescriptions(ctx, cluster)
+	if err != nil {
+		return err
+	}
+
+	var plans []FrequencyPlan
+	subBands := map[[2]uint64]SubBand{}
+	for _, planID := range gateway.FrequencyPlanIDs {
+		description, ok := descriptions[planID]
+		if !ok {
+			return fmt.Errorf("unknown frequency plan %s", planID)
+		}
+		plans = append(plans, FrequencyPlan{ID: description.ID, Name: description.Name, BandID: description.BandID})
+		if description.BandID == "" {
+			continue
+		}
+		bandSubBands, err := bandSubBands(ctx, cluster, description.BandID)
+		if err != nil {
+			return err
+		}
+		for frequencies, subBand := range bandSubBands {
+			subBands[frequencies] = subBand
+		}
+	}
+	r.plans, r.subBands = plans, subBands
+	return nil
+}
+
+// The frequency plans and bands of a cluster are the same for all its gateways, so they are cached per cluster. The
+// lock is not held while fetching, so a slow cluster does not block the others. Concurrent misses may fetch twice.
+var (
+	configurationCacheMu sync.Mutex
+	frequencyPlanCache   = map[string]cachedFrequencyPlans{}
+	bandCache            = map[string]cachedBand{}
+)
+
+type cachedFrequencyPlans struct {
+	fetchedAt time.Time
+	plans     map[string]ttnclient.FrequencyPlanDescription
+}
+
+type cachedBand struct {
+	fetchedAt time.Time
+	subBands  map[[2]uint64]SubBand
+}
+
+func frequencyPlanDescriptions(ctx context.Context, cluster config.Target) (map[string]ttnclient.FrequencyPlanDescription, error) {
+	configurationCacheMu.Lock()
+	cached, ok := frequencyPlanCache[cluster.BaseUrl]
+	configurationCacheMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < frequencyPlanRefreshInterval {
+		return cached.plans, nil
+	}
+
+	client, err := NewClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+	descriptions, err := client.ListFrequencyPlans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plans := map[string]ttnclient.FrequencyPlanDescription{}
+	for _, description := range descriptions {
+		plans[description.ID] = description
+	}
+	configurationCacheMu.Lock()
+	frequencyPlanCache[cluster.BaseUrl] = cachedFrequencyPlans{fetchedAt: time.Now(), plans: plans}
+	configurationCacheMu.Unlock()
+	return plans, nil
+}
+
+// bandSubBands returns the sub-bands of a band keyed by their frequency range. Bands are described per regional
+// parameters version. Gateways are not bound to a version and sub-bands rarely differ between versions, so the newest
+// version is used.
+func bandSubBands(ctx context.Context, cluster config.Target, bandID string) (map[[2]uint64]SubBand, error) {
+	cacheKey := cluster.BaseUrl + "|" + bandID
+	configurationCacheMu.Lock()
+	cached, ok := bandCache[cacheKey]
+	configurationCacheMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < frequencyPlanRefreshInterval {
+		return cached.subBands, nil
+	}
+
+	client, err := NewClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := client.GetBand(ctx, bandID)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("unknown band %s", bandID)
+	}
+	phyVersions := make([]string, 0, len(versions))
+	for phyVersion := range versions {
+		phyVersions = append(phyVersions, phyVersion)
+	}
+
+	subBands := map[[2]uint64]SubBand{}
+	for _, subBand := range versions[newestPHYVersion(phyVersions)].SubBands {
+		minFrequency, minErr := strconv.ParseUint(subBand.MinFrequency, 10, 64)
+		maxFrequency, maxErr := strconv.ParseUint(subBand.MaxFrequency, 10, 64)
+		if minErr != nil || maxErr != nil {
+			log.Errorw("numeric string to int conversion error", "band", bandID, "source", "sub_bands", "value", subBand)
+			continue
+		}
+		frequencies := [2]uint64{minFrequency, maxFrequency}
+		name, ok := subBandNames[bandID][frequencies]
+		if !ok {
+			name = fmt.Sprintf("%.1f-%.1f MHz", float64(minFrequency)/1e6, float64(maxFrequency)/1e6)
+		}
+		subBands[frequencies] = SubBand{
+			MinFrequency: minFrequency,
+			MaxFrequency: maxFrequency,
+			Name:         name,
+			BandID:       bandID,
+			DutyCycle:    subBand.DutyCycle,
+		}
+	}
+	configurationCacheMu.Lock()
+	bandCache[cacheKey] = cachedBand{fetchedAt: time.Now(), subBands: subBands}
+	configurationCacheMu.Unlock()
+	return subBands, nil
+}
+
+// newestPHYVersion returns the newest of the regional parameters versions of The Things Stack, e.g. TS001_V1_0_1,
+// RP001_V1_0_2_REV_B or RP002_V1_0_4. Versions that cannot be parsed are only used if there is no other.
+func newestPHYVersion(phyVersions []string) string {
+	sort.Slice(phyVersions, func(i, j int) bool {
+		a, aOk := parsePHYVersion(phyVersions[i])
+		b, bOk := parsePHYVersion(phyVersions[j])
+		if aOk != bOk {
+			return !aOk
+		}
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return phyVersions[i] < phyVersions[j]
+	})
+	return phyVersions[len(phyVersions)-1]
+}
+
+// parsePHYVersion returns the specification, version and revision of a regional parameters version, in the order of
+// their release. The versions of the LoRaWAN specification (TS001) predate the separate regional parameters documents
+// RP001 and RP002.
+func parsePHYVersion(phyVersion string) ([5]int, bool) {
+	var parsed [5]int
+	parts := strings.Split(phyVersion, "_")
+	if len(parts) < 2 || len(parts[0]) != 5 || !strings.HasPrefix(parts[1], "V") {
+		return parsed, false
+	}
+	document, err := strconv.Atoi(parts[0][2:])
+	if err != nil {
+		return parsed, false
+	}
+	switch parts[0][:2] {
+	case "TS":
+		parsed[0] = 0
+	case "RP":
+		parsed[0] = document
+	default:
+		return parsed, false
+	}
+
+	parts[1] = strings.TrimPrefix(parts[1], "V")
+	for i, part := range parts[1:] {
+		if part == "REV" && i+2 == len(parts)-1 && len(parts[i+2]) == 1 {
+			parsed[4] = int(parts[i+2][0]-'A') + 1
+			break
+		}
+		number, err := strconv.Atoi(part)
+		if err != nil || i > 2 {
+			return parsed, false
+		}
+		parsed[i+1] = number
+	}
+	return parsed, true
+}
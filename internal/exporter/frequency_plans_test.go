@@ -0,0 +1,77 @@
+package exporter
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewestPHYVersion(t *testing.T) {
+	tests := []struct {
+		versions []string
+		want     string
+	}{
+		{versions: []string{"TS001_V1_0", "TS001_V1_0_1", "RP001_V1_0_2", "RP001_V1_0_2_REV_B", "RP002_V1_0_4", "RP002_V1_0_0"}, want: "RP002_V1_0_4"},
+		{versions: []string{"TS001_V1_0_1", "RP001_V1_0_2"}, want: "RP001_V1_0_2"},
+		{versions: []string{"RP001_V1_0_2_REV_B", "RP001_V1_0_2"}, want: "RP001_V1_0_2_REV_B"},
+		{versions: []string{"RP001_V1_0_3_REV_A", "RP001_V1_1_REV_A", "RP001_V1_0_2_REV_B"}, want: "RP001_V1_1_REV_A"},
+		{versions: []string{"RP001_V1_1_REV_B", "RP001_V1_1_REV_A"}, want: "RP001_V1_1_REV_B"},
+		{versions: []string{"RP002_V1_0_10", "RP002_V1_0_9"}, want: "RP002_V1_0_10"},
+		{versions: []string{"TS001_V1_0", "UNKNOWN"}, want: "TS001_V1_0"},
+		{versions: []string{"UNKNOWN"}, want: "UNKNOWN"},
+	}
+	for _, test := range tests {
+		if got := newestPHYVersion(append([]string{}, test.versions...)); got != test.want {
+			t.Errorf("newestPHYVersion(%v) = %s, want %s", test.versions, got, test.want)
+		}
+	}
+}
+
+func TestFrequencyPlanDescriptionsDoesNotBlockOtherClusters(t *testing.T) {
+	requested, release := make(chan struct{}), make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		_, _ = w.Write([]byte(`{"frequency_plans": [{"id": "US_902_928_FSB_2", "band_id": "US_902_928"}]}`))
+	}))
+	defer slow.Close()
+	var fastRequests int32
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fastRequests, 1)
+		_, _ = w.Write([]byte(`{"frequency_plans": [{"id": "EU_863_870_TTN", "band_id": "EU_863_870"}]}`))
+	}))
+	defer fast.Close()
+
+	slowDone := make(chan error)
+	go func() {
+		_, err := frequencyPlanDescriptions(context.Background(), config.Target{APIKey: "NNSXS.TEST", BaseUrl: slow.URL, Backend: config.BackendTTN})
+		slowDone <- err
+	}()
+	<-requested
+
+	// the cache is not locked while the slow cluster is fetched
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	fastCluster := config.Target{APIKey: "NNSXS.TEST", BaseUrl: fast.URL, Backend: config.BackendTTN}
+	for i := 0; i < 2; i++ {
+		plans, err := frequencyPlanDescriptions(ctx, fastCluster)
+		if err != nil {
+			t.Fatalf("frequencyPlanDescriptions: %v", err)
+		}
+		if plans["EU_863_870_TTN"].BandID != "EU_863_870" {
+			t.Errorf("plans = %v, want EU_863_870_TTN", plans)
+		}
+	}
+	if requests := atomic.LoadInt32(&fastRequests); requests != 1 {
+		t.Errorf("requests = %d, want 1 as the plans are cached", requests)
+	}
+
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Errorf("frequencyPlanDescriptions of the slow cluster: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+// ConfigMonitor periodically fetches the packet forwarder configuration of every gateway from the Gateway
+// Configuration Server, exports its channel plan and counts changes of the effective configuration. The configuration
+// is fetched from the cluster the target is routed to, with the labels of the target metrics.
+type ConfigMonitor struct {
+	gateways []*watchedGateway
+	interval time.Duration
+	descs    map[string]*prometheus.Desc
+}
+
+type watchedGateway struct {
+	target config.Target
+	router *router
+	path   string
+
+	mu            sync.Mutex
+	cluster       string
+	fetched       bool
+	lastFetchOk   bool
+	hash          string
+	configuration ttnclient.GatewayConfiguration
+	changes       uint64
+	changedAt     time.Time
+}
+
+func NewConfigMonitor(targetConfig config.TargetConfig, targets []*Target) *ConfigMonitor {
+	labels := func(variableLabels ...string) []string {
+		return append([]string{"gateway", "backend", "tenant", "cluster"}, variableLabels...)
+	}
+	monitor := &ConfigMonitor{
+		interval: targetConfig.GatewayConfiguration.Interval,
+		descs: map[string]*prometheus.Desc{
+			"last_fetch_result":     prometheus.NewDesc(metricName("gcs_last_fetch_result"), "1 if the last fetch of the gateway configuration was successful", labels(), nil),
+			"config_info":           prometheus.NewDesc(metricName("gcs_config_info"), "Constantly 1. Exports the SHA-256 of the normalized gateway configuration as label", labels("hash"), nil),
+			"config_changed":        prometheus.NewDesc(metricName("gcs_config_changed_total"), "Number of changes of the gateway configuration since the exporter started", labels(), nil),
+			"config_changed_at":     prometheus.NewDesc(metricName("gcs_config_changed_at"), "Time a change of the gateway configuration was last detected", labels(), nil),
+			"radio_frequency":       prometheus.NewDesc(metricName("gcs_radio_frequency_hz"), "Center frequency of an enabled radio", labels("radio"), nil),
+			"channel_frequency":     prometheus.NewDesc(metricName("gcs_channel_frequency_hz"), "Frequency of an enabled channel", labels("channel", "type", "radio"), nil),
+			"channel_bandwidth":     prometheus.NewDesc(metricName("gcs_channel_bandwidth_hz"), "Bandwidth of an enabled channel", labels("channel"), nil),
+			"channel_spreading_min": prometheus.NewDesc(metricName("gcs_channel_spreading_factor_min"), "Lowest spreading factor of an enabled LoRa channel", labels("channel"), nil),
+			"channel_spreading_max": prometheus.NewDesc(metricName("gcs_channel_spreading_factor_max"), "Highest spreading factor of an enabled LoRa channel", labels("channel"), nil),
+			"lbt_enabled":           prometheus.NewDesc(metricName("gcs_lbt_enabled"), "1 if listen-before-talk is enabled", labels(), nil),
+			"antenna_gain":          prometheus.NewDesc(metricName("gcs_antenna_gain_dbi"), "Antenna gain configured in the packet forwarder", labels(), nil),
+		},
+	}
+	if !targetConfig.GatewayConfiguration.Enabled {
+		return monitor
+	}
+
+	for _, gatewayTarget := range targets {
+		router, ok := gatewayTarget.ttnRouter()
+		if !ok {
+			continue
+		}
+		target := gatewayTarget.Config()
+		path := target.GatewayConfigurationPath
+		if path == "" {
+			path = targetConfig.GatewayConfiguration.Path
+		}
+		monitor.gateways = append(monitor.gateways, &watchedGateway{target: target, router: router, path: path, cluster: target.ClusterName()})
+	}
+	return monitor
+}
+
+func (m *ConfigMonitor) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range m.descs {
+		descs <- desc
+	}
+}
+
+func (m *ConfigMonitor) Collect(metrics chan<- prometheus.Metric) {
+	for _, gateway := range m.gateways {
+		m.collect(gateway, metrics)
+	}
+}
+
+func (m *ConfigMonitor) collect(gateway *watchedGateway, metrics chan<- prometheus.Metric) {
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+	if !gateway.fetched {
+		return
+	}
+
+	metric := func(name string, valueType prometheus.ValueType, value float64, labelValues ...string) prometheus.Metric {
+		labelValues = append([]string{gateway.target.GatewayID, gateway.target.Backend, gateway.target.Tenant, gateway.cluster}, labelValues...)
+		return prometheus.MustNewConstMetric(m.descs[name], valueType, value, labelValues...)
+	}
+	metrics <- metric("last_fetch_result", prometheus.GaugeValue, boolValue(gateway.lastFetchOk))
+	metrics <- metric("config_changed", prometheus.CounterValue, float64(gateway.changes))
+	metrics <- metric("config_changed_at", prometheus.GaugeValue, unixTime(gateway.changedAt))
+	if gateway.hash == "" {
+		return
+	}
+
+	configuration := gateway.configuration
+	metrics <- metric("config_info", prometheus.GaugeValue, 1, gateway.hash)
+	metrics <- metric("lbt_enabled", prometheus.GaugeValue, boolValue(configuration.LBTEnabled))
+	metrics <- metric("antenna_gain", prometheus.GaugeValue, configuration.AntennaGain)
+	for _, radio := range configuration.Radios {
+		if radio.Enabled {
+			metrics <- metric("radio_frequency", prometheus.GaugeValue, float64(radio.Frequency), radio.Name)
+		}
+	}
+	for _, channel := range configuration.Channels {
+		if !channel.Enabled {
+			continue
+		}
+		metrics <- metric("channel_frequency", prometheus.GaugeValue, float64(channel.Frequency), channel.Name, channel.Type, channel.Radio)
+		metrics <- metric("channel_bandwidth", prometheus.GaugeValue, float64(channel.Bandwidth), channel.Name)
+		if channel.MaxSpreadingFactor > 0 {
+			metrics <- metric("channel_spreading_min", prometheus.GaugeValue, float64(channel.MinSpreadingFactor), channel.Name)
+			metrics <- metric("channel_spreading_max", prometheus.GaugeValue, float64(channel.MaxSpreadingFactor), channel.Name)
+		}
+	}
+}
+
+// Run fetches all gateway configurations immediately and then on every interval until the context is cancelled.
+func (m *ConfigMonitor) Run(ctx context.Context) {
+	if len(m.gateways) == 0 {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		for _, gateway := range m.gateways {
+			m.fetch(ctx, gateway)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *ConfigMonitor) fetch(ctx context.Context, gateway *watchedGateway) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	gatewayID := gateway.target.FullGatewayID()
+	cluster, client, err := gateway.router.Route(ctx)
+	var raw []byte
+	if err == nil {
+		raw, err = client.GetGatewayConfiguration(ctx, gateway.target.GatewayID, gateway.path)
+	}
+	var configuration ttnclient.GatewayConfiguration
+	var hash string
+	if err == nil {
+		configuration, err = ttnclient.ParseGatewayConfiguration(raw)
+	}
+	if err == nil {
+		hash, err = configurationHash(raw)
+	}
+
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+	gateway.fetched = true
+	gateway.lastFetchOk = err == nil
+	gateway.cluster = cluster.ClusterName()
+	if err != nil {
+		log.Errorw("gateway configuration fetch error", "target", gatewayID, "cluster", gateway.cluster, "path", gateway.path, "error", err)
+		return
+	}
+	if gateway.hash != "" && gateway.hash != hash {
+		gateway.changes++
+		gateway.changedAt = time.Now()
+		log.Warnw("gateway configuration changed", "target", gatewayID, "previousHash", gateway.hash, "hash", hash)
+	}
+	gateway.hash = hash
+	gateway.configuration = configuration
+}
+
+// configurationHash hashes the configuration document with sorted keys and without insignificant whitespace, so
+// only changes of the content are detected.
+func configurationHash(raw []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return "", err
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,109 @@
+package exporter
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGatewayConfiguration = `{"SX1301_conf": {
+	"radio_0": {"enable": true, "freq": 867500000},
+	"radio_1": {"enable": true, "freq": 868500000},
+	"chan_multiSF_0": {"enable": true, "radio": 1, "if": -400000},
+	"chan_Lora_std": {"enable": true, "radio": 1, "if": -200000, "bandwidth": 250000, "spread_factor": 7}
+}}`
+
+func TestConfigMonitorUsesRoutedCluster(t *testing.T) {
+	// the Identity Server on eu1 tells that the gateway is connected to nam1, which serves its configuration
+	eu1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/gateways/my-gateway" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ids": {"gateway_id": "my-gateway"}, "gateway_server_address": "localhost:1700"}`))
+	}))
+	defer eu1.Close()
+	nam1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/gcs/gateways/my-gateway/semtechudp/global_conf.json" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(testGatewayConfiguration))
+	}))
+	defer nam1.Close()
+	nam1URL := strings.Replace(nam1.URL, "127.0.0.1", "localhost", 1)
+
+	target := config.Target{GatewayID: "my-gateway", APIKey: "NNSXS.TEST", BaseUrl: eu1.URL, Cluster: "eu1", Backend: config.BackendTTN}
+	targetConfig := config.TargetConfig{
+		DefaultBaseUrl:       eu1.URL,
+		Clusters:             []config.Cluster{{Name: "eu1", BaseUrl: eu1.URL}, {Name: "nam1", BaseUrl: nam1URL}},
+		ClusterAutoRouting:   config.ClusterAutoRouting{Enabled: true, IdentityServerURL: eu1.URL, CacheTTL: time.Hour},
+		GatewayConfiguration: config.GatewayConfiguration{Enabled: true, Interval: time.Hour, Path: config.DefaultGatewayConfigurationPath},
+		Targets:              []config.Target{target},
+	}
+	gatewayTarget, err := NewTarget(targetConfig, target)
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+	monitor := NewConfigMonitor(targetConfig, []*Target{gatewayTarget})
+	monitor.fetch(context.Background(), monitor.gateways[0])
+	// the monitor follows the route of the target
+	if cluster := gatewayTarget.backend.(*ttnBackend).router.Cluster().ClusterName(); cluster != "nam1" {
+		t.Errorf("target is routed to %s, want nam1 like the monitor", cluster)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(monitor)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	values := map[string]float64{}
+	var labelNames []string
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := map[string]string{}
+			for _, pair := range metric.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["cluster"] != "nam1" || labels["gateway"] != "my-gateway" || labels["backend"] != "ttn" {
+				t.Errorf("%s has labels %v, want gateway my-gateway, backend ttn and cluster nam1", family.GetName(), labels)
+			}
+			if family.GetName() == "ttn_gateway_gcs_last_fetch_result" {
+				values[family.GetName()] = metric.Gauge.GetValue()
+				for name := range labels {
+					labelNames = append(labelNames, name)
+				}
+			}
+			if family.GetName() == "ttn_gateway_gcs_channel_frequency_hz" {
+				values[labels["channel"]] = metric.Gauge.GetValue()
+			}
+		}
+	}
+	want := map[string]float64{"ttn_gateway_gcs_last_fetch_result": 1, "chan_multiSF_0": 868100000, "chan_Lora_std": 868300000}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+
+	// the labels are the same as those of the target metrics
+	var targetLabelNames []string
+	for _, sample := range gatewayTarget.Samples(GatewayStatus{Cluster: "nam1"}, nil) {
+		if sample.Definition.Key == "connected" {
+			for name := range sample.Labels {
+				targetLabelNames = append(targetLabelNames, name)
+			}
+		}
+	}
+	sort.Strings(labelNames)
+	sort.Strings(targetLabelNames)
+	if len(targetLabelNames) == 0 || !reflect.DeepEqual(labelNames, targetLabelNames) {
+		t.Errorf("gcs labels = %v, want the labels of the target metrics %v", labelNames, targetLabelNames)
+	}
+}
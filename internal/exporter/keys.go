@@ -0,0 +1,227 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequiredRights returns the rights an API key needs for all features enabled on the target.
+func RequiredRights(targetConfig config.TargetConfig, target config.Target) []string {
+	rights := []string{"RIGHT_GATEWAY_STATUS_READ"}
+	if targetConfig.ClusterAutoRouting.Enabled || targetConfig.GatewayConfiguration.Enabled ||
+		targetConfig.SubBands.ResolveFrequencyPlans || targetConfig.LocationDrift.Enabled ||
+		targetConfig.Map.Enabled || targetConfig.PublicStatus.Publishes("location") {
+		rights = append(rights, "RIGHT_GATEWAY_INFO")
+	}
+	if targetConfig.Events.Enabled {
+		rights = append(rights, "RIGHT_GATEWAY_TRAFFIC_READ")
+	}
+	return rights
+}
+
+// KeyMonitor periodically checks every distinct API key against the auth_info endpoint and exports its expiry and
+// whether it has all required rights. Failed checks are counted, the last known expiry and rights are kept unless the
+// key was rejected.
+type KeyMonitor struct {
+	keys          []*monitoredKey
+	interval      time.Duration
+	expiryWarning time.Duration
+
+	expiry      *prometheus.GaugeVec
+	rightsOk    *prometheus.GaugeVec
+	checkErrors *prometheus.CounterVec
+}
+
+type monitoredKey struct {
+	client     *ttnclient.TTNClient
+	gatewayIDs []string
+	required   map[string]bool
+
+	mu    sync.Mutex
+	keyID string
+}
+
+func NewKeyMonitor(targetConfig config.TargetConfig) (*KeyMonitor, error) {
+	monitor := &KeyMonitor{
+		interval:      targetConfig.APIKeyCheckInterval,
+		expiryWarning: targetConfig.APIKeyExpiryWarning,
+		expiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ttn",
+			Subsystem: "api_key",
+			Name:      "expiry_timestamp_seconds",
+			Help:      "Time the API key expires. Not exported for keys without expiry",
+		}, []string{"key_id"}),
+		rightsOk: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "ttn",
+			Subsystem: "api_key",
+			Name:      "rights_ok",
+			Help:      "1 if the API key has all rights required by the targets using it",
+		}, []string{"key_id"}),
+		checkErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ttn",
+			Subsystem: "api_key",
+			Name:      "check_errors_total",
+			Help:      "Number of failed checks of the API key, e.g. because the API was not reachable",
+		}, []string{"key_id"}),
+	}
+
+	keysByCredential := map[string]*monitoredKey{}
+	for _, target := range targetConfig.Targets {
+		if target.Backend != config.BackendTTN || target.OAuth2 != nil {
+			// auth_info describes the OAuth client's user, not a key with rights and expiry
+			continue
+		}
+		credential := target.BaseUrl + "|" + target.APIKey
+		if target.APIKeyFile != "" {
+			credential = target.BaseUrl + "|file:" + target.APIKeyFile
+		} else if len(target.APIKeyCommand) > 0 {
+			credential = target.BaseUrl + "|command:" + strings.Join(target.APIKeyCommand, "\x00")
+		}
+		key, ok := keysByCredential[credential]
+		if !ok {
+			client, err := NewClient(target)
+			if err != nil {
+				return nil, err
+			}
+			key = &monitoredKey{
+				client:   client,
+				required: map[string]bool{},
+				keyID:    config.KeyID(target.APIKey),
+			}
+			keysByCredential[credential] = key
+			monitor.keys = append(monitor.keys, key)
+			monitor.checkErrors.WithLabelValues(key.keyID)
+		}
+		key.gatewayIDs = append(key.gatewayIDs, target.GatewayID)
+		for _, right := range RequiredRights(targetConfig, target) {
+			key.required[right] = true
+		}
+	}
+	return monitor, nil
+}
+
+func (m *KeyMonitor) Describe(descs chan<- *prometheus.Desc) {
+	m.expiry.Describe(descs)
+	m.rightsOk.Describe(descs)
+	m.checkErrors.Describe(descs)
+}
+
+func (m *KeyMonitor) Collect(metrics chan<- prometheus.Metric) {
+	m.expiry.Collect(metrics)
+	m.rightsOk.Collect(metrics)
+	m.checkErrors.Collect(metrics)
+}
+
+// Run checks all keys immediately and then on every interval until the context is cancelled.
+func (m *KeyMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		for _, key := range m.keys {
+			m.check(ctx, key)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *KeyMonitor) check(ctx context.Context, key *monitoredKey) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	info, err := key.client.GetAuthInfo(ctx)
+	if err != nil {
+		log.Errorw("api key check error", "keyId", key.currentID(), "gateways", key.gatewayIDs, "error", err)
+		m.checkErrors.WithLabelValues(key.currentID()).Inc()
+		// a rejected key has lost all rights, e.g. because it was deleted or expired. Other errors don't tell anything
+		// about the key, so the last known state is kept.
+		var apiErr *ttnclient.APIError
+		if errors.As(err, &apiErr) && apiErr.Unauthorized() {
+			m.rightsOk.WithLabelValues(key.currentID()).Set(0)
+		}
+		return
+	}
+	if info.APIKey == nil {
+		log.Warnw("credentials are not an api key", "keyId", key.currentID(), "gateways", key.gatewayIDs)
+		return
+	}
+
+	keyID := info.APIKey.APIKey.ID
+	if keyID == "" {
+		keyID = key.currentID()
+	}
+	if previousID := key.setID(keyID); previousID != keyID {
+		// the key behind an api_key_file was rotated
+		m.expiry.DeleteLabelValues(previousID)
+		m.rightsOk.DeleteLabelValues(previousID)
+		m.checkErrors.DeleteLabelValues(previousID)
+		m.checkErrors.WithLabelValues(keyID)
+	}
+
+	if expiresAt := info.APIKey.APIKey.ExpiresAt; expiresAt != nil && !expiresAt.IsZero() {
+		m.expiry.WithLabelValues(keyID).Set(float64(expiresAt.Unix()))
+		if remaining := time.Until(*expiresAt); remaining < m.expiryWarning {
+			log.Warnw("api key expires soon", "keyId", keyID, "expiresAt", expiresAt, "gateways", key.gatewayIDs)
+		}
+	} else {
+		m.expiry.DeleteLabelValues(keyID)
+	}
+
+	missing, extra := compareRights(info.APIKey.APIKey.Rights, key.required)
+	if len(missing) > 0 {
+		log.Errorw("api key is missing rights", "keyId", keyID, "missing", missing, "gateways", key.gatewayIDs)
+		m.rightsOk.WithLabelValues(keyID).Set(0)
+	} else {
+		m.rightsOk.WithLabelValues(keyID).Set(1)
+	}
+	if len(extra) > 0 || info.IsAdmin {
+		log.Warnw("api key is over-privileged", "keyId", keyID, "unneededRights", extra, "isAdmin", info.IsAdmin, "gateways", key.gatewayIDs)
+	}
+}
+
+func (k *monitoredKey) currentID() string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.keyID
+}
+
+func (k *monitoredKey) setID(keyID string) (previous string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	previous, k.keyID = k.keyID, keyID
+	return previous
+}
+
+// compareRights returns the required rights that are not granted, and the granted rights that are not required.
+// RIGHT_ALL and RIGHT_GATEWAY_ALL grant every (gateway) right.
+func compareRights(granted []string, required map[string]bool) (missing, extra []string) {
+	grantedSet := map[string]bool{}
+	for _, right := range granted {
+		grantedSet[right] = true
+	}
+	for right := range required {
+		if grantedSet[right] || grantedSet["RIGHT_ALL"] ||
+			(grantedSet["RIGHT_GATEWAY_ALL"] && strings.HasPrefix(right, "RIGHT_GATEWAY_")) {
+			continue
+		}
+		missing = append(missing, right)
+	}
+	for right := range grantedSet {
+		if !required[right] {
+			extra = append(extra, right)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra
+}
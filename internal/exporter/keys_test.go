@@ -0,0 +1,196 @@
+package exporter
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testKeyA = "NNSXS.KEYA.SECRET"
+	testKeyB = "NNSXS.KEYB.SECRET"
+)
+
+// authInfoServer answers /api/v3/auth_info with the configured status and body for the API key of the request.
+type authInfoServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]authInfoResponse
+}
+
+type authInfoResponse struct {
+	status int
+	body   string
+}
+
+func newAuthInfoServer(t *testing.T) *authInfoServer {
+	s := &authInfoServer{responses: map[string]authInfoResponse{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/auth_info" {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		response, ok := s.responses[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		s.mu.Unlock()
+		if !ok {
+			response = authInfoResponse{status: http.StatusUnauthorized, body: `{"code": 16, "message": "unauthenticated"}`}
+		}
+		w.WriteHeader(response.status)
+		_, _ = w.Write([]byte(response.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *authInfoServer) respond(apiKey string, status int, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[apiKey] = authInfoResponse{status: status, body: body}
+}
+
+func authInfo(keyID string, expiresAt string, rights ...string) string {
+	expiry := ""
+	if expiresAt != "" {
+		expiry = `, "expires_at": "` + expiresAt + `"`
+	}
+	return `{"api_key": {"api_key": {"id": "` + keyID + `", "rights": ["` + strings.Join(rights, `", "`) + `"]` + expiry + `},
+		"entity_ids": {"gateway_ids": {"gateway_id": "my-gateway"}}}}`
+}
+
+func newTestKeyMonitor(t *testing.T, targets ...config.Target) *KeyMonitor {
+	t.Helper()
+	targetConfig := config.TargetConfig{APIKeyCheckInterval: time.Hour, APIKeyExpiryWarning: 30 * 24 * time.Hour, Targets: targets}
+	monitor, err := NewKeyMonitor(targetConfig)
+	if err != nil {
+		t.Fatalf("NewKeyMonitor: %v", err)
+	}
+	return monitor
+}
+
+func TestCompareRights(t *testing.T) {
+	required := map[string]bool{"RIGHT_GATEWAY_STATUS_READ": true, "RIGHT_GATEWAY_INFO": true}
+	tests := []struct {
+		name        string
+		granted     []string
+		wantMissing []string
+		wantExtra   []string
+	}{
+		{name: "exact", granted: []string{"RIGHT_GATEWAY_INFO", "RIGHT_GATEWAY_STATUS_READ"}},
+		{name: "missing", granted: []string{"RIGHT_GATEWAY_STATUS_READ"}, wantMissing: []string{"RIGHT_GATEWAY_INFO"}},
+		{name: "none", granted: nil, wantMissing: []string{"RIGHT_GATEWAY_INFO", "RIGHT_GATEWAY_STATUS_READ"}},
+		{name: "extra", granted: []string{"RIGHT_GATEWAY_INFO", "RIGHT_GATEWAY_STATUS_READ", "RIGHT_GATEWAY_LINK"}, wantExtra: []string{"RIGHT_GATEWAY_LINK"}},
+		{name: "all", granted: []string{"RIGHT_ALL"}, wantExtra: []string{"RIGHT_ALL"}},
+		{name: "gateway all", granted: []string{"RIGHT_GATEWAY_ALL"}, wantExtra: []string{"RIGHT_GATEWAY_ALL"}},
+		{name: "duplicates", granted: []string{"RIGHT_GATEWAY_INFO", "RIGHT_GATEWAY_INFO", "RIGHT_GATEWAY_STATUS_READ"}},
+	}
+	for _, test := range tests {
+		missing, extra := compareRights(test.granted, required)
+		if !reflect.DeepEqual(missing, test.wantMissing) || !reflect.DeepEqual(extra, test.wantExtra) {
+			t.Errorf("%s: missing %v, extra %v, want %v and %v", test.name, missing, extra, test.wantMissing, test.wantExtra)
+		}
+	}
+
+	// RIGHT_GATEWAY_ALL only expands to gateway rights
+	if missing, _ := compareRights([]string{"RIGHT_GATEWAY_ALL"}, map[string]bool{"RIGHT_GATEWAY_TRAFFIC_READ": true, "RIGHT_USER_INFO": true}); !reflect.DeepEqual(missing, []string{"RIGHT_USER_INFO"}) {
+		t.Errorf("RIGHT_GATEWAY_ALL: missing %v, want RIGHT_USER_INFO", missing)
+	}
+}
+
+func TestNewKeyMonitorDeduplicates(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "api-key")
+	monitor := newTestKeyMonitor(t,
+		config.Target{GatewayID: "gateway-1", APIKey: testKeyA, BaseUrl: "https://eu1.cloud.thethings.network", Backend: config.BackendTTN},
+		config.Target{GatewayID: "gateway-2", APIKey: testKeyA, BaseUrl: "https://eu1.cloud.thethings.network", Backend: config.BackendTTN},
+		// the same key on another cluster is checked there
+		config.Target{GatewayID: "gateway-3", APIKey: testKeyA, BaseUrl: "https://nam1.cloud.thethings.network", Backend: config.BackendTTN},
+		config.Target{GatewayID: "gateway-4", APIKey: testKeyB, BaseUrl: "https://eu1.cloud.thethings.network", Backend: config.BackendTTN},
+		// keys read from the same file are the same, whatever they contain right now
+		config.Target{GatewayID: "gateway-6", APIKeyFile: keyFile, BaseUrl: "https://eu1.cloud.thethings.network", Backend: config.BackendTTN},
+		config.Target{GatewayID: "gateway-7", APIKeyFile: keyFile, BaseUrl: "https://eu1.cloud.thethings.network", Backend: config.BackendTTN},
+		// OAuth2 clients and ChirpStack tokens are no API keys of The Things Stack
+		config.Target{GatewayID: "gateway-5", BaseUrl: "https://eu1.cloud.thethings.network", Backend: config.BackendTTN,
+			OAuth2: &config.OAuth2{TokenURL: "https://eu1.cloud.thethings.network/oauth/token", ClientID: "exporter", ClientSecret: "secret"}},
+		config.Target{GatewayID: "0016c001ff000001", APIKey: "TOKEN", BaseUrl: "http://chirpstack:8090", Backend: config.BackendChirpStack},
+	)
+
+	var keys []string
+	for _, key := range monitor.keys {
+		keys = append(keys, key.keyID+":"+strings.Join(key.gatewayIDs, ","))
+	}
+	sort.Strings(keys)
+	want := []string{"KEYA:gateway-1,gateway-2", "KEYA:gateway-3", "KEYB:gateway-4", config.KeyID("") + ":gateway-6,gateway-7"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+}
+
+func TestKeyMonitorCheck(t *testing.T) {
+	server := newAuthInfoServer(t)
+	monitor := newTestKeyMonitor(t,
+		config.Target{GatewayID: "gateway-1", APIKey: testKeyA, BaseUrl: server.URL, Backend: config.BackendTTN},
+		config.Target{GatewayID: "gateway-2", APIKey: testKeyB, BaseUrl: server.URL, Backend: config.BackendTTN},
+	)
+	server.respond(testKeyA, http.StatusOK, authInfo("KEYA", "2027-01-01T00:00:00Z", "RIGHT_GATEWAY_ALL"))
+	server.respond(testKeyB, http.StatusOK, authInfo("KEYB", "", "RIGHT_GATEWAY_INFO"))
+	for _, key := range monitor.keys {
+		monitor.check(context.Background(), key)
+	}
+
+	// KEYB has no expiry and lacks RIGHT_GATEWAY_STATUS_READ
+	want := `
+# HELP ttn_api_key_check_errors_total Number of failed checks of the API key, e.g. because the API was not reachable
+# TYPE ttn_api_key_check_errors_total counter
+ttn_api_key_check_errors_total{key_id="KEYA"} 0
+ttn_api_key_check_errors_total{key_id="KEYB"} 0
+# HELP ttn_api_key_expiry_timestamp_seconds Time the API key expires. Not exported for keys without expiry
+# TYPE ttn_api_key_expiry_timestamp_seconds gauge
+ttn_api_key_expiry_timestamp_seconds{key_id="KEYA"} 1.7987616e+09
+# HELP ttn_api_key_rights_ok 1 if the API key has all rights required by the targets using it
+# TYPE ttn_api_key_rights_ok gauge
+ttn_api_key_rights_ok{key_id="KEYA"} 1
+ttn_api_key_rights_ok{key_id="KEYB"} 0
+`
+	if err := testutil.CollectAndCompare(monitor, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeyMonitorCheckErrors(t *testing.T) {
+	server := newAuthInfoServer(t)
+	monitor := newTestKeyMonitor(t, config.Target{GatewayID: "gateway-1", APIKey: testKeyA, BaseUrl: server.URL, Backend: config.BackendTTN})
+	key := monitor.keys[0]
+
+	server.respond(testKeyA, http.StatusOK, authInfo("KEYA", "2027-01-01T00:00:00Z", "RIGHT_GATEWAY_STATUS_READ"))
+	monitor.check(context.Background(), key)
+
+	// an unavailable API says nothing about the key, the last known state is kept
+	server.respond(testKeyA, http.StatusServiceUnavailable, `{"code": 14, "message": "unavailable"}`)
+	monitor.check(context.Background(), key)
+	if rightsOk := testutil.ToFloat64(monitor.rightsOk.WithLabelValues("KEYA")); rightsOk != 1 {
+		t.Errorf("rights_ok after an unavailable API = %v, want 1", rightsOk)
+	}
+	if expiry := testutil.ToFloat64(monitor.expiry.WithLabelValues("KEYA")); expiry != 1798761600 {
+		t.Errorf("expiry after an unavailable API = %v, want 1798761600", expiry)
+	}
+
+	// a rejected key has no rights
+	server.respond(testKeyA, http.StatusUnauthorized, `{"code": 16, "message": "error:pkg/auth:token_expired (token expired)"}`)
+	monitor.check(context.Background(), key)
+	if rightsOk := testutil.ToFloat64(monitor.rightsOk.WithLabelValues("KEYA")); rightsOk != 0 {
+		t.Errorf("rights_ok of a rejected key = %v, want 0", rightsOk)
+	}
+	if errors := testutil.ToFloat64(monitor.checkErrors.WithLabelValues("KEYA")); errors != 2 {
+		t.Errorf("check errors = %v, want 2", errors)
+	}
+}
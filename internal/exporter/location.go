@@ -0,0 +1,162 @@
+package exporter
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// registryLocationRefreshInterval is how often the registry locations of a gateway are read again
+	registryLocationRefreshInterval = time.Hour
+	// registryLocationRetryInterval is how long to wait after a failed lookup
+	registryLocationRetryInterval = 5 * time.Minute
+
+	earthRadius = 6371008.8
+)
+
+// LocationMove is a significant move of a reported antenna location.
+type LocationMove struct {
+	At       time.Time
+	Antenna  int
+	From     Location
+	To       Location
+	Distance float64
+}
+
+// registryLocationResolver reads the antenna locations configured in the Identity Server and whether they are public.
+type registryLocationResolver struct {
+	gatewayID string
+	registry  *ttnclient.TTNClient
+
+	mu         sync.Mutex
+	resolvedAt time.Time
+	retryAt    time.Time
+	locations  []*Location
+	public     *bool
+}
+
+func newRegistryLocationResolver(targetConfig config.TargetConfig, target config.Target) (*registryLocationResolver, error) {
+	registry, err := newRegistryClient(targetConfig, target)
+	if err != nil {
+		return nil, err
+	}
+	return &registryLocationResolver{gatewayID: target.GatewayID, registry: registry}, nil
+}
+
+// annotate adds the registry locations to the status. Lookup errors are logged, the last known locations are kept.
+func (r *registryLocationResolver) annotate(ctx context.Context, status *GatewayStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.resolvedAt) > registryLocationRefreshInterval && time.Now().After(r.retryAt) {
+		gateway, err := r.registry.GetGateway(ctx, r.gatewayID, "antennas", "location_public")
+		if err != nil {
+			log.Errorw("registry location lookup error", "target", r.gatewayID, "error", err)
+			r.retryAt = time.Now().Add(registryLocationRetryInterval)
+		} else {
+			r.resolvedAt = time.Now()
+			r.locations, r.public = nil, &gateway.LocationPublic
+			for _, antenna := range gateway.Antennas {
+				var location *Location
+				if antenna.Location != nil {
+					location = &Location{
+						Latitude:  antenna.Location.Latitude,
+						Longitude: antenna.Location.Longitude,
+						Altitude:  float64(antenna.Location.Altitude),
+						Accuracy:  float64(antenna.Location.Accuracy),
+						Source:    antenna.Location.Source,
+					}
+				}
+				r.locations = append(r.locations, location)
+			}
+		}
+	}
+	status.RegistryLocations = r.locations
+	status.LocationPublic = r.public
+}
+
+// PublicLocation returns the location of the first antenna for publication, and whether it may be published. The
+// registry location is preferred over the reported one. Locations of TTN gateways are only public once the registry
+// confirmed location_public.
+func PublicLocation(status GatewayStatus, backend string) (location Location, source string, ok bool) {
+	if status.LocationPublic != nil && !*status.LocationPublic {
+		return Location{}, "", false
+	}
+	if status.LocationPublic == nil && backend == config.BackendTTN {
+		return Location{}, "", false
+	}
+	switch {
+	case len(status.RegistryLocations) > 0 && status.RegistryLocations[0] != nil:
+		return *status.RegistryLocations[0], "registry", true
+	case len(status.Locations) > 0:
+		return status.Locations[0], status.Locations[0].Source, true
+	default:
+		return Location{}, "", false
+	}
+}
+
+// collectLocationDrift exports the distance of every reported antenna location to the registry location of the same
+// antenna and the number of significant moves.
+func (t *Target) collectLocationDrift(status GatewayStatus, emit func(name string, valueType prometheus.ValueType, value float64, labelValues ...string)) {
+	t.trackLocations(status.Locations, time.Now())
+	for i, location := range status.Locations {
+		if i < len(status.RegistryLocations) && status.RegistryLocations[i] != nil {
+			emit("antenna_location_drift", prometheus.GaugeValue, haversine(location, *status.RegistryLocations[i]), strconv.Itoa(i))
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	emit("antenna_moves", prometheus.CounterValue, float64(t.moves))
+}
+
+// trackLocations compares the reported antenna locations with the last known ones and records significant moves. The
+// first reported location of an antenna is its baseline.
+func (t *Target) trackLocations(locations []Location, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, location := range locations {
+		previous, ok := t.lastLocations[i]
+		if !ok {
+			t.lastLocations[i] = location
+			continue
+		}
+		distance := haversine(previous, location)
+		if distance < t.locationDrift.SignificantMove {
+			continue
+		}
+		log.Warnw("antenna moved", "target", t.config.FullGatewayID(), "antenna", i, "distance", distance,
+			"fromLat", previous.Latitude, "fromLon", previous.Longitude, "toLat", location.Latitude, "toLon", location.Longitude)
+		t.lastLocations[i] = location
+		t.moves++
+		t.locationHistory = append(t.locationHistory, LocationMove{At: now, Antenna: i, From: previous, To: location, Distance: distance})
+		if overflow := len(t.locationHistory) - t.locationDrift.HistorySize; overflow > 0 {
+			t.locationHistory = append([]LocationMove(nil), t.locationHistory[overflow:]...)
+		}
+	}
+}
+
+// LocationHistory returns the significant moves of the Gateway's antennas since the exporter started, oldest first.
+func (t *Target) LocationHistory() []LocationMove {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]LocationMove(nil), t.locationHistory...)
+}
+
+// haversine returns the great-circle distance between two locations in meters.
+func haversine(a, b Location) float64 {
+	toRadians := func(degrees float64) float64 {
+		return degrees * math.Pi / 180
+	}
+	latA, latB := toRadians(a.Latitude), toRadians(b.Latitude)
+	deltaLat := latB - latA
+	deltaLon := toRadians(b.Longitude - a.Longitude)
+	h := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) + math.Cos(latA)*math.Cos(latB)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
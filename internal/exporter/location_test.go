@@ -0,0 +1,45 @@
+package exporter
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"testing"
+	"time"
+)
+
+func TestTrackLocations(t *testing.T) {
+	target := &Target{
+		config:        config.Target{GatewayID: "my-gateway"},
+		locationDrift: config.LocationDrift{Enabled: true, SignificantMove: 100, HistorySize: 2},
+		lastLocations: map[int]Location{},
+	}
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	// 0.001° of latitude are about 111 m
+	steps := []Location{
+		{Latitude: 49.1000, Longitude: 9.2000},
+		{Latitude: 49.1005, Longitude: 9.2000},
+		{Latitude: 49.1010, Longitude: 9.2000},
+		{Latitude: 49.1020, Longitude: 9.2000},
+		{Latitude: 49.1030, Longitude: 9.2000},
+	}
+	for i, location := range steps {
+		target.trackLocations([]Location{location}, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	if target.moves != 3 {
+		t.Errorf("moves = %d, want 3", target.moves)
+	}
+	history := target.LocationHistory()
+	if len(history) != 2 {
+		t.Fatalf("len(history) = %d, want 2", len(history))
+	}
+	first, last := history[0], history[1]
+	if first.At != start.Add(3*time.Minute) || first.From != steps[2] || first.To != steps[3] {
+		t.Errorf("history[0] = %+v, want the move at +3m from %+v to %+v", first, steps[2], steps[3])
+	}
+	if last.At != start.Add(4*time.Minute) || last.From != steps[3] || last.To != steps[4] {
+		t.Errorf("history[1] = %+v, want the move at +4m from %+v to %+v", last, steps[3], steps[4])
+	}
+	if last.Distance < 100 || last.Distance > 120 {
+		t.Errorf("history[1].Distance = %f, want about 111", last.Distance)
+	}
+}
@@ -0,0 +1,257 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/chirpstack"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mqttSource subscribes to the events of ChirpStack Gateway Bridge and keeps the latest state of every gateway.
+type mqttSource struct {
+	config config.MQTTSource
+	client mqtt.Client
+
+	mu       sync.Mutex
+	gateways map[string]*mqttGatewayState
+}
+
+type mqttGatewayState struct {
+	statsReceivedAt time.Time
+	stats           chirpstack.GatewayStats
+	uplinkCount     uint64
+	downlinkCount   uint64
+
+	connStateKnown bool
+	online         bool
+	connectedAt    time.Time
+	disconnectedAt time.Time
+}
+
+var (
+	mqttSourcesMu sync.Mutex
+	mqttSources   = map[string]*mqttSource{}
+)
+
+// getMQTTSource returns the connected source with the given name, connecting to the broker on first use.
+func getMQTTSource(targetConfig config.TargetConfig, name string) (*mqttSource, error) {
+	mqttSourcesMu.Lock()
+	defer mqttSourcesMu.Unlock()
+
+	if source, ok := mqttSources[name]; ok {
+		return source, nil
+	}
+	sourceConfig, ok := targetConfig.MQTTSource(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown mqtt source %q", name)
+	}
+	source, err := newMQTTSource(sourceConfig)
+	if err != nil {
+		return nil, err
+	}
+	mqttSources[name] = source
+	return source, nil
+}
+
+func newMQTTSource(sourceConfig config.MQTTSource) (*mqttSource, error) {
+	source := &mqttSource{
+		config:   sourceConfig,
+		gateways: map[string]*mqttGatewayState{},
+	}
+
+	clientID := sourceConfig.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("ttn-gateway-exporter-%08x", rand.Uint32())
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(sourceConfig.Broker).
+		SetClientID(clientID).
+		SetUsername(sourceConfig.Username).
+		SetPassword(sourceConfig.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(source.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Warnw("mqtt connection lost", "source", sourceConfig.Name, "error", err)
+		})
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: sourceConfig.InsecureSkipVerify}
+	if sourceConfig.CAFile != "" {
+		pem, err := os.ReadFile(sourceConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no PEM certificates", sourceConfig.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	options.SetTLSConfig(tlsConfig)
+
+	source.client = mqtt.NewClient(options)
+	// with connect retry enabled, the client keeps connecting in the background
+	source.client.Connect()
+	return source, nil
+}
+
+func (s *mqttSource) topic(suffix string) string {
+	if s.config.TopicPrefix == "" {
+		return "gateway/+/" + suffix
+	}
+	return strings.TrimSuffix(s.config.TopicPrefix, "/") + "/gateway/+/" + suffix
+}
+
+func (s *mqttSource) subscribe(client mqtt.Client) {
+	log.Infow("mqtt connected", "source", s.config.Name, "broker", s.config.Broker)
+	filters := map[string]byte{
+		s.topic("event/stats"): 0,
+		s.topic("state/conn"):  0,
+	}
+	token := client.SubscribeMultiple(filters, s.handle)
+	if token.Wait() && token.Error() != nil {
+		log.Errorw("mqtt subscribe error", "source", s.config.Name, "error", token.Error())
+	}
+}
+
+// handle decodes a message of the Gateway Bridge and updates the state of the gateway.
+func (s *mqttSource) handle(_ mqtt.Client, message mqtt.Message) {
+	topicParts := strings.Split(message.Topic(), "/")
+	if len(topicParts) < 4 {
+		return
+	}
+	eui := strings.ToLower(topicParts[len(topicParts)-3])
+	kind := strings.Join(topicParts[len(topicParts)-2:], "/")
+	if _, ok := s.gatewayID(eui); !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.gateways[eui]
+	if !ok {
+		state = &mqttGatewayState{}
+		s.gateways[eui] = state
+	}
+
+	switch kind {
+	case "event/stats":
+		stats, err := chirpstack.DecodeGatewayStats(message.Payload(), s.config.Marshaler)
+		if err != nil {
+			log.Errorw("mqtt stats decode error", "source", s.config.Name, "topic", message.Topic(), "error", err)
+			return
+		}
+		state.stats = stats
+		state.statsReceivedAt = time.Now()
+		// stats contain the packets since the previous stats message
+		state.uplinkCount += uint64(stats.RxPacketsReceivedOk)
+		state.downlinkCount += uint64(stats.TxPacketsEmitted)
+	case "state/conn":
+		connState, err := chirpstack.DecodeConnState(message.Payload(), s.config.Marshaler)
+		if err != nil {
+			log.Errorw("mqtt connection state decode error", "source", s.config.Name, "topic", message.Topic(), "error", err)
+			return
+		}
+		if connState.Online && (!state.connStateKnown || !state.online) {
+			state.connectedAt = time.Now()
+		} else if !connState.Online && (!state.connStateKnown || state.online) {
+			state.disconnectedAt = time.Now()
+		}
+		state.connStateKnown = true
+		state.online = connState.Online
+	}
+}
+
+func (s *mqttSource) gatewayID(eui string) (string, bool) {
+	for configuredEUI, gatewayID := range s.config.Gateways {
+		if strings.ToLower(configuredEUI) == eui {
+			return gatewayID, true
+		}
+	}
+	return "", false
+}
+
+// status returns the latest state of a gateway as backend-neutral status.
+func (s *mqttSource) status(eui string) (GatewayStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := GatewayStatus{Cluster: s.config.Name}
+	state, ok := s.gateways[eui]
+	if !ok {
+		if !s.client.IsConnectionOpen() {
+			return status, fmt.Errorf("not connected to mqtt broker %s", s.config.Broker)
+		}
+		return status, fmt.Errorf("%w: no message received from gateway %s yet", ErrNotConnected, eui)
+	}
+
+	status.Connected = time.Since(state.statsReceivedAt) < s.config.OfflineAfter
+	if state.connStateKnown {
+		status.Connected = state.online
+	}
+	status.ConnectedAt = state.connectedAt
+	status.DisconnectedAt = state.disconnectedAt
+	status.LastSeenAt = state.statsReceivedAt
+	status.LastStatusReceivedAt = state.statsReceivedAt
+	status.Time = state.stats.Time
+	status.Versions = state.stats.Metadata
+	uplinkCount, downlinkCount := state.uplinkCount, state.downlinkCount
+	status.UplinkCount = &uplinkCount
+	status.DownlinkCount = &downlinkCount
+	if !state.statsReceivedAt.IsZero() {
+		// named like the packet forwarder stats of The Things Stack
+		status.Metrics = map[string]float64{
+			"rxin": float64(state.stats.RxPacketsReceived),
+			"rxok": float64(state.stats.RxPacketsReceivedOk),
+			"txin": float64(state.stats.TxPacketsReceived),
+			"txok": float64(state.stats.TxPacketsEmitted),
+		}
+	}
+	if location := state.stats.Location; location != nil {
+		status.Locations = []Location{{
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Altitude:  location.Altitude,
+			Accuracy:  location.Accuracy,
+			Source:    chirpStackLocationSource(location.Source),
+		}}
+	}
+	return status, nil
+}
+
+// mqttBackend reads the gateway status from the state kept by an MQTT source.
+type mqttBackend struct {
+	eui    string
+	source *mqttSource
+}
+
+func newMQTTBackend(targetConfig config.TargetConfig, target config.Target) (*mqttBackend, error) {
+	source, err := getMQTTSource(targetConfig, target.MQTTSource)
+	if err != nil {
+		return nil, err
+	}
+	return &mqttBackend{
+		eui:    target.GatewayEUI,
+		source: source,
+	}, nil
+}
+
+func (b *mqttBackend) Name() string {
+	return config.BackendChirpStackMQTT
+}
+
+func (b *mqttBackend) GatewayStatus(_ context.Context) (GatewayStatus, error) {
+	return b.source.status(b.eui)
+}
@@ -0,0 +1,233 @@
+package exporter
+
+import (
+	"encoding/hex"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m fakeMessage) Duplicate() bool   { return false }
+func (m fakeMessage) Qos() byte         { return 0 }
+func (m fakeMessage) Retained() bool    { return false }
+func (m fakeMessage) Topic() string     { return m.topic }
+func (m fakeMessage) MessageID() uint16 { return 0 }
+func (m fakeMessage) Payload() []byte   { return m.payload }
+func (m fakeMessage) Ack()              {}
+
+func newTestMQTTSource(marshaler string) *mqttSource {
+	return &mqttSource{
+		config: config.MQTTSource{
+			Name:         "bridge",
+			TopicPrefix:  "eu868",
+			Marshaler:    marshaler,
+			OfflineAfter: time.Minute,
+			Gateways:     map[string]string{"0102030405060708": "my-gateway"},
+		},
+		gateways: map[string]*mqttGatewayState{},
+	}
+}
+
+// chirpStackFixture returns a hex encoded payload of ChirpStack Gateway Bridge v4 with the protobuf marshaler from the
+// testdata of the chirpstack package.
+func chirpStackFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("..", "chirpstack", "testdata", name))
+	if err != nil {
+		t.Fatalf("reading fixture: %v", err)
+	}
+	decoded, err := hex.DecodeString(strings.Join(strings.Fields(string(content)), ""))
+	if err != nil {
+		t.Fatalf("invalid fixture %s: %v", name, err)
+	}
+	return decoded
+}
+
+func TestMQTTSourceStats(t *testing.T) {
+	source := newTestMQTTSource("protobuf")
+	source.handle(nil, fakeMessage{topic: "eu868/gateway/0102030405060708/event/stats", payload: chirpStackFixture(t, "stats.pb.hex")})
+	source.handle(nil, fakeMessage{topic: "eu868/gateway/0102030405060708/event/stats", payload: chirpStackFixture(t, "stats.pb.hex")})
+	source.handle(nil, fakeMessage{topic: "eu868/gateway/0102030405060708/event/stats", payload: []byte{0x12, 0xff}})
+
+	status, err := source.status("0102030405060708")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !status.Connected {
+		t.Error("Connected = false after recent stats, want true")
+	}
+	if status.Cluster != "bridge" {
+		t.Errorf("Cluster = %q, want bridge", status.Cluster)
+	}
+	// every stats message counts the packets since the previous one, the undecodable one is ignored
+	if status.UplinkCount == nil || *status.UplinkCount != 18 {
+		t.Errorf("UplinkCount = %v, want 18", status.UplinkCount)
+	}
+	if status.DownlinkCount == nil || *status.DownlinkCount != 4 {
+		t.Errorf("DownlinkCount = %v, want 4", status.DownlinkCount)
+	}
+	wantMetrics := map[string]float64{"rxin": 12, "rxok": 9, "txin": 3, "txok": 2}
+	for name, value := range wantMetrics {
+		if status.Metrics[name] != value {
+			t.Errorf("Metrics[%s] = %v, want %v", name, status.Metrics[name], value)
+		}
+	}
+	if want := time.Date(2026, 10, 19, 8, 15, 30, 250000000, time.UTC); !status.Time.Equal(want) {
+		t.Errorf("Time = %s, want %s", status.Time, want)
+	}
+	if status.Versions["model"] != "rak7268" {
+		t.Errorf("Versions = %v, want model rak7268", status.Versions)
+	}
+	if len(status.Locations) != 1 {
+		t.Fatalf("Locations = %+v, want one location", status.Locations)
+	}
+	want := Location{Latitude: 49.1401, Longitude: 9.2201, Altitude: 190, Accuracy: 12.5, Source: "SOURCE_GPS"}
+	if status.Locations[0] != want {
+		t.Errorf("Location = %+v, want %+v", status.Locations[0], want)
+	}
+}
+
+func TestMQTTSourceConnState(t *testing.T) {
+	source := newTestMQTTSource("protobuf")
+	topic := "eu868/gateway/0102030405060708/state/conn"
+
+	source.handle(nil, fakeMessage{topic: topic, payload: chirpStackFixture(t, "conn-online.pb.hex")})
+	status, err := source.status("0102030405060708")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	// the connection state takes precedence over the missing stats
+	if !status.Connected || status.ConnectedAt.IsZero() {
+		t.Errorf("Connected = %v at %s, want connected", status.Connected, status.ConnectedAt)
+	}
+
+	source.handle(nil, fakeMessage{topic: topic, payload: chirpStackFixture(t, "conn-offline.pb.hex")})
+	status, _ = source.status("0102030405060708")
+	if status.Connected || status.DisconnectedAt.IsZero() {
+		t.Errorf("Connected = %v, disconnected at %s, want disconnected", status.Connected, status.DisconnectedAt)
+	}
+
+	source = newTestMQTTSource("json")
+	source.handle(nil, fakeMessage{topic: topic, payload: []byte(`{"gatewayId":"0102030405060708","state":"OFFLINE"}`)})
+	status, _ = source.status("0102030405060708")
+	if status.Connected {
+		t.Error("Connected = true after OFFLINE json state, want false")
+	}
+}
+
+func TestMQTTSourceIgnoresUnknownGateways(t *testing.T) {
+	source := newTestMQTTSource("protobuf")
+	source.handle(nil, fakeMessage{topic: "eu868/gateway/0807060504030201/event/stats", payload: chirpStackFixture(t, "stats.pb.hex")})
+	source.handle(nil, fakeMessage{topic: "stats", payload: chirpStackFixture(t, "stats.pb.hex")})
+	if len(source.gateways) != 0 {
+		t.Errorf("gateways = %v, want none", source.gateways)
+	}
+
+	source.handle(nil, fakeMessage{topic: "eu868/gateway/0102030405060708/event/stats", payload: chirpStackFixture(t, "stats.pb.hex")})
+	if _, ok := source.gateways["0102030405060708"]; !ok {
+		t.Error("configured gateway not tracked")
+	}
+}
+
+// serveBridge is a minimal MQTT broker for a single client. Once the client subscribed, it publishes the messages of
+// a Gateway Bridge and sends the topic filters of the subscription.
+func serveBridge(t *testing.T, listener net.Listener, messages []fakeMessage, subscribed chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var replies []packets.ControlPacket
+		switch packet := packet.(type) {
+		case *packets.ConnectPacket:
+			replies = append(replies, packets.NewControlPacket(packets.Connack))
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = packet.MessageID
+			suback.ReturnCodes = packet.Qoss
+			replies = append(replies, suback)
+			for _, message := range messages {
+				publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				publish.TopicName = message.topic
+				publish.Payload = message.payload
+				replies = append(replies, publish)
+			}
+			subscribed <- packet.Topics
+		case *packets.PingreqPacket:
+			replies = append(replies, packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+		for _, reply := range replies {
+			if err := reply.Write(conn); err != nil {
+				t.Errorf("writing %s: %v", reply, err)
+				return
+			}
+		}
+	}
+}
+
+func TestMQTTSourceSubscription(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	subscribed := make(chan []string, 1)
+	go serveBridge(t, listener, []fakeMessage{
+		{topic: "eu868/gateway/0102030405060708/event/stats", payload: chirpStackFixture(t, "stats.pb.hex")},
+		{topic: "eu868/gateway/0102030405060708/state/conn", payload: chirpStackFixture(t, "conn-online.pb.hex")},
+	}, subscribed)
+
+	source, err := newMQTTSource(config.MQTTSource{
+		Name:         "bridge",
+		Broker:       "tcp://" + listener.Addr().String(),
+		TopicPrefix:  "eu868/",
+		Marshaler:    "protobuf",
+		OfflineAfter: time.Minute,
+		Gateways:     map[string]string{"0102030405060708": "my-gateway"},
+	})
+	if err != nil {
+		t.Fatalf("newMQTTSource: %v", err)
+	}
+	defer source.client.Disconnect(100)
+
+	select {
+	case topics := <-subscribed:
+		sort.Strings(topics)
+		if want := []string{"eu868/gateway/+/event/stats", "eu868/gateway/+/state/conn"}; !reflect.DeepEqual(topics, want) {
+			t.Errorf("subscribed to %q, want %q", topics, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no subscription within 5s")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := source.status("0102030405060708")
+		if err == nil && status.Connected && !status.ConnectedAt.IsZero() && status.UplinkCount != nil && *status.UplinkCount == 9 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %+v, %v, want the published stats and connection state", status, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
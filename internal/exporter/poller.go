@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Snapshot is the status of all targets at one point in time.
+type Snapshot struct {
+	At       time.Time
+	Statuses []TargetStatus
+}
+
+// Poller reads the status of all targets periodically and hands every snapshot to its subscribers, for consumers that
+// are not driven by scrapes.
+type Poller struct {
+	targets  []*Target
+	interval time.Duration
+
+	mu          sync.Mutex
+	subscribers []func(Snapshot)
+}
+
+func NewPoller(targets []*Target, interval time.Duration) *Poller {
+	return &Poller{targets: targets, interval: interval}
+}
+
+// Subscribe registers a function that is called with every snapshot. Subscribers are called one after another, a slow
+// subscriber delays the next poll.
+func (p *Poller) Subscribe(subscriber func(Snapshot)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscribers = append(p.subscribers, subscriber)
+}
+
+// Run polls until the context is cancelled. Statuses read by a scrape within half the interval are reused.
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+
+	snapshot := Snapshot{At: time.Now(), Statuses: Statuses(ctx, p.targets, p.interval/2)}
+	p.mu.Lock()
+	subscribers := append([]func(Snapshot){}, p.subscribers...)
+	p.mu.Unlock()
+	for _, subscriber := range subscribers {
+		subscriber(snapshot)
+	}
+}
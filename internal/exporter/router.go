@@ -0,0 +1,182 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// router sends the requests for a gateway to the cluster its Gateway Server runs on. With auto routing, the cluster
+// is looked up in the Identity Server and other configured clusters are tried when the gateway is not found.
+type router struct {
+	autoRouting    config.ClusterAutoRouting
+	identityServer *ttnclient.TTNClient
+	candidates     []config.Target
+
+	mu        sync.Mutex
+	clients   map[string]*ttnclient.TTNClient
+	current   config.Target
+	routedAt  time.Time
+	gatewayID string
+}
+
+func newRouter(targetConfig config.TargetConfig, target config.Target) (*router, error) {
+	r := &router{
+		autoRouting: targetConfig.ClusterAutoRouting,
+		clients:     map[string]*ttnclient.TTNClient{},
+		current:     target,
+		gatewayID:   target.GatewayID,
+	}
+	if _, err := r.client(target); err != nil {
+		return nil, err
+	}
+	if !r.autoRouting.Enabled {
+		return r, nil
+	}
+
+	identityServer, err := NewClient(target.WithBaseUrl(r.autoRouting.IdentityServerURL))
+	if err != nil {
+		return nil, err
+	}
+	r.identityServer = identityServer
+	r.candidates = targetConfig.RouteCandidates(target)
+	return r, nil
+}
+
+// Cluster returns the target as configured for the cluster requests are currently sent to.
+func (r *router) Cluster() config.Target {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Route returns the cluster the gateway is currently routed to and a client for it. With auto routing, the cluster is
+// looked up again once the cache TTL has passed.
+func (r *router) Route(ctx context.Context) (config.Target, *ttnclient.TTNClient, error) {
+	r.refresh(ctx)
+	current := r.Cluster()
+	client, err := r.client(current)
+	return current, client, err
+}
+
+// GetGatewayConnectionStats returns the connection stats of the gateway and the cluster they were read from.
+func (r *router) GetGatewayConnectionStats(ctx context.Context) (ttnclient.GatewayConnectionStats, config.Target, error) {
+	current, client, err := r.Route(ctx)
+	if err != nil {
+		return ttnclient.GatewayConnectionStats{}, current, err
+	}
+	stats, err := client.GetGatewayConnectionStats(ctx, r.gatewayID)
+	// an offline gateway is not connected to any cluster, only a gateway that is unknown to the cluster is looked for
+	// on the others
+	var apiErr *ttnclient.APIError
+	if !r.autoRouting.Enabled || !errors.As(err, &apiErr) || !apiErr.NotFound() || apiErr.NotConnected() {
+		return stats, current, err
+	}
+
+	for _, candidate := range r.candidates {
+		if hostOf(candidate.BaseUrl) == hostOf(current.BaseUrl) {
+			continue
+		}
+		candidateClient, candidateErr := r.client(candidate)
+		if candidateErr != nil {
+			continue
+		}
+		candidateStats, candidateErr := candidateClient.GetGatewayConnectionStats(ctx, r.gatewayID)
+		if candidateErr != nil {
+			log.Debugw("gateway not available on cluster", "target", r.gatewayID, "cluster", candidate.ClusterName(), "error", candidateErr)
+			continue
+		}
+		log.Infow("gateway found on other cluster", "target", r.gatewayID, "previousCluster", current.ClusterName(), "cluster", candidate.ClusterName())
+		r.mu.Lock()
+		r.current, r.routedAt = candidate, time.Now()
+		r.mu.Unlock()
+		return candidateStats, candidate, nil
+	}
+	return stats, current, err
+}
+
+// refresh looks up the cluster once the cache TTL has passed. The lock is only held to read and update the route, so
+// a slow Identity Server or cluster does not block the other users of the router.
+func (r *router) refresh(ctx context.Context) {
+	if !r.autoRouting.Enabled {
+		return
+	}
+	r.mu.Lock()
+	expired := time.Since(r.routedAt) > r.autoRouting.CacheTTL
+	if expired {
+		// concurrent requests keep the current route instead of looking it up again
+		r.routedAt = time.Now()
+	}
+	r.mu.Unlock()
+	if expired {
+		r.lookup(ctx)
+	}
+}
+
+// lookup asks the Identity Server for the gateway's Gateway Server address and routes to the matching configured
+// cluster.
+func (r *router) lookup(ctx context.Context) {
+	gateway, err := r.identityServer.GetGateway(ctx, r.gatewayID, "gateway_server_address")
+	if err != nil {
+		log.Warnw("gateway server lookup error", "target", r.gatewayID, "error", err)
+		return
+	}
+	host := gatewayServerHost(gateway.GatewayServerAddress)
+	if host == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if host == hostOf(r.current.BaseUrl) {
+		return
+	}
+	// the address is set by the gateway owner, so credentials are only sent to configured clusters
+	for _, candidate := range r.candidates {
+		if hostOf(candidate.BaseUrl) == host {
+			log.Infow("routing gateway to its gateway server", "target", r.gatewayID, "previousCluster", r.current.ClusterName(), "cluster", candidate.ClusterName())
+			r.current = candidate
+			return
+		}
+	}
+	log.Warnw("gateway server is not a configured cluster, keeping current cluster", "target", r.gatewayID, "gatewayServer", host, "cluster", r.current.ClusterName())
+}
+
+func (r *router) client(target config.Target) (*ttnclient.TTNClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if client, ok := r.clients[target.BaseUrl]; ok {
+		return client, nil
+	}
+	client, err := NewClient(target)
+	if err != nil {
+		return nil, err
+	}
+	r.clients[target.BaseUrl] = client
+	return client, nil
+}
+
+// gatewayServerHost extracts the host from a gateway_server_address, which may carry a scheme and a port.
+func gatewayServerHost(address string) string {
+	if strings.Contains(address, "://") {
+		return hostOf(address)
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+func hostOf(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
@@ -0,0 +1,216 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	notConnectedBody = `{"code": 5, "message": "error:pkg/gatewayserver:not_connected (gateway not connected)",
+		"details": [{"namespace": "pkg/gatewayserver", "name": "not_connected"}]}`
+	notFoundBody = `{"code": 5, "message": "error:pkg/gatewayserver:gateway_not_found (gateway not found)",
+		"details": [{"namespace": "pkg/gatewayserver", "name": "gateway_not_found"}]}`
+	connectionStatsBody = `{"connected_at": "2026-10-19T08:00:00Z", "protocol": "udp"}`
+)
+
+// testCluster is a cluster serving the connection stats of my-gateway with a configurable response. Its Identity
+// Server reports the configured gateway server address.
+type testCluster struct {
+	*httptest.Server
+
+	mu                   sync.Mutex
+	statsStatus          int
+	statsBody            string
+	gatewayServerAddress string
+	requests             map[string]int
+}
+
+func newTestCluster(t *testing.T, statsStatus int, statsBody string) *testCluster {
+	c := &testCluster{statsStatus: statsStatus, statsBody: statsBody, requests: map[string]int{}}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.requests[r.URL.Path]++
+		statsStatus, statsBody, gatewayServerAddress := c.statsStatus, c.statsBody, c.gatewayServerAddress
+		c.mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v3/gs/gateways/my-gateway/connection/stats":
+			w.WriteHeader(statsStatus)
+			_, _ = w.Write([]byte(statsBody))
+		case "/api/v3/gateways/my-gateway":
+			_, _ = w.Write([]byte(`{"ids": {"gateway_id": "my-gateway"}, "gateway_server_address": "` + gatewayServerAddress + `"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *testCluster) count(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests[path]
+}
+
+const (
+	statsPath   = "/api/v3/gs/gateways/my-gateway/connection/stats"
+	gatewayPath = "/api/v3/gateways/my-gateway"
+)
+
+// newTestRouter routes my-gateway, configured on eu1, between eu1 and nam1. eu1 is also the Identity Server. The
+// clusters are told apart by their host name, 127.0.0.1 and localhost.
+func newTestRouter(t *testing.T, eu1, nam1 *testCluster, cacheTTL time.Duration) *router {
+	t.Helper()
+	nam1URL := strings.Replace(nam1.URL, "127.0.0.1", "localhost", 1)
+	target := config.Target{GatewayID: "my-gateway", APIKey: "NNSXS.TEST", BaseUrl: eu1.URL, Cluster: "eu1", Backend: config.BackendTTN}
+	targetConfig := config.TargetConfig{
+		DefaultBaseUrl:     eu1.URL,
+		Clusters:           []config.Cluster{{Name: "eu1", BaseUrl: eu1.URL}, {Name: "nam1", BaseUrl: nam1URL}},
+		ClusterAutoRouting: config.ClusterAutoRouting{Enabled: true, IdentityServerURL: eu1.URL, CacheTTL: cacheTTL},
+		Targets:            []config.Target{target},
+	}
+	r, err := newRouter(targetConfig, target)
+	if err != nil {
+		t.Fatalf("newRouter: %v", err)
+	}
+	return r
+}
+
+func TestRouterLookup(t *testing.T) {
+	eu1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	nam1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	eu1.gatewayServerAddress = "localhost:1700"
+	r := newTestRouter(t, eu1, nam1, time.Hour)
+
+	cluster, _, err := r.Route(context.Background())
+	if err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if cluster.ClusterName() != "nam1" || r.Cluster().ClusterName() != "nam1" {
+		t.Errorf("cluster = %s, want nam1 as reported by the Identity Server", cluster.ClusterName())
+	}
+	if _, cluster, err = r.GetGatewayConnectionStats(context.Background()); err != nil || cluster.ClusterName() != "nam1" {
+		t.Errorf("GetGatewayConnectionStats = %s, %v, want the stats of nam1", cluster.ClusterName(), err)
+	}
+	if eu1.count(statsPath) != 0 || nam1.count(statsPath) != 1 {
+		t.Errorf("stats requests eu1 = %d, nam1 = %d, want only nam1", eu1.count(statsPath), nam1.count(statsPath))
+	}
+}
+
+func TestRouterLookupUnknownGatewayServer(t *testing.T) {
+	eu1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	nam1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	eu1.gatewayServerAddress = "wss://gs.example.com:8887"
+	r := newTestRouter(t, eu1, nam1, time.Hour)
+
+	if cluster, _, _ := r.Route(context.Background()); cluster.ClusterName() != "eu1" {
+		t.Errorf("cluster = %s, want eu1 for a gateway server that is not configured", cluster.ClusterName())
+	}
+}
+
+func TestRouterFailover(t *testing.T) {
+	eu1 := newTestCluster(t, http.StatusNotFound, notFoundBody)
+	nam1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	r := newTestRouter(t, eu1, nam1, time.Hour)
+
+	stats, cluster, err := r.GetGatewayConnectionStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetGatewayConnectionStats: %v", err)
+	}
+	if cluster.ClusterName() != "nam1" || stats.Protocol != "udp" {
+		t.Errorf("stats = %+v of %s, want the stats of nam1", stats, cluster.ClusterName())
+	}
+	// the gateway stays on the cluster it was found on
+	if _, cluster, _ = r.GetGatewayConnectionStats(context.Background()); cluster.ClusterName() != "nam1" {
+		t.Errorf("second read from %s, want nam1", cluster.ClusterName())
+	}
+	if eu1.count(statsPath) != 1 || nam1.count(statsPath) != 2 {
+		t.Errorf("stats requests eu1 = %d, nam1 = %d, want 1 and 2", eu1.count(statsPath), nam1.count(statsPath))
+	}
+}
+
+func TestRouterNoFailoverWhenNotConnected(t *testing.T) {
+	eu1 := newTestCluster(t, http.StatusNotFound, notConnectedBody)
+	nam1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	r := newTestRouter(t, eu1, nam1, time.Hour)
+
+	_, cluster, err := r.GetGatewayConnectionStats(context.Background())
+	var apiErr *ttnclient.APIError
+	if !errors.As(err, &apiErr) || !apiErr.NotConnected() {
+		t.Errorf("error = %v, want not_connected", err)
+	}
+	if cluster.ClusterName() != "eu1" {
+		t.Errorf("cluster = %s, want eu1", cluster.ClusterName())
+	}
+	if requests := nam1.count(statsPath); requests != 0 {
+		t.Errorf("stats requests to nam1 = %d, want none for a gateway that is offline", requests)
+	}
+}
+
+func TestRouterCacheTTL(t *testing.T) {
+	eu1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	nam1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	r := newTestRouter(t, eu1, nam1, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := r.Route(context.Background()); err != nil {
+			t.Fatalf("Route: %v", err)
+		}
+	}
+	if lookups := eu1.count(gatewayPath); lookups != 1 {
+		t.Errorf("lookups within the cache TTL = %d, want 1", lookups)
+	}
+
+	// once the TTL has passed, the gateway is routed to the cluster it moved to
+	eu1.mu.Lock()
+	eu1.gatewayServerAddress = "localhost"
+	eu1.mu.Unlock()
+	r.mu.Lock()
+	r.routedAt = time.Now().Add(-2 * time.Hour)
+	r.mu.Unlock()
+	if cluster, _, _ := r.Route(context.Background()); cluster.ClusterName() != "nam1" {
+		t.Errorf("cluster after the cache TTL = %s, want nam1", cluster.ClusterName())
+	}
+	if lookups := eu1.count(gatewayPath); lookups != 2 {
+		t.Errorf("lookups after the cache TTL = %d, want 2", lookups)
+	}
+}
+
+func TestRouterDoesNotLockDuringRequests(t *testing.T) {
+	requested, release := make(chan struct{}), make(chan struct{})
+	eu1 := newTestCluster(t, http.StatusOK, connectionStatsBody)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		_, _ = w.Write([]byte(connectionStatsBody))
+	}))
+	defer slow.Close()
+	defer close(release)
+	r := newTestRouter(t, eu1, eu1, time.Hour)
+	r.current = r.current.WithBaseUrl(slow.URL)
+
+	go func() {
+		_, _, _ = r.GetGatewayConnectionStats(context.Background())
+	}()
+	<-requested
+
+	clusterRead := make(chan struct{})
+	go func() {
+		r.Cluster()
+		_, _, _ = r.Route(context.Background())
+		close(clusterRead)
+	}()
+	select {
+	case <-clusterRead:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cluster and Route are blocked by the request to a slow cluster")
+	}
+}
@@ -0,0 +1,46 @@
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Sample is a value of a target metric, for consumers that don't scrape the exporter.
+type Sample struct {
+	Definition MetricDefinition
+	// Labels are all labels of the sample, including gateway, backend, tenant and cluster
+	Labels map[string]string
+	Value  float64
+}
+
+// Samples derives the metrics of a status, or of the error reading it, the same way Collect does.
+func (t *Target) Samples(status GatewayStatus, err error) []Sample {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		defer close(metrics)
+		t.collect(status, err, metrics)
+	}()
+
+	var samples []Sample
+	for metric := range metrics {
+		var written dto.Metric
+		if err := metric.Write(&written); err != nil {
+			log.Errorw("sample encoding error", "target", t.config.FullGatewayID(), "error", err)
+			continue
+		}
+		sample := Sample{Definition: t.definitions[metric.Desc()], Labels: map[string]string{}}
+		for _, label := range written.Label {
+			sample.Labels[label.GetName()] = label.GetValue()
+		}
+		switch {
+		case written.Gauge != nil:
+			sample.Value = written.Gauge.GetValue()
+		case written.Counter != nil:
+			sample.Value = written.Counter.GetValue()
+		case written.Untyped != nil:
+			sample.Value = written.Untyped.GetValue()
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
@@ -2,60 +2,139 @@ package exporter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
 	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
-	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
 	"github.com/prometheus/client_golang/prometheus"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 var log = logging.Logger("target")
 
 type Target struct {
-	config config.Target
-	client *ttnclient.TTNClient
-	descs  map[string]*prometheus.Desc
+	config  config.Target
+	backend Backend
+	descs   map[string]*prometheus.Desc
+	// definitions maps the descs back to the metric definitions, for Samples
+	definitions map[*prometheus.Desc]MetricDefinition
+	// nearLimitThreshold is the share of the utilization limit from which a sub-band is flagged as near its limit
+	nearLimitThreshold float64
+	health             config.Health
+	locationDrift      config.LocationDrift
+
+	mu sync.Mutex
+	// gpsSeenAt is when the Gateway last reported a GPS location, it stays zero for gateways without GPS
+	gpsSeenAt time.Time
+	// lastLocations are the reported antenna locations after the last significant move, by antenna
+	lastLocations   map[int]Location
+	moves           uint64
+	locationHistory []LocationMove
+
+	statusMu   sync.Mutex
+	lastStatus GatewayStatus
+	lastErr    error
+	lastReadAt time.Time
+}
+
+// MetricDefinition describes a metric exported for every target. Besides its labels, every metric carries the const
+// labels gateway, backend and tenant and the variable label cluster.
+type MetricDefinition struct {
+	// Key identifies the metric in Collect
+	Key    string
+	Name   string
+	Help   string
+	Type   prometheus.ValueType
+	Labels []string
+	// Unit is seconds, nanoseconds, timestamp (Unix seconds), meters, ratio, or empty for counts and booleans
+	Unit string
+}
+
+// Info reports whether the metric is constantly 1 and exports its information as labels.
+func (d MetricDefinition) Info() bool {
+	return strings.HasPrefix(d.Help, "Constantly 1")
+}
+
+var targetMetrics = []MetricDefinition{
+	{Key: "last_scrape_result", Name: metricName("last_scrape_result"), Help: "1 if the scrape from the TTN API was successful", Type: prometheus.GaugeValue},
+	{Key: "connected", Name: metricName("connected"), Help: "1 if the Gateway is connected to the network server", Type: prometheus.GaugeValue},
+	{Key: "last_seen_at", Name: metricName("last_seen_at"), Help: "Time the network server last received a status or an uplink from the Gateway", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "connected_at", Name: metricName("connected_at"), Help: "Time the Gateway connected", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "disconnected_at", Name: metricName("disconnected_at"), Help: "Time the Gateway disconnected", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "last_status_at", Name: metricName("last_status_at"), Help: "Time TTN last received a status from the Gateway", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "last_uplink_at", Name: metricName("last_uplink_at"), Help: "Time TTN last received an uplink from the Gateway", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "last_downlink_at", Name: metricName("last_downlink_at"), Help: "Time TTN last sent a downlink to the Gateway", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "downlink_count", Name: metricName("downlink_count"), Help: "Number of downlinks through this Gateway", Type: prometheus.CounterValue},
+	{Key: "uplink_count", Name: metricName("uplink_count"), Help: "Number of uplinks through this Gateway", Type: prometheus.CounterValue},
+	{Key: "rtt_min", Name: metricName("rtt_min"), Help: "Minimum round-trip-time", Type: prometheus.GaugeValue, Unit: "nanoseconds"},
+	{Key: "rtt_max", Name: metricName("rtt_max"), Help: "Maximum round-trip-time", Type: prometheus.GaugeValue, Unit: "nanoseconds"},
+	{Key: "rtt_median", Name: metricName("rtt_median"), Help: "Median round-trip-time", Type: prometheus.GaugeValue, Unit: "nanoseconds"},
+	{Key: "rtt_count", Name: metricName("rtt_count"), Help: "Number of round-trips", Type: prometheus.CounterValue},
+	{Key: "time", Name: metricName("time"), Help: "Gateway time", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "boot_time", Name: metricName("boot_time"), Help: "Gateway boot time", Type: prometheus.GaugeValue, Unit: "timestamp"},
+	{Key: "version", Name: metricName("version"), Help: "Constantly 1. Exports the version of a subsystem as label.", Type: prometheus.GaugeValue, Labels: []string{"subsystem", "version"}},
+	{Key: "ip", Name: metricName("ip"), Help: "Constantly 1. Exports the IP of the Gateway as label", Type: prometheus.GaugeValue, Labels: []string{"num", "ip"}},
+	{Key: "protocol", Name: metricName("protocol"), Help: "Constantly 1. Exports the used protocol by the Gateway as label", Type: prometheus.GaugeValue, Labels: []string{"protocol"}},
+	{Key: "status_metrics", Name: metricName("status_metrics"), Help: "Gateway status metrics", Type: prometheus.GaugeValue, Labels: []string{"metric"}},
+	{Key: "antenna_location", Name: metricName("antenna_location"), Help: "Constantly 1. Antenna Location", Type: prometheus.GaugeValue, Labels: []string{"antenna", "lat", "lon", "accuracy", "altitude", "source"}},
+	{Key: "antenna_location_lat", Name: metricName("antenna_location_lat"), Help: "Antenna Latitude", Type: prometheus.GaugeValue, Labels: []string{"antenna"}},
+	{Key: "antenna_location_lon", Name: metricName("antenna_location_lon"), Help: "Antenna Longitude", Type: prometheus.GaugeValue, Labels: []string{"antenna"}},
+	{Key: "antenna_location_alt", Name: metricName("antenna_location_alt"), Help: "Antenna Altitude", Type: prometheus.GaugeValue, Unit: "meters", Labels: []string{"antenna"}},
+	{Key: "antenna_location_accuracy", Name: metricName("antenna_location_accuracy"), Help: "Antenna location accuracy", Type: prometheus.GaugeValue, Unit: "meters", Labels: []string{"antenna"}},
+	{Key: "antenna_location_source", Name: metricName("antenna_location_source"), Help: "Constantly 1. Exports the antenna location source as label.", Type: prometheus.GaugeValue, Labels: []string{"antenna", "source"}},
+	{Key: "antenna_location_drift", Name: metricName("antenna_location_drift_meters"), Help: "Distance between the reported antenna location and the location in the registry", Type: prometheus.GaugeValue, Unit: "meters", Labels: []string{"antenna"}},
+	{Key: "antenna_moves", Name: metricName("antenna_moves_total"), Help: "Number of significant moves of the reported antenna locations since the exporter started", Type: prometheus.CounterValue},
+	{Key: "subband_utilization_limit", Name: metricName("subband_utilization_limit"), Help: "Sub-band utilization limit", Type: prometheus.GaugeValue, Unit: "ratio", Labels: []string{"freqMin", "freqMax"}},
+	{Key: "subband_utilization", Name: metricName("subband_utilization"), Help: "Sub-band utilization", Type: prometheus.GaugeValue, Unit: "ratio", Labels: []string{"freqMin", "freqMax"}},
+	{Key: "subband_headroom", Name: metricName("subband_headroom"), Help: "Share of the sub-band utilization limit that is still available", Type: prometheus.GaugeValue, Unit: "ratio", Labels: []string{"freqMin", "freqMax"}},
+	{Key: "subband_near_limit", Name: metricName("subband_near_limit"), Help: "1 if the sub-band utilization is at or above the near_limit_threshold of its limit", Type: prometheus.GaugeValue, Labels: []string{"freqMin", "freqMax"}},
+	{Key: "subband_info", Name: metricName("subband_info"), Help: "Constantly 1. Exports the regional name, band and duty-cycle of a sub-band as labels", Type: prometheus.GaugeValue, Labels: []string{"freqMin", "freqMax", "name", "band", "duty_cycle"}},
+	{Key: "clock_offset", Name: metricName("clock_offset_seconds"), Help: "Gateway time minus the time the network server received the status", Type: prometheus.GaugeValue, Unit: "seconds"},
+	{Key: "clock_unsynced", Name: metricName("clock_unsynced"), Help: "1 if the clock offset exceeds max_clock_offset", Type: prometheus.GaugeValue},
+	{Key: "uptime", Name: metricName("uptime_seconds"), Help: "Time since the Gateway booted", Type: prometheus.GaugeValue, Unit: "seconds"},
+	{Key: "since_last_status", Name: metricName("seconds_since_last_status"), Help: "Time since the network server last received a status from the Gateway", Type: prometheus.GaugeValue, Unit: "seconds"},
+	{Key: "since_last_uplink", Name: metricName("seconds_since_last_uplink"), Help: "Time since the network server last received an uplink from the Gateway", Type: prometheus.GaugeValue, Unit: "seconds"},
+	{Key: "since_last_downlink", Name: metricName("seconds_since_last_downlink"), Help: "Time since the network server last sent a downlink to the Gateway", Type: prometheus.GaugeValue, Unit: "seconds"},
+	{Key: "gps_fix", Name: metricName("gps_fix"), Help: "1 if the Gateway reports an antenna location from GPS", Type: prometheus.GaugeValue},
+	{Key: "gps_lost", Name: metricName("gps_lost"), Help: "1 if the Gateway reported a GPS location before but none for gps_lost_after", Type: prometheus.GaugeValue},
+	{Key: "frequency_plan", Name: metricName("frequency_plan"), Help: "Constantly 1. Exports a frequency plan of the Gateway as labels", Type: prometheus.GaugeValue, Labels: []string{"frequency_plan", "name", "band"}},
+}
+
+// TargetMetrics returns the definitions of the metrics exported for every target.
+func TargetMetrics() []MetricDefinition {
+	return append([]MetricDefinition{}, targetMetrics...)
 }
 
-func NewTarget(config config.Target) (*Target, error) {
-	client, err := ttnclient.NewTTNClient(config.BaseUrl, ttnclient.ApiKeyAuthenticator{ApiKey: config.APIKey})
+func NewTarget(targetConfig config.TargetConfig, config config.Target) (*Target, error) {
+	backend, err := newBackend(targetConfig, config)
 	if err != nil {
 		return nil, err
 	}
+	// tenant is empty for community targets, but always set, as descriptors of the same name must have the same labels
+	constLabels := prometheus.Labels{
+		"gateway": config.GatewayID,
+		"backend": backend.Name(),
+		"tenant":  config.Tenant,
+	}
+	descs := map[string]*prometheus.Desc{}
+	definitions := map[*prometheus.Desc]MetricDefinition{}
+	for _, definition := range targetMetrics {
+		descs[definition.Key] = desc(constLabels, definition.Name, definition.Help, definition.Labels)
+		definitions[descs[definition.Key]] = definition
+	}
 	return &Target{
-		config: config,
-		client: client,
-		descs: map[string]*prometheus.Desc{
-			"last_scrape_result":        desc(config.GatewayID, metricName("last_scrape_result"), "1 if the scrape from the TTN API was successful", []string{}),
-			"connected_at":              desc(config.GatewayID, metricName("connected_at"), "Time the Gateway connected", []string{}),
-			"disconnected_at":           desc(config.GatewayID, metricName("disconnected_at"), "Time the Gateway disconnected", []string{}),
-			"last_status_at":            desc(config.GatewayID, metricName("last_status_at"), "Time TTN last received a status from the Gateway", []string{}),
-			"last_uplink_at":            desc(config.GatewayID, metricName("last_uplink_at"), "Time TTN last received an uplink from the Gateway", []string{}),
-			"last_downlink_at":          desc(config.GatewayID, metricName("last_downlink_at"), "Time TTN last sent a downlink to the Gateway", []string{}),
-			"downlink_count":            desc(config.GatewayID, metricName("downlink_count"), "Number of downlinks through this Gateway", []string{}),
-			"uplink_count":              desc(config.GatewayID, metricName("uplink_count"), "Number of uplinks through this Gateway", []string{}),
-			"rtt_min":                   desc(config.GatewayID, metricName("rtt_min"), "Minimum round-trip-time", []string{}),
-			"rtt_max":                   desc(config.GatewayID, metricName("rtt_max"), "Maximum round-trip-time", []string{}),
-			"rtt_median":                desc(config.GatewayID, metricName("rtt_median"), "Median round-trip-time", []string{}),
-			"rtt_count":                 desc(config.GatewayID, metricName("rtt_count"), "Number of round-trips", []string{}),
-			"time":                      desc(config.GatewayID, metricName("time"), "Gateway time", []string{}),
-			"boot_time":                 desc(config.GatewayID, metricName("boot_time"), "Gateway boot time", []string{}),
-			"version":                   desc(config.GatewayID, metricName("version"), "Constantly 1. Exports the version of a subsystem as label.", []string{"subsystem", "version"}),
-			"ip":                        desc(config.GatewayID, metricName("ip"), "Constantly 1. Exports the IP of the Gateway as label", []string{"num", "ip"}),
-			"protocol":                  desc(config.GatewayID, metricName("protocol"), "Constantly 1. Exports the used protocol by the Gateway as label", []string{"protocol"}),
-			"status_metrics":            desc(config.GatewayID, metricName("status_metrics"), "Gateway status metrics", []string{"metric"}),
-			"antenna_location":          desc(config.GatewayID, metricName("antenna_location"), "Constantly 1. Antenna Location", []string{"antenna", "lat", "lon", "accuracy", "altitude", "source"}),
-			"antenna_location_lat":      desc(config.GatewayID, metricName("antenna_location_lat"), "Antenna Latitude", []string{"antenna"}),
-			"antenna_location_lon":      desc(config.GatewayID, metricName("antenna_location_lon"), "Antenna Longitude", []string{"antenna"}),
-			"antenna_location_alt":      desc(config.GatewayID, metricName("antenna_location_alt"), "Antenna Altitude", []string{"antenna"}),
-			"antenna_location_accuracy": desc(config.GatewayID, metricName("antenna_location_accuracy"), "Antenna location accuracy", []string{"antenna"}),
-			"antenna_location_source":   desc(config.GatewayID, metricName("antenna_location_source"), "Constantly 1. Exports the antenna location source as label.", []string{"antenna", "source"}),
-			"subband_utilization_limit": desc(config.GatewayID, metricName("subband_utilization_limit"), "Sub-band utilization limit", []string{"freqMin", "freqMax"}),
-			"subband_utilization":       desc(config.GatewayID, metricName("subband_utilization"), "Sub-band utilization", []string{"freqMin", "freqMax"}),
-		},
+		config:             config,
+		backend:            backend,
+		nearLimitThreshold: targetConfig.SubBands.NearLimitThreshold,
+		health:             targetConfig.Health,
+		locationDrift:      targetConfig.LocationDrift,
+		lastLocations:      map[int]Location{},
+		descs:              descs,
+		definitions:        definitions,
 	}, nil
 }
 
@@ -69,74 +148,201 @@ func (t *Target) Collect(metrics chan<- prometheus.Metric) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	stats, err := t.client.GetGatewayConnectionStats(ctx, t.config.GatewayID)
+	status, err := t.readStatus(ctx)
+	t.collect(status, err, metrics)
+}
+
+// collect derives the metrics of a status, or of the error reading it.
+func (t *Target) collect(status GatewayStatus, err error, metrics chan<- prometheus.Metric) {
+	metric := func(name string, valueType prometheus.ValueType, value float64, labelValues ...string) prometheus.Metric {
+		return prometheus.MustNewConstMetric(t.descs[name], valueType, value, append(labelValues, status.Cluster)...)
+	}
 	if err != nil {
-		metrics <- prometheus.MustNewConstMetric(t.descs["last_scrape_result"], prometheus.GaugeValue, 0)
+		metrics <- metric("last_scrape_result", prometheus.GaugeValue, 0)
+		if errors.Is(err, ErrNotConnected) {
+			metrics <- metric("connected", prometheus.GaugeValue, 0)
+		}
 		log.Errorw("scrape error", "target", t.config.GatewayID, "error", err)
 		return
 	} else {
-		metrics <- prometheus.MustNewConstMetric(t.descs["last_scrape_result"], prometheus.GaugeValue, 1)
+		metrics <- metric("last_scrape_result", prometheus.GaugeValue, 1)
 	}
 
-	if stats.DownlinkCount == "" {
-		metrics <- prometheus.MustNewConstMetric(t.descs["downlink_count"], prometheus.CounterValue, 0)
-	} else if downlinkCount, err := strconv.ParseInt(stats.DownlinkCount, 10, 64); err != nil {
-		log.Errorw("numeric string to int conversion error", "target", t.config.GatewayID, "source", "downlink_count", "value", stats.DownlinkCount)
-	} else {
-		metrics <- prometheus.MustNewConstMetric(t.descs["downlink_count"], prometheus.CounterValue, float64(downlinkCount))
+	metrics <- metric("connected", prometheus.GaugeValue, boolValue(status.Connected))
+	if status.DownlinkCount != nil {
+		metrics <- metric("downlink_count", prometheus.CounterValue, float64(*status.DownlinkCount))
+	}
+	if status.UplinkCount != nil {
+		metrics <- metric("uplink_count", prometheus.CounterValue, float64(*status.UplinkCount))
 	}
 
-	if stats.UplinkCount == "" {
-		metrics <- prometheus.MustNewConstMetric(t.descs["uplink_count"], prometheus.CounterValue, 0)
-	} else if uplinkCount, err := strconv.ParseInt(stats.UplinkCount, 10, 64); err != nil {
-		log.Errorw("numeric string to int conversion error", "target", t.config.GatewayID, "source", "uplink_count", "value", stats.UplinkCount)
-	} else {
-		metrics <- prometheus.MustNewConstMetric(t.descs["uplink_count"], prometheus.CounterValue, float64(uplinkCount))
-	}
-
-	metrics <- prometheus.MustNewConstMetric(t.descs["connected_at"], prometheus.GaugeValue, unixTime(stats.ConnectedAt))
-	metrics <- prometheus.MustNewConstMetric(t.descs["disconnected_at"], prometheus.GaugeValue, unixTime(stats.DisconnectedAt))
-	metrics <- prometheus.MustNewConstMetric(t.descs["last_status_at"], prometheus.GaugeValue, unixTime(stats.LastStatusReceivedAt))
-	metrics <- prometheus.MustNewConstMetric(t.descs["last_uplink_at"], prometheus.GaugeValue, unixTime(stats.LastUplinkReceivedAt))
-	metrics <- prometheus.MustNewConstMetric(t.descs["last_downlink_at"], prometheus.GaugeValue, unixTime(stats.LastDownlinkReceivedAt))
-	metrics <- prometheus.MustNewConstMetric(t.descs["rtt_min"], prometheus.GaugeValue, float64(stats.RoundTripTimes.Min))
-	metrics <- prometheus.MustNewConstMetric(t.descs["rtt_max"], prometheus.GaugeValue, float64(stats.RoundTripTimes.Max))
-	metrics <- prometheus.MustNewConstMetric(t.descs["rtt_median"], prometheus.GaugeValue, float64(stats.RoundTripTimes.Median))
-	metrics <- prometheus.MustNewConstMetric(t.descs["rtt_count"], prometheus.CounterValue, float64(stats.RoundTripTimes.Count))
-	metrics <- prometheus.MustNewConstMetric(t.descs["time"], prometheus.GaugeValue, unixTime(stats.LastStatus.Time))
-	metrics <- prometheus.MustNewConstMetric(t.descs["boot_time"], prometheus.GaugeValue, unixTime(stats.LastStatus.BootTime))
-	for subsystem, version := range stats.LastStatus.Versions {
-		metrics <- prometheus.MustNewConstMetric(t.descs["version"], prometheus.GaugeValue, 1, subsystem, version)
-	}
-	for i, ip := range stats.LastStatus.IP {
-		metrics <- prometheus.MustNewConstMetric(t.descs["ip"], prometheus.GaugeValue, 1, fmt.Sprintf("%d", i), ip)
-	}
-	metrics <- prometheus.MustNewConstMetric(t.descs["protocol"], prometheus.GaugeValue, 1, stats.Protocol)
-	for metricName, metricValue := range stats.LastStatus.Metrics {
-		metrics <- prometheus.MustNewConstMetric(t.descs["status_metrics"], prometheus.GaugeValue, float64(metricValue), metricName)
-	}
-	for i, antennaLocation := range stats.LastStatus.AntennaLocations {
+	metrics <- metric("connected_at", prometheus.GaugeValue, unixTime(status.ConnectedAt))
+	metrics <- metric("disconnected_at", prometheus.GaugeValue, unixTime(status.DisconnectedAt))
+	metrics <- metric("last_seen_at", prometheus.GaugeValue, unixTime(status.LastSeenAt))
+	metrics <- metric("last_status_at", prometheus.GaugeValue, unixTime(status.LastStatusReceivedAt))
+	metrics <- metric("last_uplink_at", prometheus.GaugeValue, unixTime(status.LastUplinkReceivedAt))
+	metrics <- metric("last_downlink_at", prometheus.GaugeValue, unixTime(status.LastDownlinkReceivedAt))
+	if rtt := status.RoundTripTimes; rtt != nil {
+		metrics <- metric("rtt_min", prometheus.GaugeValue, float64(rtt.Min))
+		metrics <- metric("rtt_max", prometheus.GaugeValue, float64(rtt.Max))
+		metrics <- metric("rtt_median", prometheus.GaugeValue, float64(rtt.Median))
+		metrics <- metric("rtt_count", prometheus.CounterValue, float64(rtt.Count))
+	}
+	metrics <- metric("time", prometheus.GaugeValue, unixTime(status.Time))
+	metrics <- metric("boot_time", prometheus.GaugeValue, unixTime(status.BootTime))
+	t.collectHealth(status, time.Now(), func(name string, value float64) {
+		metrics <- metric(name, prometheus.GaugeValue, value)
+	})
+	for subsystem, version := range status.Versions {
+		metrics <- metric("version", prometheus.GaugeValue, 1, subsystem, version)
+	}
+	for i, ip := range status.IPs {
+		metrics <- metric("ip", prometheus.GaugeValue, 1, fmt.Sprintf("%d", i), ip)
+	}
+	if status.Protocol != "" {
+		metrics <- metric("protocol", prometheus.GaugeValue, 1, status.Protocol)
+	}
+	for metricName, metricValue := range status.Metrics {
+		metrics <- metric("status_metrics", prometheus.GaugeValue, metricValue, metricName)
+	}
+	for i, antennaLocation := range status.Locations {
 		antennaNumber := fmt.Sprintf("%d", i)
-		metrics <- prometheus.MustNewConstMetric(t.descs["antenna_location_lat"], prometheus.GaugeValue, antennaLocation.Latitude, antennaNumber)
-		metrics <- prometheus.MustNewConstMetric(t.descs["antenna_location_lon"], prometheus.GaugeValue, antennaLocation.Longitude, antennaNumber)
-		metrics <- prometheus.MustNewConstMetric(t.descs["antenna_location_alt"], prometheus.GaugeValue, float64(antennaLocation.Altitude), antennaNumber)
-		metrics <- prometheus.MustNewConstMetric(t.descs["antenna_location_accuracy"], prometheus.GaugeValue, float64(antennaLocation.Accuracy), antennaNumber)
-		metrics <- prometheus.MustNewConstMetric(t.descs["antenna_location_source"], prometheus.GaugeValue, 1, antennaNumber, antennaLocation.Source)
-		metrics <- prometheus.MustNewConstMetric(
-			t.descs["antenna_location"],
+		metrics <- metric("antenna_location_lat", prometheus.GaugeValue, antennaLocation.Latitude, antennaNumber)
+		metrics <- metric("antenna_location_lon", prometheus.GaugeValue, antennaLocation.Longitude, antennaNumber)
+		metrics <- metric("antenna_location_alt", prometheus.GaugeValue, antennaLocation.Altitude, antennaNumber)
+		metrics <- metric("antenna_location_accuracy", prometheus.GaugeValue, antennaLocation.Accuracy, antennaNumber)
+		metrics <- metric("antenna_location_source", prometheus.GaugeValue, 1, antennaNumber, antennaLocation.Source)
+		metrics <- metric(
+			"antenna_location",
 			prometheus.GaugeValue,
 			1,
 			antennaNumber,
 			fmt.Sprintf("%f", antennaLocation.Latitude),
 			fmt.Sprintf("%f", antennaLocation.Longitude),
-			fmt.Sprintf("%d", antennaLocation.Altitude),
-			fmt.Sprintf("%d", antennaLocation.Accuracy),
+			fmt.Sprintf("%d", int64(antennaLocation.Altitude)),
+			fmt.Sprintf("%d", int64(antennaLocation.Accuracy)),
 			antennaLocation.Source,
 		)
 	}
-	for _, band := range stats.SubBands {
-		metrics <- prometheus.MustNewConstMetric(t.descs["subband_utilization_limit"], prometheus.GaugeValue, band.DownlinkUtilizationLimit, band.MinFrequency, band.MaxFrequency)
-		metrics <- prometheus.MustNewConstMetric(t.descs["subband_utilization"], prometheus.GaugeValue, band.DownlinkUtilization, band.MinFrequency, band.MaxFrequency)
+	if t.locationDrift.Enabled {
+		t.collectLocationDrift(status, func(name string, valueType prometheus.ValueType, value float64, labelValues ...string) {
+			metrics <- metric(name, valueType, value, labelValues...)
+		})
+	}
+	for _, band := range status.SubBands {
+		minFrequency, maxFrequency := strconv.FormatUint(band.MinFrequency, 10), strconv.FormatUint(band.MaxFrequency, 10)
+		metrics <- metric("subband_utilization_limit", prometheus.GaugeValue, band.DownlinkUtilizationLimit, minFrequency, maxFrequency)
+		metrics <- metric("subband_utilization", prometheus.GaugeValue, band.DownlinkUtilization, minFrequency, maxFrequency)
+		if band.DownlinkUtilizationLimit > 0 {
+			used := band.DownlinkUtilization / band.DownlinkUtilizationLimit
+			metrics <- metric("subband_headroom", prometheus.GaugeValue, math.Max(0, 1-used), minFrequency, maxFrequency)
+			metrics <- metric("subband_near_limit", prometheus.GaugeValue, boolValue(used >= t.nearLimitThreshold), minFrequency, maxFrequency)
+		}
+		if band.Name != "" {
+			dutyCycle := strconv.FormatFloat(band.DutyCycle, 'f', -1, 64)
+			metrics <- metric("subband_info", prometheus.GaugeValue, 1, minFrequency, maxFrequency, band.Name, band.BandID, dutyCycle)
+		}
+	}
+	for _, plan := range status.FrequencyPlans {
+		metrics <- metric("frequency_plan", prometheus.GaugeValue, 1, plan.ID, plan.Name, plan.BandID)
+	}
+}
+
+// Config returns the configuration of the target.
+func (t *Target) Config() config.Target {
+	return t.config
+}
+
+// BackendName returns the name of the backend the status is read from.
+func (t *Target) BackendName() string {
+	return t.backend.Name()
+}
+
+// Status returns the last status of the Gateway if it was read less than maxAge ago, and reads it from the backend
+// otherwise.
+func (t *Target) Status(ctx context.Context, maxAge time.Duration) (GatewayStatus, error) {
+	t.statusMu.Lock()
+	if !t.lastReadAt.IsZero() && time.Since(t.lastReadAt) < maxAge {
+		defer t.statusMu.Unlock()
+		return t.lastStatus, t.lastErr
+	}
+	t.statusMu.Unlock()
+	return t.readStatus(ctx)
+}
+
+// TargetStatus is the status of a target, or the error reading it.
+type TargetStatus struct {
+	Target *Target
+	Status GatewayStatus
+	Err    error
+}
+
+// Statuses reads the status of all targets concurrently, reusing statuses younger than maxAge. The result is in the
+// order of targets.
+func Statuses(ctx context.Context, targets []*Target, maxAge time.Duration) []TargetStatus {
+	statuses := make([]TargetStatus, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *Target) {
+			defer wg.Done()
+			status, err := target.Status(ctx, maxAge)
+			statuses[i] = TargetStatus{Target: target, Status: status, Err: err}
+		}(i, target)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// readStatus reads the status from the backend and keeps it for Status.
+func (t *Target) readStatus(ctx context.Context) (GatewayStatus, error) {
+	status, err := t.backend.GatewayStatus(ctx)
+	t.statusMu.Lock()
+	defer t.statusMu.Unlock()
+	t.lastStatus, t.lastErr, t.lastReadAt = status, err, time.Now()
+	return status, err
+}
+
+// collectHealth derives clock and GPS health from the status timestamps and antenna locations at now. Durations since
+// an event are only emitted if the event happened.
+func (t *Target) collectHealth(status GatewayStatus, now time.Time, gauge func(name string, value float64)) {
+	if offset, ok := status.ClockOffset(); ok {
+		gauge("clock_offset", offset.Seconds())
+		gauge("clock_unsynced", boolValue(offset > t.health.MaxClockOffset || -offset > t.health.MaxClockOffset))
+	}
+	if !status.BootTime.IsZero() {
+		// measured on the gateway clock at the last status, then advanced by the time since that status
+		uptime := now.Sub(status.BootTime)
+		if !status.Time.IsZero() && !status.LastStatusReceivedAt.IsZero() {
+			uptime = status.Time.Sub(status.BootTime) + now.Sub(status.LastStatusReceivedAt)
+		}
+		gauge("uptime", uptime.Seconds())
+	}
+	for name, at := range map[string]time.Time{
+		"since_last_status":   status.LastStatusReceivedAt,
+		"since_last_uplink":   status.LastUplinkReceivedAt,
+		"since_last_downlink": status.LastDownlinkReceivedAt,
+	} {
+		if !at.IsZero() {
+			gauge(name, now.Sub(at).Seconds())
+		}
+	}
+
+	gpsFix := false
+	for _, location := range status.Locations {
+		if location.Source == "SOURCE_GPS" {
+			gpsFix = true
+		}
+	}
+	gauge("gps_fix", boolValue(gpsFix))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if gpsFix {
+		t.gpsSeenAt = now
+	}
+	if !t.gpsSeenAt.IsZero() {
+		gauge("gps_lost", boolValue(now.Sub(t.gpsSeenAt) > t.health.GPSLostAfter))
 	}
 }
 
@@ -144,10 +350,17 @@ func metricName(names ...string) string {
 	return prometheus.BuildFQName("ttn", "gateway", strings.Join(names, "_"))
 }
 
-func desc(gw, name, help string, variableLabels []string) *prometheus.Desc {
-	return prometheus.NewDesc(name, help, variableLabels, prometheus.Labels{
-		"gateway": gw,
-	})
+// desc creates a descriptor for a gateway metric. Every metric carries the cluster the gateway's stats were read
+// from as its last variable label.
+func desc(constLabels prometheus.Labels, name, help string, variableLabels []string) *prometheus.Desc {
+	return prometheus.NewDesc(name, help, append(variableLabels, "cluster"), constLabels)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 func unixTime(in time.Time) float64 {
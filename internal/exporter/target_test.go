@@ -0,0 +1,153 @@
+package exporter
+
+import (
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// healthCollector collects the health metrics of a status at a fixed time.
+type healthCollector struct {
+	target *Target
+	status GatewayStatus
+	now    time.Time
+}
+
+func (c healthCollector) Describe(descs chan<- *prometheus.Desc) {
+	c.target.Describe(descs)
+}
+
+func (c healthCollector) Collect(metrics chan<- prometheus.Metric) {
+	c.target.collectHealth(c.status, c.now, func(name string, value float64) {
+		metrics <- prometheus.MustNewConstMetric(c.target.descs[name], prometheus.GaugeValue, value, c.status.Cluster)
+	})
+}
+
+// healthExposition returns the expected exposition of the health metrics of my-gateway, keyed by metric key.
+func healthExposition(values map[string]float64) string {
+	var lines []string
+	for _, definition := range targetMetrics {
+		value, ok := values[definition.Key]
+		if !ok {
+			continue
+		}
+		lines = append(lines,
+			fmt.Sprintf("# HELP %s %s", definition.Name, definition.Help),
+			fmt.Sprintf("# TYPE %s gauge", definition.Name),
+			fmt.Sprintf(`%s{backend="ttn",cluster="eu1",gateway="my-gateway",tenant=""} %v`, definition.Name, value))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// healthMetricNames are the names of all health metrics, so missing ones are detected.
+func healthMetricNames() []string {
+	var names []string
+	for _, definition := range targetMetrics {
+		switch definition.Key {
+		case "clock_offset", "clock_unsynced", "uptime", "since_last_status", "since_last_uplink", "since_last_downlink", "gps_fix", "gps_lost":
+			names = append(names, definition.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newHealthTarget(t *testing.T) *Target {
+	t.Helper()
+	targetConfig := config.TargetConfig{Health: config.Health{MaxClockOffset: 2 * time.Second, GPSLostAfter: time.Hour}}
+	target, err := NewTarget(targetConfig, config.Target{GatewayID: "my-gateway", APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN})
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+	return target
+}
+
+func TestCollectHealth(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	receivedAt := now.Add(-30 * time.Second)
+	gps := []Location{{Latitude: 49.14, Longitude: 9.22, Source: "SOURCE_GPS"}}
+	tests := []struct {
+		name   string
+		status GatewayStatus
+		want   map[string]float64
+	}{
+		{
+			name: "synced",
+			status: GatewayStatus{
+				LastStatusReceivedAt: receivedAt,
+				LastUplinkReceivedAt: now.Add(-10 * time.Second),
+				Time:                 receivedAt.Add(time.Second),
+				BootTime:             receivedAt.Add(time.Second - 2*time.Hour),
+				Locations:            gps,
+			},
+			// uptime is measured on the gateway clock and advanced by the time since the status
+			want: map[string]float64{"clock_offset": 1, "clock_unsynced": 0, "uptime": 7230, "since_last_status": 30, "since_last_uplink": 10, "gps_fix": 1, "gps_lost": 0},
+		},
+		{
+			name:   "gateway clock ahead",
+			status: GatewayStatus{LastStatusReceivedAt: receivedAt, Time: receivedAt.Add(3 * time.Second)},
+			want:   map[string]float64{"clock_offset": 3, "clock_unsynced": 1, "since_last_status": 30, "gps_fix": 0},
+		},
+		{
+			name:   "gateway clock behind",
+			status: GatewayStatus{LastStatusReceivedAt: receivedAt, Time: receivedAt.Add(-3 * time.Second)},
+			want:   map[string]float64{"clock_offset": -3, "clock_unsynced": 1, "since_last_status": 30, "gps_fix": 0},
+		},
+		{
+			name:   "offset at the threshold",
+			status: GatewayStatus{LastStatusReceivedAt: receivedAt, Time: receivedAt.Add(-2 * time.Second)},
+			want:   map[string]float64{"clock_offset": -2, "clock_unsynced": 0, "since_last_status": 30, "gps_fix": 0},
+		},
+		{
+			name:   "missing boot time",
+			status: GatewayStatus{LastStatusReceivedAt: receivedAt, LastDownlinkReceivedAt: now.Add(-time.Minute), Time: receivedAt},
+			want:   map[string]float64{"clock_offset": 0, "clock_unsynced": 0, "since_last_status": 30, "since_last_downlink": 60, "gps_fix": 0},
+		},
+		{
+			name:   "boot time without gateway time",
+			status: GatewayStatus{BootTime: now.Add(-time.Hour)},
+			want:   map[string]float64{"uptime": 3600, "gps_fix": 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.status.Cluster = "eu1"
+			collector := healthCollector{target: newHealthTarget(t), status: test.status, now: now}
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(healthExposition(test.want)), healthMetricNames()...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCollectHealthGPSLost(t *testing.T) {
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	gps := GatewayStatus{Cluster: "eu1", Locations: []Location{{Latitude: 49.14, Longitude: 9.22, Source: "SOURCE_GPS"}}}
+	noGPS := GatewayStatus{Cluster: "eu1", Locations: []Location{{Latitude: 49.14, Longitude: 9.22, Source: "SOURCE_REGISTRY"}}}
+	target := newHealthTarget(t)
+
+	steps := []struct {
+		name   string
+		status GatewayStatus
+		at     time.Time
+		want   map[string]float64
+	}{
+		// a gateway that never had GPS has not lost it
+		{name: "never had GPS", status: noGPS, at: now, want: map[string]float64{"gps_fix": 0}},
+		{name: "GPS fix", status: gps, at: now.Add(time.Minute), want: map[string]float64{"gps_fix": 1, "gps_lost": 0}},
+		{name: "no GPS within gps_lost_after", status: noGPS, at: now.Add(time.Hour), want: map[string]float64{"gps_fix": 0, "gps_lost": 0}},
+		{name: "no GPS after gps_lost_after", status: noGPS, at: now.Add(2 * time.Hour), want: map[string]float64{"gps_fix": 0, "gps_lost": 1}},
+		{name: "GPS fix again", status: gps, at: now.Add(3 * time.Hour), want: map[string]float64{"gps_fix": 1, "gps_lost": 0}},
+	}
+	for _, step := range steps {
+		collector := healthCollector{target: target, status: step.status, now: step.at}
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(healthExposition(step.want)), healthMetricNames()...); err != nil {
+			t.Errorf("%s: %v", step.name, err)
+		}
+	}
+}
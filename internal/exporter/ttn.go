@@ -0,0 +1,145 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/ttnclient"
+	"strconv"
+)
+
+// ttnBackend reads the gateway status from the Gateway Server of The Things Stack.
+type ttnBackend struct {
+	gatewayID      string
+	router         *router
+	frequencyPlans *frequencyPlanResolver
+	locations      *registryLocationResolver
+}
+
+func newTTNBackend(targetConfig config.TargetConfig, target config.Target) (*ttnBackend, error) {
+	router, err := newRouter(targetConfig, target)
+	if err != nil {
+		return nil, err
+	}
+	backend := &ttnBackend{
+		gatewayID: target.GatewayID,
+		router:    router,
+	}
+	if targetConfig.SubBands.ResolveFrequencyPlans {
+		backend.frequencyPlans, err = newFrequencyPlanResolver(targetConfig, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if targetConfig.LocationDrift.Enabled || targetConfig.Map.Enabled || targetConfig.PublicStatus.Publishes("location") {
+		backend.locations, err = newRegistryLocationResolver(targetConfig, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return backend, nil
+}
+
+// ttnRouter returns the router of a target on The Things Stack. It is shared by everything that requests the gateway,
+// so they all follow the same cluster.
+func (t *Target) ttnRouter() (*router, bool) {
+	backend, ok := t.backend.(*ttnBackend)
+	if !ok {
+		return nil, false
+	}
+	return backend.router, true
+}
+
+func (b *ttnBackend) Name() string {
+	return config.BackendTTN
+}
+
+func (b *ttnBackend) GatewayStatus(ctx context.Context) (GatewayStatus, error) {
+	stats, cluster, err := b.router.GetGatewayConnectionStats(ctx)
+	if err != nil {
+		var apiErr *ttnclient.APIError
+		if errors.As(err, &apiErr) && apiErr.NotConnected() {
+			err = fmt.Errorf("%w: %s", ErrNotConnected, err)
+		}
+		status := GatewayStatus{Cluster: cluster.ClusterName()}
+		if b.locations != nil {
+			// the registry location is also known for disconnected gateways
+			b.locations.annotate(ctx, &status)
+		}
+		return status, err
+	}
+	status := b.convert(stats, cluster.ClusterName())
+	if b.frequencyPlans != nil {
+		b.frequencyPlans.annotate(ctx, cluster, &status)
+	}
+	if b.locations != nil {
+		b.locations.annotate(ctx, &status)
+	}
+	return status, nil
+}
+
+func (b *ttnBackend) convert(stats ttnclient.GatewayConnectionStats, cluster string) GatewayStatus {
+	status := GatewayStatus{
+		Cluster:                cluster,
+		Connected:              true,
+		ConnectedAt:            stats.ConnectedAt,
+		DisconnectedAt:         stats.DisconnectedAt,
+		LastSeenAt:             latest(stats.LastStatusReceivedAt, stats.LastUplinkReceivedAt),
+		LastStatusReceivedAt:   stats.LastStatusReceivedAt,
+		LastUplinkReceivedAt:   stats.LastUplinkReceivedAt,
+		LastDownlinkReceivedAt: stats.LastDownlinkReceivedAt,
+		UplinkCount:            b.parseCount("uplink_count", stats.UplinkCount),
+		DownlinkCount:          b.parseCount("downlink_count", stats.DownlinkCount),
+		Protocol:               stats.Protocol,
+		Time:                   stats.LastStatus.Time,
+		BootTime:               stats.LastStatus.BootTime,
+		Versions:               stats.LastStatus.Versions,
+		IPs:                    stats.LastStatus.IP,
+		Metrics:                stats.LastStatus.Metrics,
+		RoundTripTimes: &RoundTripTimes{
+			Min:    stats.RoundTripTimes.Min,
+			Max:    stats.RoundTripTimes.Max,
+			Median: stats.RoundTripTimes.Median,
+			Count:  stats.RoundTripTimes.Count,
+		},
+	}
+	for _, location := range stats.LastStatus.AntennaLocations {
+		status.Locations = append(status.Locations, Location{
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Altitude:  float64(location.Altitude),
+			Accuracy:  float64(location.Accuracy),
+			Source:    location.Source,
+		})
+	}
+	for _, band := range stats.SubBands {
+		minFrequency, minErr := strconv.ParseUint(band.MinFrequency, 10, 64)
+		maxFrequency, maxErr := strconv.ParseUint(band.MaxFrequency, 10, 64)
+		if minErr != nil || maxErr != nil {
+			log.Errorw("numeric string to int conversion error", "target", b.gatewayID, "source", "sub_bands", "value", band)
+			continue
+		}
+		status.SubBands = append(status.SubBands, SubBand{
+			MinFrequency:             minFrequency,
+			MaxFrequency:             maxFrequency,
+			DownlinkUtilizationLimit: band.DownlinkUtilizationLimit,
+			DownlinkUtilization:      band.DownlinkUtilization,
+		})
+	}
+	return status
+}
+
+// parseCount parses the numeric strings TTN uses for 64 bit counters. Missing counters have not been incremented yet.
+func (b *ttnBackend) parseCount(source, value string) *uint64 {
+	var count uint64
+	if value == "" {
+		return &count
+	}
+	count, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		log.Errorw("numeric string to int conversion error", "target", b.gatewayID, "source", source, "value", value)
+		return nil
+	}
+	return &count
+}
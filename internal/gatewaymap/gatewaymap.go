@@ -0,0 +1,150 @@
+// Package gatewaymap publishes the locations and connection state of the gateways as GeoJSON, KML and an HTML map.
+package gatewaymap
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var log = logging.Logger("gateway-map")
+
+//go:embed map.html
+var mapPage []byte
+
+// Handler serves the gateway map endpoints.
+type Handler struct {
+	targets []*exporter.Target
+	config  config.Map
+}
+
+// gateway is a gateway as shown on the map.
+type gateway struct {
+	ID         string
+	Tenant     string
+	Cluster    string
+	Backend    string
+	Connected  bool
+	LastSeenAt time.Time
+	Location   exporter.Location
+	// LocationSource is registry for locations from the registry, or the source of the reported location
+	LocationSource string
+	// Moves are the significant moves of the reported antenna locations, oldest first
+	Moves []exporter.LocationMove
+}
+
+func New(targets []*exporter.Target, config config.Map) *Handler {
+	return &Handler{targets: targets, config: config}
+}
+
+// registerer is implemented by server.Server.
+type registerer interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// Register adds /gateways.geojson, /gateways.kml and /map to the server.
+func (h *Handler) Register(server registerer) {
+	server.Handle("/gateways.geojson", h.cors(http.HandlerFunc(h.serveGeoJSON)))
+	server.Handle("/gateways.kml", h.cors(http.HandlerFunc(h.serveKML)))
+	server.Handle("/map", h.cors(http.HandlerFunc(h.serveMap)))
+}
+
+func (h *Handler) serveMap(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(mapPage)
+}
+
+// cors allows the configured origins to read the response from a browser and answers preflight requests.
+func (h *Handler) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if allowed := h.allowedOrigin(origin); allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Add("Vary", "Origin")
+		}
+		switch r.Method {
+		case http.MethodOptions:
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet, http.MethodHead:
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (h *Handler) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range h.config.CORSAllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// gateways returns the gateways to show, sorted by ID. Hidden gateways, gateways whose location is not public and
+// gateways without location are left out. TTN gateways are only shown once their registry confirmed that the location
+// is public.
+func (h *Handler) gateways(ctx context.Context) []gateway {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var visible []*exporter.Target
+	for _, target := range h.targets {
+		if !target.Config().HideOnMap {
+			visible = append(visible, target)
+		}
+	}
+	var gateways []gateway
+	for _, targetStatus := range exporter.Statuses(ctx, visible, h.config.CacheTTL) {
+		if gateway, ok := h.gateway(targetStatus); ok {
+			gateways = append(gateways, gateway)
+		}
+	}
+
+	sort.Slice(gateways, func(i, j int) bool {
+		if gateways[i].ID != gateways[j].ID {
+			return gateways[i].ID < gateways[j].ID
+		}
+		return gateways[i].Tenant < gateways[j].Tenant
+	})
+	return gateways
+}
+
+func (h *Handler) gateway(targetStatus exporter.TargetStatus) (gateway, bool) {
+	target, status, err := targetStatus.Target, targetStatus.Status, targetStatus.Err
+	targetConfig := target.Config()
+	if err != nil && !errors.Is(err, exporter.ErrNotConnected) {
+		log.Debugw("gateway status error", "target", targetConfig.FullGatewayID(), "error", err)
+	}
+
+	location, source, ok := exporter.PublicLocation(status, target.BackendName())
+	if !ok {
+		return gateway{}, false
+	}
+	return gateway{
+		ID:             targetConfig.GatewayID,
+		Tenant:         targetConfig.Tenant,
+		Cluster:        status.Cluster,
+		Backend:        target.BackendName(),
+		Connected:      err == nil && status.Connected,
+		LastSeenAt:     status.LastSeenAt,
+		Location:       location,
+		LocationSource: source,
+		Moves:          target.LocationHistory(),
+	}, true
+}
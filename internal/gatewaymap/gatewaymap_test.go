@@ -0,0 +1,207 @@
+package gatewaymap
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// api serves the connection stats and the registry of the gateways. The location of private-gateway is not public. It
+// records the requested paths.
+type api struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newAPI(t *testing.T) *api {
+	a := &api{}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		a.requests = append(a.requests, r.URL.Path)
+		a.mu.Unlock()
+		for _, gatewayID := range []string{"public-gateway", "hidden-gateway", "private-gateway"} {
+			switch r.URL.Path {
+			case "/api/v3/gs/gateways/" + gatewayID + "/connection/stats":
+				_, _ = fmt.Fprint(w, `{"connected_at": "2026-10-19T08:00:00Z", "last_status_received_at": "2026-10-19T10:00:00Z",
+					"last_status": {"antenna_locations": [{"latitude": 49.1, "longitude": 9.2, "altitude": 180, "source": "SOURCE_GPS"}]}}`)
+				return
+			case "/api/v3/gateways/" + gatewayID:
+				_, _ = fmt.Fprintf(w, `{"ids": {"gateway_id": %q}, "location_public": %t,
+					"antennas": [{"location": {"latitude": 49.14, "longitude": 9.22, "altitude": 170, "source": "SOURCE_REGISTRY"}}]}`,
+					gatewayID, gatewayID != "private-gateway")
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(a.Close)
+	return a
+}
+
+func (a *api) requested(gatewayID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, path := range a.requests {
+		if strings.Contains(path, "/"+gatewayID) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestServer serves the map endpoints of public-gateway, hidden-gateway with hide_on_map and private-gateway.
+func newTestServer(t *testing.T, a *api, corsAllowedOrigins ...string) *httptest.Server {
+	t.Helper()
+	mapConfig := config.Map{Enabled: true, CORSAllowedOrigins: corsAllowedOrigins, CacheTTL: time.Minute}
+	targetConfig := config.TargetConfig{Map: mapConfig}
+	var targets []*exporter.Target
+	for _, target := range []config.Target{
+		{GatewayID: "public-gateway", Tenant: "acme"},
+		{GatewayID: "hidden-gateway", Tenant: "acme", HideOnMap: true},
+		{GatewayID: "private-gateway", Tenant: "acme"},
+	} {
+		target.APIKey, target.BaseUrl, target.Backend = "NNSXS.TEST", a.URL, config.BackendTTN
+		gatewayTarget, err := exporter.NewTarget(targetConfig, target)
+		if err != nil {
+			t.Fatalf("NewTarget: %v", err)
+		}
+		targets = append(targets, gatewayTarget)
+	}
+	mux := http.NewServeMux()
+	New(targets, mapConfig).Register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func request(t *testing.T, method, url, origin string) (*http.Response, string) {
+	t.Helper()
+	r, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", url, err)
+	}
+	return response, string(body)
+}
+
+func TestGeoJSON(t *testing.T) {
+	a := newAPI(t)
+	server := newTestServer(t, a)
+
+	response, body := request(t, http.MethodGet, server.URL+"/gateways.geojson", "")
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/geo+json" {
+		t.Errorf("Content-Type = %q, want application/geo+json", contentType)
+	}
+	var collection featureCollection
+	if err := json.Unmarshal([]byte(body), &collection); err != nil {
+		t.Fatalf("GeoJSON is not valid JSON: %v", err)
+	}
+	// hidden-gateway is hidden and the location of private-gateway is not public
+	if len(collection.Features) != 1 {
+		t.Fatalf("features = %s, want only public-gateway", body)
+	}
+	feature := collection.Features[0]
+	if feature.ID != "public-gateway@acme" {
+		t.Errorf("id = %q, want public-gateway@acme", feature.ID)
+	}
+	// longitude first, and the registry location is preferred over the reported one
+	if want := []float64{9.22, 49.14, 170}; feature.Geometry.Type != "Point" || !reflect.DeepEqual(feature.Geometry.Coordinates, want) {
+		t.Errorf("geometry = %+v, want point %v", feature.Geometry, want)
+	}
+	if feature.Properties["location_source"] != "registry" || feature.Properties["connected"] != true || feature.Properties["tenant"] != "acme" {
+		t.Errorf("properties = %v", feature.Properties)
+	}
+	if a.requested("hidden-gateway") {
+		t.Error("status of hidden-gateway was read")
+	}
+	if !a.requested("private-gateway") {
+		t.Error("status of private-gateway was not read")
+	}
+}
+
+func TestKML(t *testing.T) {
+	server := newTestServer(t, newAPI(t))
+
+	_, body := request(t, http.MethodGet, server.URL+"/gateways.kml", "")
+	if !strings.Contains(body, "<coordinates>9.22,49.14,170</coordinates>") {
+		t.Errorf("KML %s lacks the coordinates of public-gateway in longitude, latitude order", body)
+	}
+	if strings.Contains(body, "hidden-gateway") || strings.Contains(body, "private-gateway") {
+		t.Errorf("KML %s contains hidden or private gateways", body)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    string
+	}{
+		{name: "allowed origin", allowed: []string{"https://map.example.org"}, origin: "https://map.example.org", want: "https://map.example.org"},
+		{name: "other origin", allowed: []string{"https://map.example.org"}, origin: "https://evil.example.com", want: ""},
+		{name: "no origin", allowed: []string{"*"}, origin: "", want: ""},
+		{name: "wildcard", allowed: []string{"*"}, origin: "https://evil.example.com", want: "*"},
+		{name: "not configured", allowed: nil, origin: "https://map.example.org", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, newAPI(t), test.allowed...)
+			for _, path := range []string{"/gateways.geojson", "/gateways.kml", "/map"} {
+				response, _ := request(t, http.MethodGet, server.URL+path, test.origin)
+				if response.StatusCode != http.StatusOK {
+					t.Errorf("GET %s: status = %d, want 200", path, response.StatusCode)
+				}
+				if got := response.Header.Get("Access-Control-Allow-Origin"); got != test.want {
+					t.Errorf("GET %s: Access-Control-Allow-Origin = %q, want %q", path, got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	a := newAPI(t)
+	server := newTestServer(t, a, "https://map.example.org")
+
+	response, _ := request(t, http.MethodOptions, server.URL+"/gateways.geojson", "https://map.example.org")
+	if response.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", response.StatusCode)
+	}
+	if got := response.Header.Get("Access-Control-Allow-Origin"); got != "https://map.example.org" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want https://map.example.org", got)
+	}
+	if got := response.Header.Get("Access-Control-Allow-Methods"); got != "GET, OPTIONS" {
+		t.Errorf("Access-Control-Allow-Methods = %q, want GET, OPTIONS", got)
+	}
+	if a.requested("public-gateway") {
+		t.Error("preflight request read the gateway status")
+	}
+
+	response, _ = request(t, http.MethodPost, server.URL+"/gateways.geojson", "https://map.example.org")
+	if response.StatusCode != http.StatusMethodNotAllowed || response.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("POST: status = %d, Allow = %q, want 405 with GET, HEAD, OPTIONS", response.StatusCode, response.Header.Get("Allow"))
+	}
+}
@@ -0,0 +1,95 @@
+package gatewaymap
+
+import (
+	"encoding/json"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"net/http"
+	"time"
+)
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   point                  `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type point struct {
+	Type string `json:"type"`
+	// Coordinates are longitude, latitude and altitude as mandated by RFC 7946
+	Coordinates []float64 `json:"coordinates"`
+}
+
+func (h *Handler) serveGeoJSON(w http.ResponseWriter, r *http.Request) {
+	collection := featureCollection{Type: "FeatureCollection", Features: []feature{}}
+	for _, gateway := range h.gateways(r.Context()) {
+		properties := map[string]interface{}{
+			"gateway_id":      gateway.ID,
+			"cluster":         gateway.Cluster,
+			"backend":         gateway.Backend,
+			"connected":       gateway.Connected,
+			"location_source": gateway.LocationSource,
+		}
+		if gateway.Tenant != "" {
+			properties["tenant"] = gateway.Tenant
+		}
+		if !gateway.LastSeenAt.IsZero() {
+			properties["last_seen_at"] = gateway.LastSeenAt.UTC().Format(time.RFC3339)
+		}
+		if len(gateway.Moves) > 0 {
+			properties["moves"] = moves(gateway.Moves)
+		}
+		collection.Features = append(collection.Features, feature{
+			Type:       "Feature",
+			ID:         fullID(gateway),
+			Geometry:   point{Type: "Point", Coordinates: coordinates(gateway.Location)},
+			Properties: properties,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		log.Debugw("geojson write error", "error", err)
+	}
+}
+
+// move is a significant move of an antenna in the GeoJSON properties.
+type move struct {
+	At      string `json:"at"`
+	Antenna int    `json:"antenna"`
+	// From and To are longitude, latitude and altitude like the coordinates of the geometry
+	From     []float64 `json:"from"`
+	To       []float64 `json:"to"`
+	Distance float64   `json:"distance_meters"`
+}
+
+func moves(history []exporter.LocationMove) []move {
+	moves := make([]move, 0, len(history))
+	for _, m := range history {
+		moves = append(moves, move{
+			At:       m.At.UTC().Format(time.RFC3339),
+			Antenna:  m.Antenna,
+			From:     coordinates(m.From),
+			To:       coordinates(m.To),
+			Distance: m.Distance,
+		})
+	}
+	return moves
+}
+
+func coordinates(location exporter.Location) []float64 {
+	return []float64{location.Longitude, location.Latitude, location.Altitude}
+}
+
+// fullID returns the gateway ID including the tenant, if any.
+func fullID(gateway gateway) string {
+	if gateway.Tenant == "" {
+		return gateway.ID
+	}
+	return gateway.ID + "@" + gateway.Tenant
+}
@@ -0,0 +1,82 @@
+package gatewaymap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type kml struct {
+	XMLName  xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Document kmlDocument `xml:"Document"`
+}
+
+type kmlDocument struct {
+	Name       string         `xml:"name"`
+	Styles     []kmlStyle     `xml:"Style"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlStyle struct {
+	ID    string `xml:"id,attr"`
+	Color string `xml:"IconStyle>color"`
+}
+
+type kmlPlacemark struct {
+	Name         string    `xml:"name"`
+	Description  string    `xml:"description"`
+	StyleURL     string    `xml:"styleUrl"`
+	ExtendedData []kmlData `xml:"ExtendedData>Data"`
+	Coordinates  string    `xml:"Point>coordinates"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+func (h *Handler) serveKML(w http.ResponseWriter, r *http.Request) {
+	document := kml{Document: kmlDocument{
+		Name: "Gateways",
+		// KML colors are aabbggrr
+		Styles: []kmlStyle{{ID: "connected", Color: "ff00b400"}, {ID: "disconnected", Color: "ff0000dc"}},
+	}}
+	for _, gateway := range h.gateways(r.Context()) {
+		style, state := "disconnected", "disconnected"
+		if gateway.Connected {
+			style, state = "connected", "connected"
+		}
+		description := fmt.Sprintf("%s, cluster %s", state, gateway.Cluster)
+		data := []kmlData{
+			{Name: "connected", Value: strconv.FormatBool(gateway.Connected)},
+			{Name: "cluster", Value: gateway.Cluster},
+			{Name: "backend", Value: gateway.Backend},
+			{Name: "location_source", Value: gateway.LocationSource},
+		}
+		if !gateway.LastSeenAt.IsZero() {
+			lastSeenAt := gateway.LastSeenAt.UTC().Format(time.RFC3339)
+			description += ", last seen " + lastSeenAt
+			data = append(data, kmlData{Name: "last_seen_at", Value: lastSeenAt})
+		}
+		document.Document.Placemarks = append(document.Document.Placemarks, kmlPlacemark{
+			Name:         fullID(gateway),
+			Description:  description,
+			StyleURL:     "#" + style,
+			ExtendedData: data,
+			Coordinates: fmt.Sprintf("%s,%s,%s",
+				strconv.FormatFloat(gateway.Location.Longitude, 'f', -1, 64),
+				strconv.FormatFloat(gateway.Location.Latitude, 'f', -1, 64),
+				strconv.FormatFloat(gateway.Location.Altitude, 'f', -1, 64)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	_, _ = w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		log.Debugw("kml write error", "error", err)
+	}
+}
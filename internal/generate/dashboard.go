@@ -0,0 +1,300 @@
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+)
+
+// DashboardOptions configure the generated dashboard.
+type DashboardOptions struct {
+	Title string
+	UID   string
+	// Datasource is the name of the Prometheus datasource selected by default
+	Datasource string
+	Thresholds Thresholds
+	// Selector is added to every metric selector, e.g. job="ttn-gateway-exporter"
+	Selector string
+}
+
+type object = map[string]interface{}
+
+// rows group the target metrics by key prefix. Metrics matching no row are shown in a row Other, so new metrics
+// appear on the dashboard without changes here.
+var rows = []struct {
+	title    string
+	prefixes []string
+}{
+	{"Connection", []string{"last_scrape_result", "connected", "disconnected_at", "last_", "since_", "protocol", "ip"}},
+	{"Traffic", []string{"uplink_count", "downlink_count"}},
+	{"Round-trip times", []string{"rtt_"}},
+	{"Sub-bands", []string{"subband_", "frequency_plan"}},
+	{"Health", []string{"clock_", "uptime", "time", "boot_time", "gps_", "version", "status_metrics"}},
+	{"Antennas", []string{"antenna_"}},
+}
+
+// Dashboard returns a Grafana dashboard with a panel for every target metric.
+func Dashboard(options DashboardOptions) ([]byte, error) {
+	m := targetMetrics()
+	d := &dashboardBuilder{options: options}
+
+	d.row("Overview")
+	d.stat("Connected", "1 if the Gateway is connected to the network server", d.selector(m.name("connected")), "none", nil, []object{
+		{"type": "value", "options": object{
+			"0": object{"text": "Offline", "color": "red", "index": 0},
+			"1": object{"text": "Online", "color": "green", "index": 1},
+		}},
+	})
+	d.stat("Since last status", m["since_last_status"].Help, d.selector(m.name("since_last_status")), "s",
+		thresholdSteps(d.options.Thresholds.OfflineAfter.Seconds()), nil)
+	d.stat("Since last uplink", m["since_last_uplink"].Help, d.selector(m.name("since_last_uplink")), "s",
+		thresholdSteps(d.options.Thresholds.NoUplinkAfter.Seconds()), nil)
+	d.stat("Uptime", m["uptime"].Help, d.selector(m.name("uptime")), "s", nil, nil)
+
+	grouped := map[string][]exporter.MetricDefinition{}
+	for _, definition := range exporter.TargetMetrics() {
+		title := "Other"
+		for _, row := range rows {
+			if matchesPrefix(definition.Key, row.prefixes) {
+				title = row.title
+				break
+			}
+		}
+		grouped[title] = append(grouped[title], definition)
+	}
+	for _, row := range rows {
+		d.metricRow(row.title, grouped[row.title])
+	}
+	d.metricRow("Other", grouped["Other"])
+
+	return json.MarshalIndent(object{
+		"title":         options.Title,
+		"uid":           options.UID,
+		"tags":          []string{"ttn", "lorawan", "gateways"},
+		"timezone":      "browser",
+		"schemaVersion": 36,
+		"version":       1,
+		"editable":      true,
+		"refresh":       "1m",
+		"time":          object{"from": "now-24h", "to": "now"},
+		"templating": object{"list": []object{
+			{
+				"type":    "datasource",
+				"name":    "datasource",
+				"label":   "Data source",
+				"query":   "prometheus",
+				"current": object{"selected": true, "text": options.Datasource, "value": options.Datasource},
+			},
+			{
+				"type":       "query",
+				"name":       "gateway",
+				"label":      "Gateway",
+				"datasource": datasource(),
+				"query":      object{"query": fmt.Sprintf("label_values(%s, gateway)", d.plainSelector(m.name("connected"))), "refId": "gateway"},
+				"definition": fmt.Sprintf("label_values(%s, gateway)", d.plainSelector(m.name("connected"))),
+				"refresh":    2,
+				"sort":       1,
+				"multi":      true,
+				"includeAll": true,
+				"current":    object{"selected": true, "text": []string{"All"}, "value": []string{"$__all"}},
+			},
+		}},
+		"panels": d.panels,
+	}, "", "  ")
+}
+
+// dashboardBuilder lays out the panels from left to right and top to bottom.
+type dashboardBuilder struct {
+	options DashboardOptions
+	panels  []object
+	x, y    int
+	height  int
+}
+
+func (d *dashboardBuilder) place(width, height int) object {
+	if d.x+width > 24 {
+		d.x, d.y, d.height = 0, d.y+d.height, 0
+	}
+	position := object{"x": d.x, "y": d.y, "w": width, "h": height}
+	d.x += width
+	if height > d.height {
+		d.height = height
+	}
+	return position
+}
+
+func (d *dashboardBuilder) add(panel object, width, height int) {
+	panel["id"] = len(d.panels) + 1
+	panel["gridPos"] = d.place(width, height)
+	d.panels = append(d.panels, panel)
+}
+
+func (d *dashboardBuilder) row(title string) {
+	d.x = 24
+	d.add(object{"type": "row", "title": title, "collapsed": false, "panels": []object{}}, 24, 1)
+}
+
+// selector selects the metric for the gateways of the dashboard variable.
+func (d *dashboardBuilder) selector(name string) string {
+	matchers := `gateway=~"$gateway"`
+	if d.options.Selector != "" {
+		matchers += ", " + d.options.Selector
+	}
+	return name + "{" + matchers + "}"
+}
+
+func (d *dashboardBuilder) plainSelector(name string) string {
+	if d.options.Selector == "" {
+		return name
+	}
+	return name + "{" + d.options.Selector + "}"
+}
+
+func (d *dashboardBuilder) stat(title, description, expr, unit string, thresholds []object, mappings []object) {
+	if thresholds == nil {
+		thresholds = []object{{"color": "green", "value": nil}}
+	}
+	d.add(object{
+		"type":        "stat",
+		"title":       title,
+		"description": description,
+		"datasource":  datasource(),
+		"targets":     []object{{"refId": "A", "expr": expr, "legendFormat": "{{gateway}}", "instant": true}},
+		"fieldConfig": object{"defaults": object{
+			"unit":       unit,
+			"mappings":   emptyIfNil(mappings),
+			"thresholds": object{"mode": "absolute", "steps": thresholds},
+		}},
+		"options": object{"colorMode": "background", "graphMode": "none", "textMode": "value_and_name",
+			"reduceOptions": object{"calcs": []string{"lastNotNull"}, "fields": "", "values": false}},
+	}, 6, 4)
+}
+
+func (d *dashboardBuilder) metricRow(title string, definitions []exporter.MetricDefinition) {
+	if len(definitions) == 0 {
+		return
+	}
+	d.row(title)
+	for _, definition := range definitions {
+		d.metricPanel(definition)
+	}
+}
+
+// metricPanel adds a table for info metrics, a stat for timestamps, and a time series for everything else. Counters
+// are shown as rates.
+func (d *dashboardBuilder) metricPanel(definition exporter.MetricDefinition) {
+	selector := d.selector(definition.Name)
+	legend := "{{gateway}}"
+	for _, label := range definition.Labels {
+		legend += " {{" + label + "}}"
+	}
+
+	switch {
+	case definition.Info():
+		d.add(object{
+			"type":        "table",
+			"title":       definition.Name,
+			"description": definition.Help,
+			"datasource":  datasource(),
+			"targets":     []object{{"refId": "A", "expr": selector, "format": "table", "instant": true}},
+			"transformations": []object{{"id": "organize", "options": object{
+				"excludeByName": object{"Time": true, "Value": true, "__name__": true},
+			}}},
+		}, 12, 8)
+	case definition.Unit == "timestamp":
+		d.stat(definition.Name, definition.Help, fmt.Sprintf("(%s > 0) * 1000", selector), "dateTimeFromNow", nil, nil)
+	default:
+		expr, unit := selector, units[definition.Unit]
+		if definition.Type == prometheus.CounterValue {
+			expr, unit = fmt.Sprintf("rate(%s[$__rate_interval])", selector), "cps"
+		}
+		d.add(object{
+			"type":        "timeseries",
+			"title":       definition.Name,
+			"description": definition.Help,
+			"datasource":  datasource(),
+			"targets":     []object{{"refId": "A", "expr": expr, "legendFormat": legend}},
+			"fieldConfig": object{"defaults": object{
+				"unit":       unit,
+				"custom":     object{"thresholdsStyle": object{"mode": thresholdsStyle(d.thresholds(definition.Key))}},
+				"thresholds": object{"mode": "absolute", "steps": thresholdsOrDefault(d.thresholds(definition.Key))},
+			}},
+			"options": object{"legend": object{"displayMode": "list", "placement": "bottom"}, "tooltip": object{"mode": "multi"}},
+		}, 12, 8)
+	}
+}
+
+// thresholds returns the threshold steps shown on the panel of a metric, or nil.
+func (d *dashboardBuilder) thresholds(key string) []object {
+	thresholds := d.options.Thresholds
+	switch key {
+	case "since_last_status":
+		return thresholdSteps(thresholds.OfflineAfter.Seconds())
+	case "since_last_uplink":
+		return thresholdSteps(thresholds.NoUplinkAfter.Seconds())
+	case "clock_offset":
+		return []object{
+			{"color": "red", "value": nil},
+			{"color": "green", "value": -thresholds.MaxClockOffset.Seconds()},
+			{"color": "red", "value": thresholds.MaxClockOffset.Seconds()},
+		}
+	case "rtt_median", "rtt_max":
+		return thresholdSteps(float64(thresholds.MaxRTT.Nanoseconds()))
+	case "subband_headroom":
+		return []object{
+			{"color": "red", "value": nil},
+			{"color": "green", "value": 1 - thresholds.SubBandUtilization},
+		}
+	default:
+		return nil
+	}
+}
+
+var units = map[string]string{
+	"":            "short",
+	"seconds":     "s",
+	"nanoseconds": "ns",
+	"meters":      "lengthm",
+	"ratio":       "percentunit",
+}
+
+// thresholdSteps is green below the limit and red from it.
+func thresholdSteps(limit float64) []object {
+	return []object{{"color": "green", "value": nil}, {"color": "red", "value": limit}}
+}
+
+func thresholdsStyle(steps []object) string {
+	if steps == nil {
+		return "off"
+	}
+	return "line"
+}
+
+func thresholdsOrDefault(steps []object) []object {
+	if steps == nil {
+		return []object{{"color": "green", "value": nil}}
+	}
+	return steps
+}
+
+func emptyIfNil(values []object) []object {
+	if values == nil {
+		return []object{}
+	}
+	return values
+}
+
+func datasource() object {
+	return object{"type": "prometheus", "uid": "${datasource}"}
+}
+
+func matchesPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if key == prefix || strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+// Package generate derives Grafana dashboards and Prometheus alerting rules from the metric definitions of the
+// exporter, so they match the metrics of the exporter version that generated them.
+package generate
+
+import (
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"strings"
+	"time"
+)
+
+// Thresholds are shared by the dashboard, which colors values beyond them, and the alerting rules.
+type Thresholds struct {
+	// OfflineAfter is how long a gateway may be disconnected or without status
+	OfflineAfter time.Duration
+	// NoUplinkAfter is how long a gateway may receive no uplinks
+	NoUplinkAfter time.Duration
+	// SubBandUtilization is the share of the duty-cycle limit from which a sub-band is near its limit
+	SubBandUtilization float64
+	MaxClockOffset     time.Duration
+	MaxRTT             time.Duration
+}
+
+// DefaultThresholds match the defaults of the exporter config where there is one.
+var DefaultThresholds = Thresholds{
+	OfflineAfter:       10 * time.Minute,
+	NoUplinkAfter:      time.Hour,
+	SubBandUtilization: 0.8,
+	MaxClockOffset:     10 * time.Second,
+	MaxRTT:             500 * time.Millisecond,
+}
+
+// metrics are the target metrics by key.
+type metrics map[string]exporter.MetricDefinition
+
+func targetMetrics() metrics {
+	byKey := metrics{}
+	for _, definition := range exporter.TargetMetrics() {
+		byKey[definition.Key] = definition
+	}
+	return byKey
+}
+
+// name returns the name of a metric. It panics if the exporter no longer exports the metric, as the generated output
+// would silently refer to a missing metric otherwise.
+func (m metrics) name(key string) string {
+	definition, ok := m[key]
+	if !ok {
+		panic(fmt.Sprintf("metric %s is not exported", key))
+	}
+	return definition.Name
+}
+
+// promDuration formats a duration the way Prometheus writes them, e.g. 1h instead of 1h0m0s.
+func promDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
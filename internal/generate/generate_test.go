@@ -0,0 +1,125 @@
+package generate
+
+import (
+	"encoding/json"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var metricNamePattern = regexp.MustCompile(`\bttn_gateway_[a-zA-Z0-9_]+`)
+
+// checkMetricNames reports names in the expression that are not target metrics.
+func checkMetricNames(t *testing.T, expr string) {
+	t.Helper()
+	known := map[string]bool{}
+	for _, definition := range exporter.TargetMetrics() {
+		known[definition.Name] = true
+	}
+	names := metricNamePattern.FindAllString(expr, -1)
+	if len(names) == 0 {
+		t.Errorf("expression %q references no metric", expr)
+	}
+	for _, name := range names {
+		if !known[name] {
+			t.Errorf("expression %q references %s, which is not a target metric", expr, name)
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	generated, err := Dashboard(DashboardOptions{Title: "Gateways", UID: "ttn-gateways", Datasource: "Prometheus", Thresholds: DefaultThresholds, Selector: `job="ttn-gateway-exporter"`})
+	if err != nil {
+		t.Fatalf("Dashboard: %v", err)
+	}
+	var dashboard struct {
+		UID        string `json:"uid"`
+		Templating struct {
+			List []struct {
+				Name       string `json:"name"`
+				Definition string `json:"definition"`
+			} `json:"list"`
+		} `json:"templating"`
+		Panels []struct {
+			Type    string `json:"type"`
+			Title   string `json:"title"`
+			Targets []struct {
+				Expr string `json:"expr"`
+			} `json:"targets"`
+		} `json:"panels"`
+	}
+	if err := json.Unmarshal(generated, &dashboard); err != nil {
+		t.Fatalf("dashboard is not valid JSON: %v", err)
+	}
+	if dashboard.UID != "ttn-gateways" {
+		t.Errorf("uid = %q, want ttn-gateways", dashboard.UID)
+	}
+
+	for _, variable := range dashboard.Templating.List {
+		if variable.Name == "gateway" {
+			checkMetricNames(t, variable.Definition)
+		}
+	}
+	titles := map[string]bool{}
+	for _, panel := range dashboard.Panels {
+		titles[panel.Title] = true
+		for _, target := range panel.Targets {
+			checkMetricNames(t, target.Expr)
+		}
+	}
+	// new metrics appear on the dashboard without changes to the generator
+	for _, definition := range exporter.TargetMetrics() {
+		if !titles[definition.Name] {
+			t.Errorf("no panel for %s", definition.Name)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	generated, err := Rules(RuleOptions{Thresholds: DefaultThresholds, GroupName: "ttn-gateways", Selector: `job="ttn-gateway-exporter"`})
+	if err != nil {
+		t.Fatalf("Rules: %v", err)
+	}
+	var rules ruleFile
+	if err := yaml.Unmarshal(generated, &rules); err != nil {
+		t.Fatalf("rules are not valid YAML: %v", err)
+	}
+	if len(rules.Groups) != 1 || rules.Groups[0].Name != "ttn-gateways" || len(rules.Groups[0].Rules) == 0 {
+		t.Fatalf("groups = %+v, want one group with rules", rules.Groups)
+	}
+	alerts := map[string]bool{}
+	for _, r := range rules.Groups[0].Rules {
+		if alerts[r.Alert] {
+			t.Errorf("alert %s is defined twice", r.Alert)
+		}
+		alerts[r.Alert] = true
+		checkMetricNames(t, r.Expr)
+		if r.For != "" {
+			if _, err := time.ParseDuration(r.For); err != nil {
+				t.Errorf("%s: for %q is not a duration", r.Alert, r.For)
+			}
+		}
+		if r.Labels["severity"] == "" || r.Annotations["summary"] == "" || r.Annotations["description"] == "" {
+			t.Errorf("%s lacks severity, summary or description", r.Alert)
+		}
+	}
+}
+
+func TestMetricNamePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("name of a metric that is not exported did not panic")
+		}
+	}()
+	targetMetrics().name("no_such_metric")
+}
+
+func TestPromDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{time.Hour: "1h", 10 * time.Minute: "10m", 90 * time.Second: "1m30s", 500 * time.Millisecond: "500ms"} {
+		if got := promDuration(d); got != want {
+			t.Errorf("promDuration(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package generate
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"time"
+)
+
+// RuleOptions configure the generated alerting rules.
+type RuleOptions struct {
+	Thresholds Thresholds
+	// GroupName is the name of the rule group
+	GroupName string
+	// Selector is added to every metric selector, e.g. job="ttn-gateway-exporter"
+	Selector string
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// Rules returns a Prometheus rule file with alerts for the target metrics.
+func Rules(options RuleOptions) ([]byte, error) {
+	m := targetMetrics()
+	thresholds := options.Thresholds
+	selector := func(key string) string {
+		if options.Selector == "" {
+			return m.name(key)
+		}
+		return m.name(key) + "{" + options.Selector + "}"
+	}
+	alert := func(name, expr string, pending time.Duration, severity, summary, description string) rule {
+		r := rule{
+			Alert:  name,
+			Expr:   expr,
+			Labels: map[string]string{"severity": severity},
+			Annotations: map[string]string{
+				"summary":     summary,
+				"description": description,
+			},
+		}
+		if pending > 0 {
+			r.For = promDuration(pending)
+		}
+		return r
+	}
+
+	rules := []rule{
+		alert("TTNGatewayScrapeFailed",
+			selector("last_scrape_result")+" == 0", 15*time.Minute, "warning",
+			"Status of gateway {{ $labels.gateway }} cannot be read",
+			"The exporter could not read the status of gateway {{ $labels.gateway }} for 15 minutes. Check the API key and the network server."),
+		alert("TTNGatewayOffline",
+			selector("connected")+" == 0", thresholds.OfflineAfter, "critical",
+			"Gateway {{ $labels.gateway }} is offline",
+			fmt.Sprintf("Gateway {{ $labels.gateway }} has not been connected to the network server for %s.", promDuration(thresholds.OfflineAfter))),
+		alert("TTNGatewayStatusStale",
+			fmt.Sprintf("%s > %g", selector("since_last_status"), thresholds.OfflineAfter.Seconds()), 0, "warning",
+			"Gateway {{ $labels.gateway }} sends no status",
+			fmt.Sprintf("The network server received no status from gateway {{ $labels.gateway }} for more than %s.", promDuration(thresholds.OfflineAfter))),
+		alert("TTNGatewayNoUplinks",
+			fmt.Sprintf("%s > %g", selector("since_last_uplink"), thresholds.NoUplinkAfter.Seconds()), 0, "warning",
+			"Gateway {{ $labels.gateway }} receives no uplinks",
+			fmt.Sprintf("Gateway {{ $labels.gateway }} received no uplink for more than %s.", promDuration(thresholds.NoUplinkAfter))),
+		alert("TTNGatewaySubBandNearLimit",
+			fmt.Sprintf("%s / (%s > 0) >= %g", selector("subband_utilization"), selector("subband_utilization_limit"), thresholds.SubBandUtilization), 15*time.Minute, "warning",
+			"Sub-band {{ $labels.freqMin }}-{{ $labels.freqMax }} of gateway {{ $labels.gateway }} is near its duty-cycle limit",
+			fmt.Sprintf("Gateway {{ $labels.gateway }} uses more than %g%% of the duty-cycle limit of sub-band {{ $labels.freqMin }}-{{ $labels.freqMax }}, further downlinks may be dropped.", thresholds.SubBandUtilization*100)),
+		alert("TTNGatewayClockUnsynced",
+			fmt.Sprintf("abs(%s) > %g", selector("clock_offset"), thresholds.MaxClockOffset.Seconds()), 15*time.Minute, "warning",
+			"Clock of gateway {{ $labels.gateway }} is out of sync",
+			fmt.Sprintf("The clock of gateway {{ $labels.gateway }} is off by {{ $value | humanizeDuration }}, more than %s.", promDuration(thresholds.MaxClockOffset))),
+		alert("TTNGatewayHighRTT",
+			fmt.Sprintf("%s > %d", selector("rtt_median"), thresholds.MaxRTT.Nanoseconds()), 15*time.Minute, "warning",
+			"Round-trip time of gateway {{ $labels.gateway }} is high",
+			fmt.Sprintf("The median round-trip time of gateway {{ $labels.gateway }} is above %s, downlinks may miss their receive window.", thresholds.MaxRTT)),
+		alert("TTNGatewayGPSLost",
+			selector("gps_lost")+" == 1", 0, "warning",
+			"Gateway {{ $labels.gateway }} lost its GPS",
+			"Gateway {{ $labels.gateway }} reported GPS locations before, but none recently."),
+		alert("TTNGatewayAntennaMoved",
+			fmt.Sprintf("increase(%s[1h]) > 0", selector("antenna_moves")), 0, "info",
+			"Antenna of gateway {{ $labels.gateway }} moved",
+			"The reported antenna location of gateway {{ $labels.gateway }} moved significantly within the last hour."),
+	}
+	return yaml.Marshal(ruleFile{Groups: []ruleGroup{{Name: options.GroupName, Rules: rules}}})
+}
@@ -4,15 +4,42 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
+	"sync"
 )
 
+// output is shared by all loggers, so it can be redirected after the package loggers were created.
+var output = &switchableWriter{writer: os.Stdout}
+
 func Logger(targetPackage string) *zap.SugaredLogger {
 	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
-	writerSyncer := os.Stdout
 	levelEnabler := zapcore.DebugLevel
 
-	core := zapcore.NewCore(encoder, writerSyncer, levelEnabler)
+	core := zapcore.NewCore(encoder, output, levelEnabler)
 
 	logger := zap.New(core, zap.Fields(zap.String("package", targetPackage)))
 	return logger.Sugar()
 }
+
+// SetOutput redirects all loggers, e.g. to stderr for subcommands that write their result to stdout.
+func SetOutput(writer zapcore.WriteSyncer) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.writer = writer
+}
+
+type switchableWriter struct {
+	mu     sync.Mutex
+	writer zapcore.WriteSyncer
+}
+
+func (w *switchableWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Write(p)
+}
+
+func (w *switchableWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writer.Sync()
+}
@@ -0,0 +1,63 @@
+// Package lora implements calculations on LoRa modulation parameters.
+package lora
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// PreambleSymbols is the preamble length used by LoRaWAN.
+const PreambleSymbols = 8
+
+// DataRate are the modulation parameters of a LoRa transmission.
+type DataRate struct {
+	SpreadingFactor uint32
+	// Bandwidth in Hz, 125000, 250000 or 500000
+	Bandwidth uint32
+	// CodingRate in the form 4/5 to 4/8
+	CodingRate string
+}
+
+var codingRates = map[string]int{
+	"4/5": 1,
+	"4/6": 2,
+	"4/7": 3,
+	"4/8": 4,
+}
+
+// TimeOnAir calculates the time on air of a LoRa packet with an explicit header according to the formula in the
+// Semtech SX1276 datasheet, section 4.1.1.7. payloadSize is the PHY payload in bytes. LoRaWAN uplinks carry a
+// payload CRC, downlinks don't.
+func (dr DataRate) TimeOnAir(payloadSize int, crc bool) (time.Duration, error) {
+	if dr.SpreadingFactor < 7 || dr.SpreadingFactor > 12 {
+		return 0, fmt.Errorf("unsupported spreading factor %d", dr.SpreadingFactor)
+	}
+	if dr.Bandwidth != 125000 && dr.Bandwidth != 250000 && dr.Bandwidth != 500000 {
+		return 0, fmt.Errorf("unsupported bandwidth %d", dr.Bandwidth)
+	}
+	codingRate, ok := codingRates[dr.CodingRate]
+	if !ok {
+		return 0, fmt.Errorf("unsupported coding rate %q", dr.CodingRate)
+	}
+
+	sf := float64(dr.SpreadingFactor)
+	symbolDuration := math.Pow(2, sf) / float64(dr.Bandwidth)
+	// low data rate optimization is mandated for symbols longer than 16 ms
+	lowDataRateOptimize := 0.0
+	if symbolDuration > 0.016 {
+		lowDataRateOptimize = 1
+	}
+	crcBits := 0.0
+	if crc {
+		crcBits = 16
+	}
+
+	preamble := (PreambleSymbols + 4.25) * symbolDuration
+	payloadSymbols := 8 + math.Max(
+		math.Ceil((8*float64(payloadSize)-4*sf+28+crcBits)/(4*(sf-2*lowDataRateOptimize)))*float64(codingRate+4),
+		0,
+	)
+	seconds := preamble + payloadSymbols*symbolDuration
+	return time.Duration(math.Round(seconds * float64(time.Second))), nil
+}
@@ -0,0 +1,102 @@
+package lora
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeOnAir(t *testing.T) {
+	// Values of the Semtech LoRa calculator with 8 preamble symbols and explicit header. 13 bytes is the PHY payload of
+	// an uplink without FRMPayload, 23 bytes one with 10 bytes of application payload. Low data rate optimization
+	// applies to symbols longer than 16 ms, i.e. SF11 and SF12 at 125 kHz and SF12 at 250 kHz, never at 500 kHz.
+	tests := []struct {
+		spreadingFactor uint32
+		bandwidth       uint32
+		payloadSize     int
+		want            time.Duration
+	}{
+		{7, 125000, 13, 46336 * time.Microsecond},
+		{7, 125000, 23, 61696 * time.Microsecond},
+		{8, 125000, 13, 82432 * time.Microsecond},
+		{8, 125000, 23, 113152 * time.Microsecond},
+		{9, 125000, 13, 164864 * time.Microsecond},
+		{9, 125000, 23, 205824 * time.Microsecond},
+		{10, 125000, 13, 288768 * time.Microsecond},
+		{10, 125000, 23, 370688 * time.Microsecond},
+		{11, 125000, 13, 577536 * time.Microsecond},
+		{11, 125000, 23, 823296 * time.Microsecond},
+		{12, 125000, 13, 1155072 * time.Microsecond},
+		{12, 125000, 23, 1482752 * time.Microsecond},
+		{7, 250000, 13, 23168 * time.Microsecond},
+		{7, 250000, 23, 30848 * time.Microsecond},
+		{8, 250000, 13, 41216 * time.Microsecond},
+		{8, 250000, 23, 56576 * time.Microsecond},
+		{9, 250000, 13, 82432 * time.Microsecond},
+		{9, 250000, 23, 102912 * time.Microsecond},
+		{10, 250000, 13, 144384 * time.Microsecond},
+		{10, 250000, 23, 185344 * time.Microsecond},
+		{11, 250000, 13, 288768 * time.Microsecond},
+		{11, 250000, 23, 370688 * time.Microsecond},
+		{12, 250000, 13, 577536 * time.Microsecond},
+		{12, 250000, 23, 741376 * time.Microsecond},
+		{7, 500000, 13, 11584 * time.Microsecond},
+		{7, 500000, 23, 15424 * time.Microsecond},
+		{8, 500000, 13, 20608 * time.Microsecond},
+		{8, 500000, 23, 28288 * time.Microsecond},
+		{9, 500000, 13, 41216 * time.Microsecond},
+		{9, 500000, 23, 51456 * time.Microsecond},
+		{10, 500000, 13, 72192 * time.Microsecond},
+		{10, 500000, 23, 92672 * time.Microsecond},
+		{11, 500000, 13, 144384 * time.Microsecond},
+		{11, 500000, 23, 185344 * time.Microsecond},
+		{12, 500000, 13, 288768 * time.Microsecond},
+		{12, 500000, 23, 329728 * time.Microsecond},
+	}
+	for _, test := range tests {
+		dataRate := DataRate{SpreadingFactor: test.spreadingFactor, Bandwidth: test.bandwidth, CodingRate: "4/5"}
+		got, err := dataRate.TimeOnAir(test.payloadSize, true)
+		if err != nil {
+			t.Errorf("SF%d BW%d: %v", test.spreadingFactor, test.bandwidth/1000, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("SF%d BW%d %d bytes: time on air = %s, want %s", test.spreadingFactor, test.bandwidth/1000, test.payloadSize, got, test.want)
+		}
+	}
+}
+
+func TestTimeOnAirDownlink(t *testing.T) {
+	// downlinks carry no payload CRC
+	tests := []struct {
+		dataRate DataRate
+		want     time.Duration
+	}{
+		{DataRate{SpreadingFactor: 7, Bandwidth: 125000, CodingRate: "4/8"}, 78080 * time.Microsecond},
+		{DataRate{SpreadingFactor: 12, Bandwidth: 125000, CodingRate: "4/8"}, 1974272 * time.Microsecond},
+		{DataRate{SpreadingFactor: 12, Bandwidth: 250000, CodingRate: "4/8"}, 987136 * time.Microsecond},
+	}
+	for _, test := range tests {
+		got, err := test.dataRate.TimeOnAir(23, false)
+		if err != nil {
+			t.Errorf("%+v: %v", test.dataRate, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%+v: time on air = %s, want %s", test.dataRate, got, test.want)
+		}
+	}
+}
+
+func TestTimeOnAirUnsupported(t *testing.T) {
+	for _, dataRate := range []DataRate{
+		{SpreadingFactor: 6, Bandwidth: 125000, CodingRate: "4/5"},
+		{SpreadingFactor: 13, Bandwidth: 125000, CodingRate: "4/5"},
+		{SpreadingFactor: 7, Bandwidth: 812000, CodingRate: "4/5"},
+		{SpreadingFactor: 7, Bandwidth: 125000, CodingRate: "4/9"},
+		{SpreadingFactor: 7, Bandwidth: 125000},
+	} {
+		if _, err := dataRate.TimeOnAir(13, true); err == nil {
+			t.Errorf("%+v: no error", dataRate)
+		}
+	}
+}
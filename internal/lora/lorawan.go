@@ -0,0 +1,125 @@
+package lora
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// MType is the message type in the MAC header of a LoRaWAN frame.
+type MType uint8
+
+const (
+	MTypeJoinRequest         MType = 0
+	MTypeJoinAccept          MType = 1
+	MTypeUnconfirmedDataUp   MType = 2
+	MTypeUnconfirmedDataDown MType = 3
+	MTypeConfirmedDataUp     MType = 4
+	MTypeConfirmedDataDown   MType = 5
+	MTypeRejoinRequest       MType = 6
+	MTypeProprietary         MType = 7
+)
+
+// ParseMType returns the message type of a PHY payload.
+func ParseMType(payload []byte) (MType, error) {
+	if len(payload) == 0 {
+		return 0, fmt.Errorf("empty payload")
+	}
+	return MType(payload[0] >> 5), nil
+}
+
+// DevAddr is a 32 bit LoRaWAN device address.
+type DevAddr uint32
+
+// ParseDevAddr returns the device address of a data uplink or downlink. The address is transmitted little-endian
+// after the MAC header.
+func ParseDevAddr(payload []byte) (DevAddr, error) {
+	mType, err := ParseMType(payload)
+	if err != nil {
+		return 0, err
+	}
+	if mType < MTypeUnconfirmedDataUp || mType > MTypeConfirmedDataDown {
+		return 0, fmt.Errorf("message type %d has no DevAddr", mType)
+	}
+	if len(payload) < 5 {
+		return 0, fmt.Errorf("payload of %d bytes too short for a DevAddr", len(payload))
+	}
+	return DevAddr(binary.LittleEndian.Uint32(payload[1:5])), nil
+}
+
+// nwkIDBits is the length of the NwkID in DevAddrs of every NetID type, see the LoRaWAN Backend Interfaces
+// specification, section 13.
+var nwkIDBits = [8]uint{6, 6, 9, 11, 12, 13, 15, 17}
+
+// NetID returns the NetID the address was assigned from. The type is encoded as the number of leading one bits,
+// followed by the NwkID, which are the least significant bits of the NetID.
+func (addr DevAddr) NetID() (NetID, error) {
+	netIDType := uint(bits.LeadingZeros32(^uint32(addr)))
+	if netIDType > 7 {
+		return 0, fmt.Errorf("DevAddr %s has an invalid NetID type", addr)
+	}
+	prefixBits := netIDType + 1
+	nwkID := uint32(addr) << prefixBits >> (32 - nwkIDBits[netIDType])
+	return NetID(uint32(netIDType)<<21 | nwkID), nil
+}
+
+func (addr DevAddr) String() string {
+	return fmt.Sprintf("%08X", uint32(addr))
+}
+
+// NetID is a 24 bit LoRaWAN network identifier.
+type NetID uint32
+
+// NetIDTTN is the NetID of The Things Network.
+const NetIDTTN NetID = 0x000013
+
+func (netID NetID) String() string {
+	return fmt.Sprintf("%06X", uint32(netID))
+}
+
+// ParseNetID parses a NetID in hexadecimal notation, e.g. 000013.
+func ParseNetID(value string) (NetID, error) {
+	if len(value) != 6 {
+		return 0, fmt.Errorf("NetID %q must have 6 hex digits", value)
+	}
+	parsed, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("NetID %q is not hexadecimal", value)
+	}
+	return NetID(parsed), nil
+}
+
+// DevAddrPrefix is a range of device addresses, written as address/length like 260B0000/16.
+type DevAddrPrefix struct {
+	DevAddr DevAddr
+	Length  uint
+}
+
+// ParseDevAddrPrefix parses a prefix in the form 260B0000/16.
+func ParseDevAddrPrefix(value string) (DevAddrPrefix, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return DevAddrPrefix{}, fmt.Errorf("DevAddr prefix %q must have the form 260B0000/16", value)
+	}
+	addr, err := hex.DecodeString(parts[0])
+	if err != nil || len(addr) != 4 {
+		return DevAddrPrefix{}, fmt.Errorf("DevAddr prefix %q must start with 8 hex digits", value)
+	}
+	length, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil || length > 32 {
+		return DevAddrPrefix{}, fmt.Errorf("DevAddr prefix %q must have a length between 0 and 32", value)
+	}
+	return DevAddrPrefix{DevAddr: DevAddr(binary.BigEndian.Uint32(addr)), Length: uint(length)}, nil
+}
+
+// Matches reports whether the address is in the range of the prefix.
+func (prefix DevAddrPrefix) Matches(addr DevAddr) bool {
+	if prefix.Length == 0 {
+		return true
+	}
+	mask := ^uint32(0) << (32 - prefix.Length)
+	return uint32(addr)&mask == uint32(prefix.DevAddr)&mask
+}
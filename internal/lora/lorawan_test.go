@@ -0,0 +1,83 @@
+package lora
+
+import (
+	"testing"
+)
+
+func TestDevAddrNetID(t *testing.T) {
+	tests := []struct {
+		devAddr DevAddr
+		want    NetID
+	}{
+		// type 0: prefix 0, 6 bit NwkID, e.g. The Things Network
+		{devAddr: 0x260B1234, want: 0x000013},
+		{devAddr: 0x01FFFFFF, want: 0x000000},
+		// type 1: prefix 10, 6 bit NwkID
+		{devAddr: 0xAA800000, want: 0x20002A},
+		// type 2: prefix 110, 9 bit NwkID
+		{devAddr: 0xD5500000, want: 0x400155},
+		// type 3: prefix 1110, 11 bit NwkID
+		{devAddr: 0xE2460000, want: 0x600123},
+		// type 4: prefix 11110, 12 bit NwkID
+		{devAddr: 0xF55E0000, want: 0x800ABC},
+		// type 5: prefix 111110, 13 bit NwkID
+		{devAddr: 0xF8002000, want: 0xA00001},
+		// type 6: prefix 1111110, 15 bit NwkID
+		{devAddr: 0xFDFFFC00, want: 0xC07FFF},
+		// type 7: prefix 11111110, 17 bit NwkID
+		{devAddr: 0xFE800000, want: 0xE10000},
+	}
+	for _, test := range tests {
+		got, err := test.devAddr.NetID()
+		if err != nil {
+			t.Errorf("%s: NetID: %v", test.devAddr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: NetID = %s, want %s", test.devAddr, got, test.want)
+		}
+	}
+
+	if netID, err := DevAddr(0xFF000000).NetID(); err == nil {
+		t.Errorf("FF000000: NetID = %s, want an error for the invalid type", netID)
+	}
+}
+
+func TestParseDevAddr(t *testing.T) {
+	tests := []struct {
+		payload []byte
+		want    DevAddr
+		wantErr bool
+	}{
+		{payload: []byte{0x40, 0x34, 0x12, 0x0B, 0x26, 0x00, 0x01, 0x00}, want: 0x260B1234},
+		{payload: []byte{0x80, 0x34, 0x12, 0x0B, 0x26}, want: 0x260B1234},
+		{payload: []byte{0x60, 0x34, 0x12, 0x0B, 0x26, 0x20}, want: 0x260B1234},
+		{payload: []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}, wantErr: true},
+		{payload: []byte{0xE0, 0x01, 0x02, 0x03, 0x04, 0x05}, wantErr: true},
+		{payload: []byte{0x40, 0x34, 0x12}, wantErr: true},
+		{payload: nil, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseDevAddr(test.payload)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseDevAddr(% X) = %s, %v, want %s, error %v", test.payload, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestDevAddrPrefix(t *testing.T) {
+	prefix, err := ParseDevAddrPrefix("260B1200/24")
+	if err != nil {
+		t.Fatalf("ParseDevAddrPrefix: %v", err)
+	}
+	for devAddr, want := range map[DevAddr]bool{0x260B1200: true, 0x260B12FF: true, 0x260B1300: false, 0x27000000: false} {
+		if got := prefix.Matches(devAddr); got != want {
+			t.Errorf("%s matches 260B1200/24 = %v, want %v", devAddr, got, want)
+		}
+	}
+	for _, value := range []string{"260B1200", "260B12/24", "260B1200/33", "XXXXXXXX/8"} {
+		if _, err := ParseDevAddrPrefix(value); err == nil {
+			t.Errorf("ParseDevAddrPrefix(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package mqttpublisher
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"strings"
+)
+
+// discoveryConfig is a Home Assistant MQTT discovery payload, see https://www.home-assistant.io/integrations/mqtt/.
+type discoveryConfig struct {
+	Name                string `json:"name"`
+	UniqueID            string `json:"unique_id"`
+	StateTopic          string `json:"state_topic"`
+	ValueTemplate       string `json:"value_template"`
+	JSONAttributesTopic string `json:"json_attributes_topic,omitempty"`
+	AvailabilityTopic   string `json:"availability_topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+	DeviceClass         string `json:"device_class,omitempty"`
+	StateClass          string `json:"state_class,omitempty"`
+	UnitOfMeasurement   string `json:"unit_of_measurement,omitempty"`
+	EntityCategory      string `json:"entity_category,omitempty"`
+	Icon                string `json:"icon,omitempty"`
+	Device              device `json:"device"`
+}
+
+type device struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+}
+
+// entity is a value of the state payload exposed as Home Assistant entity.
+type entity struct {
+	component      string
+	key            string
+	name           string
+	valueTemplate  string
+	deviceClass    string
+	stateClass     string
+	unit           string
+	entityCategory string
+	icon           string
+}
+
+// entities are rendered as "None" if the value is null, which Home Assistant shows as unknown.
+var entities = []entity{
+	{component: "binary_sensor", key: "online", name: "Online", valueTemplate: "{{ 'ON' if value_json.online else 'OFF' }}", deviceClass: "connectivity"},
+	{component: "sensor", key: "last_seen", name: "Last seen", valueTemplate: "{{ value_json.last_seen_at }}", deviceClass: "timestamp"},
+	{component: "sensor", key: "uplinks", name: "Uplinks", valueTemplate: "{{ value_json.uplink_count }}", stateClass: "total_increasing", icon: "mdi:arrow-up-bold"},
+	{component: "sensor", key: "downlinks", name: "Downlinks", valueTemplate: "{{ value_json.downlink_count }}", stateClass: "total_increasing", icon: "mdi:arrow-down-bold"},
+	{component: "sensor", key: "rtt", name: "Round-trip time", valueTemplate: "{{ value_json.rtt_median_ms }}", deviceClass: "duration", stateClass: "measurement", unit: "ms", entityCategory: "diagnostic"},
+}
+
+// discoveryConfigs returns the discovery payloads of the entities of a gateway by topic. All entities belong to one
+// device per gateway, the online entity carries the whole state as attributes.
+func (p *Publisher) discoveryConfigs(target *exporter.Target, id string) map[string]discoveryConfig {
+	targetConfig := target.Config()
+	gatewayDevice := device{
+		Identifiers: []string{"ttn_gateway_" + id},
+		Name:        targetConfig.FullGatewayID(),
+		Model:       "LoRaWAN gateway (" + target.BackendName() + ")",
+	}
+	configs := map[string]discoveryConfig{}
+	for _, e := range entities {
+		discovery := discoveryConfig{
+			Name:                e.name,
+			UniqueID:            "ttn_gateway_" + id + "_" + e.key,
+			StateTopic:          p.stateTopic(id),
+			ValueTemplate:       e.valueTemplate,
+			AvailabilityTopic:   p.availabilityTopic(),
+			PayloadAvailable:    payloadOnline,
+			PayloadNotAvailable: payloadOffline,
+			DeviceClass:         e.deviceClass,
+			StateClass:          e.stateClass,
+			UnitOfMeasurement:   e.unit,
+			EntityCategory:      e.entityCategory,
+			Icon:                e.icon,
+			Device:              gatewayDevice,
+		}
+		if e.key == "online" {
+			discovery.JSONAttributesTopic = p.stateTopic(id)
+		}
+		topic := strings.TrimSuffix(p.config.HomeAssistant.DiscoveryPrefix, "/") + "/" + e.component + "/ttn_gateway_" + id + "/" + e.key + "/config"
+		configs[topic] = discovery
+	}
+	return configs
+}
@@ -0,0 +1,62 @@
+package mqttpublisher
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"sort"
+	"testing"
+)
+
+func TestDiscoveryConfigs(t *testing.T) {
+	p := &Publisher{config: config.MQTTPublisher{
+		TopicPrefix:   "ttn-gateways/",
+		HomeAssistant: config.HomeAssistant{Enabled: true, DiscoveryPrefix: "homeassistant/"},
+	}}
+	target := newTestTarget(t, "my-gateway", "acme")
+	id := gatewayID(target.Config())
+	if id != "my-gateway_acme" {
+		t.Fatalf("gatewayID = %q, want my-gateway_acme", id)
+	}
+
+	configs := p.discoveryConfigs(target, id)
+	var topics []string
+	for topic := range configs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	want := []string{
+		"homeassistant/binary_sensor/ttn_gateway_my-gateway_acme/online/config",
+		"homeassistant/sensor/ttn_gateway_my-gateway_acme/downlinks/config",
+		"homeassistant/sensor/ttn_gateway_my-gateway_acme/last_seen/config",
+		"homeassistant/sensor/ttn_gateway_my-gateway_acme/rtt/config",
+		"homeassistant/sensor/ttn_gateway_my-gateway_acme/uplinks/config",
+	}
+	if len(topics) != len(want) {
+		t.Fatalf("topics = %q, want %q", topics, want)
+	}
+	uniqueIDs := map[string]bool{}
+	for i, topic := range topics {
+		if topic != want[i] {
+			t.Errorf("topic %d = %q, want %q", i, topic, want[i])
+		}
+		discovery := configs[topic]
+		if uniqueIDs[discovery.UniqueID] {
+			t.Errorf("unique_id %q is not unique", discovery.UniqueID)
+		}
+		uniqueIDs[discovery.UniqueID] = true
+		if discovery.StateTopic != "ttn-gateways/my-gateway_acme/state" || discovery.AvailabilityTopic != "ttn-gateways/status" {
+			t.Errorf("%s: state, availability topic = %q, %q", topic, discovery.StateTopic, discovery.AvailabilityTopic)
+		}
+		if discovery.PayloadAvailable != payloadOnline || discovery.PayloadNotAvailable != payloadOffline {
+			t.Errorf("%s: availability payloads = %q, %q", topic, discovery.PayloadAvailable, discovery.PayloadNotAvailable)
+		}
+		if len(discovery.Device.Identifiers) != 1 || discovery.Device.Identifiers[0] != "ttn_gateway_my-gateway_acme" || discovery.Device.Name != "my-gateway@acme" {
+			t.Errorf("%s: device = %+v, want the gateway", topic, discovery.Device)
+		}
+	}
+	if online := configs[want[0]]; online.UniqueID != "ttn_gateway_my-gateway_acme_online" || online.JSONAttributesTopic != online.StateTopic {
+		t.Errorf("online = %+v, want the state as attributes", online)
+	}
+	if rtt := configs[want[3]]; rtt.JSONAttributesTopic != "" || rtt.UnitOfMeasurement != "ms" {
+		t.Errorf("rtt = %+v, want ms without attributes", rtt)
+	}
+}
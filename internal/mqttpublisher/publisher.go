@@ -0,0 +1,236 @@
+// Package mqttpublisher publishes the state of every gateway as retained JSON to an MQTT broker, with Home Assistant
+// discovery configs.
+package mqttpublisher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var log = logging.Logger("mqttpublisher")
+
+const (
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+
+	publishTimeout = 10 * time.Second
+)
+
+var invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Publisher publishes the state of the gateways in the snapshots of a Poller. The availability topic is set to online
+// when connected and to offline by the broker, via the last will, when the exporter disconnects.
+type Publisher struct {
+	config config.MQTTPublisher
+	client mqtt.Client
+	desc   *prometheus.Desc
+
+	mu sync.Mutex
+	// discovered holds the gateways whose discovery configs were published since the last connect
+	discovered map[string]bool
+	published  map[string]uint64
+}
+
+// state is the retained JSON payload of a gateway. Unknown values are null, so Home Assistant shows them as unknown.
+type state struct {
+	GatewayID     string     `json:"gateway_id"`
+	Tenant        string     `json:"tenant,omitempty"`
+	Backend       string     `json:"backend"`
+	Cluster       string     `json:"cluster,omitempty"`
+	Online        bool       `json:"online"`
+	LastSeenAt    *time.Time `json:"last_seen_at"`
+	UplinkCount   *uint64    `json:"uplink_count"`
+	DownlinkCount *uint64    `json:"downlink_count"`
+	RTTMinMs      *float64   `json:"rtt_min_ms"`
+	RTTMedianMs   *float64   `json:"rtt_median_ms"`
+	RTTMaxMs      *float64   `json:"rtt_max_ms"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func New(publisherConfig config.MQTTPublisher) (*Publisher, error) {
+	publisher := &Publisher{
+		config: publisherConfig,
+		desc: prometheus.NewDesc(prometheus.BuildFQName("ttn", "gateway", "mqtt_publishes_total"),
+			"Number of MQTT messages published by result (success or error)", []string{"result"}, nil),
+		discovered: map[string]bool{},
+		published:  map[string]uint64{},
+	}
+
+	clientID := publisherConfig.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("ttn-gateway-exporter-%08x", rand.Uint32())
+	}
+	options := mqtt.NewClientOptions().
+		AddBroker(publisherConfig.Broker).
+		SetClientID(clientID).
+		SetUsername(publisherConfig.Username).
+		SetPassword(publisherConfig.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(publisher.availabilityTopic(), payloadOffline, publisherConfig.QoS, true).
+		SetOnConnectHandler(publisher.connected).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Warnw("mqtt connection lost", "broker", publisherConfig.Broker, "error", err)
+		})
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: publisherConfig.InsecureSkipVerify}
+	if publisherConfig.CAFile != "" {
+		pem, err := os.ReadFile(publisherConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no PEM certificates", publisherConfig.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	options.SetTLSConfig(tlsConfig)
+
+	publisher.client = mqtt.NewClient(options)
+	// with connect retry enabled, the client keeps connecting in the background
+	publisher.client.Connect()
+	return publisher, nil
+}
+
+func (p *Publisher) Describe(descs chan<- *prometheus.Desc) {
+	descs <- p.desc
+}
+
+func (p *Publisher) Collect(metrics chan<- prometheus.Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for result, count := range p.published {
+		metrics <- prometheus.MustNewConstMetric(p.desc, prometheus.CounterValue, float64(count), result)
+	}
+}
+
+// connected announces the availability and makes Observe publish the discovery configs again, in case the broker lost
+// its retained messages.
+func (p *Publisher) connected(_ mqtt.Client) {
+	log.Infow("mqtt connected", "broker", p.config.Broker)
+	p.mu.Lock()
+	p.discovered = map[string]bool{}
+	p.mu.Unlock()
+	p.publish(p.availabilityTopic(), []byte(payloadOnline))
+}
+
+// Observe publishes the state of every gateway in the snapshot. It is meant to be subscribed to an exporter.Poller.
+// Statuses that could not be read for other reasons than a disconnected gateway are skipped, so the last retained
+// state stays.
+func (p *Publisher) Observe(snapshot exporter.Snapshot) {
+	if !p.client.IsConnectionOpen() {
+		log.Debugw("mqtt not connected, skipping snapshot")
+		return
+	}
+	for _, targetStatus := range snapshot.Statuses {
+		target, status, err := targetStatus.Target, targetStatus.Status, targetStatus.Err
+		if err != nil && !errors.Is(err, exporter.ErrNotConnected) {
+			log.Debugw("gateway status error", "target", target.Config().FullGatewayID(), "error", err)
+			continue
+		}
+		id := gatewayID(target.Config())
+
+		if p.config.HomeAssistant.Enabled {
+			p.mu.Lock()
+			discovered := p.discovered[id]
+			p.discovered[id] = true
+			p.mu.Unlock()
+			if !discovered {
+				for topic, discoveryConfig := range p.discoveryConfigs(target, id) {
+					p.publishJSON(topic, discoveryConfig)
+				}
+			}
+		}
+		p.publishJSON(p.stateTopic(id), newState(target, status, err == nil && status.Connected, snapshot.At))
+	}
+}
+
+func newState(target *exporter.Target, status exporter.GatewayStatus, online bool, now time.Time) state {
+	targetConfig := target.Config()
+	gatewayState := state{
+		GatewayID:     targetConfig.GatewayID,
+		Tenant:        targetConfig.Tenant,
+		Backend:       target.BackendName(),
+		Cluster:       status.Cluster,
+		Online:        online,
+		UplinkCount:   status.UplinkCount,
+		DownlinkCount: status.DownlinkCount,
+		UpdatedAt:     now.UTC(),
+	}
+	if !status.LastSeenAt.IsZero() {
+		lastSeenAt := status.LastSeenAt.UTC()
+		gatewayState.LastSeenAt = &lastSeenAt
+	}
+	if rtt := status.RoundTripTimes; rtt != nil {
+		milliseconds := func(d time.Duration) *float64 {
+			ms := float64(d) / float64(time.Millisecond)
+			return &ms
+		}
+		gatewayState.RTTMinMs = milliseconds(rtt.Min)
+		gatewayState.RTTMedianMs = milliseconds(rtt.Median)
+		gatewayState.RTTMaxMs = milliseconds(rtt.Max)
+	}
+	return gatewayState
+}
+
+// gatewayID identifies the gateway in topics and Home Assistant IDs, which don't allow all characters of gateway IDs
+// with tenant.
+func gatewayID(target config.Target) string {
+	return invalidIDChars.ReplaceAllString(target.FullGatewayID(), "_")
+}
+
+func (p *Publisher) availabilityTopic() string {
+	return strings.TrimSuffix(p.config.TopicPrefix, "/") + "/status"
+}
+
+func (p *Publisher) stateTopic(id string) string {
+	return strings.TrimSuffix(p.config.TopicPrefix, "/") + "/" + id + "/state"
+}
+
+func (p *Publisher) publishJSON(topic string, payload interface{}) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		log.Errorw("mqtt payload encoding error", "topic", topic, "error", err)
+		return
+	}
+	p.publish(topic, encoded)
+}
+
+// publish sends a retained message and waits until it was handed to the broker.
+func (p *Publisher) publish(topic string, payload []byte) {
+	token := p.client.Publish(topic, p.config.QoS, true, payload)
+	var err error
+	if token.WaitTimeout(publishTimeout) {
+		err = token.Error()
+	} else {
+		err = fmt.Errorf("no acknowledgement within %s", publishTimeout)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.published["error"]++
+		log.Errorw("mqtt publish error", "topic", topic, "error", err)
+		return
+	}
+	p.published["success"]++
+}
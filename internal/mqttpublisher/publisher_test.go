@@ -0,0 +1,223 @@
+package mqttpublisher
+
+import (
+	"encoding/json"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestTarget(t *testing.T, gatewayID, tenant string) *exporter.Target {
+	t.Helper()
+	target, err := exporter.NewTarget(config.TargetConfig{}, config.Target{GatewayID: gatewayID, Tenant: tenant, APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN})
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+	return target
+}
+
+func TestNewState(t *testing.T) {
+	target := newTestTarget(t, "my-gateway", "acme")
+	now := time.Date(2026, 10, 19, 10, 5, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	unknown := newState(target, exporter.GatewayStatus{}, false, now)
+	encoded, err := json.Marshal(unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"gateway_id":     "my-gateway",
+		"tenant":         "acme",
+		"backend":        "ttn",
+		"online":         false,
+		"last_seen_at":   nil,
+		"uplink_count":   nil,
+		"downlink_count": nil,
+		"rtt_min_ms":     nil,
+		"rtt_median_ms":  nil,
+		"rtt_max_ms":     nil,
+		"updated_at":     "2026-10-19T08:05:00Z",
+	}
+	if !reflect.DeepEqual(payload, want) {
+		t.Errorf("state of unknown values = %v, want %v", payload, want)
+	}
+
+	uplinks, downlinks := uint64(42), uint64(0)
+	known := newState(target, exporter.GatewayStatus{
+		Cluster:       "eu1",
+		LastSeenAt:    now.Add(-time.Minute),
+		UplinkCount:   &uplinks,
+		DownlinkCount: &downlinks,
+		RoundTripTimes: &exporter.RoundTripTimes{
+			Min:    1500 * time.Microsecond,
+			Median: 40 * time.Millisecond,
+			Max:    2 * time.Second,
+		},
+	}, true, now)
+	if !known.Online || known.Cluster != "eu1" {
+		t.Errorf("online, cluster = %v, %q, want true, eu1", known.Online, known.Cluster)
+	}
+	if known.LastSeenAt == nil || !known.LastSeenAt.Equal(now.Add(-time.Minute)) || known.LastSeenAt.Location() != time.UTC {
+		t.Errorf("last_seen_at = %v, want a minute ago in UTC", known.LastSeenAt)
+	}
+	if known.UplinkCount == nil || *known.UplinkCount != 42 || known.DownlinkCount == nil || *known.DownlinkCount != 0 {
+		t.Errorf("uplink_count, downlink_count = %v, %v, want 42, 0", known.UplinkCount, known.DownlinkCount)
+	}
+	for name, got := range map[string]*float64{"min": known.RTTMinMs, "median": known.RTTMedianMs, "max": known.RTTMaxMs} {
+		wantMs := map[string]float64{"min": 1.5, "median": 40, "max": 2000}[name]
+		if got == nil || *got != wantMs {
+			t.Errorf("rtt_%s_ms = %v, want %v", name, got, wantMs)
+		}
+	}
+}
+
+// broker is a minimal MQTT broker that accepts a single client and records its connect and publish packets.
+type broker struct {
+	listener net.Listener
+
+	mu        sync.Mutex
+	connect   *packets.ConnectPacket
+	published []*packets.PublishPacket
+}
+
+func newBroker(t *testing.T) *broker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &broker{listener: listener}
+	t.Cleanup(func() { _ = listener.Close() })
+	go b.serve()
+	return b
+}
+
+func (b *broker) serve() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var reply packets.ControlPacket
+		switch packet := packet.(type) {
+		case *packets.ConnectPacket:
+			b.mu.Lock()
+			b.connect = packet
+			b.mu.Unlock()
+			reply = packets.NewControlPacket(packets.Connack)
+		case *packets.PublishPacket:
+			b.mu.Lock()
+			b.published = append(b.published, packet)
+			b.mu.Unlock()
+			if packet.Qos == 1 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = packet.MessageID
+				reply = puback
+			}
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if reply != nil {
+			if err := reply.Write(conn); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *broker) messages() []*packets.PublishPacket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*packets.PublishPacket(nil), b.published...)
+}
+
+// waitFor waits until the broker received count messages.
+func (b *broker) waitFor(t *testing.T, count int) []*packets.PublishPacket {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.messages()) < count && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	messages := b.messages()
+	if len(messages) < count {
+		t.Fatalf("broker received %d messages, want %d", len(messages), count)
+	}
+	return messages
+}
+
+func TestAvailability(t *testing.T) {
+	b := newBroker(t)
+	p, err := New(config.MQTTPublisher{Broker: "tcp://" + b.listener.Addr().String(), ClientID: "exporter", TopicPrefix: "ttn-gateways/", QoS: 1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.client.Disconnect(100)
+
+	online := b.waitFor(t, 1)[0]
+	if online.TopicName != "ttn-gateways/status" || string(online.Payload) != payloadOnline || !online.Retain || online.Qos != 1 {
+		t.Errorf("first message = %s %q retained %v QoS %d, want retained %q on ttn-gateways/status with QoS 1", online.TopicName, online.Payload, online.Retain, online.Qos, payloadOnline)
+	}
+
+	b.mu.Lock()
+	connect := b.connect
+	b.mu.Unlock()
+	if connect == nil || !connect.WillFlag {
+		t.Fatalf("connect = %v, want a last will", connect)
+	}
+	if connect.WillTopic != "ttn-gateways/status" || string(connect.WillMessage) != payloadOffline || !connect.WillRetain || connect.WillQos != 1 {
+		t.Errorf("will = %s %q retained %v QoS %d, want retained %q on ttn-gateways/status with QoS 1", connect.WillTopic, connect.WillMessage, connect.WillRetain, connect.WillQos, payloadOffline)
+	}
+}
+
+func TestObservePublishesDiscoveryOnce(t *testing.T) {
+	b := newBroker(t)
+	p, err := New(config.MQTTPublisher{
+		Broker:        "tcp://" + b.listener.Addr().String(),
+		TopicPrefix:   "ttn-gateways",
+		HomeAssistant: config.HomeAssistant{Enabled: true, DiscoveryPrefix: "homeassistant"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.client.Disconnect(100)
+	b.waitFor(t, 1)
+
+	snapshot := exporter.Snapshot{
+		At:       time.Date(2026, 10, 19, 8, 5, 0, 0, time.UTC),
+		Statuses: []exporter.TargetStatus{{Target: newTestTarget(t, "my-gateway", ""), Status: exporter.GatewayStatus{Connected: true}}},
+	}
+	p.Observe(snapshot)
+	p.Observe(snapshot)
+
+	topics := map[string]int{}
+	for _, message := range b.waitFor(t, 1+len(entities)+2) {
+		if !message.Retain {
+			t.Errorf("message on %s is not retained", message.TopicName)
+		}
+		topics[message.TopicName]++
+	}
+	if topics["ttn-gateways/my-gateway/state"] != 2 {
+		t.Errorf("state published %d times, want 2", topics["ttn-gateways/my-gateway/state"])
+	}
+	if topics["homeassistant/binary_sensor/ttn_gateway_my-gateway/online/config"] != 1 {
+		t.Errorf("discovery config published %d times, want once", topics["homeassistant/binary_sensor/ttn_gateway_my-gateway/online/config"])
+	}
+	if len(topics) != 2+len(entities) {
+		t.Errorf("topics = %v, want status, state and %d discovery configs", topics, len(entities))
+	}
+}
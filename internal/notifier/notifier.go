@@ -0,0 +1,202 @@
+// Package notifier sends messages to webhooks, chats and email when gateways go offline, come back online, flap or
+// lose clock sync.
+package notifier
+
+import (
+	"context"
+	"errors"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+var log = logging.Logger("notifier")
+
+// Notifier derives state transitions from the snapshots of a Poller and notifies the recipients of the gateway.
+type Notifier struct {
+	targetConfig config.TargetConfig
+	config       config.Notifications
+	templates    templates
+	sinks        map[string]sink
+	desc         *prometheus.Desc
+
+	mu    sync.Mutex
+	state map[string]*gatewayState
+	sent  map[delivery]uint64
+}
+
+// delivery labels the notifications_total counter.
+type delivery struct {
+	sink   string
+	event  string
+	result string
+}
+
+func New(targetConfig config.TargetConfig) (*Notifier, error) {
+	templates, err := newTemplates(targetConfig.Notifications.Templates)
+	if err != nil {
+		return nil, err
+	}
+	sinks := map[string]sink{}
+	for _, sinkConfig := range targetConfig.Notifications.Sinks {
+		sinks[sinkConfig.Name], err = newSink(sinkConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Notifier{
+		targetConfig: targetConfig,
+		config:       targetConfig.Notifications,
+		templates:    templates,
+		sinks:        sinks,
+		desc: prometheus.NewDesc(prometheus.BuildFQName("ttn", "gateway", "notifications_total"),
+			"Number of notifications by sink, event and result (success or error)", []string{"sink", "event", "result"}, nil),
+		state: map[string]*gatewayState{},
+		sent:  map[delivery]uint64{},
+	}, nil
+}
+
+func (n *Notifier) Describe(descs chan<- *prometheus.Desc) {
+	descs <- n.desc
+}
+
+func (n *Notifier) Collect(metrics chan<- prometheus.Metric) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for delivery, count := range n.sent {
+		metrics <- prometheus.MustNewConstMetric(n.desc, prometheus.CounterValue, float64(count), delivery.sink, delivery.event, delivery.result)
+	}
+}
+
+// Observe updates the state of every gateway in the snapshot and sends the resulting notifications. It is meant to be
+// subscribed to an exporter.Poller. Statuses that could not be read for other reasons than a disconnected gateway are
+// ignored.
+func (n *Notifier) Observe(snapshot exporter.Snapshot) {
+	for _, targetStatus := range snapshot.Statuses {
+		target, status, err := targetStatus.Target, targetStatus.Status, targetStatus.Err
+		if err != nil && !errors.Is(err, exporter.ErrNotConnected) {
+			continue
+		}
+		for _, notification := range n.transitions(target, status, err == nil && status.Connected, snapshot.At) {
+			n.notify(target.Config(), notification)
+		}
+	}
+}
+
+// transitions updates the state of the gateway and returns the notifications due.
+func (n *Notifier) transitions(target *exporter.Target, status exporter.GatewayStatus, online bool, now time.Time) []Notification {
+	targetConfig := target.Config()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	state, ok := n.state[targetConfig.FullGatewayID()]
+	if !ok {
+		state = &gatewayState{}
+		n.state[targetConfig.FullGatewayID()] = state
+	}
+	base := Notification{
+		GatewayID:  targetConfig.GatewayID,
+		Tenant:     targetConfig.Tenant,
+		Cluster:    status.Cluster,
+		Backend:    target.BackendName(),
+		LastSeenAt: status.LastSeenAt,
+		Time:       now,
+	}
+	var notifications []Notification
+	add := func(event string, since time.Time, repeat bool) *Notification {
+		notification := base
+		notification.Event, notification.Since, notification.Repeat = event, since, repeat
+		notifications = append(notifications, notification)
+		return &notifications[len(notifications)-1]
+	}
+
+	changes := state.countChanges(!online, now, n.config.FlappingWindow)
+	if changes >= n.config.FlappingTransitions && !state.flapping {
+		state.flapping = true
+		notification := add("flapping", now, false)
+		notification.Transitions, notification.Window = changes, n.config.FlappingWindow
+	} else if changes < n.config.FlappingTransitions {
+		state.flapping = false
+	}
+
+	if state.offline.update(!online, now, n.config.HoldDown) {
+		if state.offline.confirmed {
+			add("offline", state.offline.observedSince, false)
+			state.offline.notifiedAt = now
+		} else {
+			add("online", state.offline.observedSince, false)
+		}
+	} else if state.offline.repeatDue(now, n.config.RepeatInterval) {
+		add("offline", state.offline.observedSince, true)
+		state.offline.notifiedAt = now
+	}
+
+	// the clock can only be judged from the status of a connected gateway
+	offset, ok := status.ClockOffset()
+	if !online || !ok {
+		return notifications
+	}
+	maxOffset := n.targetConfig.Health.MaxClockOffset
+	if state.unsynced.update(offset > maxOffset || -offset > maxOffset, now, n.config.HoldDown) {
+		if state.unsynced.confirmed {
+			add("clock_unsynced", state.unsynced.observedSince, false).ClockOffset = offset
+			state.unsynced.notifiedAt = now
+		} else {
+			add("clock_synced", state.unsynced.observedSince, false).ClockOffset = offset
+		}
+	} else if state.unsynced.repeatDue(now, n.config.RepeatInterval) {
+		add("clock_unsynced", state.unsynced.observedSince, true).ClockOffset = offset
+		state.unsynced.notifiedAt = now
+	}
+	return notifications
+}
+
+// notify renders the notification and delivers it to every recipient of the target in the background.
+func (n *Notifier) notify(target config.Target, notification Notification) {
+	title, body, err := n.templates.render(notification)
+	if err != nil {
+		log.Errorw("notification template error", "target", target.FullGatewayID(), "event", notification.Event, "error", err)
+		return
+	}
+	log.Infow("gateway state changed", "target", target.FullGatewayID(), "event", notification.Event, "repeat", notification.Repeat)
+	for _, recipient := range n.targetConfig.NotificationRecipients(target) {
+		go n.deliver(target, recipient, message{Title: title, Body: body, Notification: notification})
+	}
+}
+
+func (n *Notifier) deliver(target config.Target, recipient config.Recipient, message message) {
+	err := n.Send(context.Background(), recipient, message.Title, message.Body, message.Notification)
+	result := "success"
+	if err != nil {
+		result = "error"
+		log.Errorw("notification delivery error", "target", target.FullGatewayID(), "sink", recipient.Sink, "event", message.Notification.Event, "error", err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent[delivery{sink: recipient.Sink, event: message.Notification.Event, result: result}]++
+}
+
+// Send delivers a message to a recipient, falling back to the default recipient of the sink.
+func (n *Notifier) Send(ctx context.Context, recipient config.Recipient, title, body string, notification Notification) error {
+	sink, ok := n.sinks[recipient.Sink]
+	if !ok {
+		return errors.New("unknown sink " + recipient.Sink)
+	}
+	to := recipient.To
+	if to == "" {
+		for _, sinkConfig := range n.config.Sinks {
+			if sinkConfig.Name == recipient.Sink {
+				to = sinkConfig.To
+			}
+		}
+	}
+	return sink.send(ctx, message{Title: title, Body: body, Notification: notification}, to)
+}
+
+// Render renders the title and message of a notification with the configured templates.
+func (n *Notifier) Render(notification Notification) (title, body string, err error) {
+	return n.templates.render(notification)
+}
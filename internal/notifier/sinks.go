@@ -0,0 +1,170 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// message is a rendered notification.
+type message struct {
+	Title        string
+	Body         string
+	Notification Notification
+}
+
+// sink delivers messages to a recipient, whose meaning depends on the sink.
+type sink interface {
+	send(ctx context.Context, message message, to string) error
+}
+
+func newSink(sinkConfig config.NotificationSink) (sink, error) {
+	client := &http.Client{Timeout: sinkConfig.Timeout}
+	switch sinkConfig.Type {
+	case config.SinkWebhook:
+		return &webhookSink{config: sinkConfig, client: client}, nil
+	case config.SinkMatrix:
+		return &matrixSink{config: sinkConfig, client: client}, nil
+	case config.SinkTelegram:
+		return &telegramSink{config: sinkConfig, client: client}, nil
+	case config.SinkNtfy:
+		return &ntfySink{config: sinkConfig, client: client}, nil
+	case config.SinkSMTP:
+		return &smtpSink{config: sinkConfig}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sinkConfig.Type)
+	}
+}
+
+// webhookSink posts the notification as JSON.
+type webhookSink struct {
+	config config.NotificationSink
+	client *http.Client
+}
+
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	GatewayID string    `json:"gateway_id"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Cluster   string    `json:"cluster,omitempty"`
+	Since     time.Time `json:"since"`
+	Repeat    bool      `json:"repeat"`
+	Title     string    `json:"title"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+func (s *webhookSink) send(ctx context.Context, message message, _ string) error {
+	notification := message.Notification
+	body, err := json.Marshal(webhookPayload{
+		Event:     notification.Event,
+		GatewayID: notification.GatewayID,
+		Tenant:    notification.Tenant,
+		Cluster:   notification.Cluster,
+		Since:     notification.Since,
+		Repeat:    notification.Repeat,
+		Title:     message.Title,
+		Message:   message.Body,
+		Time:      notification.Time,
+	})
+	if err != nil {
+		return err
+	}
+	return post(ctx, s.client, http.MethodPost, s.config.URL, "application/json", body, s.config.Headers)
+}
+
+// matrixSink sends a text message to a room through the client-server API.
+type matrixSink struct {
+	config config.NotificationSink
+	client *http.Client
+}
+
+// matrixTransactions makes the transaction IDs of a process unique, the homeserver uses them to deduplicate retries
+var matrixTransactions uint64
+
+func (s *matrixSink) send(ctx context.Context, message message, room string) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": message.Title + "\n" + message.Body})
+	if err != nil {
+		return err
+	}
+	txnID := fmt.Sprintf("ttn-gateway-exporter-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&matrixTransactions, 1))
+	reqUrl := strings.TrimSuffix(s.config.URL, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(room) + "/send/m.room.message/" + txnID
+	return post(ctx, s.client, http.MethodPut, reqUrl, "application/json", body, map[string]string{"Authorization": "Bearer " + s.config.Token})
+}
+
+// telegramSink sends a message to a chat through the Bot API.
+type telegramSink struct {
+	config config.NotificationSink
+	client *http.Client
+}
+
+func (s *telegramSink) send(ctx context.Context, message message, chatID string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": chatID, "text": message.Title + "\n" + message.Body})
+	if err != nil {
+		return err
+	}
+	reqUrl := strings.TrimSuffix(s.config.URL, "/") + "/bot" + s.config.Token + "/sendMessage"
+	return post(ctx, s.client, http.MethodPost, reqUrl, "application/json", body, nil)
+}
+
+// ntfySink publishes the message to a topic.
+type ntfySink struct {
+	config config.NotificationSink
+	client *http.Client
+}
+
+func (s *ntfySink) send(ctx context.Context, message message, topic string) error {
+	headers := map[string]string{"Title": message.Title, "Tags": ntfyTags[message.Notification.Event]}
+	if message.Notification.Event == "offline" {
+		headers["Priority"] = "high"
+	}
+	if s.config.Token != "" {
+		headers["Authorization"] = "Bearer " + s.config.Token
+	}
+	reqUrl := strings.TrimSuffix(s.config.URL, "/") + "/" + url.PathEscape(topic)
+	return post(ctx, s.client, http.MethodPost, reqUrl, "text/plain; charset=utf-8", []byte(message.Body), headers)
+}
+
+var ntfyTags = map[string]string{
+	"offline":        "red_circle",
+	"online":         "green_circle",
+	"flapping":       "warning",
+	"clock_unsynced": "clock",
+	"clock_synced":   "clock",
+}
+
+func post(ctx context.Context, client *http.Client, method, reqUrl, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		// the URL of some sinks contains a token, it must not end up in the logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("%s: %w", method, urlErr.Err)
+		}
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		response, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s: %s: %s", method, res.Status, strings.TrimSpace(string(response)))
+	}
+	_, _ = io.Copy(io.Discard, res.Body)
+	return nil
+}
@@ -0,0 +1,302 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedRequest is a request received by a stand-in server.
+type recordedRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// standIn is a local stand-in for the HTTP APIs of the sinks.
+type standIn struct {
+	*httptest.Server
+	status int
+
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+func newStandIn(t *testing.T, status int) *standIn {
+	s := &standIn{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, recordedRequest{method: r.Method, path: r.URL.EscapedPath(), header: r.Header.Clone(), body: body})
+		s.mu.Unlock()
+		w.WriteHeader(s.status)
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standIn) received() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]recordedRequest(nil), s.requests...)
+}
+
+func (s *standIn) last(t *testing.T) recordedRequest {
+	t.Helper()
+	requests := s.received()
+	if len(requests) == 0 {
+		t.Fatal("no request received")
+	}
+	return requests[len(requests)-1]
+}
+
+var testMessage = message{
+	Title: "Gateway my-gateway: offline",
+	Body:  "Gateway my-gateway is offline since 2026-10-19 08:00 UTC.",
+	Notification: Notification{
+		Event:     "offline",
+		GatewayID: "my-gateway",
+		Tenant:    "acme",
+		Cluster:   "eu1",
+		Since:     time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+		Time:      time.Date(2026, 10, 19, 8, 5, 0, 0, time.UTC),
+	},
+}
+
+func newTestSink(t *testing.T, sinkConfig config.NotificationSink) sink {
+	t.Helper()
+	sinkConfig.Timeout = 5 * time.Second
+	s, err := newSink(sinkConfig)
+	if err != nil {
+		t.Fatalf("newSink: %v", err)
+	}
+	return s
+}
+
+func TestWebhookSink(t *testing.T) {
+	server := newStandIn(t, http.StatusNoContent)
+	s := newTestSink(t, config.NotificationSink{Type: config.SinkWebhook, URL: server.URL + "/hook", Headers: map[string]string{"X-Secret": "s3cr3t"}})
+	if err := s.send(context.Background(), testMessage, ""); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	request := server.last(t)
+	if request.method != http.MethodPost || request.path != "/hook" {
+		t.Errorf("request = %s %s, want POST /hook", request.method, request.path)
+	}
+	if got := request.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := request.header.Get("X-Secret"); got != "s3cr3t" {
+		t.Errorf("X-Secret = %q, want the configured header", got)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(request.body, &payload); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	want := map[string]interface{}{
+		"event":      "offline",
+		"gateway_id": "my-gateway",
+		"tenant":     "acme",
+		"cluster":    "eu1",
+		"since":      "2026-10-19T08:00:00Z",
+		"repeat":     false,
+		"title":      testMessage.Title,
+		"message":    testMessage.Body,
+		"time":       "2026-10-19T08:05:00Z",
+	}
+	if len(payload) != len(want) {
+		t.Errorf("payload = %v, want %v", payload, want)
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("payload[%s] = %v, want %v", key, payload[key], value)
+		}
+	}
+}
+
+func TestMatrixSink(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	s := newTestSink(t, config.NotificationSink{Type: config.SinkMatrix, URL: server.URL + "/", Token: "syt_token"})
+	for i := 0; i < 2; i++ {
+		if err := s.send(context.Background(), testMessage, "!room:example.org"); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+
+	requests := server.received()
+	prefix := "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/"
+	for _, request := range requests {
+		if request.method != http.MethodPut || !strings.HasPrefix(request.path, prefix) || len(request.path) == len(prefix) {
+			t.Errorf("request = %s %s, want PUT %s<txnID>", request.method, request.path, prefix)
+		}
+		if got := request.header.Get("Authorization"); got != "Bearer syt_token" {
+			t.Errorf("Authorization = %q, want the bearer token", got)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(request.body, &body); err != nil {
+			t.Fatalf("body: %v", err)
+		}
+		if body["msgtype"] != "m.text" || body["body"] != testMessage.Title+"\n"+testMessage.Body {
+			t.Errorf("body = %v, want an m.text with title and message", body)
+		}
+	}
+	// the homeserver drops messages with a transaction ID it has seen before
+	if len(requests) == 2 && requests[0].path == requests[1].path {
+		t.Errorf("transaction ID %s reused", requests[0].path)
+	}
+}
+
+func TestTelegramSink(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	s := newTestSink(t, config.NotificationSink{Type: config.SinkTelegram, URL: server.URL, Token: "123456:ABC-DEF"})
+	if err := s.send(context.Background(), testMessage, "-100123"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	request := server.last(t)
+	if request.method != http.MethodPost || request.path != "/bot123456:ABC-DEF/sendMessage" {
+		t.Errorf("request = %s %s, want POST /bot123456:ABC-DEF/sendMessage", request.method, request.path)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(request.body, &body); err != nil {
+		t.Fatalf("body: %v", err)
+	}
+	if body["chat_id"] != "-100123" || body["text"] != testMessage.Title+"\n"+testMessage.Body || len(body) != 2 {
+		t.Errorf("body = %v, want chat_id and text", body)
+	}
+}
+
+func TestNtfySink(t *testing.T) {
+	tests := []struct {
+		event        string
+		token        string
+		wantTags     string
+		wantPriority string
+	}{
+		{event: "offline", token: "tk_secret", wantTags: "red_circle", wantPriority: "high"},
+		{event: "online", wantTags: "green_circle"},
+		{event: "flapping", wantTags: "warning"},
+		{event: "clock_unsynced", wantTags: "clock"},
+	}
+	for _, test := range tests {
+		t.Run(test.event, func(t *testing.T) {
+			server := newStandIn(t, http.StatusOK)
+			s := newTestSink(t, config.NotificationSink{Type: config.SinkNtfy, URL: server.URL, Token: test.token})
+			msg := testMessage
+			msg.Notification.Event = test.event
+			if err := s.send(context.Background(), msg, "gateways"); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			request := server.last(t)
+			if request.method != http.MethodPost || request.path != "/gateways" {
+				t.Errorf("request = %s %s, want POST /gateways", request.method, request.path)
+			}
+			if got := request.header.Get("Title"); got != msg.Title {
+				t.Errorf("Title = %q, want %q", got, msg.Title)
+			}
+			if got := request.header.Get("Tags"); got != test.wantTags {
+				t.Errorf("Tags = %q, want %q", got, test.wantTags)
+			}
+			if got := request.header.Get("Priority"); got != test.wantPriority {
+				t.Errorf("Priority = %q, want %q", got, test.wantPriority)
+			}
+			wantAuthorization := ""
+			if test.token != "" {
+				wantAuthorization = "Bearer " + test.token
+			}
+			if got := request.header.Get("Authorization"); got != wantAuthorization {
+				t.Errorf("Authorization = %q, want %q", got, wantAuthorization)
+			}
+			if string(request.body) != msg.Body {
+				t.Errorf("body = %q, want %q", request.body, msg.Body)
+			}
+		})
+	}
+}
+
+func TestSinkErrors(t *testing.T) {
+	server := newStandIn(t, http.StatusForbidden)
+	s := newTestSink(t, config.NotificationSink{Type: config.SinkWebhook, URL: server.URL})
+	if err := s.send(context.Background(), testMessage, ""); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("send = %v, want the status in the error", err)
+	}
+
+	// the Telegram URL contains the bot token
+	server.Close()
+	s = newTestSink(t, config.NotificationSink{Type: config.SinkTelegram, URL: server.URL, Token: "123456:ABC-DEF"})
+	if err := s.send(context.Background(), testMessage, "-100123"); err == nil || strings.Contains(err.Error(), "ABC-DEF") {
+		t.Errorf("send = %v, want an error without the token", err)
+	}
+}
+
+func TestObserveSendsOnePerRecipient(t *testing.T) {
+	server := newStandIn(t, http.StatusOK)
+	targetConfig := config.TargetConfig{
+		Notifications: config.Notifications{
+			Enabled:             true,
+			HoldDown:            5 * time.Minute,
+			FlappingTransitions: 4,
+			FlappingWindow:      time.Hour,
+			Sinks: []config.NotificationSink{
+				{Name: "hook", Type: config.SinkWebhook, URL: server.URL + "/hook", Timeout: 5 * time.Second},
+				{Name: "ntfy", Type: config.SinkNtfy, URL: server.URL, To: "ops", Timeout: 5 * time.Second},
+			},
+			Recipients: []config.Recipient{{Sink: "hook"}, {Sink: "ntfy"}, {Sink: "ntfy", To: "oncall"}},
+		},
+	}
+	n, err := New(targetConfig)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	target, err := exporter.NewTarget(targetConfig, config.Target{GatewayID: "my-gateway", APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN})
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	observe := func(offset time.Duration, connected bool) {
+		status := exporter.TargetStatus{Target: target, Status: exporter.GatewayStatus{Cluster: "eu1", Connected: connected}}
+		n.Observe(exporter.Snapshot{At: start.Add(offset), Statuses: []exporter.TargetStatus{status}})
+	}
+	observe(0, true)
+	observe(time.Minute, false)
+	observe(3*time.Minute, false)
+	if requests := server.received(); len(requests) != 0 {
+		t.Fatalf("%d notifications sent within the hold-down time", len(requests))
+	}
+	observe(6*time.Minute, false)
+	observe(7*time.Minute, false)
+	observe(8*time.Minute, false)
+
+	// deliveries run in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	paths := map[string]int{}
+	for _, request := range server.received() {
+		paths[request.path]++
+	}
+	want := map[string]int{"/hook": 1, "/ops": 1, "/oncall": 1}
+	if len(paths) != len(want) {
+		t.Errorf("notifications by path = %v, want %v", paths, want)
+	}
+	for path, count := range want {
+		if paths[path] != count {
+			t.Errorf("notifications to %s = %d, want %d", path, paths[path], count)
+		}
+	}
+}
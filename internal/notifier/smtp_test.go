@@ -0,0 +1,177 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpServer is a minimal SMTP server that accepts every mail without TLS and records the session.
+type smtpServer struct {
+	listener net.Listener
+	t        *testing.T
+
+	mu       sync.Mutex
+	commands []string
+	data     string
+	done     chan struct{}
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpServer{listener: listener, t: t, done: make(chan struct{})}
+	t.Cleanup(func() { _ = listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		_, _ = conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if !strings.HasSuffix(line, "\r\n") {
+			s.t.Errorf("command %q not terminated by CRLF", line)
+		}
+		command := strings.TrimSuffix(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		switch verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0]); verb {
+		case "EHLO":
+			reply("250-localhost", "250-8BITMIME", "250 AUTH PLAIN")
+		case "AUTH":
+			reply("235 2.7.0 Authentication successful")
+		case "MAIL", "RCPT":
+			reply("250 2.1.0 Ok")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.data = data.String()
+			s.mu.Unlock()
+			reply("250 2.0.0 Ok: queued")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func TestSMTPSink(t *testing.T) {
+	server := newSMTPServer(t)
+	s := newTestSink(t, config.NotificationSink{
+		Type:     config.SinkSMTP,
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "exporter",
+		Password: "secret",
+		From:     "exporter@example.org",
+	})
+	msg := testMessage
+	msg.Title = "Gateway my-gateway: offline ⚠"
+	msg.Body = "Gateway my-gateway is offline.\nIt was last seen yesterday."
+	if err := s.send(context.Background(), msg, "ops@example.org"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	<-server.done
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	var mail, rcpt, auth string
+	for _, command := range server.commands {
+		switch {
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			mail = command
+		case strings.HasPrefix(command, "RCPT TO:"):
+			rcpt = command
+		case strings.HasPrefix(command, "AUTH PLAIN "):
+			auth = command
+		}
+	}
+	if !strings.HasPrefix(mail, "MAIL FROM:<exporter@example.org>") {
+		t.Errorf("MAIL = %q, want the configured sender", mail)
+	}
+	if rcpt != "RCPT TO:<ops@example.org>" {
+		t.Errorf("RCPT = %q, want the recipient", rcpt)
+	}
+	credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "AUTH PLAIN "))
+	if string(credentials) != "\x00exporter\x00secret" {
+		t.Errorf("AUTH credentials = %q, want the configured username and password", credentials)
+	}
+
+	separator := strings.Index(server.data, "\r\n\r\n")
+	if separator < 0 {
+		t.Fatalf("mail %q has no header/body separator", server.data)
+	}
+	headers, body := server.data[:separator], server.data[separator+4:]
+	for _, want := range []string{
+		"From: exporter@example.org",
+		"To: ops@example.org",
+		"Subject: =?utf-8?q?Gateway_my-gateway:_offline_=E2=9A=A0?=",
+		"Date: Mon, 19 Oct 2026 08:05:00 +0000",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	} {
+		if !strings.Contains("\r\n"+headers+"\r\n", "\r\n"+want+"\r\n") {
+			t.Errorf("headers %q lack %q", headers, want)
+		}
+	}
+	if want := "Gateway my-gateway is offline.\r\nIt was last seen yesterday.\r\n"; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestSMTPEmailLineEndings(t *testing.T) {
+	s := &smtpSink{config: config.NotificationSink{From: "exporter@example.org"}}
+	msg := testMessage
+	msg.Body = "first\nsecond\nthird"
+	email := string(s.email(msg, "ops@example.org"))
+	if strings.Contains(strings.ReplaceAll(email, "\r\n", ""), "\n") {
+		t.Errorf("email %q contains bare LF", email)
+	}
+	if !strings.HasSuffix(email, "\r\n\r\nfirst\r\nsecond\r\nthird\r\n") {
+		t.Errorf("email %q does not end with the CRLF body", email)
+	}
+}
@@ -0,0 +1,54 @@
+package notifier
+
+import (
+	"time"
+)
+
+// condition is a bad state of a gateway, like being offline, that is only confirmed once it lasted for the hold-down
+// time. Conditions start in the good state, so gateways that are offline when the exporter starts are notified, but
+// gateways that are online are not.
+type condition struct {
+	observed      bool
+	observedSince time.Time
+	confirmed     bool
+	// notifiedAt is when the confirmed bad state was last notified, for repeats
+	notifiedAt time.Time
+}
+
+// update records an observation and reports whether the confirmed state changed.
+func (c *condition) update(value bool, now time.Time, holdDown time.Duration) bool {
+	if value != c.observed || c.observedSince.IsZero() {
+		c.observed, c.observedSince = value, now
+	}
+	if c.observed == c.confirmed || now.Sub(c.observedSince) < holdDown {
+		return false
+	}
+	c.confirmed = c.observed
+	return true
+}
+
+// repeatDue reports whether the confirmed bad state was last notified at least repeatInterval ago.
+func (c *condition) repeatDue(now time.Time, repeatInterval time.Duration) bool {
+	return c.confirmed && repeatInterval > 0 && !c.notifiedAt.IsZero() && now.Sub(c.notifiedAt) >= repeatInterval
+}
+
+// gatewayState is what the notifier knows about a gateway.
+type gatewayState struct {
+	offline  condition
+	unsynced condition
+
+	// changes are the times the gateway was observed going online or offline, within the flapping window
+	changes  []time.Time
+	flapping bool
+}
+
+// countChanges records whether the observed online state changed and returns the number of changes within the window.
+func (s *gatewayState) countChanges(offline bool, now time.Time, window time.Duration) int {
+	if !s.offline.observedSince.IsZero() && offline != s.offline.observed {
+		s.changes = append(s.changes, now)
+	}
+	for len(s.changes) > 0 && now.Sub(s.changes[0]) > window {
+		s.changes = s.changes[1:]
+	}
+	return len(s.changes)
+}
@@ -0,0 +1,128 @@
+package notifier
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConditionHoldDown(t *testing.T) {
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	holdDown := 5 * time.Minute
+	steps := []struct {
+		offset  time.Duration
+		value   bool
+		changed bool
+	}{
+		{0, false, false},
+		// a short outage within the hold-down time is not confirmed
+		{time.Minute, true, false},
+		{3 * time.Minute, true, false},
+		{4 * time.Minute, false, false},
+		// a longer one is confirmed once it lasted for the hold-down time
+		{10 * time.Minute, true, false},
+		{14 * time.Minute, true, false},
+		{15 * time.Minute, true, true},
+		{16 * time.Minute, true, false},
+		// and the recovery has to last as well
+		{20 * time.Minute, false, false},
+		{25 * time.Minute, false, true},
+		{30 * time.Minute, false, false},
+	}
+	var c condition
+	for _, step := range steps {
+		if changed := c.update(step.value, start.Add(step.offset), holdDown); changed != step.changed {
+			t.Errorf("update(%v) at +%s = %v, want %v", step.value, step.offset, changed, step.changed)
+		}
+	}
+	if c.confirmed {
+		t.Error("confirmed = true after recovery, want false")
+	}
+}
+
+func TestConditionStartsGood(t *testing.T) {
+	now := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+
+	var offline condition
+	if !offline.update(true, now, 0) {
+		t.Error("bad state at start without hold-down not notified")
+	}
+	if offline.observedSince != now {
+		t.Errorf("observedSince = %s, want %s", offline.observedSince, now)
+	}
+
+	var online condition
+	if online.update(false, now, 0) {
+		t.Error("good state at start notified")
+	}
+}
+
+func TestConditionRepeat(t *testing.T) {
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	var c condition
+	if c.repeatDue(start, time.Hour) {
+		t.Error("repeat due before the bad state was confirmed")
+	}
+	c.update(true, start, 0)
+	if c.repeatDue(start.Add(2*time.Hour), time.Hour) {
+		t.Error("repeat due before the bad state was notified")
+	}
+	c.notifiedAt = start
+
+	tests := []struct {
+		after          time.Duration
+		repeatInterval time.Duration
+		want           bool
+	}{
+		{59 * time.Minute, time.Hour, false},
+		{time.Hour, time.Hour, true},
+		{3 * time.Hour, time.Hour, true},
+		// repeats are disabled with a zero interval
+		{3 * time.Hour, 0, false},
+	}
+	for _, test := range tests {
+		if got := c.repeatDue(start.Add(test.after), test.repeatInterval); got != test.want {
+			t.Errorf("repeatDue after %s with interval %s = %v, want %v", test.after, test.repeatInterval, got, test.want)
+		}
+	}
+
+	c.update(false, start.Add(4*time.Hour), 0)
+	if c.repeatDue(start.Add(5*time.Hour), time.Hour) {
+		t.Error("repeat due after recovery")
+	}
+}
+
+func TestCountChanges(t *testing.T) {
+	start := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	window := 10 * time.Minute
+	var s gatewayState
+	observe := func(offline bool, offset time.Duration) int {
+		now := start.Add(offset)
+		changes := s.countChanges(offline, now, window)
+		s.offline.update(offline, now, 0)
+		return changes
+	}
+
+	steps := []struct {
+		offset  time.Duration
+		offline bool
+		want    int
+	}{
+		// the first observation is no change, even if the gateway is offline
+		{0, true, 0},
+		{time.Minute, true, 0},
+		{2 * time.Minute, false, 1},
+		{3 * time.Minute, true, 2},
+		{4 * time.Minute, false, 3},
+		{5 * time.Minute, false, 3},
+		// changes older than the window are forgotten
+		{12*time.Minute + 30*time.Second, false, 2},
+		{13*time.Minute + 30*time.Second, false, 1},
+		{14*time.Minute + 30*time.Second, true, 1},
+		{30 * time.Minute, true, 0},
+	}
+	for _, step := range steps {
+		if got := observe(step.offline, step.offset); got != step.want {
+			t.Errorf("countChanges(%v) at +%s = %d, want %d", step.offline, step.offset, got, step.want)
+		}
+	}
+}
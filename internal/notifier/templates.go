@@ -0,0 +1,80 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"text/template"
+	"time"
+)
+
+// Notification is the data the message templates are executed with.
+type Notification struct {
+	// Event is offline, online, flapping, clock_unsynced or clock_synced
+	Event     string
+	GatewayID string
+	Tenant    string
+	Cluster   string
+	Backend   string
+	// Since is when the gateway was first observed in the new state
+	Since      time.Time
+	LastSeenAt time.Time
+	// ClockOffset is the gateway time minus the server time, for clock events
+	ClockOffset time.Duration
+	// Transitions is the number of online/offline changes within Window, for flapping
+	Transitions int
+	Window      time.Duration
+	// Repeat is true for repeated notifications of an ongoing state
+	Repeat bool
+	Time   time.Time
+}
+
+const timeLayout = "2006-01-02 15:04 MST"
+
+var defaultTemplates = map[string]string{
+	"title":          `Gateway {{.GatewayID}}: {{.Event}}`,
+	"offline":        `{{if .Repeat}}Still offline: g{{else}}G{{end}}ateway {{.GatewayID}} is offline since {{.Since.Format "` + timeLayout + `"}}{{if not .LastSeenAt.IsZero}}, it was last seen {{.LastSeenAt.Format "` + timeLayout + `"}}{{end}}.`,
+	"online":         `Gateway {{.GatewayID}} is back online since {{.Since.Format "` + timeLayout + `"}}.`,
+	"flapping":       `Gateway {{.GatewayID}} went online or offline {{.Transitions}} times within {{.Window}}.`,
+	"clock_unsynced": `{{if .Repeat}}Still unsynced: t{{else}}T{{end}}he clock of gateway {{.GatewayID}} is off by {{.ClockOffset}}.`,
+	"clock_synced":   `The clock of gateway {{.GatewayID}} is synchronized again.`,
+}
+
+// templates renders titles and messages from the configured templates, falling back to the defaults.
+type templates map[string]*template.Template
+
+func newTemplates(configured map[string]string) (templates, error) {
+	parsed := templates{}
+	for _, name := range append([]string{"title"}, config.NotificationEvents...) {
+		text, ok := configured[name]
+		if !ok {
+			text = defaultTemplates[name]
+		}
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		parsed[name] = t
+	}
+	return parsed, nil
+}
+
+func (t templates) render(notification Notification) (title, body string, err error) {
+	render := func(name string) (string, error) {
+		if t[name] == nil {
+			return "", fmt.Errorf("unknown event %q", name)
+		}
+		var out bytes.Buffer
+		if err := t[name].Execute(&out, notification); err != nil {
+			return "", err
+		}
+		return out.String(), nil
+	}
+	if title, err = render("title"); err != nil {
+		return "", "", err
+	}
+	if body, err = render(notification.Event); err != nil {
+		return "", "", err
+	}
+	return title, body, nil
+}
@@ -0,0 +1,213 @@
+package otlp
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Field numbers of the OTLP metrics protocol, see opentelemetry/proto/collector/metrics/v1/metrics_service.proto and
+// opentelemetry/proto/metrics/v1/metrics.proto.
+const (
+	requestResourceMetrics = 1
+
+	resourceMetricsResource     = 1
+	resourceMetricsScopeMetrics = 2
+
+	resourceAttributes = 1
+
+	scopeMetricsScope   = 1
+	scopeMetricsMetrics = 2
+
+	scopeName = 1
+
+	metricName        = 1
+	metricDescription = 2
+	metricUnit        = 3
+	metricGauge       = 5
+	metricSum         = 7
+
+	gaugeDataPoints = 1
+
+	sumDataPoints             = 1
+	sumAggregationTemporality = 2
+	sumIsMonotonic            = 3
+
+	dataPointStartTime  = 2
+	dataPointTime       = 3
+	dataPointAsDouble   = 4
+	dataPointAttributes = 7
+
+	keyValueKey   = 1
+	keyValueValue = 2
+
+	anyValueString = 1
+
+	temporalityCumulative = 2
+)
+
+// scope is the instrumentation scope of the pushed metrics.
+const scope = "github.com/opendata-heilbronn/ttn-gateway-exporter"
+
+// resourceLabels are the sample labels that become resource attributes instead of data point attributes.
+var resourceLabels = []string{"gateway", "cluster", "tenant", "backend"}
+
+// units maps the units of the metric definitions to UCUM units.
+var units = map[string]string{
+	"":            "1",
+	"seconds":     "s",
+	"nanoseconds": "ns",
+	"timestamp":   "s",
+	"meters":      "m",
+	"ratio":       "1",
+}
+
+// resource is the samples of one target.
+type resource struct {
+	attributes map[string]string
+	samples    []exporter.Sample
+	// startTime is the start of cumulative sums, the time the gateway connected or the exporter started
+	startTime time.Time
+}
+
+// encodeRequest encodes an ExportMetricsServiceRequest with one ResourceMetrics per target.
+func encodeRequest(resources []resource, serviceName string, now time.Time) []byte {
+	var request []byte
+	for _, r := range resources {
+		request = appendMessage(request, requestResourceMetrics, encodeResourceMetrics(r, serviceName, now))
+	}
+	return request
+}
+
+func encodeResourceMetrics(r resource, serviceName string, now time.Time) []byte {
+	attributes := map[string]string{"service.name": serviceName}
+	for key, value := range r.attributes {
+		if value != "" {
+			attributes[key] = value
+		}
+	}
+	var res []byte
+	res = appendAttributes(res, resourceAttributes, attributes)
+
+	var scopeMessage []byte
+	scopeMessage = protowire.AppendTag(scopeMessage, scopeName, protowire.BytesType)
+	scopeMessage = protowire.AppendString(scopeMessage, scope)
+
+	var scopeMetrics []byte
+	scopeMetrics = appendMessage(scopeMetrics, scopeMetricsScope, scopeMessage)
+	for _, metric := range groupByMetric(r.samples) {
+		scopeMetrics = appendMessage(scopeMetrics, scopeMetricsMetrics, encodeMetric(metric, r.startTime, now))
+	}
+
+	var resourceMetrics []byte
+	resourceMetrics = appendMessage(resourceMetrics, resourceMetricsResource, res)
+	resourceMetrics = appendMessage(resourceMetrics, resourceMetricsScopeMetrics, scopeMetrics)
+	return resourceMetrics
+}
+
+// metricSamples are the samples of one metric definition.
+type metricSamples struct {
+	definition exporter.MetricDefinition
+	samples    []exporter.Sample
+}
+
+func groupByMetric(samples []exporter.Sample) []metricSamples {
+	var metrics []metricSamples
+	index := map[string]int{}
+	for _, sample := range samples {
+		i, ok := index[sample.Definition.Name]
+		if !ok {
+			i = len(metrics)
+			index[sample.Definition.Name] = i
+			metrics = append(metrics, metricSamples{definition: sample.Definition})
+		}
+		metrics[i].samples = append(metrics[i].samples, sample)
+	}
+	return metrics
+}
+
+// encodeMetric maps gauges to OTel gauges and counters to monotonic cumulative sums. The _total suffix of counters is
+// dropped, as OTel instruments don't carry it.
+func encodeMetric(metric metricSamples, startTime, now time.Time) []byte {
+	definition := metric.definition
+	var m []byte
+	name := definition.Name
+	if definition.Type == prometheus.CounterValue {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	m = protowire.AppendTag(m, metricName, protowire.BytesType)
+	m = protowire.AppendString(m, name)
+	m = protowire.AppendTag(m, metricDescription, protowire.BytesType)
+	m = protowire.AppendString(m, definition.Help)
+	m = protowire.AppendTag(m, metricUnit, protowire.BytesType)
+	m = protowire.AppendString(m, units[definition.Unit])
+
+	var data []byte
+	for _, sample := range metric.samples {
+		var point []byte
+		if definition.Type == prometheus.CounterValue {
+			point = protowire.AppendTag(point, dataPointStartTime, protowire.Fixed64Type)
+			point = protowire.AppendFixed64(point, uint64(startTime.UnixNano()))
+		}
+		point = protowire.AppendTag(point, dataPointTime, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, uint64(now.UnixNano()))
+		point = protowire.AppendTag(point, dataPointAsDouble, protowire.Fixed64Type)
+		point = protowire.AppendFixed64(point, math.Float64bits(sample.Value))
+		point = appendAttributes(point, dataPointAttributes, pointAttributes(sample.Labels))
+		if definition.Type == prometheus.CounterValue {
+			data = appendMessage(data, sumDataPoints, point)
+		} else {
+			data = appendMessage(data, gaugeDataPoints, point)
+		}
+	}
+	if definition.Type == prometheus.CounterValue {
+		data = protowire.AppendTag(data, sumAggregationTemporality, protowire.VarintType)
+		data = protowire.AppendVarint(data, temporalityCumulative)
+		data = protowire.AppendTag(data, sumIsMonotonic, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+		return appendMessage(m, metricSum, data)
+	}
+	return appendMessage(m, metricGauge, data)
+}
+
+// pointAttributes returns the labels that are not resource attributes.
+func pointAttributes(labels map[string]string) map[string]string {
+	attributes := map[string]string{}
+	for key, value := range labels {
+		attributes[key] = value
+	}
+	for _, key := range resourceLabels {
+		delete(attributes, key)
+	}
+	return attributes
+}
+
+// appendAttributes appends string KeyValues sorted by key, so equal attribute sets encode equally.
+func appendAttributes(b []byte, field protowire.Number, attributes map[string]string) []byte {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var value []byte
+		value = protowire.AppendTag(value, anyValueString, protowire.BytesType)
+		value = protowire.AppendString(value, attributes[key])
+
+		var keyValue []byte
+		keyValue = protowire.AppendTag(keyValue, keyValueKey, protowire.BytesType)
+		keyValue = protowire.AppendString(keyValue, key)
+		keyValue = appendMessage(keyValue, keyValueValue, value)
+		b = appendMessage(b, field, keyValue)
+	}
+	return b
+}
+
+func appendMessage(b []byte, field protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
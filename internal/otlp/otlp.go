@@ -0,0 +1,310 @@
+// Package otlp pushes the gateway metrics to an OpenTelemetry Collector via OTLP/HTTP or OTLP/gRPC.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var log = logging.Logger("otlp")
+
+const (
+	grpcExportPath = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+	maxAttempts = 3
+)
+
+// retryDelay is the delay before the first retry, it doubles with every attempt
+var retryDelay = time.Second
+
+// retryableGRPCCodes are CANCELLED, DEADLINE_EXCEEDED, ABORTED, OUT_OF_RANGE, UNAVAILABLE and DATA_LOSS, which the
+// OTLP specification allows to retry.
+var retryableGRPCCodes = map[string]bool{"1": true, "4": true, "10": true, "11": true, "14": true, "15": true}
+
+// Exporter pushes the samples of every snapshot it observes. It is meant to be subscribed to an exporter.Poller.
+type Exporter struct {
+	config    config.OTLP
+	client    *http.Client
+	startedAt time.Time
+	descs     map[string]*prometheus.Desc
+
+	mu      sync.Mutex
+	exports map[string]uint64
+	points  uint64
+}
+
+// exportError is a failed export that may be retried after the delay.
+type exportError struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+func (e *exportError) Error() string {
+	return e.err.Error()
+}
+
+func New(otlpConfig config.OTLP) (*Exporter, error) {
+	endpoint, err := url.Parse(otlpConfig.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{}
+	if otlpConfig.Protocol == config.OTLPProtocolGRPC {
+		transport := &http2.Transport{}
+		if endpoint.Scheme == "http" {
+			// gRPC without TLS is HTTP/2 with prior knowledge
+			transport.AllowHTTP = true
+			transport.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			}
+		}
+		client.Transport = transport
+	}
+	return &Exporter{
+		config:    otlpConfig,
+		client:    client,
+		startedAt: time.Now(),
+		descs: map[string]*prometheus.Desc{
+			"exports": prometheus.NewDesc(prometheus.BuildFQName("ttn", "gateway", "otlp_exports_total"), "Number of OTLP exports by result (success or error)", []string{"result"}, nil),
+			"points":  prometheus.NewDesc(prometheus.BuildFQName("ttn", "gateway", "otlp_exported_points_total"), "Number of data points exported via OTLP", nil, nil),
+		},
+		exports: map[string]uint64{},
+	}, nil
+}
+
+func (e *Exporter) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range e.descs {
+		descs <- desc
+	}
+}
+
+func (e *Exporter) Collect(metrics chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for result, count := range e.exports {
+		metrics <- prometheus.MustNewConstMetric(e.descs["exports"], prometheus.CounterValue, float64(count), result)
+	}
+	metrics <- prometheus.MustNewConstMetric(e.descs["points"], prometheus.CounterValue, float64(e.points))
+}
+
+// Push exports the samples of all targets in the snapshot, one resource per gateway.
+func (e *Exporter) Push(snapshot exporter.Snapshot) {
+	var resources []resource
+	points := 0
+	for _, targetStatus := range snapshot.Statuses {
+		target, status := targetStatus.Target, targetStatus.Status
+		targetConfig := target.Config()
+		startTime := e.startedAt
+		if !status.ConnectedAt.IsZero() {
+			// the counters of the network server start when the gateway connects
+			startTime = status.ConnectedAt
+		}
+		samples := target.Samples(status, targetStatus.Err)
+		points += len(samples)
+		resources = append(resources, resource{
+			attributes: map[string]string{
+				"gateway": targetConfig.GatewayID,
+				"cluster": status.Cluster,
+				"tenant":  targetConfig.Tenant,
+				"backend": target.BackendName(),
+			},
+			samples:   samples,
+			startTime: startTime,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.Timeout)
+	defer cancel()
+	err := e.export(ctx, encodeRequest(resources, e.config.ServiceName, snapshot.At))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.exports["error"]++
+		log.Errorw("otlp export error", "endpoint", e.config.Endpoint, "error", err)
+		return
+	}
+	e.exports["success"]++
+	e.points += uint64(points)
+}
+
+// export sends the request, retrying retryable errors as long as the context allows.
+func (e *Exporter) export(ctx context.Context, request []byte) error {
+	var err error
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		if e.config.Protocol == config.OTLPProtocolGRPC {
+			err = e.sendGRPC(ctx, request)
+		} else {
+			err = e.sendHTTP(ctx, request)
+		}
+		var exportErr *exportError
+		if err == nil || !errors.As(err, &exportErr) || !exportErr.retryable || attempt == maxAttempts {
+			return err
+		}
+		if exportErr.retryAfter > delay {
+			delay = exportErr.retryAfter
+		}
+		log.Debugw("otlp export failed, retrying", "error", err, "retryIn", delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (e *Exporter) sendHTTP(ctx context.Context, request []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.config.Endpoint, bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range e.config.Headers {
+		req.Header.Set(name, value)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return &exportError{err: err, retryable: true}
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return &exportError{err: err, retryable: true}
+	}
+	switch {
+	case res.StatusCode == http.StatusOK:
+		logPartialSuccess(body)
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusBadGateway ||
+		res.StatusCode == http.StatusServiceUnavailable || res.StatusCode == http.StatusGatewayTimeout:
+		retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return &exportError{err: fmt.Errorf("%s", res.Status), retryable: true, retryAfter: time.Duration(retryAfter) * time.Second}
+	default:
+		return &exportError{err: fmt.Errorf("%s", res.Status)}
+	}
+}
+
+func (e *Exporter) sendGRPC(ctx context.Context, request []byte) error {
+	// a gRPC message is prefixed with a compression flag and its length
+	frame := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(request)))
+	frame = append(frame, request...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.config.Endpoint, "/")+grpcExportPath, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	for name, value := range e.config.Headers {
+		req.Header.Set(strings.ToLower(name), value)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return &exportError{err: err, retryable: true}
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return &exportError{err: err, retryable: true}
+	}
+	if res.StatusCode != http.StatusOK {
+		return &exportError{err: fmt.Errorf("%s", res.Status), retryable: res.StatusCode == http.StatusServiceUnavailable}
+	}
+
+	// the status is sent in the trailers, or in the headers of responses without message
+	status, message := res.Trailer.Get("grpc-status"), res.Trailer.Get("grpc-message")
+	if status == "" {
+		status, message = res.Header.Get("grpc-status"), res.Header.Get("grpc-message")
+	}
+	if status != "0" {
+		if decoded, err := url.PathUnescape(message); err == nil {
+			message = decoded
+		}
+		return &exportError{err: fmt.Errorf("grpc status %s: %s", status, message), retryable: retryableGRPCCodes[status]}
+	}
+	if len(body) >= 5 {
+		logPartialSuccess(body[5:])
+	}
+	return nil
+}
+
+// logPartialSuccess logs the data points the receiver rejected.
+func logPartialSuccess(response []byte) {
+	if rejected, message := partialSuccess(response); rejected > 0 || message != "" {
+		log.Warnw("otlp export partially rejected", "rejectedDataPoints", rejected, "message", message)
+	}
+}
+
+// partialSuccess returns the number of rejected data points and the error message of the partial_success field of an
+// ExportMetricsServiceResponse.
+func partialSuccess(response []byte) (rejected uint64, message string) {
+	field := consumeField(response, 1)
+	for len(field) > 0 {
+		number, wireType, n := protowire.ConsumeTag(field)
+		if n < 0 {
+			return rejected, message
+		}
+		field = field[n:]
+		switch {
+		case number == 1 && wireType == protowire.VarintType:
+			rejected, n = protowire.ConsumeVarint(field)
+		case number == 2 && wireType == protowire.BytesType:
+			var value []byte
+			value, n = protowire.ConsumeBytes(field)
+			message = string(value)
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, field)
+		}
+		if n < 0 {
+			return rejected, message
+		}
+		field = field[n:]
+	}
+	return rejected, message
+}
+
+// consumeField returns the value of a length-delimited field of a message, or nil.
+func consumeField(message []byte, field protowire.Number) []byte {
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return nil
+		}
+		message = message[n:]
+		if number == field && wireType == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(message)
+			if n < 0 {
+				return nil
+			}
+			return value
+		}
+		n = protowire.ConsumeFieldValue(number, wireType, message)
+		if n < 0 {
+			return nil
+		}
+		message = message[n:]
+	}
+	return nil
+}
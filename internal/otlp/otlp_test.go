@@ -0,0 +1,534 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	retryDelay = time.Millisecond
+}
+
+// receiver is a local OTLP receiver that answers the requests with the configured responses, the last one repeatedly.
+type receiver struct {
+	*httptest.Server
+	t         *testing.T
+	responses []func(w http.ResponseWriter)
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) handle(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Errorf("reading request: %v", err)
+	}
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	n := len(r.requests)
+	r.mu.Unlock()
+	if n > len(r.responses) {
+		n = len(r.responses)
+	}
+	r.responses[n-1](w)
+}
+
+func (r *receiver) attempts() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newHTTPReceiver(t *testing.T, responses ...func(w http.ResponseWriter)) *receiver {
+	r := &receiver{t: t, responses: responses}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// newGRPCReceiver starts a receiver speaking HTTP/2 without TLS, like an OpenTelemetry Collector on port 4317.
+func newGRPCReceiver(t *testing.T, responses ...func(w http.ResponseWriter)) *receiver {
+	r := &receiver{t: t, responses: responses}
+	r.Server = httptest.NewServer(h2c.NewHandler(http.HandlerFunc(r.handle), &http2.Server{}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func httpStatus(code int, header ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		w.WriteHeader(code)
+	}
+}
+
+// grpcStatus answers with a framed ExportMetricsServiceResponse and the status in the trailers.
+func grpcStatus(code string, response []byte) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "grpc-status, grpc-message")
+		w.WriteHeader(http.StatusOK)
+		frame := make([]byte, 5, 5+len(response))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(response)))
+		_, _ = w.Write(append(frame, response...))
+		w.Header().Set("grpc-status", code)
+		if code != "0" {
+			w.Header().Set("grpc-message", "receiver%20unavailable")
+		}
+	}
+}
+
+// partialSuccessResponse is an ExportMetricsServiceResponse rejecting some data points.
+func partialSuccessResponse(rejected uint64, message string) []byte {
+	var partialSuccess []byte
+	partialSuccess = protowire.AppendTag(partialSuccess, 1, protowire.VarintType)
+	partialSuccess = protowire.AppendVarint(partialSuccess, rejected)
+	partialSuccess = protowire.AppendTag(partialSuccess, 2, protowire.BytesType)
+	partialSuccess = protowire.AppendString(partialSuccess, message)
+	return appendMessage(nil, 1, partialSuccess)
+}
+
+func newTestExporter(t *testing.T, protocol, endpoint string) *Exporter {
+	t.Helper()
+	e, err := New(config.OTLP{Enabled: true, Protocol: protocol, Endpoint: endpoint, Timeout: 5 * time.Second, ServiceName: "ttn-gateway-exporter"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return e
+}
+
+func newTestTarget(t *testing.T, gatewayID, tenant string) *exporter.Target {
+	t.Helper()
+	target, err := exporter.NewTarget(
+		config.TargetConfig{LocationDrift: config.LocationDrift{Enabled: true, SignificantMove: 100, HistorySize: 10}},
+		config.Target{GatewayID: gatewayID, Tenant: tenant, APIKey: "NNSXS.TEST", BaseUrl: "http://127.0.0.1:1", Backend: config.BackendTTN},
+	)
+	if err != nil {
+		t.Fatalf("NewTarget: %v", err)
+	}
+	return target
+}
+
+// decodedResource is a ResourceMetrics of a request, decoded in the test independently of the encoder.
+type decodedResource struct {
+	attributes map[string]string
+	scope      string
+	metrics    map[string]decodedMetric
+}
+
+type decodedMetric struct {
+	description, unit string
+	sum, monotonic    bool
+	temporality       uint64
+	points            []decodedPoint
+}
+
+type decodedPoint struct {
+	startTime, time uint64
+	value           float64
+	attributes      map[string]string
+}
+
+type field struct {
+	number protowire.Number
+	value  []byte
+	scalar uint64
+}
+
+// fields decodes the fields of a message, in order.
+func fields(t *testing.T, message []byte) []field {
+	t.Helper()
+	var decoded []field
+	for len(message) > 0 {
+		number, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		message = message[n:]
+		f := field{number: number}
+		switch wireType {
+		case protowire.BytesType:
+			f.value, n = protowire.ConsumeBytes(message)
+		case protowire.VarintType:
+			f.scalar, n = protowire.ConsumeVarint(message)
+		case protowire.Fixed64Type:
+			f.scalar, n = protowire.ConsumeFixed64(message)
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", wireType, number)
+		}
+		if n < 0 {
+			t.Fatalf("invalid field %d: %v", number, protowire.ParseError(n))
+		}
+		message = message[n:]
+		decoded = append(decoded, f)
+	}
+	return decoded
+}
+
+func decodeKeyValue(t *testing.T, keyValue []byte) (key, value string) {
+	for _, f := range fields(t, keyValue) {
+		switch f.number {
+		case keyValueKey:
+			key = string(f.value)
+		case keyValueValue:
+			for _, v := range fields(t, f.value) {
+				if v.number != anyValueString {
+					t.Errorf("attribute %s is not a string", key)
+				}
+				value = string(v.value)
+			}
+		}
+	}
+	return key, value
+}
+
+func decodeRequest(t *testing.T, request []byte) []decodedResource {
+	t.Helper()
+	var resources []decodedResource
+	for _, rm := range fields(t, request) {
+		if rm.number != requestResourceMetrics {
+			t.Fatalf("unexpected request field %d", rm.number)
+		}
+		r := decodedResource{attributes: map[string]string{}, metrics: map[string]decodedMetric{}}
+		for _, f := range fields(t, rm.value) {
+			switch f.number {
+			case resourceMetricsResource:
+				for _, a := range fields(t, f.value) {
+					key, value := decodeKeyValue(t, a.value)
+					r.attributes[key] = value
+				}
+			case resourceMetricsScopeMetrics:
+				for _, sm := range fields(t, f.value) {
+					switch sm.number {
+					case scopeMetricsScope:
+						r.scope = string(fields(t, sm.value)[0].value)
+					case scopeMetricsMetrics:
+						name, metric := decodeMetric(t, sm.value)
+						r.metrics[name] = metric
+					}
+				}
+			}
+		}
+		resources = append(resources, r)
+	}
+	return resources
+}
+
+func decodeMetric(t *testing.T, message []byte) (string, decodedMetric) {
+	var name string
+	var metric decodedMetric
+	for _, f := range fields(t, message) {
+		switch f.number {
+		case metricName:
+			name = string(f.value)
+		case metricDescription:
+			metric.description = string(f.value)
+		case metricUnit:
+			metric.unit = string(f.value)
+		case metricGauge, metricSum:
+			metric.sum = f.number == metricSum
+			for _, d := range fields(t, f.value) {
+				switch d.number {
+				case sumDataPoints:
+					metric.points = append(metric.points, decodePoint(t, d.value))
+				case sumAggregationTemporality:
+					metric.temporality = d.scalar
+				case sumIsMonotonic:
+					metric.monotonic = d.scalar == 1
+				}
+			}
+		}
+	}
+	return name, metric
+}
+
+func decodePoint(t *testing.T, message []byte) decodedPoint {
+	point := decodedPoint{attributes: map[string]string{}}
+	for _, f := range fields(t, message) {
+		switch f.number {
+		case dataPointStartTime:
+			point.startTime = f.scalar
+		case dataPointTime:
+			point.time = f.scalar
+		case dataPointAsDouble:
+			point.value = math.Float64frombits(f.scalar)
+		case dataPointAttributes:
+			key, value := decodeKeyValue(t, f.value)
+			point.attributes[key] = value
+		}
+	}
+	return point
+}
+
+func testSnapshot(t *testing.T) (exporter.Snapshot, time.Time) {
+	now := time.Now().Truncate(time.Second)
+	connectedAt := now.Add(-time.Hour)
+	uplinks := uint64(42)
+	return exporter.Snapshot{
+		At: now,
+		Statuses: []exporter.TargetStatus{
+			{
+				Target: newTestTarget(t, "gw-community", ""),
+				Status: exporter.GatewayStatus{
+					Cluster:     "eu1",
+					Connected:   true,
+					ConnectedAt: connectedAt,
+					UplinkCount: &uplinks,
+					BootTime:    now.Add(-2 * time.Hour),
+					Versions:    map[string]string{"firmware": "1.0.0"},
+					RoundTripTimes: &exporter.RoundTripTimes{
+						Min: 20 * time.Millisecond, Max: 80 * time.Millisecond, Median: 40 * time.Millisecond, Count: 7,
+					},
+				},
+			},
+			{
+				Target: newTestTarget(t, "gw-tenant", "acme"),
+				Status: exporter.GatewayStatus{Cluster: "eu1.acme"},
+			},
+		},
+	}, connectedAt
+}
+
+func TestPushHTTP(t *testing.T) {
+	r := newHTTPReceiver(t, httpStatus(http.StatusOK))
+	e := newTestExporter(t, config.OTLPProtocolHTTP, r.URL+"/v1/metrics")
+	snapshot, connectedAt := testSnapshot(t)
+	e.Push(snapshot)
+
+	if r.attempts() != 1 {
+		t.Fatalf("attempts = %d, want 1", r.attempts())
+	}
+	request := r.requests[0]
+	if request.Method != http.MethodPost || request.URL.Path != "/v1/metrics" {
+		t.Errorf("request = %s %s, want POST /v1/metrics", request.Method, request.URL.Path)
+	}
+	if contentType := request.Header.Get("Content-Type"); contentType != "application/x-protobuf" {
+		t.Errorf("Content-Type = %q, want application/x-protobuf", contentType)
+	}
+
+	resources := decodeRequest(t, r.bodies[0])
+	if len(resources) != 2 {
+		t.Fatalf("len(resources) = %d, want 2", len(resources))
+	}
+	wantAttributes := []map[string]string{
+		{"service.name": "ttn-gateway-exporter", "gateway": "gw-community", "cluster": "eu1", "backend": "ttn"},
+		{"service.name": "ttn-gateway-exporter", "gateway": "gw-tenant", "cluster": "eu1.acme", "tenant": "acme", "backend": "ttn"},
+	}
+	for i, want := range wantAttributes {
+		if got := resources[i].attributes; !reflect.DeepEqual(got, want) {
+			t.Errorf("resource %d attributes = %v, want %v", i, got, want)
+		}
+		if resources[i].scope != scope {
+			t.Errorf("resource %d scope = %q, want %q", i, resources[i].scope, scope)
+		}
+	}
+
+	metrics := resources[0].metrics
+	at := uint64(snapshot.At.UnixNano())
+	connected, ok := metrics["ttn_gateway_connected"]
+	if !ok {
+		t.Fatal("ttn_gateway_connected missing")
+	}
+	if connected.sum || connected.unit != "1" || len(connected.points) != 1 {
+		t.Errorf("ttn_gateway_connected = %+v, want a gauge with unit 1 and one point", connected)
+	} else if point := connected.points[0]; point.value != 1 || point.time != at || point.startTime != 0 || len(point.attributes) != 0 {
+		t.Errorf("ttn_gateway_connected point = %+v, want 1 at %d without start time and attributes", point, at)
+	}
+
+	uplinks := metrics["ttn_gateway_uplink_count"]
+	if !uplinks.sum || !uplinks.monotonic || uplinks.temporality != temporalityCumulative || len(uplinks.points) != 1 {
+		t.Errorf("ttn_gateway_uplink_count = %+v, want a monotonic cumulative sum with one point", uplinks)
+	} else if point := uplinks.points[0]; point.value != 42 || point.startTime != uint64(connectedAt.UnixNano()) || point.time != at {
+		t.Errorf("ttn_gateway_uplink_count point = %+v, want 42 from %d to %d", point, connectedAt.UnixNano(), at)
+	}
+
+	// counters of gateways without connection time start when the exporter started
+	moves, ok := resources[1].metrics["ttn_gateway_antenna_moves"]
+	if _, suffixed := resources[1].metrics["ttn_gateway_antenna_moves_total"]; suffixed || !ok {
+		t.Errorf("ttn_gateway_antenna_moves_total not exported without _total suffix")
+	} else if !moves.sum || moves.points[0].startTime != uint64(e.startedAt.UnixNano()) {
+		t.Errorf("ttn_gateway_antenna_moves = %+v, want a sum starting at %d", moves, e.startedAt.UnixNano())
+	}
+
+	for name, unit := range map[string]string{
+		"ttn_gateway_uptime_seconds": "s",
+		"ttn_gateway_boot_time":      "s",
+		"ttn_gateway_rtt_min":        "ns",
+		"ttn_gateway_rtt_count":      "1",
+	} {
+		if got := metrics[name].unit; got != unit {
+			t.Errorf("%s unit = %q, want %q", name, got, unit)
+		}
+	}
+
+	version := metrics["ttn_gateway_version"]
+	if len(version.points) != 1 || !reflect.DeepEqual(version.points[0].attributes, map[string]string{"subsystem": "firmware", "version": "1.0.0"}) {
+		t.Errorf("ttn_gateway_version points = %+v, want the subsystem and version as only attributes", version.points)
+	}
+
+	if e.exports["success"] != 1 || e.exports["error"] != 0 {
+		t.Errorf("exports = %v, want 1 success", e.exports)
+	}
+}
+
+func TestRetryHTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []func(w http.ResponseWriter)
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "too many requests",
+			responses:    []func(w http.ResponseWriter){httpStatus(http.StatusTooManyRequests, "Retry-After", "0"), httpStatus(http.StatusOK)},
+			wantAttempts: 2,
+		},
+		{
+			name:         "unavailable",
+			responses:    []func(w http.ResponseWriter){httpStatus(http.StatusServiceUnavailable), httpStatus(http.StatusServiceUnavailable), httpStatus(http.StatusOK)},
+			wantAttempts: 3,
+		},
+		{
+			name:         "unavailable until the attempts are exhausted",
+			responses:    []func(w http.ResponseWriter){httpStatus(http.StatusServiceUnavailable)},
+			wantAttempts: maxAttempts,
+			wantErr:      true,
+		},
+		{
+			name:         "bad request",
+			responses:    []func(w http.ResponseWriter){httpStatus(http.StatusBadRequest), httpStatus(http.StatusOK)},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newHTTPReceiver(t, test.responses...)
+			e := newTestExporter(t, config.OTLPProtocolHTTP, r.URL)
+			snapshot, _ := testSnapshot(t)
+			e.Push(snapshot)
+
+			if r.attempts() != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", r.attempts(), test.wantAttempts)
+			}
+			wantExports := map[string]uint64{"success": 1}
+			if test.wantErr {
+				wantExports = map[string]uint64{"error": 1}
+			}
+			if !reflect.DeepEqual(e.exports, wantExports) {
+				t.Errorf("exports = %v, want %v", e.exports, wantExports)
+			}
+		})
+	}
+}
+
+func TestPushGRPC(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []func(w http.ResponseWriter)
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "ok", responses: []func(w http.ResponseWriter){grpcStatus("0", nil)}, wantAttempts: 1},
+		{
+			name:         "unavailable",
+			responses:    []func(w http.ResponseWriter){grpcStatus("14", nil), grpcStatus("0", nil)},
+			wantAttempts: 2,
+		},
+		{
+			name:         "invalid argument",
+			responses:    []func(w http.ResponseWriter){grpcStatus("3", nil), grpcStatus("0", nil)},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "partial success",
+			responses:    []func(w http.ResponseWriter){grpcStatus("0", partialSuccessResponse(3, "unsupported unit"))},
+			wantAttempts: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newGRPCReceiver(t, test.responses...)
+			e := newTestExporter(t, config.OTLPProtocolGRPC, r.URL+"/")
+			snapshot, _ := testSnapshot(t)
+			e.Push(snapshot)
+
+			if r.attempts() != test.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", r.attempts(), test.wantAttempts)
+			}
+			request := r.requests[0]
+			if request.ProtoMajor != 2 || request.URL.Path != grpcExportPath {
+				t.Errorf("request = HTTP/%d %s, want HTTP/2 %s", request.ProtoMajor, request.URL.Path, grpcExportPath)
+			}
+			if contentType := request.Header.Get("Content-Type"); contentType != "application/grpc" {
+				t.Errorf("Content-Type = %q, want application/grpc", contentType)
+			}
+			body := r.bodies[0]
+			if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+				t.Fatalf("request is not a single uncompressed gRPC message")
+			}
+			if resources := decodeRequest(t, body[5:]); len(resources) != 2 || resources[1].attributes["tenant"] != "acme" {
+				t.Errorf("resources = %+v, want the resources of both targets", resources)
+			}
+
+			wantExports := map[string]uint64{"success": 1}
+			if test.wantErr {
+				wantExports = map[string]uint64{"error": 1}
+			}
+			if !reflect.DeepEqual(e.exports, wantExports) {
+				t.Errorf("exports = %v, want %v", e.exports, wantExports)
+			}
+		})
+	}
+}
+
+func TestPartialSuccess(t *testing.T) {
+	tests := []struct {
+		name         string
+		response     []byte
+		wantRejected uint64
+		wantMessage  string
+	}{
+		{name: "empty response", response: nil},
+		{name: "rejected points", response: partialSuccessResponse(3, "unsupported unit"), wantRejected: 3, wantMessage: "unsupported unit"},
+		{name: "warning only", response: partialSuccessResponse(0, "deprecated"), wantMessage: "deprecated"},
+		{name: "truncated", response: partialSuccessResponse(3, "unsupported unit")[:4]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rejected, message := partialSuccess(test.response)
+			if rejected != test.wantRejected || message != test.wantMessage {
+				t.Errorf("partialSuccess() = %d, %q, want %d, %q", rejected, message, test.wantRejected, test.wantMessage)
+			}
+		})
+	}
+
+	// a partially successful export still counts as success
+	r := newHTTPReceiver(t, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(partialSuccessResponse(3, "unsupported unit"))
+	})
+	e := newTestExporter(t, config.OTLPProtocolHTTP, r.URL)
+	snapshot, _ := testSnapshot(t)
+	e.Push(snapshot)
+	if r.attempts() != 1 || e.exports["success"] != 1 {
+		t.Errorf("attempts = %d, exports = %v, want a single successful export", r.attempts(), e.exports)
+	}
+}
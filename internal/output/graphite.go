@@ -0,0 +1,86 @@
+package output
+
+import (
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	invalidPathChars = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+	invalidTagChars  = regexp.MustCompile(`[;~!^=\s]`)
+)
+
+// graphite writes the plaintext protocol over TCP.
+type graphite struct {
+	layout  layout
+	address string
+}
+
+func newGraphite(output config.Output) *graphite {
+	return &graphite{layout: newLayout(output.Layout), address: output.Address}
+}
+
+// encode writes one line per sample. Without {metric} in the path, the metric key is appended. The labels of the
+// metric are appended to the path as well, unless the layout is tagged.
+func (g *graphite) encode(at time.Time, samples []exporter.Sample) []string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	lines := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		components := strings.Split(g.layout.measurement(sample, pathComponent), ".")
+		if !g.layout.perMetric {
+			components = append(components, pathComponent(sample.Definition.Key))
+		}
+		if !g.layout.Tagged {
+			for _, label := range sample.Definition.Labels {
+				components = append(components, pathComponent(sample.Labels[label]))
+			}
+		}
+
+		var path strings.Builder
+		for _, component := range components {
+			// empty labels, e.g. the tenant of community targets, would result in empty path nodes
+			if component == "" {
+				continue
+			}
+			if path.Len() > 0 {
+				path.WriteString(".")
+			}
+			path.WriteString(component)
+		}
+		if g.layout.Tagged {
+			for _, tag := range g.layout.tags(sample) {
+				path.WriteString(";" + invalidTagChars.ReplaceAllString(tag.name, "_") + "=" + invalidTagChars.ReplaceAllString(tag.value, "_"))
+			}
+		}
+		lines = append(lines, path.String()+" "+strconv.FormatFloat(sample.Value, 'f', -1, 64)+" "+timestamp)
+	}
+	return lines
+}
+
+// pathComponent replaces the characters that have a meaning in Graphite paths or are not safe in file names.
+func pathComponent(value string) string {
+	return invalidPathChars.ReplaceAllString(value, "_")
+}
+
+func (g *graphite) write(ctx context.Context, lines []string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", g.address)
+	if err != nil {
+		return &writeError{err: err, retryable: true}
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	_, err = conn.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	if err != nil {
+		return &writeError{err: err, retryable: true}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package output
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"reflect"
+	"testing"
+)
+
+func TestGraphiteEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  config.OutputLayout
+		samples []exporter.Sample
+		want    []string
+	}{
+		{
+			name:   "metric appended to the path",
+			layout: config.OutputLayout{Measurement: "ttn.{cluster}.{tenant}.{gateway}"},
+			samples: []exporter.Sample{
+				testSample(uplinkCount, "my-gateway", 10),
+				testSample(channelRx, "my-gateway", 5, "frequency", "868.1"),
+			},
+			want: []string{
+				// the empty tenant leaves no empty path node
+				"ttn.eu1.my-gateway.uplink_count 10 1760861100",
+				"ttn.eu1.my-gateway.channel_rx_count.868_1 5 1760861100",
+			},
+		},
+		{
+			name:   "metric in the path",
+			layout: config.OutputLayout{Measurement: "ttn.{metric}.{gateway}"},
+			samples: []exporter.Sample{
+				testSample(downlinkCount, "my-gateway", 2.5),
+			},
+			want: []string{"ttn.downlink_count.my-gateway 2.5 1760861100"},
+		},
+		{
+			name:   "path sanitizing",
+			layout: config.OutputLayout{Measurement: "ttn.{gateway}"},
+			samples: []exporter.Sample{
+				testSample(uplinkCount, "my.gateway/1 (roof)", 1),
+			},
+			want: []string{"ttn.my_gateway_1__roof_.uplink_count 1 1760861100"},
+		},
+		{
+			name:   "tagged",
+			layout: config.OutputLayout{Measurement: "ttn.{metric}", Tagged: true, Tags: []string{"gateway", "tenant"}, ExtraTags: map[string]string{"site": "heil bronn"}},
+			samples: []exporter.Sample{
+				testSample(channelRx, "my gw;x=1", 5, "frequency", "868.1"),
+			},
+			want: []string{"ttn.channel_rx_count;frequency=868.1;gateway=my_gw_x_1;site=heil_bronn 5 1760861100"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := newGraphite(config.Output{Address: "localhost:2003", Layout: test.layout})
+			if got := sink.encode(testTime, test.samples); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("encode =\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
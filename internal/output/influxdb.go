@@ -0,0 +1,111 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	measurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// influxDB writes line protocol to the v2 write API, which InfluxDB 1.8 and later provide.
+type influxDB struct {
+	layout   layout
+	writeURL string
+	token    string
+	client   *http.Client
+}
+
+func newInfluxDB(output config.Output) *influxDB {
+	query := url.Values{"org": {output.Org}, "bucket": {output.Bucket}, "precision": {"s"}}
+	return &influxDB{
+		layout:   newLayout(output.Layout),
+		writeURL: strings.TrimSuffix(output.URL, "/") + "/api/v2/write?" + query.Encode(),
+		token:    output.Token,
+		client:   &http.Client{},
+	}
+}
+
+// encode writes one line per measurement and tag set. Measurements with {metric} have a single value field, the
+// others one field per metric.
+func (i *influxDB) encode(at time.Time, samples []exporter.Sample) []string {
+	type point struct {
+		series string
+		fields map[string]float64
+	}
+	var points []*point
+	bySeries := map[string]*point{}
+	for _, sample := range samples {
+		var series strings.Builder
+		series.WriteString(i.layout.measurement(sample, measurementEscaper.Replace))
+		for _, tag := range i.layout.tags(sample) {
+			series.WriteString("," + tagEscaper.Replace(tag.name) + "=" + tagEscaper.Replace(tag.value))
+		}
+		p, ok := bySeries[series.String()]
+		if !ok {
+			p = &point{series: series.String(), fields: map[string]float64{}}
+			bySeries[p.series] = p
+			points = append(points, p)
+		}
+		field := sample.Definition.Key
+		if i.layout.perMetric {
+			field = "value"
+		}
+		p.fields[field] = sample.Value
+	}
+
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	lines := make([]string, 0, len(points))
+	for _, p := range points {
+		names := make([]string, 0, len(p.fields))
+		for name := range p.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := make([]string, len(names))
+		for j, name := range names {
+			fields[j] = tagEscaper.Replace(name) + "=" + strconv.FormatFloat(p.fields[name], 'g', -1, 64)
+		}
+		lines = append(lines, p.series+" "+strings.Join(fields, ",")+" "+timestamp)
+	}
+	return lines
+}
+
+func (i *influxDB) write(ctx context.Context, lines []string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.writeURL, strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+	res, err := i.client.Do(req)
+	if err != nil {
+		return &writeError{err: err, retryable: true}
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4*1024))
+	switch {
+	case res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusOK:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return &writeError{err: fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body)), retryable: true, retryAfter: time.Duration(retryAfter) * time.Second}
+	default:
+		// the lines are invalid or the token is not allowed to write them, retrying won't help
+		return &writeError{err: fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))}
+	}
+}
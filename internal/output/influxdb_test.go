@@ -0,0 +1,89 @@
+package output
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	testTime = time.Unix(1760861100, 0)
+
+	uplinkCount   = exporter.MetricDefinition{Key: "uplink_count"}
+	downlinkCount = exporter.MetricDefinition{Key: "downlink_count"}
+	channelRx     = exporter.MetricDefinition{Key: "channel_rx_count", Labels: []string{"frequency"}}
+)
+
+// testSample returns a sample of the gateway in the eu1 cluster without tenant, with the metric labels as pairs.
+func testSample(definition exporter.MetricDefinition, gateway string, value float64, labels ...string) exporter.Sample {
+	sampleLabels := map[string]string{"gateway": gateway, "backend": "ttn", "cluster": "eu1", "tenant": ""}
+	for i := 0; i+1 < len(labels); i += 2 {
+		sampleLabels[labels[i]] = labels[i+1]
+	}
+	return exporter.Sample{Definition: definition, Labels: sampleLabels, Value: value}
+}
+
+func TestInfluxDBEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  config.OutputLayout
+		samples []exporter.Sample
+		want    []string
+	}{
+		{
+			name:   "measurement per metric",
+			layout: config.OutputLayout{Measurement: "ttn_{metric}", Tags: []string{"gateway", "cluster", "tenant"}, ExtraTags: map[string]string{"site": "heilbronn"}},
+			samples: []exporter.Sample{
+				testSample(uplinkCount, "my-gateway", 10),
+				testSample(downlinkCount, "my-gateway", 2.5),
+			},
+			want: []string{
+				// the empty tenant is not written
+				"ttn_uplink_count,cluster=eu1,gateway=my-gateway,site=heilbronn value=10 1760861100",
+				"ttn_downlink_count,cluster=eu1,gateway=my-gateway,site=heilbronn value=2.5 1760861100",
+			},
+		},
+		{
+			name:   "field per metric",
+			layout: config.OutputLayout{Measurement: "gateway", Tags: []string{"gateway"}},
+			samples: []exporter.Sample{
+				testSample(uplinkCount, "my-gateway", 10),
+				testSample(channelRx, "my-gateway", 5, "frequency", "868100000"),
+				testSample(downlinkCount, "my-gateway", 2),
+				testSample(uplinkCount, "other-gateway", 1),
+			},
+			want: []string{
+				"gateway,gateway=my-gateway downlink_count=2,uplink_count=10 1760861100",
+				"gateway,frequency=868100000,gateway=my-gateway channel_rx_count=5 1760861100",
+				"gateway,gateway=other-gateway uplink_count=1 1760861100",
+			},
+		},
+		{
+			name:   "escaping",
+			layout: config.OutputLayout{Measurement: "gw_{gateway}", Tags: []string{"gateway"}, ExtraTags: map[string]string{"my tag": `a=b,c\d`}},
+			samples: []exporter.Sample{
+				testSample(uplinkCount, "my gateway,1=\n", 1),
+			},
+			want: []string{
+				`gw_my\ gateway\,1=\n,gateway=my\ gateway\,1\=\n,my\ tag=a\=b\,c\\d uplink_count=1 1760861100`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := newInfluxDB(config.Output{URL: "http://localhost:8086", Layout: test.layout})
+			if got := sink.encode(testTime, test.samples); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("encode =\n%q\nwant\n%q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInfluxDBWriteURL(t *testing.T) {
+	sink := newInfluxDB(config.Output{URL: "http://localhost:8086/", Org: "acme corp", Bucket: "gateways"})
+	if want := "http://localhost:8086/api/v2/write?bucket=gateways&org=acme+corp&precision=s"; sink.writeURL != want {
+		t.Errorf("writeURL = %q, want %q", sink.writeURL, want)
+	}
+}
@@ -0,0 +1,65 @@
+package output
+
+import (
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var placeholder = regexp.MustCompile(`\{([A-Za-z_]+)\}`)
+
+// layout applies an OutputLayout to samples.
+type layout struct {
+	config.OutputLayout
+	// perMetric is set if the measurement contains {metric}
+	perMetric bool
+}
+
+func newLayout(outputLayout config.OutputLayout) layout {
+	return layout{OutputLayout: outputLayout, perMetric: strings.Contains(outputLayout.Measurement, "{metric}")}
+}
+
+// measurement expands the placeholders of the measurement with the metric key and the labels of the sample, passed
+// through escape.
+func (l layout) measurement(sample exporter.Sample, escape func(string) string) string {
+	return placeholder.ReplaceAllStringFunc(l.Measurement, func(match string) string {
+		name := match[1 : len(match)-1]
+		if name == "metric" {
+			return escape(sample.Definition.Key)
+		}
+		return escape(sample.Labels[name])
+	})
+}
+
+// tags returns the configured target labels, the labels of the metric and the extra tags of a sample, without empty
+// values, sorted by name.
+func (l layout) tags(sample exporter.Sample) []tag {
+	values := map[string]string{}
+	for name, value := range l.ExtraTags {
+		values[name] = value
+	}
+	for _, name := range l.Tags {
+		values[name] = sample.Labels[name]
+	}
+	for _, name := range sample.Definition.Labels {
+		values[name] = sample.Labels[name]
+	}
+
+	var tags []tag
+	for name, value := range values {
+		if value != "" {
+			tags = append(tags, tag{name: name, value: value})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].name < tags[j].name
+	})
+	return tags
+}
+
+type tag struct {
+	name  string
+	value string
+}
@@ -0,0 +1,208 @@
+// Package output pushes the gateway metrics to time series databases that are not scraped, like InfluxDB and Graphite.
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"math"
+	"sync"
+	"time"
+)
+
+var log = logging.Logger("output")
+
+// retryDelay is the delay before the first retry, it doubles with every attempt
+var retryDelay = time.Second
+
+// sink serializes samples to lines of its protocol and writes batches of them.
+type sink interface {
+	encode(at time.Time, samples []exporter.Sample) []string
+	write(ctx context.Context, lines []string) error
+}
+
+// writeError is a failed write that may be retried after the delay.
+type writeError struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+func (e *writeError) Error() string {
+	return e.err.Error()
+}
+
+// Output buffers the lines of every snapshot it observes and writes them in batches. Push is meant to be subscribed
+// to an exporter.Poller, Run writes the buffer in the background, so an unreachable output doesn't delay the poller.
+type Output struct {
+	config  config.Output
+	sink    sink
+	descs   map[string]*prometheus.Desc
+	flushes chan struct{}
+
+	mu      sync.Mutex
+	buffer  []string
+	writing int
+	writes  map[string]uint64
+	written uint64
+	dropped uint64
+}
+
+func New(outputConfig config.Output) (*Output, error) {
+	var outputSink sink
+	switch outputConfig.Type {
+	case config.OutputInfluxDB:
+		outputSink = newInfluxDB(outputConfig)
+	case config.OutputGraphite:
+		outputSink = newGraphite(outputConfig)
+	default:
+		return nil, fmt.Errorf("unknown output type %q", outputConfig.Type)
+	}
+	desc := func(name, help string, labels []string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("ttn", "gateway", name), help, labels, prometheus.Labels{"output": outputConfig.Name})
+	}
+	return &Output{
+		config: outputConfig,
+		sink:   outputSink,
+		descs: map[string]*prometheus.Desc{
+			"writes":   desc("output_writes_total", "Number of batches written to the output by result (success or error)", []string{"result"}),
+			"written":  desc("output_written_lines_total", "Number of lines written to the output", nil),
+			"dropped":  desc("output_dropped_lines_total", "Number of lines dropped because the output rejected them or the buffer was full", nil),
+			"buffered": desc("output_buffered_lines", "Number of lines waiting to be written to the output", nil),
+		},
+		flushes: make(chan struct{}, 1),
+		writes:  map[string]uint64{},
+	}, nil
+}
+
+func (o *Output) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range o.descs {
+		descs <- desc
+	}
+}
+
+func (o *Output) Collect(metrics chan<- prometheus.Metric) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for result, count := range o.writes {
+		metrics <- prometheus.MustNewConstMetric(o.descs["writes"], prometheus.CounterValue, float64(count), result)
+	}
+	metrics <- prometheus.MustNewConstMetric(o.descs["written"], prometheus.CounterValue, float64(o.written))
+	metrics <- prometheus.MustNewConstMetric(o.descs["dropped"], prometheus.CounterValue, float64(o.dropped))
+	metrics <- prometheus.MustNewConstMetric(o.descs["buffered"], prometheus.GaugeValue, float64(len(o.buffer)+o.writing))
+}
+
+// Push adds the samples of all targets in the snapshot to the buffer and wakes up Run.
+func (o *Output) Push(snapshot exporter.Snapshot) {
+	var samples []exporter.Sample
+	for _, targetStatus := range snapshot.Statuses {
+		for _, sample := range targetStatus.Target.Samples(targetStatus.Status, targetStatus.Err) {
+			// neither line protocol nor Graphite can represent NaN or infinity
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			samples = append(samples, sample)
+		}
+	}
+	lines := o.sink.encode(snapshot.At, samples)
+
+	o.mu.Lock()
+	o.enqueue(append(o.buffer, lines...))
+	o.mu.Unlock()
+
+	select {
+	case o.flushes <- struct{}{}:
+	default:
+	}
+}
+
+// Run writes the buffer whenever Push added lines, until the context is cancelled.
+func (o *Output) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.flushes:
+			o.flush(ctx)
+		}
+	}
+}
+
+// enqueue replaces the buffer, dropping the oldest lines beyond the buffer size. The caller must hold the lock.
+func (o *Output) enqueue(buffer []string) {
+	if excess := len(buffer) - o.config.BufferSize; excess > 0 {
+		o.dropped += uint64(excess)
+		log.Warnw("output buffer full, dropping oldest lines", "output", o.config.Name, "dropped", excess)
+		buffer = buffer[excess:]
+	}
+	o.buffer = buffer
+}
+
+// flush writes the buffer in batches. A batch that still fails after all retries is put back and the remaining
+// lines wait for the next snapshot.
+func (o *Output) flush(ctx context.Context) {
+	for {
+		o.mu.Lock()
+		size := len(o.buffer)
+		if size > o.config.BatchSize {
+			size = o.config.BatchSize
+		}
+		batch := o.buffer[:size]
+		o.buffer = o.buffer[size:]
+		o.writing = size
+		o.mu.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+
+		err := o.write(ctx, batch)
+
+		o.mu.Lock()
+		o.writing = 0
+		var writeErr *writeError
+		switch {
+		case err == nil:
+			o.writes["success"]++
+			o.written += uint64(len(batch))
+		case errors.As(err, &writeErr) && writeErr.retryable:
+			o.writes["error"]++
+			o.enqueue(append(append([]string{}, batch...), o.buffer...))
+			o.mu.Unlock()
+			log.Errorw("output write error, keeping lines for the next interval", "output", o.config.Name, "lines", len(batch), "error", err)
+			return
+		default:
+			o.writes["error"]++
+			o.dropped += uint64(len(batch))
+			log.Errorw("output rejected lines, dropping them", "output", o.config.Name, "lines", len(batch), "error", err)
+		}
+		o.mu.Unlock()
+	}
+}
+
+// write writes a batch, retrying retryable errors up to the configured number of times.
+func (o *Output) write(ctx context.Context, batch []string) error {
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		writeCtx, cancel := context.WithTimeout(ctx, o.config.Timeout)
+		err := o.sink.write(writeCtx, batch)
+		cancel()
+		var writeErr *writeError
+		if err == nil || !errors.As(err, &writeErr) || !writeErr.retryable || attempt == o.config.MaxRetries {
+			return err
+		}
+		if writeErr.retryAfter > delay {
+			delay = writeErr.retryAfter
+		}
+		log.Debugw("output write failed, retrying", "output", o.config.Name, "error", err, "retryIn", delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,220 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// influxDBServer answers writes with the given statuses in turn, the last one repeatedly, and records the lines.
+type influxDBServer struct {
+	*httptest.Server
+	statuses []int
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func newInfluxDBServer(t *testing.T, statuses ...int) *influxDBServer {
+	s := &influxDBServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Token secret" {
+			t.Errorf("Authorization = %q, want the token", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		status := s.statuses[len(s.statuses)-1]
+		if len(s.batches) < len(s.statuses) {
+			status = s.statuses[len(s.batches)]
+		}
+		s.batches = append(s.batches, strings.Split(string(body), "\n"))
+		s.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *influxDBServer) received() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.batches...)
+}
+
+func newTestOutput(t *testing.T, outputConfig config.Output) *Output {
+	t.Helper()
+	outputConfig.Name = "test"
+	outputConfig.Timeout = 5 * time.Second
+	outputConfig.Token = "secret"
+	o, err := New(outputConfig)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return o
+}
+
+func testLines(count int) []string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = "gateway uplink_count=" + strconv.Itoa(i) + " 1760861100"
+	}
+	return lines
+}
+
+func TestFlush(t *testing.T) {
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = time.Millisecond
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		lines        int
+		wantBatches  []int
+		wantWrites   map[string]uint64
+		wantWritten  uint64
+		wantDropped  uint64
+		wantBuffered int
+	}{
+		{
+			name:        "batches",
+			statuses:    []int{http.StatusNoContent},
+			lines:       5,
+			wantBatches: []int{2, 2, 1},
+			wantWrites:  map[string]uint64{"success": 3},
+			wantWritten: 5,
+		},
+		{
+			name:        "retried",
+			statuses:    []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent},
+			lines:       2,
+			wantBatches: []int{2, 2, 2},
+			wantWrites:  map[string]uint64{"success": 1},
+			wantWritten: 2,
+		},
+		{
+			name:         "kept after all retries",
+			statuses:     []int{http.StatusInternalServerError},
+			lines:        3,
+			wantBatches:  []int{2, 2, 2},
+			wantWrites:   map[string]uint64{"error": 1},
+			wantBuffered: 3,
+		},
+		{
+			name:        "rejected",
+			statuses:    []int{http.StatusBadRequest, http.StatusNoContent},
+			lines:       3,
+			wantBatches: []int{2, 1},
+			wantWrites:  map[string]uint64{"error": 1, "success": 1},
+			wantWritten: 1,
+			wantDropped: 2,
+		},
+		{
+			name:        "buffer full",
+			statuses:    []int{http.StatusNoContent},
+			lines:       12,
+			wantBatches: []int{2, 2, 2, 2, 2},
+			wantWrites:  map[string]uint64{"success": 5},
+			wantWritten: 10,
+			wantDropped: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newInfluxDBServer(t, test.statuses...)
+			o := newTestOutput(t, config.Output{Type: config.OutputInfluxDB, URL: server.URL, BatchSize: 2, MaxRetries: 2, BufferSize: 10})
+			o.mu.Lock()
+			o.enqueue(testLines(test.lines))
+			o.mu.Unlock()
+			o.flush(context.Background())
+
+			var batches []int
+			for _, batch := range server.received() {
+				batches = append(batches, len(batch))
+			}
+			if !reflect.DeepEqual(batches, test.wantBatches) {
+				t.Errorf("batch sizes = %v, want %v", batches, test.wantBatches)
+			}
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			if !reflect.DeepEqual(o.writes, test.wantWrites) {
+				t.Errorf("writes = %v, want %v", o.writes, test.wantWrites)
+			}
+			if o.written != test.wantWritten || o.dropped != test.wantDropped || len(o.buffer) != test.wantBuffered {
+				t.Errorf("written, dropped, buffered = %d, %d, %d, want %d, %d, %d", o.written, o.dropped, len(o.buffer), test.wantWritten, test.wantDropped, test.wantBuffered)
+			}
+		})
+	}
+}
+
+func TestFlushKeepsOrder(t *testing.T) {
+	server := newInfluxDBServer(t, http.StatusNoContent)
+	o := newTestOutput(t, config.Output{Type: config.OutputInfluxDB, URL: server.URL, BatchSize: 2, BufferSize: 3})
+	lines := testLines(5)
+	o.mu.Lock()
+	o.enqueue(lines[:2])
+	o.enqueue(append(o.buffer, lines[2:]...))
+	o.mu.Unlock()
+	o.flush(context.Background())
+
+	var written []string
+	for _, batch := range server.received() {
+		written = append(written, batch...)
+	}
+	// the oldest lines are dropped
+	if !reflect.DeepEqual(written, lines[2:]) {
+		t.Errorf("written = %q, want %q", written, lines[2:])
+	}
+}
+
+func TestGraphiteWrite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	o := newTestOutput(t, config.Output{Type: config.OutputGraphite, Address: listener.Addr().String(), BatchSize: 10, BufferSize: 10})
+	lines := []string{"ttn.my-gateway.uplink_count 10 1760861100", "ttn.my-gateway.downlink_count 2 1760861100"}
+	o.mu.Lock()
+	o.enqueue(lines)
+	o.mu.Unlock()
+	o.flush(context.Background())
+
+	select {
+	case got := <-received:
+		if !reflect.DeepEqual(got, lines) {
+			t.Errorf("received %q, want %q", got, lines)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no lines received")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.written != 2 || o.writes["success"] != 1 {
+		t.Errorf("written = %d, writes = %v, want 2 lines in one write", o.written, o.writes)
+	}
+}
@@ -0,0 +1,164 @@
+// Package publicstatus publishes a filtered status of the gateways that can be exposed to the public.
+package publicstatus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var log = logging.Logger("public-status")
+
+// Handler serves /public/status.json. The generated feed is cached for the configured TTL, so requests never reach
+// the backends more often than that.
+type Handler struct {
+	targets []*exporter.Target
+	config  config.PublicStatus
+	fields  map[string]bool
+	limiter *rateLimiter
+
+	mu          sync.Mutex
+	body        []byte
+	generatedAt time.Time
+}
+
+type status struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Gateways    []gateway `json:"gateways"`
+}
+
+// gateway is the published status of a gateway. Fields that are not in the allowlist are left empty and omitted.
+type gateway struct {
+	GatewayID     string     `json:"gateway_id,omitempty"`
+	Tenant        string     `json:"tenant,omitempty"`
+	Online        *bool      `json:"online,omitempty"`
+	LastSeenAt    *time.Time `json:"last_seen_at,omitempty"`
+	ConnectedAt   *time.Time `json:"connected_at,omitempty"`
+	UplinkCount   *uint64    `json:"uplink_count,omitempty"`
+	DownlinkCount *uint64    `json:"downlink_count,omitempty"`
+	Location      *location  `json:"location,omitempty"`
+}
+
+type location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+func New(targets []*exporter.Target, config config.PublicStatus) *Handler {
+	fields := map[string]bool{}
+	for _, field := range config.Fields {
+		fields[field] = true
+	}
+	return &Handler{
+		targets: targets,
+		config:  config,
+		fields:  fields,
+		limiter: newRateLimiter(config.RateLimit, config.RateLimitBurst),
+	}
+}
+
+// registerer is implemented by server.Server.
+type registerer interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// Register adds /public/status.json to the server.
+func (h *Handler) Register(server registerer) {
+	server.Handle("/public/status.json", h)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if retryAfter, ok := h.limiter.allow(clientIP(r), time.Now()); !ok {
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	body, generatedAt := h.status(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+maxAge(generatedAt.Add(h.config.CacheTTL)))
+	w.Header().Set("Last-Modified", generatedAt.UTC().Format(http.TimeFormat))
+	_, _ = w.Write(body)
+}
+
+// status returns the cached feed, generating it again once it is older than the cache TTL. Concurrent requests wait
+// for a single generation.
+func (h *Handler) status(ctx context.Context) ([]byte, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.body != nil && time.Since(h.generatedAt) < h.config.CacheTTL {
+		return h.body, h.generatedAt
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	feed := status{GeneratedAt: time.Now().UTC(), Gateways: []gateway{}}
+	for _, targetStatus := range exporter.Statuses(ctx, h.targets, h.config.CacheTTL) {
+		feed.Gateways = append(feed.Gateways, h.gateway(targetStatus))
+	}
+	sort.SliceStable(feed.Gateways, func(i, j int) bool {
+		return feed.Gateways[i].GatewayID < feed.Gateways[j].GatewayID
+	})
+	body, err := json.Marshal(feed)
+	if err != nil {
+		log.Errorw("public status encoding error", "error", err)
+		return h.body, h.generatedAt
+	}
+	h.body, h.generatedAt = body, feed.GeneratedAt
+	return h.body, h.generatedAt
+}
+
+// gateway copies the allowed fields of the status. The location is only published if it is public.
+func (h *Handler) gateway(targetStatus exporter.TargetStatus) gateway {
+	target, status, err := targetStatus.Target, targetStatus.Status, targetStatus.Err
+	targetConfig := target.Config()
+	if err != nil && !errors.Is(err, exporter.ErrNotConnected) {
+		log.Debugw("gateway status error", "target", targetConfig.FullGatewayID(), "error", err)
+	}
+
+	var published gateway
+	if h.fields["gateway_id"] {
+		published.GatewayID = targetConfig.GatewayID
+	}
+	if h.fields["tenant"] {
+		published.Tenant = targetConfig.Tenant
+	}
+	if h.fields["online"] {
+		online := err == nil && status.Connected
+		published.Online = &online
+	}
+	if h.fields["last_seen_at"] && !status.LastSeenAt.IsZero() {
+		lastSeenAt := status.LastSeenAt.UTC()
+		published.LastSeenAt = &lastSeenAt
+	}
+	if h.fields["connected_at"] && !status.ConnectedAt.IsZero() {
+		connectedAt := status.ConnectedAt.UTC()
+		published.ConnectedAt = &connectedAt
+	}
+	if h.fields["uplink_count"] {
+		published.UplinkCount = status.UplinkCount
+	}
+	if h.fields["downlink_count"] {
+		published.DownlinkCount = status.DownlinkCount
+	}
+	if h.fields["location"] {
+		if publicLocation, _, ok := exporter.PublicLocation(status, target.BackendName()); ok {
+			published.Location = &location{Latitude: publicLocation.Latitude, Longitude: publicLocation.Longitude, Altitude: publicLocation.Altitude}
+		}
+	}
+	return published
+}
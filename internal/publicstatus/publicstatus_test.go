@@ -0,0 +1,196 @@
+package publicstatus
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/exporter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// api serves the connection stats and the registry of the gateways public-gateway and private-gateway, whose location
+// is not public. It counts the connection stats requests.
+type api struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	reads int
+}
+
+func newAPI(t *testing.T) *api {
+	a := &api{}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, gatewayID := range []string{"public-gateway", "private-gateway"} {
+			switch r.URL.Path {
+			case "/api/v3/gs/gateways/" + gatewayID + "/connection/stats":
+				a.mu.Lock()
+				a.reads++
+				a.mu.Unlock()
+				_, _ = fmt.Fprint(w, `{"connected_at": "2026-10-19T08:00:00Z", "last_status_received_at": "2026-10-19T10:00:00Z",
+					"uplink_count": "42", "downlink_count": "7", "protocol": "udp",
+					"last_status": {"antenna_locations": [{"latitude": 49.1, "longitude": 9.2, "altitude": 180, "source": "SOURCE_GPS"}]}}`)
+				return
+			case "/api/v3/gateways/" + gatewayID:
+				_, _ = fmt.Fprintf(w, `{"ids": {"gateway_id": %q}, "location_public": %t,
+					"antennas": [{"location": {"latitude": 49.14, "longitude": 9.22, "altitude": 170, "source": "SOURCE_REGISTRY"}}]}`,
+					gatewayID, gatewayID == "public-gateway")
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(a.Close)
+	return a
+}
+
+func (a *api) statsReads() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reads
+}
+
+func newTestHandler(t *testing.T, a *api, publicStatus config.PublicStatus, gatewayIDs ...string) *Handler {
+	t.Helper()
+	targetConfig := config.TargetConfig{PublicStatus: publicStatus}
+	var targets []*exporter.Target
+	for _, gatewayID := range gatewayIDs {
+		target, err := exporter.NewTarget(targetConfig, config.Target{GatewayID: gatewayID, Tenant: "acme", APIKey: "NNSXS.TEST", BaseUrl: a.URL, Backend: config.BackendTTN})
+		if err != nil {
+			t.Fatalf("NewTarget: %v", err)
+		}
+		targets = append(targets, target)
+	}
+	return New(targets, publicStatus)
+}
+
+func get(handler http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/public/status.json", nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestFields(t *testing.T) {
+	publicStatus := config.PublicStatus{Enabled: true, Fields: []string{"gateway_id", "online", "location"}, CacheTTL: time.Minute, RateLimit: 1, RateLimitBurst: 10}
+	handler := newTestHandler(t, newAPI(t), publicStatus, "public-gateway", "private-gateway")
+
+	w := get(handler, "192.0.2.1:1234")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var feed struct {
+		Gateways []map[string]json.RawMessage `json:"gateways"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("feed is not valid JSON: %v", err)
+	}
+	if len(feed.Gateways) != 2 {
+		t.Fatalf("gateways = %d, want 2", len(feed.Gateways))
+	}
+	for _, published := range feed.Gateways {
+		for field := range published {
+			if !handler.fields[field] {
+				t.Errorf("field %s is published, but not in the allowlist", field)
+			}
+		}
+		if string(published["online"]) != "true" {
+			t.Errorf("%s: online = %s, want true", published["gateway_id"], published["online"])
+		}
+	}
+	if body := w.Body.String(); strings.Contains(body, "acme") {
+		t.Errorf("feed %s contains the tenant", body)
+	}
+
+	// the gateways are sorted by ID, only the public location is published, and the registry location is preferred
+	if id := string(feed.Gateways[0]["gateway_id"]); id != `"private-gateway"` {
+		t.Errorf("first gateway = %s, want private-gateway", id)
+	}
+	if location, ok := feed.Gateways[0]["location"]; ok {
+		t.Errorf("location %s of private-gateway is published", location)
+	}
+	if location := string(feed.Gateways[1]["location"]); location != `{"latitude":49.14,"longitude":9.22,"altitude":170}` {
+		t.Errorf("location of public-gateway = %s, want the registry location", location)
+	}
+}
+
+func TestFieldsWithoutLocation(t *testing.T) {
+	publicStatus := config.PublicStatus{Enabled: true, Fields: []string{"gateway_id"}, CacheTTL: time.Minute, RateLimit: 1, RateLimitBurst: 10}
+	handler := newTestHandler(t, newAPI(t), publicStatus, "public-gateway")
+
+	body := get(handler, "192.0.2.1:1234").Body.String()
+	if !strings.Contains(body, `"gateways":[{"gateway_id":"public-gateway"}]`) {
+		t.Errorf("feed = %s, want only the gateway ID", body)
+	}
+}
+
+func TestCache(t *testing.T) {
+	a := newAPI(t)
+	publicStatus := config.PublicStatus{Enabled: true, Fields: []string{"gateway_id", "last_seen_at"}, CacheTTL: 200 * time.Millisecond, RateLimit: 1, RateLimitBurst: 10}
+	handler := newTestHandler(t, a, publicStatus, "public-gateway")
+
+	first := get(handler, "192.0.2.1:1234")
+	second := get(handler, "192.0.2.2:1234")
+	if reads := a.statsReads(); reads != 1 {
+		t.Errorf("stats reads within the cache TTL = %d, want 1", reads)
+	}
+	if first.Body.String() != second.Body.String() || first.Header().Get("Last-Modified") != second.Header().Get("Last-Modified") {
+		t.Errorf("second response %s differs from the cached one %s", second.Body, first.Body)
+	}
+	if cacheControl := first.Header().Get("Cache-Control"); cacheControl != "public, max-age=0" {
+		t.Errorf("Cache-Control = %q, want public, max-age=0", cacheControl)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	get(handler, "192.0.2.1:1234")
+	if reads := a.statsReads(); reads != 2 {
+		t.Errorf("stats reads after the cache TTL = %d, want 2", reads)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	publicStatus := config.PublicStatus{Enabled: true, Fields: []string{"gateway_id"}, CacheTTL: time.Minute, RateLimit: 0.1, RateLimitBurst: 2}
+	handler := newTestHandler(t, newAPI(t), publicStatus, "public-gateway")
+
+	for i := 0; i < 2; i++ {
+		if w := get(handler, "192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	w := get(handler, "192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request beyond the burst: status = %d, want 429", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "10" {
+		t.Errorf("Retry-After = %q, want 10", retryAfter)
+	}
+	// the bucket is per client IP
+	if w := get(handler, "192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("request of another client: status = %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter := newRateLimiter(2, 1)
+	now := time.Unix(1760861100, 0)
+	if _, ok := limiter.allow("192.0.2.1", now); !ok {
+		t.Fatal("first request was limited")
+	}
+	if retryAfter, ok := limiter.allow("192.0.2.1", now.Add(100*time.Millisecond)); ok || retryAfter != "1" {
+		t.Errorf("request before the refill = %q, %v, want Retry-After 1", retryAfter, ok)
+	}
+	if _, ok := limiter.allow("192.0.2.1", now.Add(600*time.Millisecond)); !ok {
+		t.Error("request after the refill was limited")
+	}
+
+	// idle buckets are pruned once they are full again
+	limiter.allow("192.0.2.2", now.Add(idleBucketTimeout+time.Second))
+	if _, ok := limiter.buckets["192.0.2.1"]; ok {
+		t.Error("idle bucket was not pruned")
+	}
+}
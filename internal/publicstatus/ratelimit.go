@@ -0,0 +1,84 @@
+package publicstatus
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// idleBucketTimeout is how long the bucket of a client is kept after its last request
+const idleBucketTimeout = 10 * time.Minute
+
+// rateLimiter is a token bucket per client IP.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	prunedAt time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// allow takes a token from the bucket of the client. If the bucket is empty, it returns the seconds until the next
+// token as value of a Retry-After header.
+func (l *rateLimiter) allow(client string, now time.Time) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, updatedAt: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updatedAt).Seconds()*l.rate)
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return strconv.Itoa(int(math.Ceil((1 - b.tokens) / l.rate))), false
+	}
+	b.tokens--
+	return "", true
+}
+
+// prune removes the buckets of clients that were idle long enough for their bucket to be full again.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.prunedAt) < idleBucketTimeout {
+		return
+	}
+	l.prunedAt = now
+	for client, b := range l.buckets {
+		if now.Sub(b.updatedAt) > idleBucketTimeout {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientIP returns the IP address of the peer. Forwarding headers are not trusted, as they are set by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// maxAge returns the seconds until the expiry, for a Cache-Control header.
+func maxAge(expiry time.Time) string {
+	seconds := int(time.Until(expiry).Seconds())
+	if seconds < 0 {
+		seconds = 0
+	}
+	return strconv.Itoa(seconds)
+}
@@ -0,0 +1,141 @@
+package remotewrite
+
+import (
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Field numbers of prometheus/prompb/remote.proto and types.proto.
+const (
+	writeRequestTimeseries = 1
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+// sample is a single sample of a time series, the unit of the queue.
+type sample struct {
+	// labels are sorted by name and include __name__
+	labels      []label
+	value       float64
+	timestampMs int64
+}
+
+// samplesOf flattens the gathered metric families into samples the way the text exposition format does: summaries
+// and histograms become their _sum, _count, quantile and _bucket series.
+func samplesOf(families []*dto.MetricFamily, externalLabels map[string]string, timestampMs int64) []sample {
+	var samples []sample
+	for _, family := range families {
+		name := family.GetName()
+		for _, metric := range family.Metric {
+			timestamp := timestampMs
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...label) {
+				labels := []label{{name: "__name__", value: name + suffix}}
+				seen := map[string]bool{}
+				for _, pair := range metric.Label {
+					// empty labels, e.g. the tenant of community targets, are the same as missing ones
+					if pair.GetValue() == "" {
+						continue
+					}
+					labels = append(labels, label{name: pair.GetName(), value: pair.GetValue()})
+					seen[pair.GetName()] = true
+				}
+				for _, l := range extra {
+					labels = append(labels, l)
+					seen[l.name] = true
+				}
+				// external labels don't override the labels of the series
+				for labelName, labelValue := range externalLabels {
+					if !seen[labelName] {
+						labels = append(labels, label{name: labelName, value: labelValue})
+					}
+				}
+				sort.Slice(labels, func(i, j int) bool {
+					return labels[i].name < labels[j].name
+				})
+				samples = append(samples, sample{labels: labels, value: value, timestampMs: timestamp})
+			}
+
+			switch {
+			case metric.Counter != nil:
+				add("", metric.Counter.GetValue())
+			case metric.Gauge != nil:
+				add("", metric.Gauge.GetValue())
+			case metric.Untyped != nil:
+				add("", metric.Untyped.GetValue())
+			case metric.Summary != nil:
+				for _, quantile := range metric.Summary.Quantile {
+					add("", quantile.GetValue(), label{name: "quantile", value: formatFloat(quantile.GetQuantile())})
+				}
+				add("_sum", metric.Summary.GetSampleSum())
+				add("_count", float64(metric.Summary.GetSampleCount()))
+			case metric.Histogram != nil:
+				infSeen := false
+				for _, bucket := range metric.Histogram.Bucket {
+					if math.IsInf(bucket.GetUpperBound(), 1) {
+						infSeen = true
+					}
+					add("_bucket", float64(bucket.GetCumulativeCount()), label{name: "le", value: formatFloat(bucket.GetUpperBound())})
+				}
+				if !infSeen {
+					add("_bucket", float64(metric.Histogram.GetSampleCount()), label{name: "le", value: "+Inf"})
+				}
+				add("_sum", metric.Histogram.GetSampleSum())
+				add("_count", float64(metric.Histogram.GetSampleCount()))
+			}
+		}
+	}
+	return samples
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes a prompb.WriteRequest with one time series per sample.
+func encodeWriteRequest(samples []sample) []byte {
+	var request []byte
+	for _, s := range samples {
+		var series []byte
+		for _, l := range s.labels {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, labelName, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, l.name)
+			encoded = protowire.AppendTag(encoded, labelValue, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, l.value)
+			series = protowire.AppendTag(series, timeSeriesLabels, protowire.BytesType)
+			series = protowire.AppendBytes(series, encoded)
+		}
+		var encoded []byte
+		encoded = protowire.AppendTag(encoded, sampleValue, protowire.Fixed64Type)
+		encoded = protowire.AppendFixed64(encoded, math.Float64bits(s.value))
+		encoded = protowire.AppendTag(encoded, sampleTimestamp, protowire.VarintType)
+		encoded = protowire.AppendVarint(encoded, uint64(s.timestampMs))
+		series = protowire.AppendTag(series, timeSeriesSamples, protowire.BytesType)
+		series = protowire.AppendBytes(series, encoded)
+
+		request = protowire.AppendTag(request, writeRequestTimeseries, protowire.BytesType)
+		request = protowire.AppendBytes(request, series)
+	}
+	return request
+}
@@ -0,0 +1,234 @@
+// Package remotewrite pushes the metrics of the exporter to a Prometheus remote-write endpoint, for exporters that
+// cannot be scraped, e.g. behind NAT.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var log = logging.Logger("remotewrite")
+
+// retryDelay is the delay before the first retry, it doubles with every attempt
+var retryDelay = time.Second
+
+// Client gathers the metrics periodically into an in-memory queue and sends it in order. Samples are only removed from
+// the queue once they were sent, rejected, or failed after all retries. If the endpoint is unreachable for long, the
+// oldest samples are dropped to keep the queue bounded.
+type Client struct {
+	config   config.RemoteWrite
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	descs    map[string]*prometheus.Desc
+	queued   chan struct{}
+
+	mu      sync.Mutex
+	queue   []sample
+	sending int
+	sent    uint64
+	failed  uint64
+	retried uint64
+	dropped uint64
+}
+
+// sendError is a failed request that may be retried after the delay.
+type sendError struct {
+	err        error
+	retryable  bool
+	retryAfter time.Duration
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func New(remoteWriteConfig config.RemoteWrite, gatherer prometheus.Gatherer) *Client {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("ttn", "gateway", name), help, nil, nil)
+	}
+	return &Client{
+		config:   remoteWriteConfig,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: remoteWriteConfig.Timeout},
+		descs: map[string]*prometheus.Desc{
+			"sent":    desc("remote_write_sent_samples_total", "Number of samples sent to the remote-write endpoint"),
+			"failed":  desc("remote_write_failed_samples_total", "Number of samples the remote-write endpoint rejected or that failed after all retries"),
+			"retried": desc("remote_write_retried_samples_total", "Number of samples whose request was retried"),
+			"dropped": desc("remote_write_dropped_samples_total", "Number of samples dropped because the queue was full"),
+			"pending": desc("remote_write_pending_samples", "Number of samples waiting to be sent"),
+		},
+		queued: make(chan struct{}, 1),
+	}
+}
+
+func (c *Client) Describe(descs chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		descs <- desc
+	}
+}
+
+func (c *Client) Collect(metrics chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metrics <- prometheus.MustNewConstMetric(c.descs["sent"], prometheus.CounterValue, float64(c.sent))
+	metrics <- prometheus.MustNewConstMetric(c.descs["failed"], prometheus.CounterValue, float64(c.failed))
+	metrics <- prometheus.MustNewConstMetric(c.descs["retried"], prometheus.CounterValue, float64(c.retried))
+	metrics <- prometheus.MustNewConstMetric(c.descs["dropped"], prometheus.CounterValue, float64(c.dropped))
+	metrics <- prometheus.MustNewConstMetric(c.descs["pending"], prometheus.GaugeValue, float64(len(c.queue)+c.sending))
+}
+
+// Run gathers the metrics every interval and sends the queue in the background until the context is cancelled.
+func (c *Client) Run(ctx context.Context) {
+	go c.send(ctx)
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		c.gather()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) gather() {
+	families, err := c.gatherer.Gather()
+	if err != nil {
+		// the families that could be gathered are still returned
+		log.Warnw("error gathering metrics for remote write", "error", err)
+	}
+	samples := samplesOf(families, c.config.ExternalLabels, time.Now().UnixNano()/int64(time.Millisecond))
+
+	c.mu.Lock()
+	queue := append(c.queue, samples...)
+	if excess := len(queue) - c.config.QueueCapacity; excess > 0 {
+		c.dropped += uint64(excess)
+		log.Warnw("remote write queue full, dropping oldest samples", "dropped", excess)
+		queue = queue[excess:]
+	}
+	c.queue = queue
+	c.mu.Unlock()
+
+	select {
+	case c.queued <- struct{}{}:
+	default:
+	}
+}
+
+// send sends the queue in requests of at most MaxSamplesPerSend samples, oldest first.
+func (c *Client) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.queued:
+		}
+		for {
+			c.mu.Lock()
+			size := len(c.queue)
+			if size > c.config.MaxSamplesPerSend {
+				size = c.config.MaxSamplesPerSend
+			}
+			batch := c.queue[:size:size]
+			c.queue = c.queue[size:]
+			c.sending = size
+			c.mu.Unlock()
+			if len(batch) == 0 {
+				break
+			}
+
+			err := c.sendWithRetries(ctx, batch)
+
+			c.mu.Lock()
+			c.sending = 0
+			if err == nil {
+				c.sent += uint64(len(batch))
+			} else {
+				c.failed += uint64(len(batch))
+			}
+			c.mu.Unlock()
+			if err != nil {
+				log.Errorw("remote write error, giving up samples", "url", c.config.URL, "samples", len(batch), "error", err)
+			}
+		}
+	}
+}
+
+// sendWithRetries sends a batch, retrying retryable errors with exponential backoff up to MaxRetries times.
+func (c *Client) sendWithRetries(ctx context.Context, batch []sample) error {
+	request := snappy.Encode(nil, encodeWriteRequest(batch))
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.post(ctx, request)
+		var sendErr *sendError
+		if err == nil || !errors.As(err, &sendErr) || !sendErr.retryable || attempt == c.config.MaxRetries {
+			return err
+		}
+		if sendErr.retryAfter > delay {
+			delay = sendErr.retryAfter
+		}
+		if delay > c.config.MaxBackoff {
+			delay = c.config.MaxBackoff
+		}
+		c.mu.Lock()
+		c.retried += uint64(len(batch))
+		c.mu.Unlock()
+		log.Debugw("remote write failed, retrying", "error", err, "retryIn", delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) post(ctx context.Context, request []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.URL, bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	for name, value := range c.config.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "ttn-gateway-exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case c.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.config.BearerToken)
+	case c.config.Username != "":
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return &sendError{err: err, retryable: true}
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 4*1024))
+	switch {
+	case res.StatusCode/100 == 2:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode/100 == 5:
+		retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return &sendError{err: fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body)), retryable: true, retryAfter: time.Duration(retryAfter) * time.Second}
+	default:
+		// other client errors, e.g. out of order samples, won't succeed when retried
+		return &sendError{err: fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))}
+	}
+}
@@ -0,0 +1,330 @@
+package remotewrite
+
+import (
+	"context"
+	"github.com/golang/snappy"
+	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a remote-write endpoint that answers with the given statuses in turn, the last one repeatedly.
+type receiver struct {
+	*httptest.Server
+	statuses []int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	samples  [][]sample
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		decoded, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("snappy: %v", err)
+		}
+		r.mu.Lock()
+		status := r.statuses[len(r.statuses)-1]
+		if len(r.requests) < len(r.statuses) {
+			status = r.statuses[len(r.requests)]
+		}
+		r.requests = append(r.requests, req)
+		r.samples = append(r.samples, decodeWriteRequest(t, decoded))
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) received() ([]*http.Request, [][]sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*http.Request(nil), r.requests...), append([][]sample(nil), r.samples...)
+}
+
+// decodeWriteRequest decodes a prompb.WriteRequest as encoded by encodeWriteRequest.
+func decodeWriteRequest(t *testing.T, b []byte) []sample {
+	t.Helper()
+	var samples []sample
+	eachField(t, b, func(number protowire.Number, value []byte, _ uint64) {
+		if number != writeRequestTimeseries {
+			t.Errorf("unexpected WriteRequest field %d", number)
+			return
+		}
+		var s sample
+		eachField(t, value, func(number protowire.Number, value []byte, _ uint64) {
+			switch number {
+			case timeSeriesLabels:
+				var l label
+				eachField(t, value, func(number protowire.Number, value []byte, _ uint64) {
+					switch number {
+					case labelName:
+						l.name = string(value)
+					case labelValue:
+						l.value = string(value)
+					}
+				})
+				s.labels = append(s.labels, l)
+			case timeSeriesSamples:
+				eachField(t, value, func(number protowire.Number, _ []byte, scalar uint64) {
+					switch number {
+					case sampleValue:
+						s.value = math.Float64frombits(scalar)
+					case sampleTimestamp:
+						s.timestampMs = int64(scalar)
+					}
+				})
+			}
+		})
+		samples = append(samples, s)
+	})
+	return samples
+}
+
+// eachField calls fn with the bytes of length-delimited fields and the value of varint and fixed64 fields.
+func eachField(t *testing.T, b []byte, fn func(number protowire.Number, value []byte, scalar uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("invalid bytes: %v", protowire.ParseError(n))
+			}
+			fn(number, value, 0)
+			b = b[n:]
+		case protowire.VarintType:
+			value, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("invalid varint: %v", protowire.ParseError(n))
+			}
+			fn(number, nil, value)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				t.Fatalf("invalid fixed64: %v", protowire.ParseError(n))
+			}
+			fn(number, nil, value)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+}
+
+func newTestClient(url string, registry *prometheus.Registry) *Client {
+	return New(config.RemoteWrite{
+		URL:               url,
+		Timeout:           5 * time.Second,
+		QueueCapacity:     1000,
+		MaxSamplesPerSend: 1000,
+		MaxRetries:        2,
+		MaxBackoff:        10 * time.Millisecond,
+	}, registry)
+}
+
+// sendQueue sends the queue of the client and waits until count samples were sent or given up.
+func sendQueue(t *testing.T, c *Client, count uint64) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.send(ctx)
+	c.queued <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		done := c.sent+c.failed >= count
+		c.mu.Unlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("samples not sent within 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteRequest(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "ttn_gateway_up"}, []string{"gateway_id", "tenant", "cluster"})
+	up.WithLabelValues("my-gateway", "", "eu1").Set(1)
+	rssi := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ttn_gateway_rssi", Buckets: []float64{-100, -50}})
+	rssi.Observe(-80)
+	rssi.Observe(-30)
+	registry.MustRegister(up, rssi)
+
+	server := newReceiver(t, http.StatusNoContent)
+	c := newTestClient(server.URL, registry)
+	c.config.ExternalLabels = map[string]string{"cluster": "external", "exporter": "heilbronn"}
+	c.gather()
+	sendQueue(t, c, 6)
+
+	_, requests := server.received()
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	values := map[string]float64{}
+	for _, s := range requests[0] {
+		var key string
+		for i, l := range s.labels {
+			if i > 0 && s.labels[i-1].name >= l.name {
+				t.Errorf("labels %v are not sorted by name", s.labels)
+			}
+			if l.value == "" {
+				t.Errorf("labels %v contain an empty label", s.labels)
+			}
+			key += l.name + "=" + l.value + ","
+		}
+		if s.timestampMs == 0 {
+			t.Errorf("sample %v has no timestamp", s)
+		}
+		values[key] = s.value
+	}
+	want := map[string]float64{
+		// the cluster of a series wins over the external label, series without one get it; the empty tenant is dropped
+		"__name__=ttn_gateway_up,cluster=eu1,exporter=heilbronn,gateway_id=my-gateway,": 1,
+		"__name__=ttn_gateway_rssi_bucket,cluster=external,exporter=heilbronn,le=-100,": 0,
+		"__name__=ttn_gateway_rssi_bucket,cluster=external,exporter=heilbronn,le=-50,":  1,
+		"__name__=ttn_gateway_rssi_bucket,cluster=external,exporter=heilbronn,le=+Inf,": 2,
+		"__name__=ttn_gateway_rssi_sum,cluster=external,exporter=heilbronn,":            -110,
+		"__name__=ttn_gateway_rssi_count,cluster=external,exporter=heilbronn,":          2,
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("series = %v, want %v", values, want)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	tests := []struct {
+		name string
+		rw   config.RemoteWrite
+		want string
+	}{
+		{name: "bearer", rw: config.RemoteWrite{BearerToken: "token", Username: "ignored"}, want: "Bearer token"},
+		{name: "basic", rw: config.RemoteWrite{Username: "exporter", Password: "secret"}, want: "Basic ZXhwb3J0ZXI6c2VjcmV0"},
+		{name: "none", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newReceiver(t, http.StatusOK)
+			c := newTestClient(server.URL, prometheus.NewRegistry())
+			c.config.BearerToken, c.config.Username, c.config.Password = test.rw.BearerToken, test.rw.Username, test.rw.Password
+			c.config.Headers = map[string]string{"X-Scope-OrgID": "acme"}
+			if err := c.sendWithRetries(context.Background(), []sample{{labels: []label{{name: "__name__", value: "up"}}, value: 1}}); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			requests, _ := server.received()
+			header := requests[0].Header
+			if got := header.Get("Authorization"); got != test.want {
+				t.Errorf("Authorization = %q, want %q", got, test.want)
+			}
+			for name, want := range map[string]string{
+				"Content-Encoding":                  "snappy",
+				"Content-Type":                      "application/x-protobuf",
+				"X-Prometheus-Remote-Write-Version": "0.1.0",
+				"X-Scope-OrgID":                     "acme",
+			} {
+				if got := header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = time.Millisecond
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantSent     uint64
+		wantFailed   uint64
+		wantRetried  uint64
+	}{
+		{name: "success", statuses: []int{http.StatusNoContent}, wantRequests: 1, wantSent: 2},
+		{name: "server error then success", statuses: []int{http.StatusServiceUnavailable, http.StatusNoContent}, wantRequests: 2, wantSent: 2, wantRetried: 2},
+		{name: "too many requests then success", statuses: []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}, wantRequests: 3, wantSent: 2, wantRetried: 4},
+		{name: "server error after all retries", statuses: []int{http.StatusInternalServerError}, wantRequests: 3, wantFailed: 2, wantRetried: 4},
+		{name: "bad request is not retried", statuses: []int{http.StatusBadRequest}, wantRequests: 1, wantFailed: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "ttn_gateway_up"}, []string{"gateway_id"})
+			up.WithLabelValues("gateway-one").Set(1)
+			up.WithLabelValues("gateway-two").Set(0)
+			registry.MustRegister(up)
+
+			server := newReceiver(t, test.statuses...)
+			c := newTestClient(server.URL, registry)
+			c.gather()
+			sendQueue(t, c, 2)
+
+			requests, _ := server.received()
+			if len(requests) != test.wantRequests {
+				t.Errorf("got %d requests, want %d", len(requests), test.wantRequests)
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.sent != test.wantSent || c.failed != test.wantFailed || c.retried != test.wantRetried {
+				t.Errorf("sent, failed, retried = %d, %d, %d, want %d, %d, %d", c.sent, c.failed, c.retried, test.wantSent, test.wantFailed, test.wantRetried)
+			}
+		})
+	}
+}
+
+func TestQueueDropsOldest(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "ttn_gateway_up"}, []string{"gateway_id"})
+	registry.MustRegister(up)
+
+	server := newReceiver(t, http.StatusOK)
+	c := newTestClient(server.URL, registry)
+	c.config.QueueCapacity = 3
+	up.WithLabelValues("gateway-one").Set(1)
+	up.WithLabelValues("gateway-two").Set(2)
+	c.gather()
+	up.WithLabelValues("gateway-one").Set(3)
+	up.WithLabelValues("gateway-two").Set(4)
+	c.gather()
+
+	c.mu.Lock()
+	dropped := c.dropped
+	c.mu.Unlock()
+	if dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+	sendQueue(t, c, 3)
+	_, requests := server.received()
+	var values []float64
+	for _, request := range requests {
+		for _, s := range request {
+			values = append(values, s.value)
+		}
+	}
+	if want := []float64{2, 3, 4}; !reflect.DeepEqual(values, want) {
+		t.Errorf("sent values = %v, want %v", values, want)
+	}
+}
@@ -7,20 +7,35 @@ import (
 
 type Server struct {
 	server *http.Server
+	mux    *http.ServeMux
 }
 
 func NewServer(addr string) *Server {
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	server := newServer(addr)
+	server.mux.Handle("/metrics", promhttp.Handler())
+	return server
+}
+
+// NewPublicServer returns a server without /metrics, e.g. for endpoints that are exposed to the public.
+func NewPublicServer(addr string) *Server {
+	return newServer(addr)
+}
 
+func newServer(addr string) *Server {
+	mux := http.NewServeMux()
 	httpServer := &http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
-	server := &Server{
+	return &Server{
 		server: httpServer,
+		mux:    mux,
 	}
-	return server
+}
+
+// Handle registers an additional handler.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
 }
 
 func (s *Server) ListenAndServe() error {
@@ -0,0 +1,52 @@
+package ttnclient
+
+import (
+	"context"
+	"time"
+)
+
+// AuthInfo https://www.thethingsindustries.com/docs/reference/api/entity_access/#message:AuthInfoResponse
+type AuthInfo struct {
+	APIKey          *APIKeyAccess `json:"api_key"`
+	UniversalRights Rights        `json:"universal_rights"`
+	IsAdmin         bool          `json:"is_admin"`
+}
+
+// APIKeyAccess https://www.thethingsindustries.com/docs/reference/api/entity_access/#message:AuthInfoResponse.APIKeyAccess
+type APIKeyAccess struct {
+	APIKey    APIKey    `json:"api_key"`
+	EntityIDs EntityIDs `json:"entity_ids"`
+}
+
+// APIKey https://www.thethingsindustries.com/docs/reference/api/entity_access/#message:APIKey
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Rights    []string   `json:"rights"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// EntityIDs https://www.thethingsindustries.com/docs/reference/api/entity_access/#message:EntityIdentifiers
+type EntityIDs struct {
+	GatewayIDs *struct {
+		GatewayID string `json:"gateway_id"`
+	} `json:"gateway_ids,omitempty"`
+	UserIDs *struct {
+		UserID string `json:"user_id"`
+	} `json:"user_ids,omitempty"`
+	OrganizationIDs *struct {
+		OrganizationID string `json:"organization_id"`
+	} `json:"organization_ids,omitempty"`
+}
+
+// Rights https://www.thethingsindustries.com/docs/reference/api/entity_access/#message:Rights
+type Rights struct {
+	Rights []string `json:"rights"`
+}
+
+// GetAuthInfo returns information about the credentials used by the client.
+func (client *TTNClient) GetAuthInfo(ctx context.Context) (info AuthInfo, err error) {
+	err = client.get(ctx, "/api/v3/auth_info", nil, &info)
+	return info, err
+}
@@ -1,8 +1,13 @@
 package ttnclient
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Authenticator interface {
@@ -17,3 +22,97 @@ func (a ApiKeyAuthenticator) Authenticate(request *http.Request) error {
 	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.ApiKey))
 	return nil
 }
+
+// FileApiKeyAuthenticator reads the API key from a file and re-reads it whenever the file's modification time changes,
+// so keys can be rotated without restarting the exporter.
+type FileApiKeyAuthenticator struct {
+	Path string
+
+	mu      sync.Mutex
+	apiKey  string
+	modTime time.Time
+}
+
+func (a *FileApiKeyAuthenticator) Authenticate(request *http.Request) error {
+	apiKey, err := a.currentKey()
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	return nil
+}
+
+func (a *FileApiKeyAuthenticator) currentKey() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.Path)
+	if err != nil {
+		if a.apiKey != "" {
+			// keep using the last known key while the file is being replaced
+			log.Warnw("api key file not readable, using previous key", "path", a.Path, "error", err)
+			return a.apiKey, nil
+		}
+		return "", err
+	}
+	if a.apiKey != "" && info.ModTime().Equal(a.modTime) {
+		return a.apiKey, nil
+	}
+
+	content, err := os.ReadFile(a.Path)
+	if err != nil {
+		if a.apiKey != "" {
+			log.Warnw("api key file not readable, using previous key", "path", a.Path, "error", err)
+			return a.apiKey, nil
+		}
+		return "", err
+	}
+	apiKey := strings.TrimSpace(string(content))
+	if apiKey == "" {
+		if a.apiKey != "" {
+			return a.apiKey, nil
+		}
+		return "", fmt.Errorf("api key file %s is empty", a.Path)
+	}
+	if a.apiKey != "" && apiKey != a.apiKey {
+		log.Infow("api key file changed, using new key", "path", a.Path)
+	}
+	a.apiKey = apiKey
+	a.modTime = info.ModTime()
+	return a.apiKey, nil
+}
+
+// CommandApiKeyAuthenticator uses the API key printed by a command. The key is kept until the API rejects it, then the
+// command is run again, so rotated keys are picked up without restarting the exporter.
+type CommandApiKeyAuthenticator struct {
+	run func(ctx context.Context) (string, error)
+
+	mu     sync.Mutex
+	apiKey string
+}
+
+// NewCommandApiKeyAuthenticator creates an authenticator that starts with apiKey, if set, and gets new keys from run.
+func NewCommandApiKeyAuthenticator(apiKey string, run func(ctx context.Context) (string, error)) *CommandApiKeyAuthenticator {
+	return &CommandApiKeyAuthenticator{run: run, apiKey: apiKey}
+}
+
+func (a *CommandApiKeyAuthenticator) Authenticate(request *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.apiKey == "" {
+		apiKey, err := a.run(request.Context())
+		if err != nil {
+			return err
+		}
+		a.apiKey = apiKey
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.apiKey))
+	return nil
+}
+
+// Invalidate drops the key, so the next request runs the command again.
+func (a *CommandApiKeyAuthenticator) Invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.apiKey = ""
+}
@@ -0,0 +1,141 @@
+package ttnclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCommandApiKeyAuthenticatorRotation(t *testing.T) {
+	accepted := "key-1"
+	var apiRequests int
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiRequests++
+		if r.Header.Get("Authorization") != "Bearer "+accepted {
+			http.Error(w, `{"code":16,"message":"api key not found"}`, http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"protocol":"udp"}`))
+	}))
+	defer api.Close()
+
+	runs := 0
+	authenticator := NewCommandApiKeyAuthenticator("key-1", func(ctx context.Context) (string, error) {
+		runs++
+		return fmt.Sprintf("key-%d", runs+1), nil
+	})
+	client, err := NewTTNClient(api.URL, authenticator, api.Client())
+	if err != nil {
+		t.Fatalf("NewTTNClient: %v", err)
+	}
+
+	// the key resolved at startup is used without running the command
+	if _, err := client.GetGatewayConnectionStats(context.Background(), "gw-1"); err != nil {
+		t.Fatalf("GetGatewayConnectionStats: %v", err)
+	}
+	if runs != 0 || apiRequests != 1 {
+		t.Errorf("runs = %d, API requests = %d, want 0 and 1", runs, apiRequests)
+	}
+
+	// once the key is rotated, the rejected request is retried with the key the command prints now
+	accepted = "key-2"
+	if _, err := client.GetGatewayConnectionStats(context.Background(), "gw-1"); err != nil {
+		t.Fatalf("GetGatewayConnectionStats after rotation: %v", err)
+	}
+	if runs != 1 || apiRequests != 3 {
+		t.Errorf("runs = %d, API requests = %d, want 1 and 3", runs, apiRequests)
+	}
+	if _, err := client.GetGatewayConnectionStats(context.Background(), "gw-1"); err != nil {
+		t.Fatalf("GetGatewayConnectionStats with rotated key: %v", err)
+	}
+	if runs != 1 || apiRequests != 4 {
+		t.Errorf("runs = %d, API requests = %d, want the rotated key to be kept", runs, apiRequests)
+	}
+}
+
+func TestCommandApiKeyAuthenticatorError(t *testing.T) {
+	authenticator := NewCommandApiKeyAuthenticator("", func(ctx context.Context) (string, error) {
+		return "", fmt.Errorf("api_key_command pass: exit status 1")
+	})
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err := authenticator.Authenticate(req); err == nil {
+		t.Errorf("Authenticate succeeded with Authorization %q, want error", req.Header.Get("Authorization"))
+	}
+}
+
+func TestFileApiKeyAuthenticatorReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-key")
+	modTime := time.Now().Add(-time.Hour)
+	writeKey := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("writing api key file: %v", err)
+		}
+		// the file system may not resolve writes within the same second, so every write gets a later mtime
+		modTime = modTime.Add(time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("touching api key file: %v", err)
+		}
+	}
+	authenticator := &FileApiKeyAuthenticator{Path: path}
+	authorization := func() string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		if err := authenticator.Authenticate(req); err != nil {
+			t.Fatalf("Authenticate: %v", err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	writeKey("key-1\n")
+	if got := authorization(); got != "Bearer key-1" {
+		t.Errorf("Authorization = %q, want the key of the file without the newline", got)
+	}
+
+	writeKey("key-2\n")
+	if got := authorization(); got != "Bearer key-2" {
+		t.Errorf("Authorization after rewriting the file = %q, want the new key", got)
+	}
+
+	// an empty file is a rotation in progress
+	writeKey("")
+	if got := authorization(); got != "Bearer key-2" {
+		t.Errorf("Authorization with an empty file = %q, want the previous key", got)
+	}
+
+	// a directory at the path can be stat'ed but not read, even by root
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if got := authorization(); got != "Bearer key-2" {
+		t.Errorf("Authorization with an unreadable file = %q, want the previous key", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := authorization(); got != "Bearer key-2" {
+		t.Errorf("Authorization with a removed file = %q, want the previous key", got)
+	}
+
+	writeKey("key-3")
+	if got := authorization(); got != "Bearer key-3" {
+		t.Errorf("Authorization after restoring the file = %q, want the new key", got)
+	}
+}
+
+func TestFileApiKeyAuthenticatorMissingFile(t *testing.T) {
+	authenticator := &FileApiKeyAuthenticator{Path: filepath.Join(t.TempDir(), "api-key")}
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err := authenticator.Authenticate(req); err == nil {
+		t.Errorf("Authenticate succeeded with Authorization %q, want error without a previous key", req.Header.Get("Authorization"))
+	}
+}
@@ -1,18 +1,17 @@
 package ttnclient
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/opendata-heilbronn/ttn-gateway-exporter/internal/logging"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io"
 	"net/http"
 	"net/url"
 	"path"
 	"strconv"
-	"time"
 )
 
 var log = logging.Logger("ttn-client")
@@ -55,51 +54,62 @@ func init() {
 	)
 }
 
+// invalidator is implemented by authenticators that cache credentials which may be rejected before they expire.
+type invalidator interface {
+	Invalidate()
+}
+
 type TTNClient struct {
 	baseUrl       url.URL
 	authenticator Authenticator
-	http          http.Client
+	http          *http.Client
 }
 
-func NewTTNClient(baseUrl string, authenticator Authenticator) (*TTNClient, error) {
+// NewTTNClient creates a client for the TTN API at baseUrl. If httpClient is nil, an HTTP client with default
+// options is used.
+func NewTTNClient(baseUrl string, authenticator Authenticator, httpClient *http.Client) (*TTNClient, error) {
 	parsedUrl, err := url.ParseRequestURI(baseUrl)
 	if err != nil {
 		return nil, err
 	}
 
+	if httpClient == nil {
+		httpClient, err = NewHTTPClient(HTTPOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &TTNClient{
 		baseUrl:       *parsedUrl,
 		authenticator: authenticator,
-		http: http.Client{
-			Timeout: 10 * time.Second,
-			Transport: promhttp.InstrumentRoundTripperDuration(
-				ttnApiRequestDuration,
-				promhttp.InstrumentRoundTripperInFlight(
-					ttnApiRequestsInFlight,
-					http.DefaultTransport,
-				),
-			),
-		},
+		http:          httpClient,
 	}, nil
 }
 
 func (client *TTNClient) GetGatewayConnectionStats(ctx context.Context, gatewayId string) (stats GatewayConnectionStats, err error) {
-	reqUrl := client.baseUrl
-	reqUrl.Path = path.Join(reqUrl.Path, fmt.Sprintf("/api/v3/gs/gateways/%s/connection/stats", gatewayId))
+	err = client.get(ctx, fmt.Sprintf("/api/v3/gs/gateways/%s/connection/stats", gatewayId), nil, &stats)
+	return stats, err
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl.String(), nil)
+// get requests the given API path and decodes the JSON response into out.
+func (client *TTNClient) get(ctx context.Context, apiPath string, query url.Values, out interface{}) error {
+	body, err := client.getRaw(ctx, apiPath, query)
 	if err != nil {
-		return stats, err
+		return err
 	}
+	return json.Unmarshal(body, out)
+}
 
-	err = client.authenticator.Authenticate(req)
-	if err != nil {
-		return stats, err
-	}
+// getRaw requests the given API path and returns the response body.
+func (client *TTNClient) getRaw(ctx context.Context, apiPath string, query url.Values) (body []byte, err error) {
+	reqUrl := client.baseUrl
+	reqUrl.Path = path.Join(reqUrl.Path, apiPath)
+	reqUrl.RawQuery = query.Encode()
 
-	resp, err := client.http.Do(req)
+	resp, err := client.do(ctx, http.MethodGet, reqUrl.String(), nil)
 	if err != nil {
-		return stats, err
+		return nil, err
 	}
 	defer func() {
 		closeErr := resp.Body.Close()
@@ -124,22 +134,49 @@ func (client *TTNClient) GetGatewayConnectionStats(ctx context.Context, gatewayI
 		}
 	}
 
+	respBuf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
 	if resp.StatusCode != 200 {
-		respBuf, err := io.ReadAll(resp.Body)
+		return nil, newAPIError(resp, respBuf)
+	}
+	return respBuf, nil
+}
+
+// do sends an authenticated request. If the credentials are rejected and the authenticator caches them, they are
+// invalidated and the request is retried once.
+func (client *TTNClient) do(ctx context.Context, method, reqUrl string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, reqUrl, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		err = client.authenticator.Authenticate(req)
 		if err != nil {
-			return stats, err
+			return nil, err
 		}
-		var potentialJsonBody map[string]interface{}
-		err = json.Unmarshal(respBuf, &potentialJsonBody)
+
+		resp, err := client.http.Do(req)
 		if err != nil {
-			return stats, fmt.Errorf("TTN API responded with non 200 status code %s: %s", resp.Status, string(respBuf))
+			return nil, err
 		}
-		return stats, fmt.Errorf("TTN API responded with non 200 status code %s: %#v", resp.Status, potentialJsonBody)
-	}
 
-	err = json.NewDecoder(resp.Body).Decode(&stats)
-	if err != nil {
-		return stats, err
+		cachingAuthenticator, ok := client.authenticator.(invalidator)
+		if resp.StatusCode != http.StatusUnauthorized || !ok || attempt > 0 {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		log.Infow("credentials rejected, retrying with fresh credentials", "url", reqUrl)
+		cachingAuthenticator.Invalidate()
 	}
-	return stats, nil
 }
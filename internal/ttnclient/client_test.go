@@ -0,0 +1,48 @@
+package ttnclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRequestPaths(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.RequestURI)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer api.Close()
+	client, err := NewTTNClient(api.URL+"/prefix", ApiKeyAuthenticator{ApiKey: "NNSXS.TEST"}, api.Client())
+	if err != nil {
+		t.Fatalf("NewTTNClient: %v", err)
+	}
+
+	// IDs are escaped exactly once
+	ctx := context.Background()
+	_, _ = client.GetGatewayConnectionStats(ctx, "my gateway")
+	_, _ = client.GetGateway(ctx, "my gateway")
+	_, _ = client.GetBand(ctx, "EU 863")
+	_, _ = client.GetGatewayConfiguration(ctx, "my gateway", "/api/v3/gcs/gateways/{gateway_id}/semtechudp/global_conf.json")
+	want := []string{
+		"/prefix/api/v3/gs/gateways/my%20gateway/connection/stats",
+		"/prefix/api/v3/gateways/my%20gateway",
+		"/prefix/api/v3/configuration/bands/EU%20863",
+		"/prefix/api/v3/gcs/gateways/my%20gateway/semtechudp/global_conf.json",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requested) != len(want) {
+		t.Fatalf("requested %v, want %v", requested, want)
+	}
+	for i := range want {
+		if requested[i] != want[i] {
+			t.Errorf("requested %s, want %s", requested[i], want[i])
+		}
+	}
+}
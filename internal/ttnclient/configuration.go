@@ -0,0 +1,53 @@
+package ttnclient
+
+import (
+	"context"
+	"fmt"
+)
+
+// FrequencyPlanDescription https://www.thethingsindustries.com/docs/reference/api/configuration/#message:FrequencyPlanDescription
+type FrequencyPlanDescription struct {
+	ID     string `json:"id"`
+	BaseID string `json:"base_id"`
+	Name   string `json:"name"`
+	// BaseFrequency is in MHz, e.g. 868 or 915
+	BaseFrequency uint32 `json:"base_frequency"`
+	BandID        string `json:"band_id"`
+}
+
+// BandDescription https://www.thethingsindustries.com/docs/reference/api/configuration/#message:BandDescription
+type BandDescription struct {
+	ID       string                   `json:"id"`
+	SubBands []BandDescriptionSubBand `json:"sub_bands"`
+}
+
+// BandDescriptionSubBand https://www.thethingsindustries.com/docs/reference/api/configuration/#message:BandDescription.SubBandParameters
+type BandDescriptionSubBand struct {
+	MinFrequency string  `json:"min_frequency"`
+	MaxFrequency string  `json:"max_frequency"`
+	DutyCycle    float64 `json:"duty_cycle"`
+}
+
+// ListFrequencyPlans returns all frequency plans known to the cluster.
+func (client *TTNClient) ListFrequencyPlans(ctx context.Context) ([]FrequencyPlanDescription, error) {
+	var response struct {
+		FrequencyPlans []FrequencyPlanDescription `json:"frequency_plans"`
+	}
+	err := client.get(ctx, "/api/v3/configuration/frequency-plans", nil, &response)
+	return response.FrequencyPlans, err
+}
+
+// GetBand returns the description of a band for every regional parameters version it is defined in, keyed by the
+// PHY version.
+func (client *TTNClient) GetBand(ctx context.Context, bandId string) (map[string]BandDescription, error) {
+	var response struct {
+		Descriptions map[string]struct {
+			Band map[string]BandDescription `json:"band"`
+		} `json:"descriptions"`
+	}
+	err := client.get(ctx, fmt.Sprintf("/api/v3/configuration/bands/%s", bandId), nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Descriptions[bandId].Band, nil
+}
@@ -0,0 +1,75 @@
+package ttnclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// APIError is returned for non 200 responses of the TTN API. It carries the decoded error details of The Things Stack
+// if the response body contained them.
+type APIError struct {
+	StatusCode int
+	Status     string
+	// Code is the gRPC status code reported by The Things Stack
+	Code      int
+	Namespace string
+	Name      string
+	Message   string
+	Body      string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("TTN API responded with non 200 status code %s: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("TTN API responded with non 200 status code %s: %s", e.Status, e.Body)
+}
+
+// NotFound reports whether the requested entity does not exist or is not available on the requested cluster.
+func (e *APIError) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// NotConnected reports whether the gateway is registered, but not connected to the Gateway Server.
+func (e *APIError) NotConnected() bool {
+	return e.NotFound() && e.Name == "not_connected"
+}
+
+// Unauthorized reports whether the credentials were rejected.
+func (e *APIError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized
+}
+
+// Forbidden reports whether the credentials lack the rights for the request.
+func (e *APIError) Forbidden() bool {
+	return e.StatusCode == http.StatusForbidden
+}
+
+// https://www.thethingsindustries.com/docs/reference/api/concepts/#errors
+type errorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Details []struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"details"`
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+	var decoded errorResponse
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		apiErr.Code = decoded.Code
+		apiErr.Message = decoded.Message
+		if len(decoded.Details) > 0 {
+			apiErr.Namespace = decoded.Details[0].Namespace
+			apiErr.Name = decoded.Details[0].Name
+		}
+	}
+	return apiErr
+}
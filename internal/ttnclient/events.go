@@ -0,0 +1,140 @@
+package ttnclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+)
+
+// Event https://www.thethingsindustries.com/docs/reference/api/events/#message:Event
+type Event struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	// Data is the payload of the event, its type depends on the event name
+	Data json.RawMessage `json:"data"`
+}
+
+// GatewayUplinkMessage is the data of gs.up.receive events. It wraps the uplink with the band of the gateway's
+// frequency plan.
+// https://www.thethingsindustries.com/docs/reference/api/gateway_server/#message:GatewayUplinkMessage
+type GatewayUplinkMessage struct {
+	Message *UplinkMessage `json:"message"`
+	BandID  string         `json:"band_id"`
+}
+
+// UplinkMessage is an uplink as received by a gateway.
+// https://www.thethingsindustries.com/docs/reference/api/end_device/#message:UplinkMessage
+type UplinkMessage struct {
+	RawPayload []byte     `json:"raw_payload"`
+	Settings   TxSettings `json:"settings"`
+}
+
+// DecodeGatewayUplinkMessage decodes the data of a gs.up.receive event. Older versions of The Things Stack send the
+// bare UplinkMessage, which is returned without band.
+func DecodeGatewayUplinkMessage(data []byte) (GatewayUplinkMessage, error) {
+	var message GatewayUplinkMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return GatewayUplinkMessage{}, err
+	}
+	if message.Message != nil {
+		return message, nil
+	}
+	var uplink UplinkMessage
+	if err := json.Unmarshal(data, &uplink); err != nil {
+		return GatewayUplinkMessage{}, err
+	}
+	return GatewayUplinkMessage{Message: &uplink}, nil
+}
+
+// DownlinkMessage is the data of gs.down.send events.
+// https://www.thethingsindustries.com/docs/reference/api/end_device/#message:DownlinkMessage
+type DownlinkMessage struct {
+	RawPayload []byte      `json:"raw_payload"`
+	Scheduled  *TxSettings `json:"scheduled"`
+}
+
+// TxSettings https://www.thethingsindustries.com/docs/reference/api/end_device/#message:TxSettings
+type TxSettings struct {
+	DataRate DataRate `json:"data_rate"`
+	// CodingRate is set by older versions of The Things Stack, newer ones set it in the LoRa data rate
+	CodingRate string `json:"coding_rate"`
+	Frequency  string `json:"frequency"`
+}
+
+// DataRate https://www.thethingsindustries.com/docs/reference/api/end_device/#message:DataRate
+type DataRate struct {
+	LoRa *LoRaDataRate `json:"lora"`
+}
+
+// LoRaDataRate https://www.thethingsindustries.com/docs/reference/api/end_device/#message:LoRaDataRate
+type LoRaDataRate struct {
+	Bandwidth       uint32 `json:"bandwidth"`
+	SpreadingFactor uint32 `json:"spreading_factor"`
+	CodingRate      string `json:"coding_rate"`
+}
+
+// StreamGatewayEvents subscribes to the events with the given names of a gateway and calls handle for every event
+// until the stream ends or the context is cancelled. The request timeout of the HTTP client doesn't apply to the
+// stream.
+func (client *TTNClient) StreamGatewayEvents(ctx context.Context, gatewayId string, names []string, handle func(Event)) (err error) {
+	request, err := json.Marshal(map[string]interface{}{
+		"identifiers": []interface{}{
+			map[string]interface{}{"gateway_ids": map[string]string{"gateway_id": gatewayId}},
+		},
+		"names": names,
+	})
+	if err != nil {
+		return err
+	}
+
+	streaming := *client
+	streamingHttp := *client.http
+	streamingHttp.Timeout = 0
+	streaming.http = &streamingHttp
+
+	reqUrl := client.baseUrl
+	reqUrl.Path = path.Join(reqUrl.Path, "/api/v3/events")
+	resp, err := streaming.do(ctx, http.MethodPost, reqUrl.String(), request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := resp.Body.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	if resp.StatusCode != 200 {
+		respBuf, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return newAPIError(resp, respBuf)
+	}
+
+	// the stream is a sequence of JSON objects, each holding either an event or an error
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var message struct {
+			Result *Event           `json:"result"`
+			Error  *json.RawMessage `json:"error"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if message.Error != nil {
+			return fmt.Errorf("event stream error: %s", *message.Error)
+		}
+		if message.Result != nil {
+			handle(*message.Result)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ttnclient
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Gateway https://www.thethingsindustries.com/docs/reference/api/gateway/#message:Gateway
+type Gateway struct {
+	IDs                  GatewayIdentifiers `json:"ids"`
+	Name                 string             `json:"name"`
+	GatewayServerAddress string             `json:"gateway_server_address"`
+	FrequencyPlanIDs     []string           `json:"frequency_plan_ids"`
+	Antennas             []GatewayAntenna   `json:"antennas"`
+	LocationPublic       bool               `json:"location_public"`
+}
+
+// GatewayAntenna https://www.thethingsindustries.com/docs/reference/api/gateway/#message:GatewayAntenna
+type GatewayAntenna struct {
+	Gain     float64   `json:"gain"`
+	Location *Location `json:"location"`
+}
+
+// GatewayIdentifiers https://www.thethingsindustries.com/docs/reference/api/gateway/#message:GatewayIdentifiers
+type GatewayIdentifiers struct {
+	GatewayID string `json:"gateway_id"`
+	EUI       string `json:"eui"`
+}
+
+// GetGateway reads a gateway from the Identity Server. Only the fields in fieldMask are populated.
+func (client *TTNClient) GetGateway(ctx context.Context, gatewayId string, fieldMask ...string) (gateway Gateway, err error) {
+	query := url.Values{}
+	if len(fieldMask) > 0 {
+		query.Set("field_mask", strings.Join(fieldMask, ","))
+	}
+	err = client.get(ctx, fmt.Sprintf("/api/v3/gateways/%s", gatewayId), query, &gateway)
+	return gateway, err
+}
+
+const listGatewaysPageSize = 100
+
+// ListGateways returns all gateways the credentials have access to. For admin keys, these are all gateways of the
+// tenant. Only the fields in fieldMask are populated.
+func (client *TTNClient) ListGateways(ctx context.Context, fieldMask ...string) ([]Gateway, error) {
+	var gateways []Gateway
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("limit", strconv.Itoa(listGatewaysPageSize))
+		query.Set("page", strconv.Itoa(page))
+		if len(fieldMask) > 0 {
+			query.Set("field_mask", strings.Join(fieldMask, ","))
+		}
+
+		var response struct {
+			Gateways []Gateway `json:"gateways"`
+		}
+		err := client.get(ctx, "/api/v3/gateways", query, &response)
+		if err != nil {
+			return nil, err
+		}
+		gateways = append(gateways, response.Gateways...)
+		if len(response.Gateways) < listGatewaysPageSize {
+			return gateways, nil
+		}
+	}
+}
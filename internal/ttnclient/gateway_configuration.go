@@ -0,0 +1,172 @@
+package ttnclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GetGatewayConfiguration reads a packet forwarder configuration from the Gateway Configuration Server. The
+// {gateway_id} placeholder in apiPath is replaced by the gateway ID. The raw document is returned, see
+// ParseGatewayConfiguration.
+func (client *TTNClient) GetGatewayConfiguration(ctx context.Context, gatewayId string, apiPath string) ([]byte, error) {
+	return client.getRaw(ctx, strings.ReplaceAll(apiPath, "{gateway_id}", gatewayId), nil)
+}
+
+// GatewayConfiguration is the channel plan of a packet forwarder configuration.
+type GatewayConfiguration struct {
+	Radios      []Radio
+	Channels    []Channel
+	LBTEnabled  bool
+	AntennaGain float64
+}
+
+// Radio is an RF chain of a concentrator.
+type Radio struct {
+	// Name is radio_N, prefixed with the concentrator index for Basic Station configs with several concentrators
+	Name      string
+	Enabled   bool
+	Frequency uint64
+}
+
+// Channel is an IF channel of a concentrator.
+type Channel struct {
+	// Name is the key in the config, e.g. chan_multiSF_0, chan_Lora_std or chan_FSK
+	Name string
+	// Type is multisf, lora or fsk
+	Type    string
+	Enabled bool
+	Radio   string
+	// Frequency is the center frequency of the radio plus the IF offset of the channel
+	Frequency uint64
+	Bandwidth uint64
+	// MinSpreadingFactor and MaxSpreadingFactor are 0 for FSK channels
+	MinSpreadingFactor int
+	MaxSpreadingFactor int
+}
+
+// concentratorConf holds the scalar settings of the SX1301_conf object shared by global_conf.json and Basic Station
+// router_config. Radios and channels are keyed by index and parsed separately.
+type concentratorConf struct {
+	LBT *struct {
+		Enable bool `json:"enable"`
+	} `json:"lbt_cfg"`
+	AntennaGain float64 `json:"antenna_gain"`
+}
+
+// ParseGatewayConfiguration extracts the channel plan from a Semtech UDP packet forwarder global_conf.json, which has
+// an SX1301_conf object, or from a Basic Station router_config, which has a list of sx1301_conf objects.
+func ParseGatewayConfiguration(raw []byte) (GatewayConfiguration, error) {
+	var document struct {
+		Semtech      json.RawMessage   `json:"SX1301_conf"`
+		BasicStation []json.RawMessage `json:"sx1301_conf"`
+	}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return GatewayConfiguration{}, err
+	}
+
+	var configuration GatewayConfiguration
+	switch {
+	case document.Semtech != nil:
+		if err := configuration.add("", document.Semtech); err != nil {
+			return GatewayConfiguration{}, err
+		}
+	case document.BasicStation != nil:
+		for i, concentrator := range document.BasicStation {
+			prefix := ""
+			if len(document.BasicStation) > 1 {
+				prefix = strconv.Itoa(i) + "/"
+			}
+			if err := configuration.add(prefix, concentrator); err != nil {
+				return GatewayConfiguration{}, err
+			}
+		}
+	default:
+		return GatewayConfiguration{}, fmt.Errorf("neither SX1301_conf nor sx1301_conf found in gateway configuration")
+	}
+	return configuration, nil
+}
+
+// add parses a concentrator config, prefixing radio and channel names with prefix.
+func (c *GatewayConfiguration) add(prefix string, raw json.RawMessage) error {
+	var conf concentratorConf
+	if err := json.Unmarshal(raw, &conf); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+
+	if conf.LBT != nil && conf.LBT.Enable {
+		c.LBTEnabled = true
+	}
+	if conf.AntennaGain > c.AntennaGain {
+		c.AntennaGain = conf.AntennaGain
+	}
+
+	radios := map[int]Radio{}
+	var channelNames []string
+	for name, value := range fields {
+		if index, err := strconv.Atoi(strings.TrimPrefix(name, "radio_")); strings.HasPrefix(name, "radio_") && err == nil {
+			var radio struct {
+				Enable bool   `json:"enable"`
+				Freq   uint64 `json:"freq"`
+			}
+			if err := json.Unmarshal(value, &radio); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			radios[index] = Radio{Name: prefix + name, Enabled: radio.Enable, Frequency: radio.Freq}
+		} else if strings.HasPrefix(name, "chan_") {
+			channelNames = append(channelNames, name)
+		}
+	}
+	radioIndexes := make([]int, 0, len(radios))
+	for index := range radios {
+		radioIndexes = append(radioIndexes, index)
+	}
+	sort.Ints(radioIndexes)
+	for _, index := range radioIndexes {
+		c.Radios = append(c.Radios, radios[index])
+	}
+
+	sort.Strings(channelNames)
+	for _, name := range channelNames {
+		var channel struct {
+			Enable       bool   `json:"enable"`
+			Radio        int    `json:"radio"`
+			IF           int64  `json:"if"`
+			Bandwidth    uint64 `json:"bandwidth"`
+			SpreadFactor int    `json:"spread_factor"`
+		}
+		if err := json.Unmarshal(fields[name], &channel); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		parsed := Channel{
+			Name:      prefix + name,
+			Enabled:   channel.Enable,
+			Radio:     prefix + "radio_" + strconv.Itoa(channel.Radio),
+			Frequency: uint64(int64(radios[channel.Radio].Frequency) + channel.IF),
+			Bandwidth: channel.Bandwidth,
+		}
+		switch {
+		case strings.HasPrefix(name, "chan_multiSF_"):
+			// multi-SF channels demodulate all spreading factors at 125 kHz
+			parsed.Type = "multisf"
+			parsed.Bandwidth = 125000
+			parsed.MinSpreadingFactor, parsed.MaxSpreadingFactor = 7, 12
+		case name == "chan_Lora_std":
+			parsed.Type = "lora"
+			parsed.MinSpreadingFactor, parsed.MaxSpreadingFactor = channel.SpreadFactor, channel.SpreadFactor
+		case name == "chan_FSK":
+			parsed.Type = "fsk"
+		default:
+			continue
+		}
+		c.Channels = append(c.Channels, parsed)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package ttnclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin is how long before its expiry a token is refreshed.
+const tokenExpiryMargin = time.Minute
+
+// OAuth2Authenticator authenticates requests with an OAuth2 access token obtained from the token endpoint of The Things
+// Stack, either through the client credentials grant or through a refresh token. Tokens are cached and refreshed
+// shortly before they expire.
+type OAuth2Authenticator struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// RefreshToken selects the refresh token grant instead of client credentials. If the token endpoint rotates the
+	// refresh token, the new one is used for subsequent refreshes.
+	RefreshToken string
+	Scopes       []string
+	HTTP         *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (a *OAuth2Authenticator) Authenticate(request *http.Request) error {
+	token, err := a.token(request.Context())
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	return nil
+}
+
+// Invalidate drops the cached access token, so the next request fetches a new one.
+func (a *OAuth2Authenticator) Invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.accessToken = ""
+	a.expiresAt = time.Time{}
+}
+
+func (a *OAuth2Authenticator) token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.accessToken != "" && (a.expiresAt.IsZero() || time.Until(a.expiresAt) > tokenExpiryMargin) {
+		return a.accessToken, nil
+	}
+
+	form := url.Values{}
+	if a.RefreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", a.RefreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	httpClient := a.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading oauth2 token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oauth2 token endpoint responded with non 200 status code %s: %s", resp.Status, string(body))
+	}
+
+	var token tokenResponse
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return "", fmt.Errorf("decoding oauth2 token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("oauth2 token response contains no access token")
+	}
+
+	a.accessToken = token.AccessToken
+	a.expiresAt = time.Time{}
+	if token.ExpiresIn > 0 {
+		a.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	if token.RefreshToken != "" {
+		a.RefreshToken = token.RefreshToken
+	}
+	return a.accessToken, nil
+}
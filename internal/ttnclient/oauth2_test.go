@@ -0,0 +1,252 @@
+package ttnclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// tokenServer is a fake OAuth2 token endpoint that issues numbered access tokens.
+type tokenServer struct {
+	*httptest.Server
+	t         *testing.T
+	expiresIn int64
+	// rotate makes the endpoint issue a new refresh token with every refresh
+	rotate bool
+
+	mu       sync.Mutex
+	requests []map[string]string
+}
+
+func newTokenServer(t *testing.T, expiresIn int64) *tokenServer {
+	server := &tokenServer{t: t, expiresIn: expiresIn}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *tokenServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.t.Errorf("token request method = %s, want POST", r.Method)
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/x-www-form-urlencoded" {
+		s.t.Errorf("token request content type = %q", contentType)
+	}
+	if err := r.ParseForm(); err != nil {
+		s.t.Errorf("parsing token request: %v", err)
+	}
+	clientID, clientSecret, _ := r.BasicAuth()
+
+	s.mu.Lock()
+	s.requests = append(s.requests, map[string]string{
+		"client_id":     clientID,
+		"client_secret": clientSecret,
+		"grant_type":    r.PostForm.Get("grant_type"),
+		"refresh_token": r.PostForm.Get("refresh_token"),
+		"scope":         r.PostForm.Get("scope"),
+	})
+	n := len(s.requests)
+	s.mu.Unlock()
+
+	response := tokenResponse{
+		AccessToken: fmt.Sprintf("access-%d", n),
+		TokenType:   "bearer",
+		ExpiresIn:   s.expiresIn,
+	}
+	if s.rotate {
+		response.RefreshToken = fmt.Sprintf("refresh-%d", n)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (s *tokenServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func (s *tokenServer) request(i int) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[i]
+}
+
+// authorization authenticates a request and returns its Authorization header.
+func authorization(t *testing.T, authenticator *OAuth2Authenticator) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err := authenticator.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	return req.Header.Get("Authorization")
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	server := newTokenServer(t, 3600)
+	authenticator := &OAuth2Authenticator{
+		TokenURL:     server.URL,
+		ClientID:     "exporter@tenant",
+		ClientSecret: "s3cr:et",
+		Scopes:       []string{"gateway:status", "gateway:info"},
+	}
+
+	if got := authorization(t, authenticator); got != "Bearer access-1" {
+		t.Errorf("Authorization = %q, want Bearer access-1", got)
+	}
+	if got := authorization(t, authenticator); got != "Bearer access-1" {
+		t.Errorf("Authorization of cached token = %q, want Bearer access-1", got)
+	}
+	if count := server.requestCount(); count != 1 {
+		t.Fatalf("token requests = %d, want 1", count)
+	}
+
+	request := server.request(0)
+	want := map[string]string{
+		// the credentials are form-encoded before basic auth, as RFC 6749 requires
+		"client_id":     "exporter%40tenant",
+		"client_secret": "s3cr%3Aet",
+		"grant_type":    "client_credentials",
+		"refresh_token": "",
+		"scope":         "gateway:status gateway:info",
+	}
+	for key, value := range want {
+		if request[key] != value {
+			t.Errorf("token request %s = %q, want %q", key, request[key], value)
+		}
+	}
+}
+
+func TestOAuth2ExpiryMargin(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int64
+		requests  int
+	}{
+		{name: "valid beyond margin", expiresIn: 3600, requests: 1},
+		{name: "expires within margin", expiresIn: int64(tokenExpiryMargin.Seconds()) - 30, requests: 2},
+		{name: "no expiry", expiresIn: 0, requests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTokenServer(t, test.expiresIn)
+			authenticator := &OAuth2Authenticator{TokenURL: server.URL, ClientID: "client", ClientSecret: "secret"}
+
+			authorization(t, authenticator)
+			got := authorization(t, authenticator)
+			if want := fmt.Sprintf("Bearer access-%d", test.requests); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+			if count := server.requestCount(); count != test.requests {
+				t.Errorf("token requests = %d, want %d", count, test.requests)
+			}
+		})
+	}
+}
+
+func TestOAuth2RefreshTokenRotation(t *testing.T) {
+	server := newTokenServer(t, 3600)
+	server.rotate = true
+	authenticator := &OAuth2Authenticator{TokenURL: server.URL, ClientID: "client", RefreshToken: "refresh-0"}
+
+	for i := 0; i < 3; i++ {
+		if got, want := authorization(t, authenticator), fmt.Sprintf("Bearer access-%d", i+1); got != want {
+			t.Errorf("Authorization = %q, want %q", got, want)
+		}
+		authenticator.Invalidate()
+	}
+
+	for i := 0; i < 3; i++ {
+		request := server.request(i)
+		if request["grant_type"] != "refresh_token" {
+			t.Errorf("token request %d grant_type = %q, want refresh_token", i, request["grant_type"])
+		}
+		if want := fmt.Sprintf("refresh-%d", i); request["refresh_token"] != want {
+			t.Errorf("token request %d refresh_token = %q, want the rotated %q", i, request["refresh_token"], want)
+		}
+	}
+}
+
+func TestOAuth2TokenErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "non 200 status", handler: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		}},
+		{name: "no access token", handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"token_type":"bearer","expires_in":3600}`))
+		}},
+		{name: "invalid json", handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`<html>`))
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+			authenticator := &OAuth2Authenticator{TokenURL: server.URL, ClientID: "client", ClientSecret: "secret"}
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+			if err := authenticator.Authenticate(req); err == nil {
+				t.Errorf("Authenticate succeeded with Authorization %q, want error", req.Header.Get("Authorization"))
+			}
+		})
+	}
+}
+
+func TestOAuth2RetryOnUnauthorized(t *testing.T) {
+	tests := []struct {
+		name string
+		// accepted is the access token the API accepts
+		accepted    string
+		wantErr     bool
+		apiRequests int
+	}{
+		{name: "fresh token accepted", accepted: "access-2", apiRequests: 2},
+		{name: "retried once", accepted: "never", wantErr: true, apiRequests: 2},
+		{name: "first token accepted", accepted: "access-1", apiRequests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens := newTokenServer(t, 3600)
+			var apiRequests int
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				apiRequests++
+				if r.URL.Path != "/api/v3/gs/gateways/gw-1/connection/stats" {
+					t.Errorf("API request path = %s", r.URL.Path)
+				}
+				if r.Header.Get("Authorization") != "Bearer "+test.accepted {
+					http.Error(w, `{"code":16,"message":"token expired"}`, http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`{"protocol":"udp"}`))
+			}))
+			defer api.Close()
+
+			authenticator := &OAuth2Authenticator{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "secret"}
+			client, err := NewTTNClient(api.URL, authenticator, api.Client())
+			if err != nil {
+				t.Fatalf("NewTTNClient: %v", err)
+			}
+			stats, err := client.GetGatewayConnectionStats(context.Background(), "gw-1")
+			if test.wantErr != (err != nil) {
+				t.Fatalf("GetGatewayConnectionStats error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && stats.Protocol != "udp" {
+				t.Errorf("stats.Protocol = %q, want udp", stats.Protocol)
+			}
+			if apiRequests != test.apiRequests {
+				t.Errorf("API requests = %d, want %d", apiRequests, test.apiRequests)
+			}
+			if count := tokens.requestCount(); count != test.apiRequests {
+				t.Errorf("token requests = %d, want %d", count, test.apiRequests)
+			}
+		})
+	}
+}
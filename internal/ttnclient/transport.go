@@ -0,0 +1,121 @@
+package ttnclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// HTTPOptions configures the HTTP client used to talk to the TTN API. Zero values keep the defaults of
+// http.DefaultTransport and a 10s request timeout.
+type HTTPOptions struct {
+	// ProxyURL is used for all requests. Without it, the proxy is taken from the environment.
+	ProxyURL string
+	// CAFile is a PEM bundle of additional certificate authorities to trust
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key for mutual TLS
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+
+	Timeout             time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	KeepAlive           time.Duration
+	DisableKeepAlives   bool
+	IdleConnTimeout     time.Duration
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+}
+
+// NewHTTPClient creates an HTTP client with the given options that is instrumented with the TTN API client metrics.
+func NewHTTPClient(options HTTPOptions) (*http.Client, error) {
+	transport, err := NewTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout: durationOrDefault(options.Timeout, 10*time.Second),
+		Transport: promhttp.InstrumentRoundTripperDuration(
+			ttnApiRequestDuration,
+			promhttp.InstrumentRoundTripperInFlight(
+				ttnApiRequestsInFlight,
+				transport,
+			),
+		),
+	}, nil
+}
+
+// NewTransport creates the uninstrumented transport of NewHTTPClient, for clients of other APIs that share the HTTP
+// options.
+func NewTransport(options HTTPOptions) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if options.ProxyURL != "" {
+		proxyUrl, err := url.Parse(options.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   durationOrDefault(options.DialTimeout, 30*time.Second),
+		KeepAlive: durationOrDefault(options.KeepAlive, 30*time.Second),
+	}
+	transport.DialContext = dialer.DialContext
+	transport.DisableKeepAlives = options.DisableKeepAlives
+	if options.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = options.TLSHandshakeTimeout
+	}
+	if options.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = options.IdleConnTimeout
+	}
+	if options.MaxIdleConns > 0 {
+		transport.MaxIdleConns = options.MaxIdleConns
+	}
+	if options.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if options.CAFile != "" {
+		pem, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no PEM certificates", options.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if options.CertFile != "" || options.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+func durationOrDefault(duration, defaultDuration time.Duration) time.Duration {
+	if duration > 0 {
+		return duration
+	}
+	return defaultDuration
+}
@@ -0,0 +1,251 @@
+package ttnclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testPKI is a CA with a certificate of the test server at 127.0.0.1 and a client certificate, all written as PEM
+// files.
+type testPKI struct {
+	caFile, clientCertFile, clientKeyFile string
+	pool                                  *x509.CertPool
+	server                                tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	caKey := newTestKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parsing CA: %v", err)
+	}
+	pki := &testPKI{
+		caFile:         filepath.Join(dir, "ca.pem"),
+		clientCertFile: filepath.Join(dir, "client.pem"),
+		clientKeyFile:  filepath.Join(dir, "client-key.pem"),
+		pool:           x509.NewCertPool(),
+	}
+	pki.pool.AddCert(ca)
+	writePEM(t, pki.caFile, "CERTIFICATE", caDER)
+
+	serverKey := newTestKey(t)
+	serverDER := issue(t, ca, caKey, serverKey, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	pki.server = tls.Certificate{Certificate: [][]byte{serverDER}, PrivateKey: serverKey}
+
+	clientKey := newTestKey(t)
+	clientDER := issue(t, ca, caKey, clientKey, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "exporter"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	writePEM(t, pki.clientCertFile, "CERTIFICATE", clientDER)
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatalf("marshalling client key: %v", err)
+	}
+	writePEM(t, pki.clientKeyFile, "EC PRIVATE KEY", clientKeyDER)
+	return pki
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return key
+}
+
+func issue(t *testing.T, ca *x509.Certificate, caKey, key *ecdsa.PrivateKey, template *x509.Certificate) []byte {
+	t.Helper()
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("issuing %s: %v", template.Subject.CommonName, err)
+	}
+	return der
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}
+
+// newMutualTLSServer starts a server that requires a client certificate of the test CA and answers with its common
+// name.
+func newMutualTLSServer(t *testing.T, pki *testPKI) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewHTTPClientMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := newMutualTLSServer(t, pki)
+	tests := []struct {
+		name    string
+		options HTTPOptions
+		wantErr bool
+	}{
+		{name: "without CA", options: HTTPOptions{CertFile: pki.clientCertFile, KeyFile: pki.clientKeyFile}, wantErr: true},
+		{name: "without client certificate", options: HTTPOptions{CAFile: pki.caFile}, wantErr: true},
+		{name: "with CA and client certificate", options: HTTPOptions{CAFile: pki.caFile, CertFile: pki.clientCertFile, KeyFile: pki.clientKeyFile}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewHTTPClient(test.options)
+			if err != nil {
+				t.Fatalf("NewHTTPClient: %v", err)
+			}
+			response, err := client.Get(server.URL)
+			if test.wantErr {
+				if err == nil {
+					response.Body.Close()
+					t.Error("request succeeded, want a TLS error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer response.Body.Close()
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != http.StatusOK || string(body) != "exporter" {
+				t.Errorf("status = %d, client = %q, want 200 from the exporter certificate", response.StatusCode, body)
+			}
+		})
+	}
+}
+
+func TestNewTransportErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		options HTTPOptions
+	}{
+		{name: "missing CA file", options: HTTPOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}},
+		{name: "CA file without certificates", options: HTTPOptions{CAFile: notPEM}},
+		{name: "client certificate without key", options: HTTPOptions{CertFile: pki.clientCertFile}},
+		{name: "invalid proxy URL", options: HTTPOptions{ProxyURL: "://proxy"}},
+	}
+	for _, test := range tests {
+		if _, err := NewTransport(test.options); err == nil {
+			t.Errorf("%s: NewTransport succeeded, want error", test.name)
+		}
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	requested := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- r.URL.String()
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(HTTPOptions{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	response, err := client.Get("http://eu1.cloud.example/api/v3/auth_info")
+	if err != nil {
+		t.Fatalf("request through the proxy: %v", err)
+	}
+	response.Body.Close()
+	if url := <-requested; url != "http://eu1.cloud.example/api/v3/auth_info" {
+		t.Errorf("proxy got %s, want the absolute URL of the API", url)
+	}
+}
+
+func TestNewHTTPClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewHTTPClient(HTTPOptions{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	_, err = client.Get(server.URL)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+
+	if client, _ := NewHTTPClient(HTTPOptions{}); client.Timeout != 10*time.Second {
+		t.Errorf("default timeout = %s, want 10s", client.Timeout)
+	}
+}
+
+func TestNewTransportOptions(t *testing.T) {
+	transport, err := NewTransport(HTTPOptions{TLSHandshakeTimeout: time.Second, IdleConnTimeout: time.Minute, MaxIdleConns: 7, MaxIdleConnsPerHost: 3, DisableKeepAlives: true})
+	if err != nil {
+		t.Fatalf("NewTransport: %v", err)
+	}
+	if transport.TLSHandshakeTimeout != time.Second || transport.IdleConnTimeout != time.Minute || transport.MaxIdleConns != 7 || transport.MaxIdleConnsPerHost != 3 || !transport.DisableKeepAlives {
+		t.Errorf("transport = %+v, want the configured options", transport)
+	}
+
+	// zero values keep the defaults
+	transport, err = NewTransport(HTTPOptions{})
+	if err != nil {
+		t.Fatalf("NewTransport: %v", err)
+	}
+	defaults := http.DefaultTransport.(*http.Transport)
+	if transport.TLSHandshakeTimeout != defaults.TLSHandshakeTimeout || transport.MaxIdleConns != defaults.MaxIdleConns || transport.DisableKeepAlives {
+		t.Errorf("transport = %+v, want the defaults of http.DefaultTransport", transport)
+	}
+}